@@ -0,0 +1,369 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/resp"
+)
+
+func invalidBitOffsetValue() resp.ValueNode {
+	return errorValue("bit offset is not an integer or out of range")
+}
+
+// parseBitOffset parses the bit offset of SETBIT and GETBIT.
+func parseBitOffset(arg string) (int64, bool) {
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 || offset > memstore.BitMaxOffset {
+		return 0, false
+	}
+
+	return offset, true
+}
+
+// parseBitRange parses the start, the end and the optional BYTE or BIT unit
+// of BITCOUNT and BITPOS.
+func parseBitRange(args []string) (*memstore.BitRange, resp.ValueNode, bool) {
+	r := &memstore.BitRange{
+		End: -1,
+	}
+
+	var err error
+
+	r.Start, err = strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return nil, notIntegerValue(), false
+	}
+
+	if len(args) > 1 {
+		r.End, err = strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return nil, notIntegerValue(), false
+		}
+	}
+
+	if len(args) > 2 {
+		switch strings.ToLower(args[2]) {
+		case "byte":
+		case "bit":
+			r.Bits = true
+		default:
+			return nil, syntaxErrorValue(), false
+		}
+	}
+
+	return r, resp.ValueNode{}, true
+}
+
+func SetBit(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 2 {
+		return wrongArgsValue("setbit")
+	}
+
+	offset, ok := parseBitOffset(args[0])
+	if !ok {
+		return invalidBitOffsetValue()
+	}
+
+	if args[1] != "0" && args[1] != "1" {
+		return errorValue("bit is not an integer or out of range")
+	}
+
+	prev, err := db(cmd).SetBit(cmd.Key(), offset, int(args[1][0]-'0'))
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(prev))
+}
+
+func GetBit(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 1 {
+		return wrongArgsValue("getbit")
+	}
+
+	offset, ok := parseBitOffset(args[0])
+	if !ok {
+		return invalidBitOffsetValue()
+	}
+
+	bit, err := db(cmd).GetBit(cmd.Key(), offset)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(bit))
+}
+
+func BitCount(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) > 3 {
+		return wrongArgsValue("bitcount")
+	}
+
+	var r *memstore.BitRange
+
+	if len(args) > 0 {
+		// the start can't be given without the end
+		if len(args) == 1 {
+			return syntaxErrorValue()
+		}
+
+		var (
+			errValue resp.ValueNode
+			ok       bool
+		)
+
+		r, errValue, ok = parseBitRange(args)
+		if !ok {
+			return errValue
+		}
+	}
+
+	count, err := db(cmd).BitCount(cmd.Key(), r)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(count)
+}
+
+func BitPos(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 1 || len(args) > 4 {
+		return wrongArgsValue("bitpos")
+	}
+
+	if args[0] != "0" && args[0] != "1" {
+		return errorValue("The bit argument must be 1 or 0.")
+	}
+
+	var r *memstore.BitRange
+
+	if len(args) > 1 {
+		var (
+			errValue resp.ValueNode
+			ok       bool
+		)
+
+		r, errValue, ok = parseBitRange(args[1:])
+		if !ok {
+			return errValue
+		}
+	}
+
+	pos, err := db(cmd).BitPos(cmd.Key(), int(args[0][0]-'0'), r, len(args) > 2)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(pos)
+}
+
+func BitOp(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 2 {
+		return wrongArgsValue("bitop")
+	}
+
+	var op memstore.BitOperation
+
+	// the first argument is the operation, not a key
+	switch strings.ToLower(cmd.Key()) {
+	case "and":
+		op = memstore.BitAnd
+	case "or":
+		op = memstore.BitOr
+	case "xor":
+		op = memstore.BitXor
+	case "not":
+		op = memstore.BitNot
+	default:
+		return syntaxErrorValue()
+	}
+
+	if op == memstore.BitNot && len(args) != 2 {
+		return errorValue("BITOP NOT must be called with a single source key.")
+	}
+
+	length, err := db(cmd).BitOp(op, args[0], args[1:]...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(length))
+}
+
+func BitField(cmd resp.Command) resp.ValueNode {
+	return bitField(cmd, "bitfield", false)
+}
+
+func BitFieldRO(cmd resp.Command) resp.ValueNode {
+	return bitField(cmd, "bitfield_ro", true)
+}
+
+// parseBitFieldType parses the type of a field, like i16 or u8.
+func parseBitFieldType(arg string) (signed bool, width uint, ok bool) {
+	if len(arg) < 2 {
+		return false, 0, false
+	}
+
+	switch arg[0] {
+	case 'i', 'I':
+		signed = true
+	case 'u', 'U':
+	default:
+		return false, 0, false
+	}
+
+	n, err := strconv.ParseUint(arg[1:], 10, 8)
+	if err != nil || n < 1 || n > 64 || !signed && n > 63 {
+		return false, 0, false
+	}
+
+	return signed, uint(n), true
+}
+
+// parseBitFieldOffset parses the offset of a field, an offset prefixed with
+// # is multiplied by the width of the field.
+func parseBitFieldOffset(arg string, width uint) (int64, bool) {
+	multiply := strings.HasPrefix(arg, "#")
+	if multiply {
+		arg = arg[1:]
+	}
+
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, false
+	}
+
+	if multiply {
+		if offset > memstore.BitMaxOffset/int64(width) {
+			return 0, false
+		}
+
+		offset *= int64(width)
+	}
+
+	if offset+int64(width)-1 > memstore.BitMaxOffset {
+		return 0, false
+	}
+
+	return offset, true
+}
+
+func bitField(cmd resp.Command, name string, readonly bool) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" {
+		return wrongArgsValue(name)
+	}
+
+	var (
+		ops      []memstore.BitFieldOp
+		overflow memstore.BitFieldOverflow
+		write    bool
+	)
+
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+
+		if opt == "overflow" {
+			if readonly || i+1 >= len(args) {
+				return syntaxErrorValue()
+			}
+
+			i++
+
+			switch strings.ToLower(args[i]) {
+			case "wrap":
+				overflow = memstore.BitFieldWrap
+			case "sat":
+				overflow = memstore.BitFieldSat
+			case "fail":
+				overflow = memstore.BitFieldFail
+			default:
+				return errorValue("Invalid OVERFLOW type specified")
+			}
+
+			continue
+		}
+
+		op := memstore.BitFieldOp{
+			Overflow: overflow,
+		}
+
+		argc := 2
+
+		switch opt {
+		case "get":
+			op.Type = memstore.BitFieldGet
+		case "set":
+			op.Type = memstore.BitFieldSet
+			argc = 3
+		case "incrby":
+			op.Type = memstore.BitFieldIncrBy
+			argc = 3
+		default:
+			return syntaxErrorValue()
+		}
+
+		if i+argc >= len(args) {
+			return syntaxErrorValue()
+		}
+
+		if readonly && op.Type != memstore.BitFieldGet {
+			return errorValue("BITFIELD_RO only supports the GET subcommand")
+		}
+
+		var ok bool
+
+		op.Signed, op.Width, ok = parseBitFieldType(args[i+1])
+		if !ok {
+			return errorValue("Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+		}
+
+		op.Offset, ok = parseBitFieldOffset(args[i+2], op.Width)
+		if !ok {
+			return invalidBitOffsetValue()
+		}
+
+		if argc == 3 {
+			val, err := strconv.ParseInt(args[i+3], 10, 64)
+			if err != nil {
+				return notIntegerValue()
+			}
+
+			op.Value = val
+			write = true
+		}
+
+		ops = append(ops, op)
+		i += argc
+	}
+
+	if !write {
+		// only reads, there is nothing to log
+		cmd.Propagate()
+	}
+
+	results, err := db(cmd).BitField(cmd.Key(), ops)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, result := range results {
+		if result == nil {
+			response.Append(nilValue(cmd))
+			continue
+		}
+
+		response.Append(integerValue(*result))
+	}
+
+	return response
+}
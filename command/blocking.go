@@ -0,0 +1,198 @@
+package command
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/resp"
+)
+
+// parseTimeout parses the timeout of the blocking commands in seconds,
+// zero blocks forever.
+func parseTimeout(arg string) (time.Duration, resp.ValueNode, bool) {
+	seconds, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) || seconds > math.MaxInt64/float64(time.Second) {
+		return 0, errorValue("timeout is not a float or out of range"), false
+	}
+
+	if seconds < 0 {
+		return 0, errorValue("timeout is negative"), false
+	}
+
+	return time.Duration(seconds * float64(time.Second)), resp.ValueNode{}, true
+}
+
+// block runs try on keys until one of them has data, the client waits for
+// a push from another client in between. try runs the non blocking version
+// of the command on a key and returns a null reply when the key has no data.
+// A null array is returned when timeout passes first.
+func block(cmd resp.Command, keys []string, timeout time.Duration, try func(key string) resp.ValueNode) resp.ValueNode {
+	w := db(cmd).Block(keys)
+	defer w.Unblock()
+
+	expired, stop := timer(timeout)
+	defer stop()
+
+	first := true
+
+	for {
+		for _, key := range keys {
+			// clients blocked earlier on the key are served first
+			if first && !w.First(key) {
+				continue
+			}
+
+			reply := try(key)
+			if reply.IsNull() {
+				continue
+			}
+
+			// the next client gets what is left
+			if length, err := db(cmd).ListLen(key); err == nil && length > 0 {
+				db(cmd).Notify(key)
+			}
+
+			return reply
+		}
+
+		first = false
+
+		if !wait(cmd, w, expired) {
+			return nilArrayValue(cmd)
+		}
+	}
+}
+
+// blockAll is like block for the commands reading several keys at once
+// without consuming the data, like XREAD. try runs the non blocking version
+// of the command and returns a null reply when none of the keys has data.
+func blockAll(cmd resp.Command, keys []string, timeout time.Duration, try func() resp.ValueNode) resp.ValueNode {
+	w := db(cmd).Block(keys)
+	defer w.Unblock()
+
+	expired, stop := timer(timeout)
+	defer stop()
+
+	for {
+		reply := try()
+		if !reply.IsNull() {
+			return reply
+		}
+
+		if !wait(cmd, w, expired) {
+			return nilArrayValue(cmd)
+		}
+	}
+}
+
+// timer returns a channel receiving once timeout passes, a nil channel
+// when it's zero.
+func timer(timeout time.Duration) (<-chan time.Time, func()) {
+	if timeout <= 0 {
+		return nil, func() {}
+	}
+
+	t := time.NewTimer(timeout)
+
+	return t.C, func() { t.Stop() }
+}
+
+// wait waits for a push on the keys of w and reports whether it happened
+// before expired or before the client disconnected. The command is
+// suspended meanwhile, inside a transaction it doesn't wait at all.
+func wait(cmd resp.Command, w *memstore.Waiter, expired <-chan time.Time) bool {
+	if !cmd.CanBlock() {
+		return false
+	}
+
+	var ready bool
+
+	cmd.Suspend(func() {
+		select {
+		case <-w.Ready():
+			ready = true
+		case <-expired:
+		case <-cmd.Closed():
+		}
+	})
+
+	return ready
+}
+
+func BLPop(cmd resp.Command) resp.ValueNode {
+	return blockingPop(cmd, "blpop", "lpop")
+}
+
+func BRPop(cmd resp.Command) resp.ValueNode {
+	return blockingPop(cmd, "brpop", "rpop")
+}
+
+func blockingPop(cmd resp.Command, name, pop string) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 1 {
+		return wrongArgsValue(name)
+	}
+
+	timeout, errReply, ok := parseTimeout(args[len(args)-1])
+	if !ok {
+		return errReply
+	}
+
+	keys := append([]string{cmd.Key()}, args[:len(args)-1]...)
+
+	// the pop runs as a command of its own, so it's logged as a plain pop
+	return block(cmd, keys, timeout, func(key string) resp.ValueNode {
+		reply := cmd.Call(pop, key)
+		if reply.IsNull() || reply.IsError() {
+			return reply
+		}
+
+		response := resp.NewValueNode(resp.ValueNodeTypeArray)
+		response.Append(bulkValue(key))
+		response.Append(reply)
+
+		return response
+	})
+}
+
+func BLMove(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 4 {
+		return wrongArgsValue("blmove")
+	}
+
+	_, srcOk := parseDirection(args[1])
+	_, dstOk := parseDirection(args[2])
+
+	if !srcOk || !dstOk {
+		return syntaxErrorValue()
+	}
+
+	timeout, errReply, ok := parseTimeout(args[3])
+	if !ok {
+		return errReply
+	}
+
+	return block(cmd, []string{cmd.Key()}, timeout, func(key string) resp.ValueNode {
+		return cmd.Call("lmove", key, args[0], strings.ToUpper(args[1]), strings.ToUpper(args[2]))
+	})
+}
+
+func BRPopLPush(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 2 {
+		return wrongArgsValue("brpoplpush")
+	}
+
+	timeout, errReply, ok := parseTimeout(args[1])
+	if !ok {
+		return errReply
+	}
+
+	return block(cmd, []string{cmd.Key()}, timeout, func(key string) resp.ValueNode {
+		return cmd.Call("rpoplpush", key, args[0])
+	})
+}
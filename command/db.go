@@ -0,0 +1,124 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/resp"
+)
+
+// db returns the database selected by the client of cmd, the keys it
+// modifies are counted as changes of cmd.
+func db(cmd resp.Command) *memstore.DB {
+	return memstore.Database(cmd.DB()).Recorded(cmd.Changed)
+}
+
+// parseDBIndex parses the index of a database.
+func parseDBIndex(arg string) (int, resp.ValueNode, bool) {
+	index, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, notIntegerValue(), false
+	}
+
+	if index < 0 || index >= int64(memstore.Databases()) {
+		return 0, errorValue("DB index is out of range"), false
+	}
+
+	return int(index), resp.ValueNode{}, true
+}
+
+// checkFlushMode checks the optional ASYNC or SYNC argument of the flush
+// commands. Both modes are the same here, a flush only drops the entries
+// and the garbage collector reclaims the values concurrently.
+func checkFlushMode(name string, cmd resp.Command) (resp.ValueNode, bool) {
+	if len(cmd.Args()) > 0 {
+		return wrongArgsValue(name), false
+	}
+
+	switch strings.ToLower(cmd.Key()) {
+	case "", "sync", "async":
+		return resp.ValueNode{}, true
+	}
+
+	return syntaxErrorValue(), false
+}
+
+func Select(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 0 {
+		return wrongArgsValue("select")
+	}
+
+	index, errValue, ok := parseDBIndex(cmd.Key())
+	if !ok {
+		return errValue
+	}
+
+	cmd.SetDB(index)
+
+	return okValue()
+}
+
+func SwapDB(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 1 {
+		return wrongArgsValue("swapdb")
+	}
+
+	i, errValue, ok := parseDBIndex(cmd.Key())
+	if !ok {
+		return errValue
+	}
+
+	j, errValue, ok := parseDBIndex(cmd.Args()[0])
+	if !ok {
+		return errValue
+	}
+
+	memstore.Database(i).Recorded(cmd.Changed).Swap(memstore.Database(j))
+
+	return okValue()
+}
+
+func Move(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 1 {
+		return wrongArgsValue("move")
+	}
+
+	index, errValue, ok := parseDBIndex(cmd.Args()[0])
+	if !ok {
+		return errValue
+	}
+
+	if index == cmd.DB() {
+		return errorValue("source and destination objects are the same")
+	}
+
+	moved, err := db(cmd).Move(cmd.Key(), memstore.Database(index))
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return boolIntegerValue(moved)
+}
+
+func FlushDB(cmd resp.Command) resp.ValueNode {
+	errValue, ok := checkFlushMode("flushdb", cmd)
+	if !ok {
+		return errValue
+	}
+
+	db(cmd).Flush()
+
+	return okValue()
+}
+
+func FlushAll(cmd resp.Command) resp.ValueNode {
+	errValue, ok := checkFlushMode("flushall", cmd)
+	if !ok {
+		return errValue
+	}
+
+	db(cmd).FlushAll()
+
+	return okValue()
+}
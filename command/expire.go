@@ -0,0 +1,182 @@
+package command
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/resp"
+)
+
+// expireAt converts an expiration argument to unix milliseconds, seconds
+// tells the unit of val and absolute whether it's a unix time or relative to now.
+// It reports false when the result overflows.
+func expireAt(val int64, seconds, absolute bool) (int64, bool) {
+	if seconds {
+		if val > math.MaxInt64/1000 || val < math.MinInt64/1000 {
+			return 0, false
+		}
+
+		val *= 1000
+	}
+
+	if absolute {
+		return val, true
+	}
+
+	now := time.Now().UnixMilli()
+	if val > math.MaxInt64-now || val < math.MinInt64+now {
+		return 0, false
+	}
+
+	return now + val, true
+}
+
+func Expire(cmd resp.Command) resp.ValueNode {
+	return expire(cmd, "expire", true, false)
+}
+
+func PExpire(cmd resp.Command) resp.ValueNode {
+	return expire(cmd, "pexpire", false, false)
+}
+
+func ExpireAt(cmd resp.Command) resp.ValueNode {
+	return expire(cmd, "expireat", true, true)
+}
+
+func PExpireAt(cmd resp.Command) resp.ValueNode {
+	return expire(cmd, "pexpireat", false, true)
+}
+
+func expire(cmd resp.Command, name string, seconds, absolute bool) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 1 {
+		return wrongArgsValue(name)
+	}
+
+	val, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return notIntegerValue()
+	}
+
+	var cond memstore.ExpireCondition
+
+	for _, opt := range args[1:] {
+		switch strings.ToLower(opt) {
+		case "nx":
+			cond |= memstore.ExpireNX
+		case "xx":
+			cond |= memstore.ExpireXX
+		case "gt":
+			cond |= memstore.ExpireGT
+		case "lt":
+			cond |= memstore.ExpireLT
+		default:
+			return errorValue("unsupported option %s", opt)
+		}
+	}
+
+	if cond&memstore.ExpireNX != 0 && cond&^memstore.ExpireNX != 0 {
+		return errorValue("NX and XX, GT or LT options at the same time are not compatible")
+	}
+
+	if cond&memstore.ExpireGT != 0 && cond&memstore.ExpireLT != 0 {
+		return errorValue("GT and LT options at the same time are not compatible")
+	}
+
+	at, ok := expireAt(val, seconds, absolute)
+	if !ok {
+		return errorValue("invalid expire time in '%s' command", name)
+	}
+
+	updated, err := db(cmd).Expire(cmd.Key(), at, cond)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return boolIntegerValue(updated)
+}
+
+func TTL(cmd resp.Command) resp.ValueNode {
+	return ttl(cmd, "ttl", true)
+}
+
+func PTTL(cmd resp.Command) resp.ValueNode {
+	return ttl(cmd, "pttl", false)
+}
+
+func ttl(cmd resp.Command, name string, seconds bool) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue(name)
+	}
+
+	at, err := db(cmd).ExpireTime(cmd.Key())
+	if err == memstore.ErrNilEntries {
+		return integerValue(-2)
+	}
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if at == 0 {
+		return integerValue(-1)
+	}
+
+	remaining := at - time.Now().UnixMilli()
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	if seconds {
+		remaining = (remaining + 500) / 1000
+	}
+
+	return integerValue(remaining)
+}
+
+func ExpireTime(cmd resp.Command) resp.ValueNode {
+	return expireTime(cmd, "expiretime", true)
+}
+
+func PExpireTime(cmd resp.Command) resp.ValueNode {
+	return expireTime(cmd, "pexpiretime", false)
+}
+
+func expireTime(cmd resp.Command, name string, seconds bool) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue(name)
+	}
+
+	at, err := db(cmd).ExpireTime(cmd.Key())
+	if err == memstore.ErrNilEntries {
+		return integerValue(-2)
+	}
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if at == 0 {
+		return integerValue(-1)
+	}
+
+	if seconds {
+		at /= 1000
+	}
+
+	return integerValue(at)
+}
+
+func Persist(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue("persist")
+	}
+
+	updated, err := db(cmd).Persist(cmd.Key())
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return boolIntegerValue(updated)
+}
@@ -0,0 +1,412 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/resp"
+)
+
+// parseGeoUnit returns the number of meters in the distance unit.
+func parseGeoUnit(arg string) (float64, resp.ValueNode, bool) {
+	switch strings.ToLower(arg) {
+	case "m":
+		return 1, resp.ValueNode{}, true
+	case "km":
+		return 1000, resp.ValueNode{}, true
+	case "ft":
+		return 0.3048, resp.ValueNode{}, true
+	case "mi":
+		return 1609.34, resp.ValueNode{}, true
+	}
+
+	return 0, errorValue("unsupported unit provided. please use M, KM, FT, MI"), false
+}
+
+// parseCoordinates parses a longitude and a latitude.
+func parseCoordinates(args []string) (memstore.GeoPoint, resp.ValueNode, bool) {
+	lon, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return memstore.GeoPoint{}, errorValue("value is not a valid float"), false
+	}
+
+	lat, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return memstore.GeoPoint{}, errorValue("value is not a valid float"), false
+	}
+
+	return memstore.GeoPoint{Longitude: lon, Latitude: lat}, resp.ValueNode{}, true
+}
+
+// geoDistValue is the reply for a distance, with four decimals like in Redis.
+func geoDistValue(dist float64) resp.ValueNode {
+	return bulkValue(strconv.FormatFloat(dist, 'f', 4, 64))
+}
+
+func geoPointValue(p memstore.GeoPoint) resp.ValueNode {
+	return bulkArrayValue([]string{
+		strconv.FormatFloat(p.Longitude, 'f', -1, 64),
+		strconv.FormatFloat(p.Latitude, 'f', -1, 64),
+	})
+}
+
+func GeoAdd(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" {
+		return wrongArgsValue("geoadd")
+	}
+
+	var opts memstore.SortedSetAddOptions
+
+options:
+	for len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "nx":
+			opts.NX = true
+		case "xx":
+			opts.XX = true
+		case "ch":
+			opts.CH = true
+		default:
+			break options
+		}
+
+		args = args[1:]
+	}
+
+	if len(args) == 0 || len(args)%3 != 0 {
+		return syntaxErrorValue()
+	}
+
+	if opts.NX && opts.XX {
+		return errorValue("XX and NX options at the same time are not compatible")
+	}
+
+	members := make([]memstore.GeoMember, 0, len(args)/3)
+
+	for i := 0; i < len(args); i += 3 {
+		p, errValue, ok := parseCoordinates(args[i:])
+		if !ok {
+			return errValue
+		}
+
+		members = append(members, memstore.GeoMember{Member: args[i+2], GeoPoint: p})
+	}
+
+	count, err := db(cmd).GeoAdd(cmd.Key(), opts, members...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(count))
+}
+
+func GeoPos(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" {
+		return wrongArgsValue("geopos")
+	}
+
+	points, err := db(cmd).GeoPos(cmd.Key(), cmd.Args()...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, p := range points {
+		if p == nil {
+			response.Append(nilArrayValue(cmd))
+			continue
+		}
+
+		response.Append(geoPointValue(*p))
+	}
+
+	return response
+}
+
+func GeoDist(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 2 || len(args) > 3 {
+		return wrongArgsValue("geodist")
+	}
+
+	unit := 1.0
+
+	if len(args) == 3 {
+		var (
+			errValue resp.ValueNode
+			ok       bool
+		)
+
+		unit, errValue, ok = parseGeoUnit(args[2])
+		if !ok {
+			return errValue
+		}
+	}
+
+	dist, err := db(cmd).GeoDist(cmd.Key(), args[0], args[1])
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if dist == nil {
+		return nilValue(cmd)
+	}
+
+	return geoDistValue(*dist / unit)
+}
+
+func GeoHash(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" {
+		return wrongArgsValue("geohash")
+	}
+
+	hashes, err := db(cmd).GeoHash(cmd.Key(), cmd.Args()...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, hash := range hashes {
+		if hash == nil {
+			response.Append(nilValue(cmd))
+			continue
+		}
+
+		response.Append(bulkValue(*hash))
+	}
+
+	return response
+}
+
+// geoSearchOptions are the options of GEOSEARCH and GEOSEARCHSTORE besides
+// the ones of memstore.
+type geoSearchOptions struct {
+	memstore.GeoSearchOptions
+	// unit is the number of meters in the unit of the distances
+	unit      float64
+	withCoord bool
+	withDist  bool
+	withHash  bool
+	storeDist bool
+}
+
+// parseGeoSearch parses the options of GEOSEARCH, or the ones of
+// GEOSEARCHSTORE when store is set.
+func parseGeoSearch(name string, args []string, store bool) (geoSearchOptions, resp.ValueNode, bool) {
+	var (
+		opts     geoSearchOptions
+		hasFrom  bool
+		hasBy    bool
+		hasCount bool
+	)
+
+	for i := 0; i < len(args); i++ {
+		// the number of arguments taken by the option
+		argc := 0
+
+		switch strings.ToLower(args[i]) {
+		case "frommember":
+			argc = 1
+		case "fromlonlat":
+			argc = 2
+		case "byradius":
+			argc = 2
+		case "bybox":
+			argc = 3
+		case "count":
+			argc = 1
+		}
+
+		if i+argc >= len(args) {
+			return opts, syntaxErrorValue(), false
+		}
+
+		switch opt := strings.ToLower(args[i]); opt {
+		case "frommember", "fromlonlat":
+			if hasFrom {
+				return opts, errorValue("exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", name), false
+			}
+
+			hasFrom = true
+
+			if opt == "frommember" {
+				opts.FromMember = true
+				opts.Member = args[i+1]
+
+				break
+			}
+
+			p, errValue, ok := parseCoordinates(args[i+1:])
+			if !ok {
+				return opts, errValue, false
+			}
+
+			opts.Center = p
+		case "byradius", "bybox":
+			if hasBy {
+				return opts, errorValue("exactly one of BYRADIUS and BYBOX can be specified for %s", name), false
+			}
+
+			hasBy = true
+
+			sizes := make([]float64, argc-1)
+			for j := range sizes {
+				size, err := strconv.ParseFloat(args[i+1+j], 64)
+				if err != nil {
+					return opts, errorValue("need numeric radius"), false
+				}
+
+				if size < 0 {
+					return opts, errorValue("radius cannot be negative"), false
+				}
+
+				sizes[j] = size
+			}
+
+			unit, errValue, ok := parseGeoUnit(args[i+argc])
+			if !ok {
+				return opts, errValue, false
+			}
+
+			opts.unit = unit
+
+			if opt == "byradius" {
+				opts.Radius = sizes[0] * unit
+				break
+			}
+
+			opts.ByBox = true
+			opts.Width = sizes[0] * unit
+			opts.Height = sizes[1] * unit
+		case "asc":
+			opts.Sort = memstore.GeoSortAsc
+		case "desc":
+			opts.Sort = memstore.GeoSortDesc
+		case "count":
+			count, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return opts, notIntegerValue(), false
+			}
+
+			if count <= 0 {
+				return opts, errorValue("COUNT must be > 0"), false
+			}
+
+			hasCount = true
+			opts.Count = int(count)
+
+			if i+2 < len(args) && strings.ToLower(args[i+2]) == "any" {
+				opts.Any = true
+				i++
+			}
+		case "any":
+			return opts, errorValue("the ANY argument requires COUNT argument"), false
+		case "withcoord":
+			opts.withCoord = true
+		case "withdist":
+			opts.withDist = true
+		case "withhash":
+			opts.withHash = true
+		case "storedist":
+			if !store {
+				return opts, syntaxErrorValue(), false
+			}
+
+			opts.storeDist = true
+		default:
+			return opts, syntaxErrorValue(), false
+		}
+
+		i += argc
+	}
+
+	if !hasFrom {
+		return opts, errorValue("exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", name), false
+	}
+
+	if !hasBy {
+		return opts, errorValue("exactly one of BYRADIUS and BYBOX can be specified for %s", name), false
+	}
+
+	if opts.Any && !hasCount {
+		return opts, errorValue("the ANY argument requires COUNT argument"), false
+	}
+
+	if store && (opts.withCoord || opts.withDist || opts.withHash) {
+		return opts, errorValue("STORE option in %s is not compatible with WITHDIST, WITHHASH and WITHCOORD options", name), false
+	}
+
+	return opts, resp.ValueNode{}, true
+}
+
+func GeoSearch(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" {
+		return wrongArgsValue("geosearch")
+	}
+
+	opts, errValue, ok := parseGeoSearch("geosearch", cmd.Args(), false)
+	if !ok {
+		return errValue
+	}
+
+	results, err := db(cmd).GeoSearch(cmd.Key(), opts.GeoSearchOptions)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, result := range results {
+		if !opts.withDist && !opts.withHash && !opts.withCoord {
+			response.Append(bulkValue(result.Member))
+			continue
+		}
+
+		item := resp.NewValueNode(resp.ValueNodeTypeArray)
+		item.Append(bulkValue(result.Member))
+
+		if opts.withDist {
+			item.Append(geoDistValue(result.Dist / opts.unit))
+		}
+
+		if opts.withHash {
+			item.Append(integerValue(int64(result.Hash)))
+		}
+
+		if opts.withCoord {
+			item.Append(geoPointValue(result.GeoPoint))
+		}
+
+		response.Append(item)
+	}
+
+	return response
+}
+
+func GeoSearchStore(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 1 {
+		return wrongArgsValue("geosearchstore")
+	}
+
+	opts, errValue, ok := parseGeoSearch("geosearchstore", args[1:], true)
+	if !ok {
+		return errValue
+	}
+
+	var distUnit float64
+	if opts.storeDist {
+		distUnit = opts.unit
+	}
+
+	count, err := db(cmd).GeoSearchStore(cmd.Key(), args[0], opts.GeoSearchOptions, distUnit)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(count))
+}
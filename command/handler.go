@@ -1,28 +1,182 @@
 package command
 
 import (
-	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/raspiantoro/temporama/info"
 	"github.com/raspiantoro/temporama/memstore"
 	"github.com/raspiantoro/temporama/resp"
 )
 
-func Registers() resp.CommandHandler {
+func Registers() *resp.Mux {
 	mux := resp.NewCommandMux()
 
-	mux.HandleFunc("ping", Ping)
-	mux.HandleFunc("hello", Hello)
-	mux.HandleFunc("get", Get)
-	mux.HandleFunc("set", Set)
-	mux.HandleFunc("del", Delete)
-	mux.HandleFunc("hmget", HmGet)
-	mux.HandleFunc("hmset", HmSet)
-	mux.HandleFunc("hgetall", HGetAll)
-	mux.HandleFunc("hset", HSet)
-	mux.HandleFunc("hget", HGet)
+	mux.HandleFunc("ping", Ping, -1)
+	mux.HandleFunc("hello", Hello, -1, resp.FlagNoScript)
+	mux.HandleFunc("get", Get, 2)
+	mux.HandleFunc("set", Set, -3, resp.FlagWrite)
+	mux.HandleFunc("del", Delete, -2, resp.FlagWrite)
+	mux.HandleFunc("unlink", Unlink, -2, resp.FlagWrite)
+	mux.HandleFunc("exists", Exists, -2)
+	mux.HandleFunc("type", Type, 2)
+	mux.HandleFunc("keys", Keys, 2)
+	mux.HandleFunc("scan", Scan, -2)
+	mux.HandleFunc("rename", Rename, 3, resp.FlagWrite)
+	mux.HandleFunc("renamenx", RenameNX, 3, resp.FlagWrite)
+	mux.HandleFunc("copy", Copy, -3, resp.FlagWrite)
+	mux.HandleFunc("randomkey", RandomKey, 1)
+	mux.HandleFunc("dbsize", DBSize, 1)
+	mux.HandleFunc("touch", Touch, -2)
+	mux.HandleFunc("object", Object, -2)
+	mux.HandleFunc("select", Select, 2)
+	mux.HandleFunc("swapdb", SwapDB, 3, resp.FlagWrite)
+	mux.HandleFunc("move", Move, 3, resp.FlagWrite)
+	mux.HandleFunc("flushdb", FlushDB, -1, resp.FlagWrite)
+	mux.HandleFunc("flushall", FlushAll, -1, resp.FlagWrite)
+	mux.HandleFunc("multi", Multi, 1, resp.FlagTransaction, resp.FlagNoScript)
+	mux.HandleFunc("exec", Exec, 1, resp.FlagTransaction, resp.FlagExclusive, resp.FlagNoScript)
+	mux.HandleFunc("discard", Discard, 1, resp.FlagTransaction, resp.FlagNoScript)
+	mux.HandleFunc("watch", Watch, -2, resp.FlagTransaction, resp.FlagNoScript)
+	mux.HandleFunc("unwatch", Unwatch, 1, resp.FlagNoScript)
+	mux.HandleFunc("eval", Eval, -3, resp.FlagExclusive, resp.FlagNoScript)
+	mux.HandleFunc("evalsha", EvalSha, -3, resp.FlagExclusive, resp.FlagNoScript)
+	mux.HandleFunc("script", Script, -2, resp.FlagNoScript, resp.FlagAllowBusy)
+	mux.HandleFunc("incr", Incr, 2, resp.FlagWrite)
+	mux.HandleFunc("decr", Decr, 2, resp.FlagWrite)
+	mux.HandleFunc("incrby", IncrBy, 3, resp.FlagWrite)
+	mux.HandleFunc("decrby", DecrBy, 3, resp.FlagWrite)
+	mux.HandleFunc("incrbyfloat", IncrByFloat, 3, resp.FlagWrite)
+	mux.HandleFunc("append", Append, 3, resp.FlagWrite)
+	mux.HandleFunc("strlen", StrLen, 2)
+	mux.HandleFunc("getrange", GetRange, 4)
+	mux.HandleFunc("setrange", SetRange, 4, resp.FlagWrite)
+	mux.HandleFunc("mget", MGet, -2)
+	mux.HandleFunc("mset", MSet, -3, resp.FlagWrite)
+	mux.HandleFunc("msetnx", MSetNX, -3, resp.FlagWrite)
+	mux.HandleFunc("getset", GetSet, 3, resp.FlagWrite)
+	mux.HandleFunc("getdel", GetDel, 2, resp.FlagWrite)
+	mux.HandleFunc("getex", GetEx, -2, resp.FlagWrite)
+	mux.HandleFunc("setnx", SetNX, 3, resp.FlagWrite)
+	mux.HandleFunc("setbit", SetBit, 4, resp.FlagWrite)
+	mux.HandleFunc("getbit", GetBit, 3)
+	mux.HandleFunc("bitcount", BitCount, -2)
+	mux.HandleFunc("bitpos", BitPos, -3)
+	mux.HandleFunc("bitop", BitOp, -4, resp.FlagWrite)
+	mux.HandleFunc("bitfield", BitField, -2, resp.FlagWrite)
+	mux.HandleFunc("bitfield_ro", BitFieldRO, -2)
+	mux.HandleFunc("pfadd", PFAdd, -2, resp.FlagWrite)
+	mux.HandleFunc("pfcount", PFCount, -2)
+	mux.HandleFunc("pfmerge", PFMerge, -2, resp.FlagWrite)
+	mux.HandleFunc("hmget", HmGet, -3)
+	mux.HandleFunc("hmset", HmSet, -4, resp.FlagWrite)
+	mux.HandleFunc("hgetall", HGetAll, 2)
+	mux.HandleFunc("hset", HSet, -4, resp.FlagWrite)
+	mux.HandleFunc("hget", HGet, 3)
+	mux.HandleFunc("hdel", HDel, -3, resp.FlagWrite)
+	mux.HandleFunc("hexists", HExists, 3)
+	mux.HandleFunc("hlen", HLen, 2)
+	mux.HandleFunc("hkeys", HKeys, 2)
+	mux.HandleFunc("hvals", HVals, 2)
+	mux.HandleFunc("hstrlen", HStrLen, 3)
+	mux.HandleFunc("hsetnx", HSetNX, 4, resp.FlagWrite)
+	mux.HandleFunc("hincrby", HIncrBy, 4, resp.FlagWrite)
+	mux.HandleFunc("hincrbyfloat", HIncrByFloat, 4, resp.FlagWrite)
+	mux.HandleFunc("hrandfield", HRandField, -2)
+	mux.HandleFunc("hscan", HScan, -3)
+	mux.HandleFunc("hexpire", HExpire, -6, resp.FlagWrite)
+	mux.HandleFunc("hpexpire", HPExpire, -6, resp.FlagWrite)
+	mux.HandleFunc("hexpireat", HExpireAt, -6, resp.FlagWrite)
+	mux.HandleFunc("hpexpireat", HPExpireAt, -6, resp.FlagWrite)
+	mux.HandleFunc("httl", HTTL, -5)
+	mux.HandleFunc("hpttl", HPTTL, -5)
+	mux.HandleFunc("hexpiretime", HExpireTime, -5)
+	mux.HandleFunc("hpexpiretime", HPExpireTime, -5)
+	mux.HandleFunc("hpersist", HPersist, -5, resp.FlagWrite)
+	mux.HandleFunc("expire", Expire, -3, resp.FlagWrite)
+	mux.HandleFunc("pexpire", PExpire, -3, resp.FlagWrite)
+	mux.HandleFunc("expireat", ExpireAt, -3, resp.FlagWrite)
+	mux.HandleFunc("pexpireat", PExpireAt, -3, resp.FlagWrite)
+	mux.HandleFunc("ttl", TTL, 2)
+	mux.HandleFunc("pttl", PTTL, 2)
+	mux.HandleFunc("expiretime", ExpireTime, 2)
+	mux.HandleFunc("pexpiretime", PExpireTime, 2)
+	mux.HandleFunc("persist", Persist, 2, resp.FlagWrite)
+	mux.HandleFunc("lpush", LPush, -3, resp.FlagWrite)
+	mux.HandleFunc("rpush", RPush, -3, resp.FlagWrite)
+	mux.HandleFunc("lpushx", LPushX, -3, resp.FlagWrite)
+	mux.HandleFunc("rpushx", RPushX, -3, resp.FlagWrite)
+	mux.HandleFunc("lpop", LPop, -2, resp.FlagWrite)
+	mux.HandleFunc("rpop", RPop, -2, resp.FlagWrite)
+	mux.HandleFunc("lrange", LRange, 4)
+	mux.HandleFunc("lindex", LIndex, 3)
+	mux.HandleFunc("lset", LSet, 4, resp.FlagWrite)
+	mux.HandleFunc("lrem", LRem, 4, resp.FlagWrite)
+	mux.HandleFunc("ltrim", LTrim, 4, resp.FlagWrite)
+	mux.HandleFunc("llen", LLen, 2)
+	mux.HandleFunc("linsert", LInsert, 5, resp.FlagWrite)
+	mux.HandleFunc("lpos", LPos, -3)
+	mux.HandleFunc("lmove", LMove, 5, resp.FlagWrite)
+	mux.HandleFunc("rpoplpush", RPopLPush, 3, resp.FlagWrite)
+	// the blocking commands write through the non blocking ones,
+	// so they aren't write commands themselves
+	mux.HandleFunc("blpop", BLPop, -3)
+	mux.HandleFunc("brpop", BRPop, -3)
+	mux.HandleFunc("blmove", BLMove, 6)
+	mux.HandleFunc("brpoplpush", BRPopLPush, 4)
+	mux.HandleFunc("sadd", SAdd, -3, resp.FlagWrite)
+	mux.HandleFunc("srem", SRem, -3, resp.FlagWrite)
+	mux.HandleFunc("smembers", SMembers, 2)
+	mux.HandleFunc("sismember", SIsMember, 3)
+	mux.HandleFunc("smismember", SMIsMember, -3)
+	mux.HandleFunc("scard", SCard, 2)
+	mux.HandleFunc("sinter", SInter, -2)
+	mux.HandleFunc("sunion", SUnion, -2)
+	mux.HandleFunc("sdiff", SDiff, -2)
+	mux.HandleFunc("sinterstore", SInterStore, -3, resp.FlagWrite)
+	mux.HandleFunc("sunionstore", SUnionStore, -3, resp.FlagWrite)
+	mux.HandleFunc("sdiffstore", SDiffStore, -3, resp.FlagWrite)
+	mux.HandleFunc("spop", SPop, -2, resp.FlagWrite)
+	mux.HandleFunc("srandmember", SRandMember, -2)
+	mux.HandleFunc("smove", SMove, 4, resp.FlagWrite)
+	mux.HandleFunc("zadd", ZAdd, -4, resp.FlagWrite)
+	mux.HandleFunc("zincrby", ZIncrBy, 4, resp.FlagWrite)
+	mux.HandleFunc("zrem", ZRem, -3, resp.FlagWrite)
+	mux.HandleFunc("zscore", ZScore, 3)
+	mux.HandleFunc("zcard", ZCard, 2)
+	mux.HandleFunc("zrank", ZRank, -3)
+	mux.HandleFunc("zrevrank", ZRevRank, -3)
+	mux.HandleFunc("zrange", ZRange, -4)
+	mux.HandleFunc("zrangebyscore", ZRangeByScore, -4)
+	mux.HandleFunc("zcount", ZCount, 4)
+	mux.HandleFunc("zpopmin", ZPopMin, -2, resp.FlagWrite)
+	mux.HandleFunc("zpopmax", ZPopMax, -2, resp.FlagWrite)
+	mux.HandleFunc("zunionstore", ZUnionStore, -4, resp.FlagWrite)
+	mux.HandleFunc("zinterstore", ZInterStore, -4, resp.FlagWrite)
+	mux.HandleFunc("geoadd", GeoAdd, -5, resp.FlagWrite)
+	mux.HandleFunc("geopos", GeoPos, -2)
+	mux.HandleFunc("geodist", GeoDist, -4)
+	mux.HandleFunc("geohash", GeoHash, -2)
+	mux.HandleFunc("geosearch", GeoSearch, -7)
+	mux.HandleFunc("geosearchstore", GeoSearchStore, -8, resp.FlagWrite)
+	mux.HandleFunc("xadd", XAdd, -5, resp.FlagWrite)
+	mux.HandleFunc("xtrim", XTrim, -4, resp.FlagWrite)
+	mux.HandleFunc("xlen", XLen, 2)
+	mux.HandleFunc("xrange", XRange, -4)
+	mux.HandleFunc("xrevrange", XRevRange, -4)
+	mux.HandleFunc("xread", XRead, -4)
+	mux.HandleFunc("xgroup", XGroup, -2, resp.FlagWrite)
+	mux.HandleFunc("xreadgroup", XReadGroup, -7, resp.FlagWrite)
+	mux.HandleFunc("xack", XAck, -4, resp.FlagWrite)
+	mux.HandleFunc("xpending", XPending, -3)
+	mux.HandleFunc("xclaim", XClaim, -6, resp.FlagWrite)
+	mux.HandleFunc("xautoclaim", XAutoClaim, -6, resp.FlagWrite)
+	mux.HandleFunc("xsetid", XSetID, -3, resp.FlagWrite)
+	mux.HandleFunc("save", Save, 1, resp.FlagNoScript)
+	mux.HandleFunc("bgsave", BgSave, -1, resp.FlagNoScript)
+	mux.HandleFunc("lastsave", LastSave, 1)
+	mux.HandleFunc("bgrewriteaof", BgRewriteAof, 1, resp.FlagNoScript)
 
 	return mux
 }
@@ -182,18 +336,12 @@ func Get(cmd resp.Command) resp.ValueNode {
 		)
 	}
 
-	val, err := memstore.Get(memstore.ValueTypeString, cmd.Key())
+	val, err := db(cmd).Get(memstore.ValueTypeString, cmd.Key())
 	if err == memstore.ErrNilEntries {
-		return resp.NewValueNode(
-			resp.ValueNodeTypeBulkString,
-			resp.WithValue("-1"),
-		)
+		return nilValue(cmd)
 	}
 	if err != nil {
-		return resp.NewValueNode(
-			resp.ValueNodeTypeSimpleError,
-			resp.WithValue(fmt.Sprintf("ERROR: %s", err.Error())),
-		)
+		return storeErrorValue(err)
 	}
 
 	return resp.NewValueNode(
@@ -210,12 +358,74 @@ func Set(cmd resp.Command) resp.ValueNode {
 		)
 	}
 
-	_, err := memstore.Set(memstore.ValueTypeString, cmd.Key(), cmd.Args()...)
+	args := cmd.Args()
+	opts := memstore.SetOptions{}
+	hasExpire := false
+
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+
+		switch opt {
+		case "nx":
+			if opts.XX {
+				return syntaxErrorValue()
+			}
+			opts.NX = true
+		case "xx":
+			if opts.NX {
+				return syntaxErrorValue()
+			}
+			opts.XX = true
+		case "get":
+			opts.Get = true
+		case "keepttl":
+			if hasExpire {
+				return syntaxErrorValue()
+			}
+			opts.KeepTTL = true
+		case "ex", "px", "exat", "pxat":
+			if hasExpire || opts.KeepTTL || i+1 >= len(args) {
+				return syntaxErrorValue()
+			}
+
+			i++
+
+			val, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return notIntegerValue()
+			}
+
+			if val <= 0 {
+				return errorValue("invalid expire time in 'set' command")
+			}
+
+			at, ok := expireAt(val, opt == "ex" || opt == "exat", opt == "exat" || opt == "pxat")
+			if !ok {
+				return errorValue("invalid expire time in 'set' command")
+			}
+
+			opts.ExpireAt = at
+			hasExpire = true
+		default:
+			return syntaxErrorValue()
+		}
+	}
+
+	prev, ok, err := db(cmd).SetString(cmd.Key(), args[0], opts)
 	if err != nil {
-		return resp.NewValueNode(
-			resp.ValueNodeTypeSimpleError,
-			resp.WithValue(fmt.Sprintf("ERROR: %s", err.Error())),
-		)
+		return storeErrorValue(err)
+	}
+
+	if opts.Get {
+		if prev == nil {
+			return nilValue(cmd)
+		}
+
+		return bulkValue(*prev)
+	}
+
+	if !ok {
+		return nilValue(cmd)
 	}
 
 	return resp.NewValueNode(
@@ -225,11 +435,16 @@ func Set(cmd resp.Command) resp.ValueNode {
 }
 
 func Delete(cmd resp.Command) resp.ValueNode {
-	response := memstore.Delete(cmd.Key())
-	return resp.NewValueNode(
-		resp.ValueNodeTypeIntegers,
-		resp.WithValue(response),
-	)
+	if cmd.Key() == "" {
+		return wrongArgsValue("del")
+	}
+
+	count, err := db(cmd).Delete(cmd.Argv()[1:]...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(count))
 }
 
 func HmGet(cmd resp.Command) resp.ValueNode {
@@ -240,28 +455,22 @@ func HmGet(cmd resp.Command) resp.ValueNode {
 		)
 	}
 
-	val, err := memstore.Get(memstore.ValueTypeMap, cmd.Key(), cmd.Args()...)
-	if err == memstore.ErrNilEntries {
-		return resp.NewValueNode(
-			resp.ValueNodeTypeArray,
-			resp.WithValue("-1"),
-		)
-	}
+	vals, err := db(cmd).HashGet(cmd.Key(), cmd.Args()...)
 	if err != nil {
-		return resp.NewValueNode(
-			resp.ValueNodeTypeSimpleError,
-			resp.WithValue(fmt.Sprintf("ERROR: %s", err.Error())),
-		)
+		return storeErrorValue(err)
 	}
 
-	vals := val.([]string)
-
 	response := resp.NewValueNode(resp.ValueNodeTypeArray)
 
 	for _, v := range vals {
+		if v == nil {
+			response.Append(nilValue(cmd))
+			continue
+		}
+
 		child := resp.NewValueNode(
 			resp.ValueNodeTypeBulkString,
-			resp.WithValue(v),
+			resp.WithValue(*v),
 		)
 
 		response.Append(child)
@@ -271,19 +480,16 @@ func HmGet(cmd resp.Command) resp.ValueNode {
 }
 
 func HmSet(cmd resp.Command) resp.ValueNode {
-	if cmd.Key() == "" || len(cmd.Args()) <= 0 {
+	if cmd.Key() == "" || len(cmd.Args()) <= 0 || len(cmd.Args())%2 != 0 {
 		return resp.NewValueNode(
 			resp.ValueNodeTypeSimpleError,
 			resp.WithValue("ERROR: wrong arguments number for 'hmset' command"),
 		)
 	}
 
-	_, err := memstore.Set(memstore.ValueTypeMap, cmd.Key(), cmd.Args()...)
+	_, err := db(cmd).HashSet(cmd.Key(), cmd.Args()...)
 	if err != nil {
-		return resp.NewValueNode(
-			resp.ValueNodeTypeSimpleError,
-			resp.WithValue(fmt.Sprintf("ERROR: %s", err.Error())),
-		)
+		return storeErrorValue(err)
 	}
 
 	return resp.NewValueNode(
@@ -300,29 +506,11 @@ func HGetAll(cmd resp.Command) resp.ValueNode {
 		)
 	}
 
-	val, err := memstore.Get(memstore.ValueTypeMap, cmd.Key())
-	if err == memstore.ErrNilEntries {
-		if cmd.Proto() == 2 {
-			return resp.NewValueNode(
-				resp.ValueNodeTypeArray,
-				resp.WithValue("-1"),
-			)
-		} else {
-			return resp.NewValueNode(
-				resp.ValueNodeTypeMaps,
-				resp.WithValue("-1"),
-			)
-		}
-	}
+	fields, err := db(cmd).HashGetAll(cmd.Key())
 	if err != nil {
-		return resp.NewValueNode(
-			resp.ValueNodeTypeSimpleError,
-			resp.WithValue(fmt.Sprintf("ERROR: %s", err.Error())),
-		)
+		return storeErrorValue(err)
 	}
 
-	vals := val.([]string)
-
 	var response resp.ValueNode
 
 	if cmd.Proto() == 2 {
@@ -331,32 +519,25 @@ func HGetAll(cmd resp.Command) resp.ValueNode {
 		response = resp.NewValueNode(resp.ValueNodeTypeMaps)
 	}
 
-	for _, v := range vals {
-		child := resp.NewValueNode(
-			resp.ValueNodeTypeBulkString,
-			resp.WithValue(v),
-		)
-
-		response.Append(child)
+	for _, f := range fields {
+		response.Append(bulkValue(f.Field))
+		response.Append(bulkValue(f.Value))
 	}
 
 	return response
 }
 
 func HSet(cmd resp.Command) resp.ValueNode {
-	if cmd.Key() == "" || len(cmd.Args()) <= 0 {
+	if cmd.Key() == "" || len(cmd.Args()) <= 0 || len(cmd.Args())%2 != 0 {
 		return resp.NewValueNode(
 			resp.ValueNodeTypeSimpleError,
 			resp.WithValue("ERROR: wrong arguments number for 'hset' command"),
 		)
 	}
 
-	newFieldNum, err := memstore.Set(memstore.ValueTypeMap, cmd.Key(), cmd.Args()...)
+	newFieldNum, err := db(cmd).HashSet(cmd.Key(), cmd.Args()...)
 	if err != nil {
-		return resp.NewValueNode(
-			resp.ValueNodeTypeSimpleError,
-			resp.WithValue(fmt.Sprintf("ERROR: %s", err.Error())),
-		)
+		return storeErrorValue(err)
 	}
 
 	return resp.NewValueNode(
@@ -373,25 +554,18 @@ func HGet(cmd resp.Command) resp.ValueNode {
 		)
 	}
 
-	val, err := memstore.Get(memstore.ValueTypeMap, cmd.Key(), cmd.Args()...)
-	if err == memstore.ErrNilEntries {
-		return resp.NewValueNode(
-			resp.ValueNodeTypeArray,
-			resp.WithValue("-1"),
-		)
-	}
+	vals, err := db(cmd).HashGet(cmd.Key(), cmd.Args()...)
 	if err != nil {
-		return resp.NewValueNode(
-			resp.ValueNodeTypeSimpleError,
-			resp.WithValue(fmt.Sprintf("ERROR: %s", err.Error())),
-		)
+		return storeErrorValue(err)
 	}
 
-	vals := val.([]string)
+	if vals[0] == nil {
+		return nilValue(cmd)
+	}
 
 	response := resp.NewValueNode(
 		resp.ValueNodeTypeBulkString,
-		resp.WithValue(vals[0]),
+		resp.WithValue(*vals[0]),
 	)
 
 	return response
@@ -0,0 +1,500 @@
+package command
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/resp"
+)
+
+func HDel(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) < 1 {
+		return wrongArgsValue("hdel")
+	}
+
+	removed, err := db(cmd).HashDelete(cmd.Key(), cmd.Args()...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(removed))
+}
+
+func HExists(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 1 {
+		return wrongArgsValue("hexists")
+	}
+
+	exists, err := db(cmd).HashExists(cmd.Key(), cmd.Args()[0])
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return boolIntegerValue(exists)
+}
+
+func HLen(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue("hlen")
+	}
+
+	length, err := db(cmd).HashLen(cmd.Key())
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(length))
+}
+
+func HKeys(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue("hkeys")
+	}
+
+	fields, err := db(cmd).HashKeys(cmd.Key())
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return bulkArrayValue(fields)
+}
+
+func HVals(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue("hvals")
+	}
+
+	values, err := db(cmd).HashValues(cmd.Key())
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return bulkArrayValue(values)
+}
+
+func HStrLen(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 1 {
+		return wrongArgsValue("hstrlen")
+	}
+
+	length, err := db(cmd).HashStrLen(cmd.Key(), cmd.Args()[0])
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(length))
+}
+
+func HSetNX(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 2 {
+		return wrongArgsValue("hsetnx")
+	}
+
+	set, err := db(cmd).HashSetNX(cmd.Key(), args[0], args[1])
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return boolIntegerValue(set)
+}
+
+func HIncrBy(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 2 {
+		return wrongArgsValue("hincrby")
+	}
+
+	delta, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return notIntegerValue()
+	}
+
+	result, err := db(cmd).HashIncrBy(cmd.Key(), args[0], delta)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(result)
+}
+
+func HIncrByFloat(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 2 {
+		return wrongArgsValue("hincrbyfloat")
+	}
+
+	// inf and nan are parsed, the sum is rejected by the store
+	delta, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return errorValue("value is not a valid float")
+	}
+
+	result, err := db(cmd).HashIncrByFloat(cmd.Key(), args[0], delta)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return bulkValue(result)
+}
+
+// hashFieldsValue is the reply for fields with their values. RESP2 uses a
+// flat array while RESP3 sends an array of field and value pairs.
+func hashFieldsValue(cmd resp.Command, fields []memstore.HashField, withValues bool) resp.ValueNode {
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, f := range fields {
+		switch {
+		case !withValues:
+			response.Append(bulkValue(f.Field))
+		case cmd.Proto() == 3:
+			response.Append(bulkArrayValue([]string{f.Field, f.Value}))
+		default:
+			response.Append(bulkValue(f.Field))
+			response.Append(bulkValue(f.Value))
+		}
+	}
+
+	return response
+}
+
+func HRandField(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) > 2 {
+		return wrongArgsValue("hrandfield")
+	}
+
+	count := int64(1)
+	withCount := len(args) > 0
+	withValues := false
+
+	if withCount {
+		var err error
+
+		count, err = strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return notIntegerValue()
+		}
+
+		// the count must fit twice in the reply
+		if count < -math.MaxInt32 || count > math.MaxInt32 {
+			return errorValue("value is out of range")
+		}
+	}
+
+	if len(args) == 2 {
+		if strings.ToLower(args[1]) != "withvalues" {
+			return syntaxErrorValue()
+		}
+
+		withValues = true
+	}
+
+	fields, err := db(cmd).HashRandField(cmd.Key(), int(count))
+	if err == memstore.ErrNilEntries {
+		if withCount {
+			return resp.NewValueNode(resp.ValueNodeTypeArray)
+		}
+
+		return nilValue(cmd)
+	}
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if withCount {
+		return hashFieldsValue(cmd, fields, withValues)
+	}
+
+	return bulkValue(fields[0].Field)
+}
+
+func HScan(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 1 {
+		return wrongArgsValue("hscan")
+	}
+
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return errorValue("invalid cursor")
+	}
+
+	var (
+		pattern   string
+		withMatch bool
+		noValues  bool
+	)
+
+	count := int64(10)
+
+	for i := 1; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+
+		switch {
+		case option == "match" && i+1 < len(args):
+			pattern = args[i+1]
+			withMatch = pattern != "*"
+			i++
+		case option == "count" && i+1 < len(args):
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return notIntegerValue()
+			}
+
+			if n < 1 {
+				return syntaxErrorValue()
+			}
+
+			count = n
+			i++
+		case option == "novalues":
+			noValues = true
+		default:
+			return syntaxErrorValue()
+		}
+	}
+
+	if count > math.MaxInt32 {
+		count = math.MaxInt32
+	}
+
+	next, fields, err := db(cmd).HashScan(cmd.Key(), cursor, int(count))
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	// like Redis the pattern filters the fields once they are fetched
+	items := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, f := range fields {
+		if withMatch && !match(pattern, f.Field) {
+			continue
+		}
+
+		items.Append(bulkValue(f.Field))
+
+		if !noValues {
+			items.Append(bulkValue(f.Value))
+		}
+	}
+
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+	response.Append(bulkValue(strconv.FormatUint(next, 10)))
+	response.Append(items)
+
+	return response
+}
+
+// parseFields parses the FIELDS numfields field... arguments of the hash
+// field expiration commands.
+func parseFields(args []string) ([]string, resp.ValueNode, bool) {
+	if len(args) < 2 || strings.ToLower(args[0]) != "fields" {
+		return nil, errorValue("Mandatory argument FIELDS is missing or not at the right position"), false
+	}
+
+	n, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return nil, notIntegerValue(), false
+	}
+
+	if n <= 0 {
+		return nil, errorValue("Parameter `numFields` should be greater than 0"), false
+	}
+
+	if n != int64(len(args)-2) {
+		return nil, errorValue("The `numfields` parameter must match the number of arguments"), false
+	}
+
+	return args[2:], resp.ValueNode{}, true
+}
+
+func intsValue(vals []int) resp.ValueNode {
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, val := range vals {
+		response.Append(integerValue(int64(val)))
+	}
+
+	return response
+}
+
+func HExpire(cmd resp.Command) resp.ValueNode {
+	return hexpire(cmd, "hexpire", true, false)
+}
+
+func HPExpire(cmd resp.Command) resp.ValueNode {
+	return hexpire(cmd, "hpexpire", false, false)
+}
+
+func HExpireAt(cmd resp.Command) resp.ValueNode {
+	return hexpire(cmd, "hexpireat", true, true)
+}
+
+func HPExpireAt(cmd resp.Command) resp.ValueNode {
+	return hexpire(cmd, "hpexpireat", false, true)
+}
+
+func hexpire(cmd resp.Command, name string, seconds, absolute bool) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 4 {
+		return wrongArgsValue(name)
+	}
+
+	val, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return notIntegerValue()
+	}
+
+	var cond memstore.ExpireCondition
+
+	rest := args[1:]
+
+	switch strings.ToLower(rest[0]) {
+	case "nx":
+		cond = memstore.ExpireNX
+	case "xx":
+		cond = memstore.ExpireXX
+	case "gt":
+		cond = memstore.ExpireGT
+	case "lt":
+		cond = memstore.ExpireLT
+	}
+
+	if cond != 0 {
+		rest = rest[1:]
+	}
+
+	fields, errReply, ok := parseFields(rest)
+	if !ok {
+		return errReply
+	}
+
+	if val < 0 {
+		return errorValue("invalid expire time, must be >= 0")
+	}
+
+	at, ok := expireAt(val, seconds, absolute)
+	if !ok {
+		return errorValue("invalid expire time in '%s' command", name)
+	}
+
+	results, err := db(cmd).HashExpire(cmd.Key(), at, cond, fields...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	// relative expirations are stored as absolute times and the fields
+	// already expired as deleted, so replaying doesn't depend on the time
+	var expired, deleted []string
+
+	for i, result := range results {
+		switch result {
+		case 1:
+			expired = append(expired, fields[i])
+		case 2:
+			deleted = append(deleted, fields[i])
+		}
+	}
+
+	var propagation [][]string
+
+	if len(expired) > 0 {
+		expire := []string{"HPEXPIREAT", cmd.Key(), strconv.FormatInt(at, 10), "FIELDS", strconv.Itoa(len(expired))}
+		propagation = append(propagation, append(expire, expired...))
+	}
+
+	if len(deleted) > 0 {
+		propagation = append(propagation, append([]string{"HDEL", cmd.Key()}, deleted...))
+	}
+
+	cmd.Propagate(propagation...)
+
+	return intsValue(results)
+}
+
+func HTTL(cmd resp.Command) resp.ValueNode {
+	return httl(cmd, "httl", true, false)
+}
+
+func HPTTL(cmd resp.Command) resp.ValueNode {
+	return httl(cmd, "hpttl", false, false)
+}
+
+func HExpireTime(cmd resp.Command) resp.ValueNode {
+	return httl(cmd, "hexpiretime", true, true)
+}
+
+func HPExpireTime(cmd resp.Command) resp.ValueNode {
+	return httl(cmd, "hpexpiretime", false, true)
+}
+
+// httl replies the remaining time to live of fields, or their expiration
+// time when absolute is set.
+func httl(cmd resp.Command, name string, seconds, absolute bool) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) < 3 {
+		return wrongArgsValue(name)
+	}
+
+	fields, errReply, ok := parseFields(cmd.Args())
+	if !ok {
+		return errReply
+	}
+
+	times, err := db(cmd).HashExpireTime(cmd.Key(), fields...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	ts := time.Now().UnixMilli()
+
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, at := range times {
+		if at < 0 {
+			response.Append(integerValue(at))
+			continue
+		}
+
+		val := at
+		if !absolute {
+			val -= ts
+			if val < 0 {
+				val = 0
+			}
+		}
+
+		if seconds {
+			if absolute {
+				val /= 1000
+			} else {
+				val = (val + 500) / 1000
+			}
+		}
+
+		response.Append(integerValue(val))
+	}
+
+	return response
+}
+
+func HPersist(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) < 3 {
+		return wrongArgsValue("hpersist")
+	}
+
+	fields, errReply, ok := parseFields(cmd.Args())
+	if !ok {
+		return errReply
+	}
+
+	results, err := db(cmd).HashPersist(cmd.Key(), fields...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return intsValue(results)
+}
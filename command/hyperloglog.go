@@ -0,0 +1,44 @@
+package command
+
+import (
+	"github.com/raspiantoro/temporama/resp"
+)
+
+func PFAdd(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" {
+		return wrongArgsValue("pfadd")
+	}
+
+	changed, err := db(cmd).PFAdd(cmd.Key(), cmd.Args()...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return boolIntegerValue(changed)
+}
+
+func PFCount(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" {
+		return wrongArgsValue("pfcount")
+	}
+
+	card, err := db(cmd).PFCount(append([]string{cmd.Key()}, cmd.Args()...)...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(card)
+}
+
+func PFMerge(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" {
+		return wrongArgsValue("pfmerge")
+	}
+
+	err := db(cmd).PFMerge(cmd.Key(), cmd.Args()...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return okValue()
+}
@@ -0,0 +1,320 @@
+package command
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/resp"
+)
+
+// matchOption returns the filter of a MATCH pattern, nil when the pattern
+// accepts every key.
+func matchOption(pattern string) func(string) bool {
+	if pattern == "*" {
+		return nil
+	}
+
+	return func(key string) bool {
+		return match(pattern, key)
+	}
+}
+
+func Exists(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" {
+		return wrongArgsValue("exists")
+	}
+
+	count, err := db(cmd).Exists(cmd.Argv()[1:]...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(count))
+}
+
+func Type(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 0 {
+		return wrongArgsValue("type")
+	}
+
+	name, err := db(cmd).Type(cmd.Key())
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return resp.NewValueNode(
+		resp.ValueNodeTypeSimpleString,
+		resp.WithValue(name),
+	)
+}
+
+func Keys(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 0 {
+		return wrongArgsValue("keys")
+	}
+
+	keys := db(cmd).Keys(memstore.ScanOptions{
+		Match: matchOption(cmd.Key()),
+	})
+
+	return bulkArrayValue(keys)
+}
+
+func Scan(cmd resp.Command) resp.ValueNode {
+	args := cmd.Argv()[1:]
+	if len(args) < 1 {
+		return wrongArgsValue("scan")
+	}
+
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return errorValue("invalid cursor")
+	}
+
+	opts := memstore.ScanOptions{
+		Count: 10,
+	}
+
+	for i := 1; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+
+		switch {
+		case option == "match" && i+1 < len(args):
+			opts.Match = matchOption(args[i+1])
+			i++
+		case option == "count" && i+1 < len(args):
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return notIntegerValue()
+			}
+
+			if n < 1 {
+				return syntaxErrorValue()
+			}
+
+			if n > math.MaxInt32 {
+				n = math.MaxInt32
+			}
+
+			opts.Count = int(n)
+			i++
+		case option == "type" && i+1 < len(args):
+			opts.Type = strings.ToLower(args[i+1])
+			i++
+		default:
+			return syntaxErrorValue()
+		}
+	}
+
+	next, keys := db(cmd).Scan(cursor, opts)
+
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+	response.Append(bulkValue(strconv.FormatUint(next, 10)))
+	response.Append(bulkArrayValue(keys))
+
+	return response
+}
+
+func Rename(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 1 {
+		return wrongArgsValue("rename")
+	}
+
+	_, err := db(cmd).Rename(cmd.Key(), cmd.Args()[0], false)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return okValue()
+}
+
+func RenameNX(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 1 {
+		return wrongArgsValue("renamenx")
+	}
+
+	renamed, err := db(cmd).Rename(cmd.Key(), cmd.Args()[0], true)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if !renamed {
+		// dst exists, nothing to log
+		cmd.Propagate()
+	}
+
+	return boolIntegerValue(renamed)
+}
+
+func Copy(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 1 {
+		return wrongArgsValue("copy")
+	}
+
+	var replace bool
+
+	dst := db(cmd)
+
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "replace":
+			replace = true
+		case "db":
+			if i+1 >= len(args) {
+				return syntaxErrorValue()
+			}
+
+			index, errValue, ok := parseDBIndex(args[i+1])
+			if !ok {
+				return errValue
+			}
+
+			dst = memstore.Database(index)
+			i++
+		default:
+			return syntaxErrorValue()
+		}
+	}
+
+	copied, err := db(cmd).Copy(cmd.Key(), dst, args[0], replace)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if !copied {
+		cmd.Propagate()
+	}
+
+	return boolIntegerValue(copied)
+}
+
+func RandomKey(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() != "" {
+		return wrongArgsValue("randomkey")
+	}
+
+	key, ok := db(cmd).RandomKey()
+	if !ok {
+		return nilValue(cmd)
+	}
+
+	return bulkValue(key)
+}
+
+func DBSize(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() != "" {
+		return wrongArgsValue("dbsize")
+	}
+
+	return integerValue(int64(db(cmd).DBSize()))
+}
+
+// Unlink is DEL, removing a key only drops the reference to its value and
+// the garbage collector reclaims it concurrently, so there is nothing left
+// to free in the background like in Redis.
+func Unlink(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" {
+		return wrongArgsValue("unlink")
+	}
+
+	count, err := db(cmd).Delete(cmd.Argv()[1:]...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(count))
+}
+
+func Touch(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" {
+		return wrongArgsValue("touch")
+	}
+
+	count, err := db(cmd).Touch(cmd.Argv()[1:]...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(count))
+}
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+func Object(cmd resp.Command) resp.ValueNode {
+	// the first argument is the subcommand, not a key
+	sub := strings.ToLower(cmd.Key())
+	args := cmd.Args()
+
+	switch sub {
+	case "help":
+		response := resp.NewValueNode(resp.ValueNodeTypeArray)
+		for _, line := range objectHelp {
+			response.Append(resp.NewValueNode(
+				resp.ValueNodeTypeSimpleString,
+				resp.WithValue(line),
+			))
+		}
+
+		return response
+	case "encoding", "idletime", "freq", "refcount":
+	case "":
+		return wrongArgsValue("object")
+	default:
+		return errorValue("unknown subcommand '%s'. Try OBJECT HELP.", cmd.Key())
+	}
+
+	if len(args) != 1 {
+		return wrongArgsValue("object|" + sub)
+	}
+
+	lfu := memstore.CurrentEvictionPolicy() == memstore.AllKeysLFU ||
+		memstore.CurrentEvictionPolicy() == memstore.VolatileLFU
+
+	if sub == "idletime" && lfu {
+		return errorValue("An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+	}
+
+	if sub == "freq" && !lfu {
+		return errorValue("An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+	}
+
+	info, err := db(cmd).Object(args[0])
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if info == nil {
+		return nilValue(cmd)
+	}
+
+	switch sub {
+	case "encoding":
+		return bulkValue(info.Encoding)
+	case "idletime":
+		return integerValue(info.IdleTime)
+	case "freq":
+		return integerValue(int64(info.Freq))
+	}
+
+	// values are never shared
+	return integerValue(1)
+}
@@ -0,0 +1,352 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/resp"
+)
+
+func LPush(cmd resp.Command) resp.ValueNode {
+	return push(cmd, "lpush", true, false)
+}
+
+func RPush(cmd resp.Command) resp.ValueNode {
+	return push(cmd, "rpush", false, false)
+}
+
+func LPushX(cmd resp.Command) resp.ValueNode {
+	return push(cmd, "lpushx", true, true)
+}
+
+func RPushX(cmd resp.Command) resp.ValueNode {
+	return push(cmd, "rpushx", false, true)
+}
+
+func push(cmd resp.Command, name string, front, existing bool) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) < 1 {
+		return wrongArgsValue(name)
+	}
+
+	length, err := db(cmd).ListPush(cmd.Key(), front, existing, cmd.Args()...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(length))
+}
+
+func LPop(cmd resp.Command) resp.ValueNode {
+	return pop(cmd, "lpop", true)
+}
+
+func RPop(cmd resp.Command) resp.ValueNode {
+	return pop(cmd, "rpop", false)
+}
+
+func pop(cmd resp.Command, name string, front bool) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) > 1 {
+		return wrongArgsValue(name)
+	}
+
+	count := int64(1)
+	withCount := len(args) == 1
+
+	if withCount {
+		var err error
+
+		count, err = strconv.ParseInt(args[0], 10, 64)
+		if err != nil || count < 0 {
+			return errorValue("value is out of range, must be positive")
+		}
+	}
+
+	vals, err := db(cmd).ListPop(cmd.Key(), front, int(count))
+	if err == memstore.ErrNilEntries {
+		if withCount {
+			return nilArrayValue(cmd)
+		}
+
+		return nilValue(cmd)
+	}
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if withCount {
+		return bulkArrayValue(vals)
+	}
+
+	return bulkValue(vals[0])
+}
+
+func LRange(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 2 {
+		return wrongArgsValue("lrange")
+	}
+
+	start, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return notIntegerValue()
+	}
+
+	stop, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return notIntegerValue()
+	}
+
+	vals, err := db(cmd).ListRange(cmd.Key(), start, stop)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return bulkArrayValue(vals)
+}
+
+func LIndex(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 1 {
+		return wrongArgsValue("lindex")
+	}
+
+	index, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return notIntegerValue()
+	}
+
+	val, err := db(cmd).ListIndex(cmd.Key(), index)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if val == nil {
+		return nilValue(cmd)
+	}
+
+	return bulkValue(*val)
+}
+
+func LSet(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 2 {
+		return wrongArgsValue("lset")
+	}
+
+	index, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return notIntegerValue()
+	}
+
+	err = db(cmd).ListSet(cmd.Key(), index, args[1])
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return okValue()
+}
+
+func LRem(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 2 {
+		return wrongArgsValue("lrem")
+	}
+
+	count, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return notIntegerValue()
+	}
+
+	removed, err := db(cmd).ListRemove(cmd.Key(), count, args[1])
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(removed))
+}
+
+func LTrim(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 2 {
+		return wrongArgsValue("ltrim")
+	}
+
+	start, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return notIntegerValue()
+	}
+
+	stop, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return notIntegerValue()
+	}
+
+	err = db(cmd).ListTrim(cmd.Key(), start, stop)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return okValue()
+}
+
+func LLen(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue("llen")
+	}
+
+	length, err := db(cmd).ListLen(cmd.Key())
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(length))
+}
+
+func LInsert(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 3 {
+		return wrongArgsValue("linsert")
+	}
+
+	var before bool
+
+	switch strings.ToLower(args[0]) {
+	case "before":
+		before = true
+	case "after":
+		before = false
+	default:
+		return syntaxErrorValue()
+	}
+
+	length, err := db(cmd).ListInsert(cmd.Key(), before, args[1], args[2])
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(length))
+}
+
+func LPos(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 1 {
+		return wrongArgsValue("lpos")
+	}
+
+	opts := memstore.ListPosOptions{
+		Rank: 1,
+	}
+	withCount := false
+
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return syntaxErrorValue()
+		}
+
+		val, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			return notIntegerValue()
+		}
+
+		switch strings.ToLower(args[i]) {
+		case "rank":
+			if val == 0 {
+				return errorValue("RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			opts.Rank = val
+		case "count":
+			if val < 0 {
+				return errorValue("COUNT can't be negative")
+			}
+			opts.Count = val
+			withCount = true
+		case "maxlen":
+			if val < 0 {
+				return errorValue("MAXLEN can't be negative")
+			}
+			opts.MaxLen = val
+		default:
+			return syntaxErrorValue()
+		}
+	}
+
+	// without COUNT only the first match is needed
+	if !withCount {
+		opts.Count = 1
+	}
+
+	positions, err := db(cmd).ListPos(cmd.Key(), args[0], opts)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if withCount {
+		response := resp.NewValueNode(resp.ValueNodeTypeArray)
+		for _, pos := range positions {
+			response.Append(integerValue(pos))
+		}
+
+		return response
+	}
+
+	if len(positions) == 0 {
+		return nilValue(cmd)
+	}
+
+	return integerValue(positions[0])
+}
+
+func LMove(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 3 {
+		return wrongArgsValue("lmove")
+	}
+
+	srcFront, ok := parseDirection(args[1])
+	if !ok {
+		return syntaxErrorValue()
+	}
+
+	dstFront, ok := parseDirection(args[2])
+	if !ok {
+		return syntaxErrorValue()
+	}
+
+	return move(cmd, cmd.Key(), args[0], srcFront, dstFront)
+}
+
+func RPopLPush(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 1 {
+		return wrongArgsValue("rpoplpush")
+	}
+
+	return move(cmd, cmd.Key(), args[0], false, true)
+}
+
+func move(cmd resp.Command, src, dst string, srcFront, dstFront bool) resp.ValueNode {
+	val, err := db(cmd).ListMove(src, dst, srcFront, dstFront)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if val == nil {
+		return nilValue(cmd)
+	}
+
+	return bulkValue(*val)
+}
+
+// parseDirection parses the LEFT and RIGHT arguments, left is the head of the list.
+func parseDirection(arg string) (front bool, ok bool) {
+	switch strings.ToLower(arg) {
+	case "left":
+		return true, true
+	case "right":
+		return false, true
+	}
+
+	return false, false
+}
@@ -0,0 +1,103 @@
+package command
+
+// match reports whether str matches the glob style pattern of the MATCH
+// option like in Redis: * matches any sequence, ? any character, [abc] and
+// [a-z] a character of the class, [^abc] a character out of it, and \
+// escapes the next character.
+func match(pattern, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 1 {
+				return true
+			}
+
+			for i := 0; i <= len(str); i++ {
+				if match(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+
+			str = str[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+
+			var ok bool
+
+			pattern, ok = matchClass(pattern[1:], str[0])
+			if !ok {
+				return false
+			}
+
+			str = str[1:]
+
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+
+			fallthrough
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+
+			str = str[1:]
+		}
+
+		pattern = pattern[1:]
+	}
+
+	return len(str) == 0
+}
+
+// matchClass matches c against the character class at the start of pattern,
+// right after the opening bracket. It returns the pattern after the class.
+func matchClass(pattern string, c byte) (string, bool) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+
+	var matched bool
+
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			pattern = pattern[1:]
+			matched = matched || pattern[0] == c
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			start, end := pattern[0], pattern[2]
+			if start > end {
+				start, end = end, start
+			}
+
+			matched = matched || (c >= start && c <= end)
+			pattern = pattern[2:]
+		default:
+			matched = matched || pattern[0] == c
+		}
+
+		pattern = pattern[1:]
+	}
+
+	// the closing bracket is optional at the end of the pattern
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+
+	return pattern, matched != negate
+}
@@ -0,0 +1,89 @@
+package command
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/raspiantoro/temporama/persistence"
+	"github.com/raspiantoro/temporama/resp"
+)
+
+func Save(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() != "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue("save")
+	}
+
+	err := persistence.Save()
+	if errors.Is(err, persistence.ErrSaveInProgress) {
+		return errorValue("Background save already in progress")
+	}
+
+	if err != nil {
+		return errorValue("%s", err.Error())
+	}
+
+	return okValue()
+}
+
+func BgSave(cmd resp.Command) resp.ValueNode {
+	if len(cmd.Args()) > 0 {
+		return wrongArgsValue("bgsave")
+	}
+
+	schedule := false
+
+	if cmd.Key() != "" {
+		if !strings.EqualFold(cmd.Key(), "schedule") {
+			return syntaxErrorValue()
+		}
+
+		schedule = true
+	}
+
+	scheduled, err := persistence.BackgroundSave(schedule)
+	if errors.Is(err, persistence.ErrSaveInProgress) {
+		return errorValue("Background save already in progress")
+	}
+
+	if err != nil {
+		return errorValue("%s", err.Error())
+	}
+
+	status := "Background saving started"
+	if scheduled {
+		status = "Background saving scheduled"
+	}
+
+	return resp.NewValueNode(
+		resp.ValueNodeTypeSimpleString,
+		resp.WithValue(status),
+	)
+}
+
+func LastSave(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() != "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue("lastsave")
+	}
+
+	return integerValue(persistence.LastSave().Unix())
+}
+
+func BgRewriteAof(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() != "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue("bgrewriteaof")
+	}
+
+	err := persistence.RewriteAppendOnly()
+	if errors.Is(err, persistence.ErrRewriteInProgress) {
+		return errorValue("Background append only file rewriting already in progress")
+	}
+
+	if err != nil {
+		return errorValue("%s", err.Error())
+	}
+
+	return resp.NewValueNode(
+		resp.ValueNodeTypeSimpleString,
+		resp.WithValue("Background append only file rewriting started"),
+	)
+}
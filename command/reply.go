@@ -0,0 +1,123 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/resp"
+	"github.com/raspiantoro/temporama/script"
+)
+
+// codedErrors already start with the redis error code that clients look for,
+// so they are sent without the ERROR prefix.
+var codedErrors = []error{
+	memstore.ErrOOM,
+	memstore.ErrWrongType,
+	memstore.ErrNoGroup,
+	memstore.ErrBusyGroup,
+	memstore.ErrNotHyperLogLog,
+	memstore.ErrHyperLogLogCorrupted,
+	resp.ErrExecAbort,
+	script.ErrNoScript,
+	script.ErrNotBusy,
+	script.ErrUnkillable,
+}
+
+func errorValue(format string, args ...any) resp.ValueNode {
+	return resp.NewValueNode(
+		resp.ValueNodeTypeSimpleError,
+		resp.WithValue(fmt.Sprintf("ERROR: "+format, args...)),
+	)
+}
+
+// storeErrorValue converts an error returned by memstore to a reply.
+func storeErrorValue(err error) resp.ValueNode {
+	for _, coded := range codedErrors {
+		if errors.Is(err, coded) {
+			return resp.NewValueNode(
+				resp.ValueNodeTypeSimpleError,
+				resp.WithValue(err.Error()),
+			)
+		}
+	}
+
+	return errorValue("%s", err.Error())
+}
+
+func wrongArgsValue(name string) resp.ValueNode {
+	return errorValue("wrong arguments number for '%s' command", name)
+}
+
+func syntaxErrorValue() resp.ValueNode {
+	return errorValue("syntax error")
+}
+
+func notIntegerValue() resp.ValueNode {
+	return errorValue("value is not an integer or out of range")
+}
+
+func okValue() resp.ValueNode {
+	return resp.NewValueNode(
+		resp.ValueNodeTypeSimpleString,
+		resp.WithValue("OK"),
+	)
+}
+
+func integerValue(val int64) resp.ValueNode {
+	return resp.NewValueNode(
+		resp.ValueNodeTypeIntegers,
+		resp.WithValue(strconv.FormatInt(val, 10)),
+	)
+}
+
+func boolIntegerValue(val bool) resp.ValueNode {
+	if val {
+		return integerValue(1)
+	}
+
+	return integerValue(0)
+}
+
+func bulkValue(val string) resp.ValueNode {
+	return resp.NewValueNode(
+		resp.ValueNodeTypeBulkString,
+		resp.WithValue(val),
+	)
+}
+
+// nilValue is the reply for a missing value, RESP3 has a dedicated null type
+// while RESP2 uses a nil bulk string.
+func nilValue(cmd resp.Command) resp.ValueNode {
+	if cmd.Proto() == 3 {
+		return resp.NewNullValueNode()
+	}
+
+	return resp.NewValueNode(
+		resp.ValueNodeTypeBulkString,
+		resp.WithNull(),
+	)
+}
+
+// nilArrayValue is the reply for a missing array, RESP2 uses a nil array.
+func nilArrayValue(cmd resp.Command) resp.ValueNode {
+	if cmd.Proto() == 3 {
+		return resp.NewNullValueNode()
+	}
+
+	return resp.NewValueNode(
+		resp.ValueNodeTypeArray,
+		resp.WithNull(),
+	)
+}
+
+func bulkArrayValue(vals []string) resp.ValueNode {
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, val := range vals {
+		response.Append(bulkValue(val))
+	}
+
+	return response
+}
@@ -0,0 +1,145 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/raspiantoro/temporama/resp"
+	"github.com/raspiantoro/temporama/script"
+)
+
+var scriptHelp = []string{
+	"SCRIPT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"EXISTS <sha1> [<sha1> ...]",
+	"    Return information about the existence of the scripts in the script cache.",
+	"FLUSH [ASYNC|SYNC]",
+	"    Flush the Lua scripts cache.",
+	"KILL",
+	"    Kill the currently executing Lua script.",
+	"LOAD <script>",
+	"    Load a script into the scripts cache without executing it.",
+	"HELP",
+	"    Print this help.",
+}
+
+// parseScriptArgs splits the arguments following the script of EVAL and
+// EVALSHA in the keys and the arguments of the script.
+func parseScriptArgs(name string, cmd resp.Command) (keys, args []string, errValue resp.ValueNode, ok bool) {
+	rest := cmd.Args()
+	if cmd.Key() == "" || len(rest) < 1 {
+		return nil, nil, wrongArgsValue(name), false
+	}
+
+	numKeys, err := strconv.ParseInt(rest[0], 10, 64)
+	if err != nil {
+		return nil, nil, notIntegerValue(), false
+	}
+
+	if numKeys < 0 {
+		return nil, nil, errorValue("Number of keys can't be negative"), false
+	}
+
+	if numKeys > int64(len(rest)-1) {
+		return nil, nil, errorValue("Number of keys can't be greater than number of args"), false
+	}
+
+	return rest[1 : numKeys+1], rest[numKeys+1:], resp.ValueNode{}, true
+}
+
+func Eval(cmd resp.Command) resp.ValueNode {
+	keys, args, errValue, ok := parseScriptArgs("eval", cmd)
+	if !ok {
+		return errValue
+	}
+
+	reply, err := script.Eval(cmd, cmd.Key(), keys, args)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return reply
+}
+
+func EvalSha(cmd resp.Command) resp.ValueNode {
+	keys, args, errValue, ok := parseScriptArgs("evalsha", cmd)
+	if !ok {
+		return errValue
+	}
+
+	reply, err := script.EvalSHA(cmd, cmd.Key(), keys, args)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return reply
+}
+
+func Script(cmd resp.Command) resp.ValueNode {
+	// the first argument is the subcommand, not a script
+	sub := strings.ToLower(cmd.Key())
+	args := cmd.Args()
+
+	switch sub {
+	case "help":
+		response := resp.NewValueNode(resp.ValueNodeTypeArray)
+		for _, line := range scriptHelp {
+			response.Append(resp.NewValueNode(
+				resp.ValueNodeTypeSimpleString,
+				resp.WithValue(line),
+			))
+		}
+
+		return response
+	case "load":
+		if len(args) != 1 {
+			return wrongArgsValue("script|load")
+		}
+
+		sha, err := script.Load(args[0])
+		if err != nil {
+			return storeErrorValue(err)
+		}
+
+		return bulkValue(sha)
+	case "exists":
+		if len(args) == 0 {
+			return wrongArgsValue("script|exists")
+		}
+
+		response := resp.NewValueNode(resp.ValueNodeTypeArray)
+		for _, sha := range args {
+			response.Append(boolIntegerValue(script.Exists(sha)))
+		}
+
+		return response
+	case "flush":
+		if len(args) > 1 {
+			return wrongArgsValue("script|flush")
+		}
+
+		// the cache is dropped at once, there is nothing to release
+		// in the background
+		if len(args) == 1 && !strings.EqualFold(args[0], "async") && !strings.EqualFold(args[0], "sync") {
+			return syntaxErrorValue()
+		}
+
+		script.Flush()
+
+		return okValue()
+	case "kill":
+		if len(args) != 0 {
+			return wrongArgsValue("script|kill")
+		}
+
+		err := script.Kill()
+		if err != nil {
+			return storeErrorValue(err)
+		}
+
+		return okValue()
+	case "":
+		return wrongArgsValue("script")
+	}
+
+	return errorValue("unknown subcommand '%s'. Try SCRIPT HELP.", cmd.Key())
+}
@@ -0,0 +1,255 @@
+package command
+
+import (
+	"strconv"
+
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/resp"
+)
+
+// setValue is the reply for the members of a set, RESP3 has a dedicated
+// set type while RESP2 uses an array.
+func setValue(cmd resp.Command, members []string) resp.ValueNode {
+	if cmd.Proto() != 3 {
+		return bulkArrayValue(members)
+	}
+
+	response := resp.NewValueNode(resp.ValueNodeTypeSet)
+
+	for _, member := range members {
+		response.Append(bulkValue(member))
+	}
+
+	return response
+}
+
+func SAdd(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) < 1 {
+		return wrongArgsValue("sadd")
+	}
+
+	added, err := db(cmd).SetAdd(cmd.Key(), cmd.Args()...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(added))
+}
+
+func SRem(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) < 1 {
+		return wrongArgsValue("srem")
+	}
+
+	removed, err := db(cmd).SetRemove(cmd.Key(), cmd.Args()...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(removed))
+}
+
+func SMembers(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue("smembers")
+	}
+
+	members, err := db(cmd).SetMembers(cmd.Key())
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return setValue(cmd, members)
+}
+
+func SIsMember(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 1 {
+		return wrongArgsValue("sismember")
+	}
+
+	found, err := db(cmd).SetIsMember(cmd.Key(), cmd.Args()...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return boolIntegerValue(found[0])
+}
+
+func SMIsMember(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) < 1 {
+		return wrongArgsValue("smismember")
+	}
+
+	found, err := db(cmd).SetIsMember(cmd.Key(), cmd.Args()...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+	for _, ok := range found {
+		response.Append(boolIntegerValue(ok))
+	}
+
+	return response
+}
+
+func SCard(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue("scard")
+	}
+
+	card, err := db(cmd).SetCard(cmd.Key())
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(card))
+}
+
+func SInter(cmd resp.Command) resp.ValueNode {
+	return combine(cmd, "sinter", memstore.SetInter)
+}
+
+func SUnion(cmd resp.Command) resp.ValueNode {
+	return combine(cmd, "sunion", memstore.SetUnion)
+}
+
+func SDiff(cmd resp.Command) resp.ValueNode {
+	return combine(cmd, "sdiff", memstore.SetDiff)
+}
+
+func combine(cmd resp.Command, name string, op memstore.SetOperation) resp.ValueNode {
+	if cmd.Key() == "" {
+		return wrongArgsValue(name)
+	}
+
+	keys := append([]string{cmd.Key()}, cmd.Args()...)
+
+	members, err := db(cmd).SetCombine(op, keys...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return setValue(cmd, members)
+}
+
+func SInterStore(cmd resp.Command) resp.ValueNode {
+	return combineStore(cmd, "sinterstore", memstore.SetInter)
+}
+
+func SUnionStore(cmd resp.Command) resp.ValueNode {
+	return combineStore(cmd, "sunionstore", memstore.SetUnion)
+}
+
+func SDiffStore(cmd resp.Command) resp.ValueNode {
+	return combineStore(cmd, "sdiffstore", memstore.SetDiff)
+}
+
+func combineStore(cmd resp.Command, name string, op memstore.SetOperation) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) < 1 {
+		return wrongArgsValue(name)
+	}
+
+	card, err := db(cmd).SetCombineStore(op, cmd.Key(), cmd.Args()...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(card))
+}
+
+func SPop(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) > 1 {
+		return wrongArgsValue("spop")
+	}
+
+	count := int64(1)
+	withCount := len(args) == 1
+
+	if withCount {
+		var err error
+
+		count, err = strconv.ParseInt(args[0], 10, 64)
+		if err != nil || count < 0 {
+			return errorValue("value is out of range, must be positive")
+		}
+	}
+
+	members, err := db(cmd).SetPop(cmd.Key(), int(count))
+	if err == memstore.ErrNilEntries {
+		if withCount {
+			return setValue(cmd, []string{})
+		}
+
+		return nilValue(cmd)
+	}
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	// the members are chosen at random, replaying SPOP would pop others
+	if len(members) > 0 {
+		cmd.Propagate(append([]string{"SREM", cmd.Key()}, members...))
+	} else {
+		cmd.Propagate()
+	}
+
+	if withCount {
+		return setValue(cmd, members)
+	}
+
+	return bulkValue(members[0])
+}
+
+func SRandMember(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) > 1 {
+		return wrongArgsValue("srandmember")
+	}
+
+	count := int64(1)
+	withCount := len(args) == 1
+
+	if withCount {
+		var err error
+
+		count, err = strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return notIntegerValue()
+		}
+	}
+
+	members, err := db(cmd).SetRandMember(cmd.Key(), int(count))
+	if err == memstore.ErrNilEntries {
+		if withCount {
+			return bulkArrayValue([]string{})
+		}
+
+		return nilValue(cmd)
+	}
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	// a negative count may repeat members, so it's never a set
+	if withCount {
+		return bulkArrayValue(members)
+	}
+
+	return bulkValue(members[0])
+}
+
+func SMove(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 2 {
+		return wrongArgsValue("smove")
+	}
+
+	moved, err := db(cmd).SetMove(cmd.Key(), args[0], args[1])
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return boolIntegerValue(moved)
+}
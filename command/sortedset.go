@@ -0,0 +1,571 @@
+package command
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/resp"
+)
+
+// parseScore parses a score, infinities are accepted but NaN is not.
+func parseScore(arg string) (float64, bool) {
+	score, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+
+	return score, true
+}
+
+// parseScoreBound parses a bound of a score range, it's exclusive when
+// prefixed by an open parenthesis.
+func parseScoreBound(arg string) (score float64, exclusive bool, ok bool) {
+	if strings.HasPrefix(arg, "(") {
+		arg = arg[1:]
+		exclusive = true
+	}
+
+	score, ok = parseScore(arg)
+
+	return score, exclusive, ok
+}
+
+func parseScoreRange(min, max string) (memstore.ScoreRange, resp.ValueNode, bool) {
+	var (
+		r     memstore.ScoreRange
+		okMin bool
+		okMax bool
+	)
+
+	r.Min, r.MinExclusive, okMin = parseScoreBound(min)
+	r.Max, r.MaxExclusive, okMax = parseScoreBound(max)
+
+	if !okMin || !okMax {
+		return r, errorValue("min or max is not a float"), false
+	}
+
+	return r, resp.ValueNode{}, true
+}
+
+// parseLexBound parses a bound of a lex range, - and + are the lowest and
+// the highest members, other bounds start with [ when they are included
+// or ( when they are excluded.
+func parseLexBound(arg string) (memstore.LexBound, bool) {
+	switch {
+	case arg == "-":
+		return memstore.LexBound{Inf: -1}, true
+	case arg == "+":
+		return memstore.LexBound{Inf: 1}, true
+	case strings.HasPrefix(arg, "["):
+		return memstore.LexBound{Value: arg[1:]}, true
+	case strings.HasPrefix(arg, "("):
+		return memstore.LexBound{Value: arg[1:], Exclusive: true}, true
+	}
+
+	return memstore.LexBound{}, false
+}
+
+func parseLexRange(min, max string) (memstore.LexRange, resp.ValueNode, bool) {
+	var (
+		r     memstore.LexRange
+		okMin bool
+		okMax bool
+	)
+
+	r.Min, okMin = parseLexBound(min)
+	r.Max, okMax = parseLexBound(max)
+
+	if !okMin || !okMax {
+		return r, errorValue("min or max not valid string range item"), false
+	}
+
+	return r, resp.ValueNode{}, true
+}
+
+// scoreValue is the reply for a score, RESP3 has a dedicated double type
+// while RESP2 uses a bulk string.
+func scoreValue(cmd resp.Command, score float64) resp.ValueNode {
+	if cmd.Proto() == 3 {
+		return resp.NewDoubleValueNode(score)
+	}
+
+	return bulkValue(resp.FormatDouble(score))
+}
+
+// scoredMembersValue is the reply for the members of a sorted set. With
+// scores RESP2 interleaves the members and the scores in a flat array
+// while RESP3 sends an array of member and score pairs.
+func scoredMembersValue(cmd resp.Command, members []memstore.ScoredMember, withScores bool) resp.ValueNode {
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, m := range members {
+		switch {
+		case !withScores:
+			response.Append(bulkValue(m.Member))
+		case cmd.Proto() == 3:
+			pair := resp.NewValueNode(resp.ValueNodeTypeArray)
+			pair.Append(bulkValue(m.Member))
+			pair.Append(scoreValue(cmd, m.Score))
+			response.Append(pair)
+		default:
+			response.Append(bulkValue(m.Member))
+			response.Append(scoreValue(cmd, m.Score))
+		}
+	}
+
+	return response
+}
+
+func ZAdd(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" {
+		return wrongArgsValue("zadd")
+	}
+
+	var opts memstore.SortedSetAddOptions
+
+options:
+	for len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "nx":
+			opts.NX = true
+		case "xx":
+			opts.XX = true
+		case "gt":
+			opts.GT = true
+		case "lt":
+			opts.LT = true
+		case "ch":
+			opts.CH = true
+		case "incr":
+			opts.Incr = true
+		default:
+			break options
+		}
+
+		args = args[1:]
+	}
+
+	if len(args) == 0 || len(args)%2 != 0 {
+		return syntaxErrorValue()
+	}
+
+	if opts.NX && opts.XX {
+		return errorValue("XX and NX options at the same time are not compatible")
+	}
+
+	if opts.GT && opts.LT || (opts.GT || opts.LT) && opts.NX {
+		return errorValue("GT, LT, and/or NX options at the same time are not compatible")
+	}
+
+	if opts.Incr && len(args) > 2 {
+		return errorValue("INCR option supports a single increment-element pair")
+	}
+
+	members := make([]memstore.ScoredMember, 0, len(args)/2)
+
+	for i := 0; i < len(args); i += 2 {
+		score, ok := parseScore(args[i])
+		if !ok {
+			return errorValue("value is not a valid float")
+		}
+
+		members = append(members, memstore.ScoredMember{Member: args[i+1], Score: score})
+	}
+
+	count, score, err := db(cmd).SortedSetAdd(cmd.Key(), opts, members...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if !opts.Incr {
+		return integerValue(int64(count))
+	}
+
+	if score == nil {
+		return nilValue(cmd)
+	}
+
+	return scoreValue(cmd, *score)
+}
+
+func ZIncrBy(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 2 {
+		return wrongArgsValue("zincrby")
+	}
+
+	incr, ok := parseScore(args[0])
+	if !ok {
+		return errorValue("value is not a valid float")
+	}
+
+	opts := memstore.SortedSetAddOptions{
+		Incr: true,
+	}
+
+	_, score, err := db(cmd).SortedSetAdd(cmd.Key(), opts, memstore.ScoredMember{Member: args[1], Score: incr})
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return scoreValue(cmd, *score)
+}
+
+func ZRem(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) < 1 {
+		return wrongArgsValue("zrem")
+	}
+
+	removed, err := db(cmd).SortedSetRemove(cmd.Key(), cmd.Args()...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(removed))
+}
+
+func ZScore(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 1 {
+		return wrongArgsValue("zscore")
+	}
+
+	score, err := db(cmd).SortedSetScore(cmd.Key(), cmd.Args()[0])
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if score == nil {
+		return nilValue(cmd)
+	}
+
+	return scoreValue(cmd, *score)
+}
+
+func ZCard(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue("zcard")
+	}
+
+	card, err := db(cmd).SortedSetCard(cmd.Key())
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(card))
+}
+
+func ZRank(cmd resp.Command) resp.ValueNode {
+	return zrank(cmd, "zrank", false)
+}
+
+func ZRevRank(cmd resp.Command) resp.ValueNode {
+	return zrank(cmd, "zrevrank", true)
+}
+
+func zrank(cmd resp.Command, name string, reverse bool) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 1 {
+		return wrongArgsValue(name)
+	}
+
+	rank, ok, err := db(cmd).SortedSetRank(cmd.Key(), cmd.Args()[0], reverse)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if !ok {
+		return nilValue(cmd)
+	}
+
+	return integerValue(int64(rank))
+}
+
+func ZRange(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 2 {
+		return wrongArgsValue("zrange")
+	}
+
+	opts := memstore.SortedSetRangeOptions{
+		Count: -1,
+	}
+	withScores := false
+	withLimit := false
+
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "byscore":
+			opts.By = memstore.RangeByScore
+		case "bylex":
+			opts.By = memstore.RangeByLex
+		case "rev":
+			opts.Reverse = true
+		case "withscores":
+			withScores = true
+		case "limit":
+			if i+2 >= len(args) {
+				return syntaxErrorValue()
+			}
+
+			var ok bool
+
+			opts.Offset, opts.Count, ok = parseLimit(args[i+1], args[i+2])
+			if !ok {
+				return notIntegerValue()
+			}
+
+			withLimit = true
+			i += 2
+		default:
+			return syntaxErrorValue()
+		}
+	}
+
+	if withLimit && opts.By == memstore.RangeByRank {
+		return errorValue("syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+
+	if withScores && opts.By == memstore.RangeByLex {
+		return errorValue("syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	// the reversed score and lex ranges are given from max to min
+	min, max := args[0], args[1]
+	if opts.Reverse && opts.By != memstore.RangeByRank {
+		min, max = max, min
+	}
+
+	return zrange(cmd, min, max, opts, withScores)
+}
+
+func ZRangeByScore(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 2 {
+		return wrongArgsValue("zrangebyscore")
+	}
+
+	opts := memstore.SortedSetRangeOptions{
+		By:    memstore.RangeByScore,
+		Count: -1,
+	}
+	withScores := false
+
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "withscores":
+			withScores = true
+		case "limit":
+			if i+2 >= len(args) {
+				return syntaxErrorValue()
+			}
+
+			var ok bool
+
+			opts.Offset, opts.Count, ok = parseLimit(args[i+1], args[i+2])
+			if !ok {
+				return notIntegerValue()
+			}
+
+			i += 2
+		default:
+			return syntaxErrorValue()
+		}
+	}
+
+	return zrange(cmd, args[0], args[1], opts, withScores)
+}
+
+func parseLimit(offsetArg, countArg string) (offset, count int64, ok bool) {
+	offset, err := strconv.ParseInt(offsetArg, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	count, err = strconv.ParseInt(countArg, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return offset, count, true
+}
+
+func zrange(cmd resp.Command, min, max string, opts memstore.SortedSetRangeOptions, withScores bool) resp.ValueNode {
+	var (
+		errValue resp.ValueNode
+		ok       bool
+		err      error
+	)
+
+	switch opts.By {
+	case memstore.RangeByScore:
+		opts.Score, errValue, ok = parseScoreRange(min, max)
+	case memstore.RangeByLex:
+		opts.Lex, errValue, ok = parseLexRange(min, max)
+	default:
+		opts.Start, err = strconv.ParseInt(min, 10, 64)
+		if err == nil {
+			opts.Stop, err = strconv.ParseInt(max, 10, 64)
+		}
+
+		errValue, ok = notIntegerValue(), err == nil
+	}
+
+	if !ok {
+		return errValue
+	}
+
+	members, err := db(cmd).SortedSetRange(cmd.Key(), opts)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return scoredMembersValue(cmd, members, withScores)
+}
+
+func ZCount(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 2 {
+		return wrongArgsValue("zcount")
+	}
+
+	r, errValue, ok := parseScoreRange(args[0], args[1])
+	if !ok {
+		return errValue
+	}
+
+	count, err := db(cmd).SortedSetCount(cmd.Key(), r)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(count))
+}
+
+func ZPopMin(cmd resp.Command) resp.ValueNode {
+	return zpop(cmd, "zpopmin", false)
+}
+
+func ZPopMax(cmd resp.Command) resp.ValueNode {
+	return zpop(cmd, "zpopmax", true)
+}
+
+func zpop(cmd resp.Command, name string, max bool) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) > 1 {
+		return wrongArgsValue(name)
+	}
+
+	count := int64(1)
+	withCount := len(args) == 1
+
+	if withCount {
+		var err error
+
+		count, err = strconv.ParseInt(args[0], 10, 64)
+		if err != nil || count < 0 {
+			return errorValue("value is out of range, must be positive")
+		}
+	}
+
+	members, err := db(cmd).SortedSetPop(cmd.Key(), int(count), max)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	// RESP3 only nests the pairs when a count is given
+	if !withCount && len(members) == 1 {
+		response := resp.NewValueNode(resp.ValueNodeTypeArray)
+		response.Append(bulkValue(members[0].Member))
+		response.Append(scoreValue(cmd, members[0].Score))
+
+		return response
+	}
+
+	return scoredMembersValue(cmd, members, true)
+}
+
+func ZUnionStore(cmd resp.Command) resp.ValueNode {
+	return zcombineStore(cmd, "zunionstore", memstore.SetUnion)
+}
+
+func ZInterStore(cmd resp.Command) resp.ValueNode {
+	return zcombineStore(cmd, "zinterstore", memstore.SetInter)
+}
+
+func zcombineStore(cmd resp.Command, name string, op memstore.SetOperation) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 2 {
+		return wrongArgsValue(name)
+	}
+
+	numKeys, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return notIntegerValue()
+	}
+
+	if numKeys < 1 {
+		return errorValue("at least 1 input key is needed for '%s' command", name)
+	}
+
+	if numKeys > int64(len(args)-1) {
+		return syntaxErrorValue()
+	}
+
+	keys := args[1 : 1+numKeys]
+	args = args[1+numKeys:]
+
+	var (
+		weights   []float64
+		aggregate = memstore.AggregateSum
+	)
+
+	for len(args) > 0 {
+		switch strings.ToLower(args[0]) {
+		case "weights":
+			if int64(len(args)-1) < numKeys {
+				return syntaxErrorValue()
+			}
+
+			weights = make([]float64, numKeys)
+
+			for i := range weights {
+				weight, ok := parseScore(args[1+i])
+				if !ok {
+					return errorValue("weight value is not a float")
+				}
+
+				weights[i] = weight
+			}
+
+			args = args[1+numKeys:]
+		case "aggregate":
+			if len(args) < 2 {
+				return syntaxErrorValue()
+			}
+
+			switch strings.ToLower(args[1]) {
+			case "sum":
+				aggregate = memstore.AggregateSum
+			case "min":
+				aggregate = memstore.AggregateMin
+			case "max":
+				aggregate = memstore.AggregateMax
+			default:
+				return syntaxErrorValue()
+			}
+
+			args = args[2:]
+		default:
+			return syntaxErrorValue()
+		}
+	}
+
+	card, err := db(cmd).SortedSetCombineStore(op, cmd.Key(), keys, weights, aggregate)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(card))
+}
@@ -0,0 +1,1122 @@
+package command
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/resp"
+)
+
+func invalidStreamIDValue() resp.ValueNode {
+	return errorValue("Invalid stream ID specified as stream command argument")
+}
+
+// parseStreamID parses an id given as ms-seq, or as ms alone in which case
+// the sequence is seq.
+func parseStreamID(arg string, seq uint64) (memstore.StreamID, bool) {
+	var (
+		id  memstore.StreamID
+		err error
+	)
+
+	ms, seqArg, withSeq := strings.Cut(arg, "-")
+
+	id.Ms, err = strconv.ParseUint(ms, 10, 64)
+	if err != nil {
+		return id, false
+	}
+
+	id.Seq = seq
+
+	if withSeq {
+		id.Seq, err = strconv.ParseUint(seqArg, 10, 64)
+		if err != nil {
+			return id, false
+		}
+	}
+
+	return id, true
+}
+
+// parseRangeID parses the start or the end of a range of ids. - and + are
+// the lowest and the highest ids, the sequence defaults to the lowest one
+// for the start and to the highest one for the end, and an id prefixed by
+// an open parenthesis is excluded.
+func parseRangeID(arg string, start bool) (memstore.StreamID, resp.ValueNode, bool) {
+	switch arg {
+	case "-":
+		return memstore.StreamID{}, resp.ValueNode{}, true
+	case "+":
+		return memstore.MaxStreamID, resp.ValueNode{}, true
+	}
+
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+	}
+
+	var seq uint64
+	if !start {
+		seq = math.MaxUint64
+	}
+
+	id, ok := parseStreamID(arg, seq)
+	if !ok {
+		return id, invalidStreamIDValue(), false
+	}
+
+	if !exclusive {
+		return id, resp.ValueNode{}, true
+	}
+
+	if start {
+		id, ok = id.Next()
+		if !ok {
+			return id, errorValue("invalid start ID for the interval"), false
+		}
+
+		return id, resp.ValueNode{}, true
+	}
+
+	id, ok = id.Prev()
+	if !ok {
+		return id, errorValue("invalid end ID for the interval"), false
+	}
+
+	return id, resp.ValueNode{}, true
+}
+
+// parseTrim parses the trimming options of XADD and XTRIM from the MAXLEN
+// or MINID strategy, it returns the number of parsed arguments.
+func parseTrim(args []string) (memstore.StreamTrimOptions, int, resp.ValueNode, bool) {
+	var opts memstore.StreamTrimOptions
+
+	if strings.ToLower(args[0]) == "minid" {
+		opts.Strategy = memstore.StreamTrimMinID
+	}
+
+	i := 1
+	if i < len(args) && (args[i] == "=" || args[i] == "~") {
+		opts.Approx = args[i] == "~"
+		i++
+	}
+
+	if i >= len(args) {
+		return opts, i, syntaxErrorValue(), false
+	}
+
+	if opts.Strategy == memstore.StreamTrimMaxLen {
+		maxLen, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil {
+			return opts, i, notIntegerValue(), false
+		}
+
+		if maxLen < 0 {
+			return opts, i, errorValue("The MAXLEN argument must be >= 0."), false
+		}
+
+		opts.MaxLen = maxLen
+	} else {
+		minID, ok := parseStreamID(args[i], 0)
+		if !ok {
+			return opts, i, invalidStreamIDValue(), false
+		}
+
+		opts.MinID = minID
+	}
+
+	i++
+
+	if i+1 < len(args) && strings.ToLower(args[i]) == "limit" {
+		limit, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			return opts, i, notIntegerValue(), false
+		}
+
+		if limit < 0 {
+			return opts, i, errorValue("The LIMIT argument must be >= 0."), false
+		}
+
+		if !opts.Approx {
+			return opts, i, errorValue("syntax error, LIMIT cannot be used without the special ~ option"), false
+		}
+
+		opts.Limit = limit
+		i += 2
+	}
+
+	return opts, i, resp.ValueNode{}, true
+}
+
+// parseCount parses the argument of the COUNT option of the read commands.
+func parseCount(arg string) (int, bool) {
+	count, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	if count < 0 {
+		count = 0
+	}
+
+	if count > math.MaxInt32 {
+		count = math.MaxInt32
+	}
+
+	return int(count), true
+}
+
+// parseBlock parses the timeout of the BLOCK option in milliseconds, zero
+// blocks forever.
+func parseBlock(arg string) (time.Duration, resp.ValueNode, bool) {
+	ms, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || ms > math.MaxInt64/int64(time.Millisecond) {
+		return 0, errorValue("timeout is not an integer or out of range"), false
+	}
+
+	if ms < 0 {
+		return 0, errorValue("timeout is negative"), false
+	}
+
+	return time.Duration(ms) * time.Millisecond, resp.ValueNode{}, true
+}
+
+// entryValue is the reply for a stream entry, an array with the id and the
+// fields. The fields are a nil array when the entry was trimmed.
+func entryValue(cmd resp.Command, entry memstore.StreamEntry) resp.ValueNode {
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+	response.Append(bulkValue(entry.ID.String()))
+
+	if entry.Fields == nil {
+		response.Append(nilArrayValue(cmd))
+	} else {
+		response.Append(bulkArrayValue(entry.Fields))
+	}
+
+	return response
+}
+
+func entriesValue(cmd resp.Command, entries []memstore.StreamEntry) resp.ValueNode {
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, entry := range entries {
+		response.Append(entryValue(cmd, entry))
+	}
+
+	return response
+}
+
+// entryIDsValue is the reply for the ids of the entries.
+func entryIDsValue(entries []memstore.StreamEntry) resp.ValueNode {
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, entry := range entries {
+		response.Append(bulkValue(entry.ID.String()))
+	}
+
+	return response
+}
+
+func streamIDsValue(ids []memstore.StreamID) resp.ValueNode {
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, id := range ids {
+		response.Append(bulkValue(id.String()))
+	}
+
+	return response
+}
+
+// readValue is the reply for the entries read from several streams, RESP3
+// maps the keys to their entries while RESP2 uses an array of key and
+// entries pairs. It's a nil array when nothing was read.
+func readValue(cmd resp.Command, results []memstore.StreamReadResult) resp.ValueNode {
+	if len(results) == 0 {
+		return nilArrayValue(cmd)
+	}
+
+	if cmd.Proto() == 3 {
+		response := resp.NewValueNode(resp.ValueNodeTypeMaps)
+
+		for _, result := range results {
+			response.Append(bulkValue(result.Key))
+			response.Append(entriesValue(cmd, result.Entries))
+		}
+
+		return response
+	}
+
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, result := range results {
+		pair := resp.NewValueNode(resp.ValueNodeTypeArray)
+		pair.Append(bulkValue(result.Key))
+		pair.Append(entriesValue(cmd, result.Entries))
+		response.Append(pair)
+	}
+
+	return response
+}
+
+func XAdd(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" {
+		return wrongArgsValue("xadd")
+	}
+
+	var opts memstore.StreamAddOptions
+
+	i := 0
+
+options:
+	for i < len(args) {
+		switch strings.ToLower(args[i]) {
+		case "nomkstream":
+			opts.NoMkStream = true
+			i++
+		case "maxlen", "minid":
+			trim, n, errReply, ok := parseTrim(args[i:])
+			if !ok {
+				return errReply
+			}
+
+			opts.Trim = &trim
+			i += n
+		default:
+			break options
+		}
+	}
+
+	if i >= len(args) {
+		return wrongArgsValue("xadd")
+	}
+
+	fields := args[i+1:]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return wrongArgsValue("xadd")
+	}
+
+	switch arg := args[i]; {
+	case arg == "*":
+		opts.Auto = true
+	case strings.HasSuffix(arg, "-*"):
+		ms, err := strconv.ParseUint(strings.TrimSuffix(arg, "-*"), 10, 64)
+		if err != nil {
+			return invalidStreamIDValue()
+		}
+
+		opts.ID.Ms = ms
+		opts.AutoSeq = true
+	default:
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return invalidStreamIDValue()
+		}
+
+		opts.ID = id
+	}
+
+	id, ok, err := db(cmd).StreamAdd(cmd.Key(), fields, opts)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if !ok {
+		return nilValue(cmd)
+	}
+
+	// a generated id would be generated again on replay
+	if opts.Auto || opts.AutoSeq {
+		propagation := append([]string{"XADD", cmd.Key()}, args[:i]...)
+		propagation = append(propagation, id.String())
+		cmd.Propagate(append(propagation, fields...))
+	}
+
+	return bulkValue(id.String())
+}
+
+func XTrim(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 2 {
+		return wrongArgsValue("xtrim")
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "maxlen", "minid":
+	default:
+		return syntaxErrorValue()
+	}
+
+	opts, n, errReply, ok := parseTrim(args)
+	if !ok {
+		return errReply
+	}
+
+	if n != len(args) {
+		return syntaxErrorValue()
+	}
+
+	removed, err := db(cmd).StreamTrim(cmd.Key(), opts)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(removed))
+}
+
+func XLen(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue("xlen")
+	}
+
+	length, err := db(cmd).StreamLen(cmd.Key())
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(length))
+}
+
+func XRange(cmd resp.Command) resp.ValueNode {
+	return xrange(cmd, "xrange", false)
+}
+
+func XRevRange(cmd resp.Command) resp.ValueNode {
+	return xrange(cmd, "xrevrange", true)
+}
+
+func xrange(cmd resp.Command, name string, reverse bool) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || (len(args) != 2 && len(args) != 4) {
+		return wrongArgsValue(name)
+	}
+
+	// the reversed range is given from end to start
+	startArg, endArg := args[0], args[1]
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+
+	start, errReply, ok := parseRangeID(startArg, true)
+	if !ok {
+		return errReply
+	}
+
+	end, errReply, ok := parseRangeID(endArg, false)
+	if !ok {
+		return errReply
+	}
+
+	count := -1
+
+	if len(args) == 4 {
+		if strings.ToLower(args[2]) != "count" {
+			return syntaxErrorValue()
+		}
+
+		count, ok = parseCount(args[3])
+		if !ok {
+			return notIntegerValue()
+		}
+
+		if count == 0 {
+			return nilArrayValue(cmd)
+		}
+	}
+
+	entries, err := db(cmd).StreamRange(cmd.Key(), start, end, count, reverse)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return entriesValue(cmd, entries)
+}
+
+// readOptions are the options shared by XREAD and XREADGROUP.
+type readOptions struct {
+	count   int
+	block   bool
+	timeout time.Duration
+	noAck   bool
+	group   string
+	// consumer is the consumer of the group read by XREADGROUP
+	consumer string
+	keys     []string
+	ids      []string
+}
+
+func parseReadOptions(name string, args []string, group bool) (readOptions, resp.ValueNode, bool) {
+	var opts readOptions
+
+	for i := 0; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+
+		switch {
+		case option == "streams":
+			streams := args[i+1:]
+			if len(streams) == 0 || len(streams)%2 != 0 {
+				return opts, errorValue("Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified.", name), false
+			}
+
+			opts.keys = streams[:len(streams)/2]
+			opts.ids = streams[len(streams)/2:]
+
+			if group && opts.group == "" {
+				return opts, errorValue("Missing GROUP option for %s", strings.ToUpper(name)), false
+			}
+
+			return opts, resp.ValueNode{}, true
+		case option == "count" && i+1 < len(args):
+			count, ok := parseCount(args[i+1])
+			if !ok {
+				return opts, notIntegerValue(), false
+			}
+
+			opts.count = count
+			i++
+		case option == "block" && i+1 < len(args):
+			timeout, errReply, ok := parseBlock(args[i+1])
+			if !ok {
+				return opts, errReply, false
+			}
+
+			opts.block = true
+			opts.timeout = timeout
+			i++
+		case option == "group" && group && i+2 < len(args):
+			opts.group = args[i+1]
+			opts.consumer = args[i+2]
+			i += 2
+		case option == "noack" && group:
+			opts.noAck = true
+		default:
+			return opts, syntaxErrorValue(), false
+		}
+	}
+
+	return opts, syntaxErrorValue(), false
+}
+
+func XRead(cmd resp.Command) resp.ValueNode {
+	opts, errReply, ok := parseReadOptions("xread", cmd.Argv()[1:], false)
+	if !ok {
+		return errReply
+	}
+
+	ids := make([]memstore.StreamID, len(opts.ids))
+
+	for i, arg := range opts.ids {
+		// $ reads the entries added after the command is called
+		if arg == "$" {
+			id, err := db(cmd).StreamLastID(opts.keys[i])
+			if err != nil {
+				return storeErrorValue(err)
+			}
+
+			ids[i] = id
+
+			continue
+		}
+
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return invalidStreamIDValue()
+		}
+
+		ids[i] = id
+	}
+
+	try := func() resp.ValueNode {
+		results, err := db(cmd).StreamRead(opts.keys, ids, opts.count)
+		if err != nil {
+			return storeErrorValue(err)
+		}
+
+		return readValue(cmd, results)
+	}
+
+	if !opts.block {
+		return try()
+	}
+
+	return blockAll(cmd, opts.keys, opts.timeout, try)
+}
+
+func XReadGroup(cmd resp.Command) resp.ValueNode {
+	opts, errReply, ok := parseReadOptions("xreadgroup", cmd.Argv()[1:], true)
+	if !ok {
+		return errReply
+	}
+
+	// a nil id reads the entries never delivered to the group
+	ids := make([]*memstore.StreamID, len(opts.ids))
+
+	for i, arg := range opts.ids {
+		if arg == ">" {
+			continue
+		}
+
+		if arg == "$" {
+			return errorValue("The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		}
+
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return invalidStreamIDValue()
+		}
+
+		ids[i] = &id
+	}
+
+	readOpts := memstore.StreamReadGroupOptions{
+		Group:    opts.group,
+		Consumer: opts.consumer,
+		Count:    opts.count,
+		NoAck:    opts.noAck,
+	}
+
+	// reading the history only touches the time the consumer was seen
+	cmd.Propagate()
+
+	try := func() resp.ValueNode {
+		results, err := db(cmd).StreamReadGroup(opts.keys, ids, readOpts)
+		if err != nil {
+			return storeErrorValue(err)
+		}
+
+		cmd.Propagate(readGroupPropagation(readOpts, results)...)
+
+		return readValue(cmd, results)
+	}
+
+	if !opts.block {
+		return try()
+	}
+
+	return blockAll(cmd, opts.keys, opts.timeout, try)
+}
+
+// readGroupPropagation returns the commands replaying the deliveries of
+// XREADGROUP, the entries are claimed at their delivery time since they
+// would be delivered again later on replay.
+func readGroupPropagation(opts memstore.StreamReadGroupOptions, results []memstore.StreamReadResult) [][]string {
+	var cmds [][]string
+
+	for _, result := range results {
+		// only the new deliveries have a delivery time
+		if result.DeliveryTime == 0 || len(result.Entries) == 0 {
+			continue
+		}
+
+		last := result.Entries[len(result.Entries)-1].ID.String()
+
+		if opts.NoAck {
+			cmds = append(cmds, []string{"XGROUP", "SETID", result.Key, opts.Group, last})
+			continue
+		}
+
+		claim := []string{"XCLAIM", result.Key, opts.Group, opts.Consumer, "0"}
+		for _, entry := range result.Entries {
+			claim = append(claim, entry.ID.String())
+		}
+
+		claim = append(claim,
+			"TIME", strconv.FormatInt(result.DeliveryTime, 10),
+			"RETRYCOUNT", "1",
+			"FORCE", "JUSTID",
+			"LASTID", last,
+		)
+
+		cmds = append(cmds, claim)
+	}
+
+	return cmds
+}
+
+func XGroup(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	subcommand := strings.ToLower(cmd.Key())
+
+	switch subcommand {
+	case "create", "setid":
+		if len(args) < 3 {
+			return wrongArgsValue("xgroup|" + subcommand)
+		}
+
+		var mkStream bool
+
+		for i := 3; i < len(args); i++ {
+			switch option := strings.ToLower(args[i]); {
+			case option == "mkstream" && subcommand == "create":
+				mkStream = true
+			case option == "entriesread" && i+1 < len(args):
+				if _, err := strconv.ParseInt(args[i+1], 10, 64); err != nil {
+					return notIntegerValue()
+				}
+
+				i++
+			default:
+				return syntaxErrorValue()
+			}
+		}
+
+		var id memstore.StreamID
+
+		last := args[2] == "$"
+		if !last {
+			var ok bool
+
+			id, ok = parseStreamID(args[2], 0)
+			if !ok {
+				return invalidStreamIDValue()
+			}
+		}
+
+		var err error
+		if subcommand == "create" {
+			err = db(cmd).StreamGroupCreate(args[0], args[1], id, last, mkStream)
+		} else {
+			err = db(cmd).StreamGroupSetID(args[0], args[1], id, last)
+		}
+
+		if err != nil {
+			return storeErrorValue(err)
+		}
+
+		return okValue()
+	case "destroy":
+		if len(args) != 2 {
+			return wrongArgsValue("xgroup|destroy")
+		}
+
+		destroyed, err := db(cmd).StreamGroupDestroy(args[0], args[1])
+		if err != nil {
+			return storeErrorValue(err)
+		}
+
+		return boolIntegerValue(destroyed)
+	case "createconsumer":
+		if len(args) != 3 {
+			return wrongArgsValue("xgroup|createconsumer")
+		}
+
+		created, err := db(cmd).StreamGroupCreateConsumer(args[0], args[1], args[2])
+		if err != nil {
+			return storeErrorValue(err)
+		}
+
+		return boolIntegerValue(created)
+	case "delconsumer":
+		if len(args) != 3 {
+			return wrongArgsValue("xgroup|delconsumer")
+		}
+
+		pending, err := db(cmd).StreamGroupDeleteConsumer(args[0], args[1], args[2])
+		if err != nil {
+			return storeErrorValue(err)
+		}
+
+		return integerValue(int64(pending))
+	case "":
+		return wrongArgsValue("xgroup")
+	}
+
+	return errorValue("unknown subcommand '%s'. Try XGROUP HELP.", cmd.Key())
+}
+
+func XAck(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 2 {
+		return wrongArgsValue("xack")
+	}
+
+	ids := make([]memstore.StreamID, 0, len(args)-1)
+
+	for _, arg := range args[1:] {
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return invalidStreamIDValue()
+		}
+
+		ids = append(ids, id)
+	}
+
+	acked, err := db(cmd).StreamAck(cmd.Key(), args[0], ids...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(acked))
+}
+
+func XPending(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 1 {
+		return wrongArgsValue("xpending")
+	}
+
+	if len(args) == 1 {
+		return xpendingSummary(cmd)
+	}
+
+	var opts memstore.StreamPendingOptions
+
+	i := 1
+	if strings.ToLower(args[i]) == "idle" && i+1 < len(args) {
+		minIdle, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			return notIntegerValue()
+		}
+
+		opts.MinIdle = minIdle
+		i += 2
+	}
+
+	rest := args[i:]
+	if len(rest) != 3 && len(rest) != 4 {
+		return syntaxErrorValue()
+	}
+
+	var (
+		errReply resp.ValueNode
+		ok       bool
+	)
+
+	opts.Start, errReply, ok = parseRangeID(rest[0], true)
+	if !ok {
+		return errReply
+	}
+
+	opts.End, errReply, ok = parseRangeID(rest[1], false)
+	if !ok {
+		return errReply
+	}
+
+	opts.Count, ok = parseCount(rest[2])
+	if !ok {
+		return notIntegerValue()
+	}
+
+	if len(rest) == 4 {
+		opts.Consumer = rest[3]
+	}
+
+	entries, err := db(cmd).StreamPending(cmd.Key(), args[0], opts)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, entry := range entries {
+		item := resp.NewValueNode(resp.ValueNodeTypeArray)
+		item.Append(bulkValue(entry.ID.String()))
+		item.Append(bulkValue(entry.Consumer))
+		item.Append(integerValue(entry.Idle))
+		item.Append(integerValue(entry.DeliveryCount))
+		response.Append(item)
+	}
+
+	return response
+}
+
+func xpendingSummary(cmd resp.Command) resp.ValueNode {
+	summary, err := db(cmd).StreamPendingSummary(cmd.Key(), cmd.Args()[0])
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+	response.Append(integerValue(int64(summary.Count)))
+
+	if summary.Count == 0 {
+		response.Append(nilValue(cmd))
+		response.Append(nilValue(cmd))
+		response.Append(nilArrayValue(cmd))
+
+		return response
+	}
+
+	response.Append(bulkValue(summary.First.String()))
+	response.Append(bulkValue(summary.Last.String()))
+
+	consumers := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, c := range summary.Consumers {
+		// the count is a bulk string like in Redis
+		consumers.Append(bulkArrayValue([]string{c.Name, strconv.Itoa(c.Count)}))
+	}
+
+	response.Append(consumers)
+
+	return response
+}
+
+// parseMinIdle parses the min idle time of XCLAIM and XAUTOCLAIM.
+func parseMinIdle(name, arg string) (int64, resp.ValueNode, bool) {
+	minIdle, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, errorValue("Invalid min-idle-time argument for %s", strings.ToUpper(name)), false
+	}
+
+	if minIdle < 0 {
+		minIdle = 0
+	}
+
+	return minIdle, resp.ValueNode{}, true
+}
+
+// claimReply is the reply for the claimed entries, only their ids with justID.
+func claimReply(cmd resp.Command, entries []memstore.StreamEntry, justID bool) resp.ValueNode {
+	if justID {
+		return entryIDsValue(entries)
+	}
+
+	return entriesValue(cmd, entries)
+}
+
+func XClaim(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 4 {
+		return wrongArgsValue("xclaim")
+	}
+
+	group, consumer := args[0], args[1]
+
+	minIdle, errReply, ok := parseMinIdle("xclaim", args[2])
+	if !ok {
+		return errReply
+	}
+
+	// the ids come first, the options start at the first argument that is
+	// not an id
+	var ids []memstore.StreamID
+
+	i := 3
+	for ; i < len(args); i++ {
+		id, ok := parseStreamID(args[i], 0)
+		if !ok {
+			break
+		}
+
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		return invalidStreamIDValue()
+	}
+
+	var (
+		opts       memstore.StreamClaimOptions
+		retryCount string
+	)
+
+	for ; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+
+		switch {
+		case option == "force":
+			opts.Force = true
+		case option == "justid":
+			opts.JustID = true
+		case option == "idle" && i+1 < len(args):
+			idle, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return errorValue("Invalid IDLE option argument for XCLAIM")
+			}
+
+			opts.DeliveryTime = time.Now().UnixMilli() - idle
+			i++
+		case option == "time" && i+1 < len(args):
+			deliveryTime, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return errorValue("Invalid TIME option argument for XCLAIM")
+			}
+
+			opts.DeliveryTime = deliveryTime
+			i++
+		case option == "retrycount" && i+1 < len(args):
+			count, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return errorValue("Invalid RETRYCOUNT option argument for XCLAIM")
+			}
+
+			opts.RetryCount = &count
+			retryCount = args[i+1]
+			i++
+		case option == "lastid" && i+1 < len(args):
+			id, ok := parseStreamID(args[i+1], 0)
+			if !ok {
+				return invalidStreamIDValue()
+			}
+
+			opts.LastID = &id
+			i++
+		default:
+			return errorValue("Unrecognized XCLAIM option '%s'", args[i])
+		}
+	}
+
+	result, err := db(cmd).StreamClaim(cmd.Key(), group, consumer, minIdle, ids, opts)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	// the entries claimed depend on the time, so they are claimed again
+	// whatever their idle time
+	var extra []string
+
+	if retryCount != "" {
+		extra = append(extra, "RETRYCOUNT", retryCount)
+	}
+
+	if opts.Force {
+		extra = append(extra, "FORCE")
+	}
+
+	if opts.JustID {
+		extra = append(extra, "JUSTID")
+	}
+
+	if opts.LastID != nil {
+		extra = append(extra, "LASTID", opts.LastID.String())
+	}
+
+	cmd.Propagate(claimPropagation(cmd.Key(), group, consumer, result, extra)...)
+
+	return claimReply(cmd, result.Claimed, opts.JustID)
+}
+
+func XAutoClaim(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 4 {
+		return wrongArgsValue("xautoclaim")
+	}
+
+	group, consumer := args[0], args[1]
+
+	minIdle, errReply, ok := parseMinIdle("xautoclaim", args[2])
+	if !ok {
+		return errReply
+	}
+
+	start, errReply, ok := parseRangeID(args[3], true)
+	if !ok {
+		return errReply
+	}
+
+	var justID bool
+
+	count := int64(100)
+
+	for i := 4; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+
+		switch {
+		case option == "justid":
+			justID = true
+		case option == "count" && i+1 < len(args):
+			var err error
+
+			count, err = strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return notIntegerValue()
+			}
+
+			// the scan of a call is bounded to ten times count
+			if count < 1 || count > math.MaxInt32/10 {
+				return errorValue("COUNT must be > 0")
+			}
+
+			i++
+		default:
+			return syntaxErrorValue()
+		}
+	}
+
+	result, err := db(cmd).StreamAutoClaim(cmd.Key(), group, consumer, minIdle, start, int(count), justID)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	var extra []string
+	if justID {
+		extra = append(extra, "JUSTID")
+	}
+
+	cmd.Propagate(claimPropagation(cmd.Key(), group, consumer, result, extra)...)
+
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+	response.Append(bulkValue(result.Next.String()))
+	response.Append(claimReply(cmd, result.Claimed, justID))
+	response.Append(streamIDsValue(result.Deleted))
+
+	return response
+}
+
+// claimPropagation returns the commands replaying a claim, an XCLAIM of
+// the claimed entries at their delivery time followed by extra options and
+// an XACK of the pending entries removed since they were trimmed.
+func claimPropagation(key, group, consumer string, result memstore.StreamClaimResult, extra []string) [][]string {
+	var cmds [][]string
+
+	if len(result.Claimed) > 0 {
+		claim := []string{"XCLAIM", key, group, consumer, "0"}
+		for _, entry := range result.Claimed {
+			claim = append(claim, entry.ID.String())
+		}
+
+		claim = append(claim, "TIME", strconv.FormatInt(result.DeliveryTime, 10))
+		cmds = append(cmds, append(claim, extra...))
+	}
+
+	if len(result.Deleted) > 0 {
+		ack := []string{"XACK", key, group}
+		for _, id := range result.Deleted {
+			ack = append(ack, id.String())
+		}
+
+		cmds = append(cmds, ack)
+	}
+
+	return cmds
+}
+
+func XSetID(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) < 1 {
+		return wrongArgsValue("xsetid")
+	}
+
+	id, ok := parseStreamID(args[0], 0)
+	if !ok {
+		return invalidStreamIDValue()
+	}
+
+	// the counters of the options are not tracked, they are only validated
+	for i := 1; i < len(args); i++ {
+		switch option := strings.ToLower(args[i]); {
+		case option == "entriesadded" && i+1 < len(args):
+			if _, err := strconv.ParseInt(args[i+1], 10, 64); err != nil {
+				return notIntegerValue()
+			}
+		case option == "maxdeletedid" && i+1 < len(args):
+			if _, ok := parseStreamID(args[i+1], 0); !ok {
+				return invalidStreamIDValue()
+			}
+		default:
+			return syntaxErrorValue()
+		}
+
+		i++
+	}
+
+	if err := db(cmd).StreamSetID(cmd.Key(), id); err != nil {
+		return storeErrorValue(err)
+	}
+
+	return okValue()
+}
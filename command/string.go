@@ -0,0 +1,341 @@
+package command
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/resp"
+)
+
+func Incr(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue("incr")
+	}
+
+	return incrBy(cmd, 1)
+}
+
+func Decr(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue("decr")
+	}
+
+	return incrBy(cmd, -1)
+}
+
+func IncrBy(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 1 {
+		return wrongArgsValue("incrby")
+	}
+
+	delta, err := strconv.ParseInt(cmd.Args()[0], 10, 64)
+	if err != nil {
+		return notIntegerValue()
+	}
+
+	return incrBy(cmd, delta)
+}
+
+func DecrBy(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 1 {
+		return wrongArgsValue("decrby")
+	}
+
+	delta, err := strconv.ParseInt(cmd.Args()[0], 10, 64)
+	if err != nil {
+		return notIntegerValue()
+	}
+
+	if delta == math.MinInt64 {
+		return errorValue("decrement would overflow")
+	}
+
+	return incrBy(cmd, -delta)
+}
+
+func incrBy(cmd resp.Command, delta int64) resp.ValueNode {
+	result, err := db(cmd).IncrBy(cmd.Key(), delta)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(result)
+}
+
+func IncrByFloat(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 1 {
+		return wrongArgsValue("incrbyfloat")
+	}
+
+	delta, err := strconv.ParseFloat(cmd.Args()[0], 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return errorValue("value is not a valid float")
+	}
+
+	result, err := db(cmd).IncrByFloat(cmd.Key(), delta)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	// the result is stored as it is, so replaying doesn't depend on the
+	// rounding of the float operations
+	cmd.Propagate([]string{"SET", cmd.Key(), result, "KEEPTTL"})
+
+	return bulkValue(result)
+}
+
+func Append(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 1 {
+		return wrongArgsValue("append")
+	}
+
+	length, err := db(cmd).Append(cmd.Key(), cmd.Args()[0])
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(length))
+}
+
+func StrLen(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue("strlen")
+	}
+
+	length, err := db(cmd).StrLen(cmd.Key())
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(length))
+}
+
+func GetRange(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 2 {
+		return wrongArgsValue("getrange")
+	}
+
+	start, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return notIntegerValue()
+	}
+
+	end, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return notIntegerValue()
+	}
+
+	result, err := db(cmd).GetRange(cmd.Key(), start, end)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return bulkValue(result)
+}
+
+func SetRange(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" || len(args) != 2 {
+		return wrongArgsValue("setrange")
+	}
+
+	offset, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return notIntegerValue()
+	}
+
+	if offset < 0 {
+		return errorValue("offset is out of range")
+	}
+
+	length, err := db(cmd).SetRange(cmd.Key(), offset, args[1])
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return integerValue(int64(length))
+}
+
+func MGet(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" {
+		return wrongArgsValue("mget")
+	}
+
+	keys := append([]string{cmd.Key()}, cmd.Args()...)
+
+	vals, err := db(cmd).GetStrings(keys...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, val := range vals {
+		if val == nil {
+			response.Append(nilValue(cmd))
+			continue
+		}
+
+		response.Append(bulkValue(*val))
+	}
+
+	return response
+}
+
+func MSet(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args())%2 != 1 {
+		return wrongArgsValue("mset")
+	}
+
+	_, err := db(cmd).SetStrings(false, cmd.Argv()[1:]...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return okValue()
+}
+
+func MSetNX(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args())%2 != 1 {
+		return wrongArgsValue("msetnx")
+	}
+
+	ok, err := db(cmd).SetStrings(true, cmd.Argv()[1:]...)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return boolIntegerValue(ok)
+}
+
+func GetSet(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 1 {
+		return wrongArgsValue("getset")
+	}
+
+	prev, _, err := db(cmd).SetString(cmd.Key(), cmd.Args()[0], memstore.SetOptions{Get: true})
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if prev == nil {
+		return nilValue(cmd)
+	}
+
+	return bulkValue(*prev)
+}
+
+func SetNX(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) != 1 {
+		return wrongArgsValue("setnx")
+	}
+
+	_, ok, err := db(cmd).SetString(cmd.Key(), cmd.Args()[0], memstore.SetOptions{NX: true})
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	return boolIntegerValue(ok)
+}
+
+func GetDel(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" || len(cmd.Args()) > 0 {
+		return wrongArgsValue("getdel")
+	}
+
+	val, err := db(cmd).GetDel(cmd.Key())
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if val == nil {
+		cmd.Propagate()
+		return nilValue(cmd)
+	}
+
+	cmd.Propagate([]string{"DEL", cmd.Key()})
+
+	return bulkValue(*val)
+}
+
+func GetEx(cmd resp.Command) resp.ValueNode {
+	args := cmd.Args()
+	if cmd.Key() == "" {
+		return wrongArgsValue("getex")
+	}
+
+	var (
+		opts    memstore.GetExOptions
+		hasOpts bool
+	)
+
+	for i := 0; i < len(args); i++ {
+		if hasOpts {
+			return syntaxErrorValue()
+		}
+
+		opt := strings.ToLower(args[i])
+
+		switch opt {
+		case "persist":
+			opts.Persist = true
+		case "ex", "px", "exat", "pxat":
+			if i+1 >= len(args) {
+				return syntaxErrorValue()
+			}
+
+			i++
+
+			val, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return notIntegerValue()
+			}
+
+			if val <= 0 {
+				return errorValue("invalid expire time in 'getex' command")
+			}
+
+			at, ok := expireAt(val, opt == "ex" || opt == "exat", opt == "exat" || opt == "pxat")
+			if !ok {
+				return errorValue("invalid expire time in 'getex' command")
+			}
+
+			opts.ExpireAt = at
+		default:
+			return syntaxErrorValue()
+		}
+
+		hasOpts = true
+	}
+
+	val, err := db(cmd).GetEx(cmd.Key(), opts)
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	if val == nil {
+		cmd.Propagate()
+		return nilValue(cmd)
+	}
+
+	// the relative expirations are stored as absolute ones, like EXPIRE
+	switch {
+	case opts.Persist:
+		cmd.Propagate([]string{"PERSIST", cmd.Key()})
+	case opts.ExpireAt != 0:
+		at, err := db(cmd).ExpireTime(cmd.Key())
+		if err != nil || at == 0 {
+			cmd.Propagate([]string{"DEL", cmd.Key()})
+			break
+		}
+
+		cmd.Propagate([]string{"PEXPIREAT", cmd.Key(), strconv.FormatInt(at, 10)})
+	default:
+		cmd.Propagate()
+	}
+
+	return bulkValue(*val)
+}
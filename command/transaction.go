@@ -0,0 +1,94 @@
+package command
+
+import (
+	"github.com/raspiantoro/temporama/resp"
+)
+
+func Multi(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() != "" {
+		return wrongArgsValue("multi")
+	}
+
+	err := cmd.Multi()
+	if err != nil {
+		return errorValue("%s", err.Error())
+	}
+
+	return okValue()
+}
+
+func Exec(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() != "" {
+		return wrongArgsValue("exec")
+	}
+
+	replies, err := cmd.Exec()
+	if err != nil {
+		return storeErrorValue(err)
+	}
+
+	// a watched key was modified
+	if replies == nil {
+		return nilArrayValue(cmd)
+	}
+
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+	for _, reply := range replies {
+		response.Append(reply)
+	}
+
+	return response
+}
+
+func Discard(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() != "" {
+		return wrongArgsValue("discard")
+	}
+
+	if !cmd.Discard() {
+		return errorValue("DISCARD without MULTI")
+	}
+
+	return okValue()
+}
+
+// Watch records the version of the keys, EXEC fails when one of them
+// has another version by then.
+func Watch(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() == "" {
+		return wrongArgsValue("watch")
+	}
+
+	if cmd.InMulti() {
+		return errorValue("WATCH inside MULTI is not allowed")
+	}
+
+	// the keys stay watched in the database selected now
+	db := db(cmd)
+
+	for _, key := range append([]string{cmd.Key()}, cmd.Args()...) {
+		key := key
+
+		version, err := db.Version(key)
+		if err != nil {
+			return storeErrorValue(err)
+		}
+
+		cmd.Watch(func() bool {
+			current, err := db.Version(key)
+			return err != nil || current != version
+		})
+	}
+
+	return okValue()
+}
+
+func Unwatch(cmd resp.Command) resp.ValueNode {
+	if cmd.Key() != "" {
+		return wrongArgsValue("unwatch")
+	}
+
+	cmd.Unwatch()
+
+	return okValue()
+}
@@ -0,0 +1,135 @@
+package command
+
+import (
+	"testing"
+	"time"
+
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/resp"
+)
+
+// client sends commands to mux as a single connection.
+type client struct {
+	mux  *resp.Mux
+	conn *resp.Connection
+}
+
+func newClient(mux *resp.Mux) *client {
+	return &client{
+		mux:  mux,
+		conn: resp.NewConnection(nil),
+	}
+}
+
+func (c *client) do(t *testing.T, name string, args ...string) resp.ValueNode {
+	t.Helper()
+
+	reply := c.mux.Serve(resp.NewCommand(name, c.conn, args...))
+	if reply.IsError() {
+		t.Fatalf("%s %v: %s", name, args, reply.Text())
+	}
+
+	return reply
+}
+
+// exec runs the commands in a transaction and reports whether EXEC ran
+// them.
+func (c *client) exec(t *testing.T, cmds ...[]string) bool {
+	t.Helper()
+
+	c.do(t, "multi")
+
+	for _, cmd := range cmds {
+		c.do(t, cmd[0], cmd[1:]...)
+	}
+
+	reply := c.do(t, "exec")
+	if reply.IsNull() {
+		return false
+	}
+
+	if len(reply.Nodes()) != len(cmds) {
+		t.Fatalf("EXEC returned %d replies, want %d", len(reply.Nodes()), len(cmds))
+	}
+
+	return true
+}
+
+func TestWatch(t *testing.T) {
+	memstore.FlushAll()
+
+	mux := Registers()
+	c, other := newClient(mux), newClient(mux)
+
+	tests := []struct {
+		name string
+		// modify runs between WATCH and EXEC
+		modify  func(t *testing.T)
+		aborted bool
+	}{
+		{
+			name:   "untouched",
+			modify: func(t *testing.T) {},
+		},
+		{
+			name: "modified",
+			modify: func(t *testing.T) {
+				other.do(t, "set", "key", "1")
+			},
+			aborted: true,
+		},
+		{
+			name: "deleted",
+			modify: func(t *testing.T) {
+				other.do(t, "set", "key", "1")
+				other.do(t, "del", "key")
+			},
+			aborted: true,
+		},
+		{
+			// the key expires before anybody reads it again
+			name: "created and expired",
+			modify: func(t *testing.T) {
+				other.do(t, "set", "key", "1", "px", "1")
+				time.Sleep(5 * time.Millisecond)
+			},
+			aborted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other.do(t, "del", "key")
+
+			c.do(t, "watch", "key")
+			tt.modify(t)
+
+			if ran := c.exec(t, []string{"ping"}); ran == tt.aborted {
+				t.Errorf("EXEC ran the transaction: %t, want %t", ran, !tt.aborted)
+			}
+		})
+	}
+}
+
+func TestWatchExpiredKey(t *testing.T) {
+	memstore.FlushAll()
+
+	c := newClient(Registers())
+
+	// a watched key expiring before EXEC aborts the transaction
+	c.do(t, "set", "key", "1", "px", "20")
+	c.do(t, "watch", "key")
+
+	time.Sleep(30 * time.Millisecond)
+
+	if c.exec(t, []string{"ping"}) {
+		t.Error("EXEC ran the transaction after the watched key expired")
+	}
+
+	// the key was already expired when it was watched
+	c.do(t, "watch", "key")
+
+	if !c.exec(t, []string{"ping"}) {
+		t.Error("EXEC aborted the transaction of an expired key")
+	}
+}
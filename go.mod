@@ -2,4 +2,7 @@ module github.com/raspiantoro/temporama
 
 go 1.20
 
-require github.com/joho/godotenv v1.5.1
+require (
+	github.com/joho/godotenv v1.5.1
+	github.com/yuin/gopher-lua v1.1.1
+)
@@ -3,11 +3,16 @@ package main
 import (
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/raspiantoro/temporama/command"
-	_ "github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/persistence"
 	"github.com/raspiantoro/temporama/resp"
+	"github.com/raspiantoro/temporama/script"
+	"github.com/raspiantoro/temporama/tools/env"
 )
 
 func main() {
@@ -18,14 +23,90 @@ func main() {
 		port = "6379"
 	}
 
+	databases, err := env.GetUint32("DATABASES")
+	if err == nil {
+		memstore.SetDatabases(int(databases))
+	}
+
+	maxMemory, err := env.GetBytes("MAXMEMORY")
+	if err == nil {
+		memstore.SetMaxMemory(maxMemory)
+	}
+
+	if name := os.Getenv("MAXMEMORY_POLICY"); name != "" {
+		policy, err := memstore.ParseEvictionPolicy(name)
+		if err != nil {
+			log.Fatalln("invalid MAXMEMORY_POLICY: ", err)
+			return
+		}
+
+		memstore.SetEvictionPolicy(policy)
+	}
+
+	samples, err := env.GetUint32("MAXMEMORY_SAMPLES")
+	if err == nil {
+		memstore.SetMaxMemorySamples(int(samples))
+	}
+
+	timeLimit, err := env.GetUint32("LUA_TIME_LIMIT")
+	if err == nil {
+		script.SetTimeLimit(time.Duration(timeLimit) * time.Millisecond)
+	}
+
+	rules, err := persistence.ParseSaveRules(persistence.DefaultSaveRules)
+	if save, ok := os.LookupEnv("SAVE"); ok {
+		rules, err = persistence.ParseSaveRules(save)
+	}
+
+	if err != nil {
+		log.Fatalln("invalid SAVE: ", err)
+		return
+	}
+
+	fsync := persistence.FsyncEverySec
+	if name := os.Getenv("APPENDFSYNC"); name != "" {
+		fsync, err = persistence.ParseFsyncPolicy(name)
+		if err != nil {
+			log.Fatalln("invalid APPENDFSYNC: ", err)
+			return
+		}
+	}
+
+	persistence.Configure(persistence.Config{
+		Dir:            os.Getenv("DIR"),
+		DBFilename:     os.Getenv("DBFILENAME"),
+		SaveRules:      rules,
+		AppendOnly:     strings.EqualFold(os.Getenv("APPENDONLY"), "yes"),
+		AppendFilename: os.Getenv("APPENDFILENAME"),
+		AppendFsync:    fsync,
+		LoadTruncated:  !strings.EqualFold(os.Getenv("AOF_LOAD_TRUNCATED"), "no"),
+	})
+
+	mux := command.Registers()
+
+	// the data is restored before accepting connections, so clients
+	// never see a partially loaded keyspace
+	err = persistence.Load(mux)
+	if err != nil {
+		log.Fatalln("failed to load data: ", err)
+		return
+	}
+
+	persistence.Start()
+
 	server := resp.NewServer("0.0.0.0", port)
-	server.Handler(command.Registers())
+	server.Handler(mux)
 
-	err := server.ServeAndListen()
+	err = server.ServeAndListen()
 	if err != nil {
 		log.Fatalln("failed to listen to network address: ", err)
 		return
 	}
 
+	err = persistence.Shutdown()
+	if err != nil {
+		log.Println("failed to save snapshot: ", err)
+	}
+
 	log.Println("bye")
 }
@@ -0,0 +1,578 @@
+package memstore
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// BitMaxOffset is the biggest bit offset, it keeps the string within
+	// stringMaxSize
+	BitMaxOffset = stringMaxSize*8 - 1
+)
+
+// BitRange selects a part of a string, both offsets are included and the
+// negative ones are counted from the end like in GETRANGE.
+type BitRange struct {
+	Start int64
+	End   int64
+	// Bits tells that the offsets are in bits instead of bytes
+	Bits bool
+}
+
+// BitOperation is the operation applied by BitOp.
+type BitOperation int
+
+const (
+	BitAnd BitOperation = iota
+	BitOr
+	BitXor
+	BitNot
+)
+
+// BitFieldOpType is the kind of a BITFIELD operation.
+type BitFieldOpType int
+
+const (
+	BitFieldGet BitFieldOpType = iota
+	BitFieldSet
+	BitFieldIncrBy
+)
+
+// BitFieldOverflow is the behavior of BITFIELD when SET or INCRBY overflows.
+type BitFieldOverflow int
+
+const (
+	// BitFieldWrap wraps around, like the integers of most languages
+	BitFieldWrap BitFieldOverflow = iota
+	// BitFieldSat saturates to the minimum or the maximum value
+	BitFieldSat
+	// BitFieldFail doesn't change the field and returns nil
+	BitFieldFail
+)
+
+// BitFieldOp is an operation of BITFIELD on an integer of Width bits at the
+// bit Offset. Value is the new value of SET or the increment of INCRBY.
+type BitFieldOp struct {
+	Type     BitFieldOpType
+	Signed   bool
+	Width    uint
+	Offset   int64
+	Value    int64
+	Overflow BitFieldOverflow
+}
+
+// getBit returns the bit at offset, the bits past the end are zero.
+func (v *valueString) getBit(offset int64) int {
+	i := offset >> 3
+	if i >= int64(len(v.val)) {
+		return 0
+	}
+
+	return int(v.val[i]>>(7-uint(offset&7))) & 1
+}
+
+// setBits applies fn to the bytes of the string, which is padded with zero
+// bytes to hold at least n bytes.
+func (v *valueString) setBits(n int64, fn func(buf []byte)) {
+	buf := []byte(v.val)
+
+	if n > int64(len(buf)) {
+		buf = append(buf, make([]byte, n-int64(len(buf)))...)
+	}
+
+	fn(buf)
+	v.val = string(buf)
+}
+
+// setBit sets the bit at offset and returns its previous value.
+func (v *valueString) setBit(offset int64, bit int) int {
+	prev := v.getBit(offset)
+
+	v.setBits(offset>>3+1, func(buf []byte) {
+		mask := byte(1) << (7 - uint(offset&7))
+
+		if bit == 1 {
+			buf[offset>>3] |= mask
+		} else {
+			buf[offset>>3] &^= mask
+		}
+	})
+
+	return prev
+}
+
+// bitRange returns the first and the last bit selected by r, ok is false
+// when the range is empty.
+func (v *valueString) bitRange(r *BitRange) (start, end int64, ok bool) {
+	length := int64(len(v.val))
+	if r == nil {
+		return 0, length*8 - 1, length > 0
+	}
+
+	if r.Bits {
+		length *= 8
+	}
+
+	start, end = r.Start, r.End
+
+	if start < 0 {
+		start += length
+	}
+
+	if end < 0 {
+		end += length
+	}
+
+	if start < 0 {
+		start = 0
+	}
+
+	if end < 0 {
+		end = 0
+	}
+
+	if end >= length {
+		end = length - 1
+	}
+
+	if start > end {
+		return 0, 0, false
+	}
+
+	if !r.Bits {
+		start, end = start*8, end*8+7
+	}
+
+	return start, end, true
+}
+
+// bitCount counts the bits set from start to end, both included.
+func (v *valueString) bitCount(start, end int64) int64 {
+	var count int64
+
+	for start <= end && start&7 != 0 {
+		count += int64(v.getBit(start))
+		start++
+	}
+
+	for ; start+7 <= end; start += 8 {
+		count += int64(bits.OnesCount8(v.val[start>>3]))
+	}
+
+	for ; start <= end; start++ {
+		count += int64(v.getBit(start))
+	}
+
+	return count
+}
+
+// bitPos returns the offset of the first bit equal to bit from start to
+// end, -1 when there is none.
+func (v *valueString) bitPos(bit int, start, end int64) int64 {
+	// the bytes without the searched bit are skipped
+	skip := byte(0)
+	if bit == 0 {
+		skip = 0xff
+	}
+
+	for start <= end {
+		if start&7 == 0 && start+7 <= end && v.val[start>>3] == skip {
+			start += 8
+			continue
+		}
+
+		if v.getBit(start) == bit {
+			return start
+		}
+
+		start++
+	}
+
+	return -1
+}
+
+// getField returns the unsigned integer of width bits at offset.
+func (v *valueString) getField(offset int64, width uint) uint64 {
+	var val uint64
+
+	for i := int64(0); i < int64(width); i++ {
+		val = val<<1 | uint64(v.getBit(offset+i))
+	}
+
+	return val
+}
+
+// setField stores the width low bits of val at offset.
+func (v *valueString) setField(offset int64, width uint, val uint64) {
+	end := offset + int64(width) - 1
+
+	v.setBits(end>>3+1, func(buf []byte) {
+		for i := end; i >= offset; i-- {
+			mask := byte(1) << (7 - uint(i&7))
+
+			if val&1 == 1 {
+				buf[i>>3] |= mask
+			} else {
+				buf[i>>3] &^= mask
+			}
+
+			val >>= 1
+		}
+	})
+}
+
+// bitField runs op on the string, the result is nil when op failed with
+// the FAIL overflow.
+func (v *valueString) bitField(op BitFieldOp) *int64 {
+	raw := v.getField(op.Offset, op.Width)
+
+	old := int64(raw)
+	if op.Signed && op.Width < 64 && raw>>(op.Width-1) == 1 {
+		old = int64(raw | math.MaxUint64<<op.Width)
+	}
+
+	if op.Type == BitFieldGet {
+		return &old
+	}
+
+	value, incr := op.Value, int64(0)
+	if op.Type == BitFieldIncrBy {
+		value, incr = old, op.Value
+	}
+
+	var (
+		result   int64
+		overflow bool
+	)
+
+	if op.Signed {
+		result, overflow = signedField(value, incr, op.Width, op.Overflow)
+	} else {
+		result, overflow = unsignedField(value, incr, op.Width, op.Overflow)
+	}
+
+	if overflow && op.Overflow == BitFieldFail {
+		return nil
+	}
+
+	v.setField(op.Offset, op.Width, uint64(result))
+
+	// SET returns the previous value while INCRBY returns the new one
+	if op.Type == BitFieldSet {
+		return &old
+	}
+
+	return &result
+}
+
+// signedField adds incr to value as a signed integer of width bits and
+// reports whether it overflowed, the result follows the overflow mode.
+func signedField(value, incr int64, width uint, mode BitFieldOverflow) (int64, bool) {
+	max := int64(math.MaxInt64)
+	if width < 64 {
+		max = 1<<(width-1) - 1
+	}
+
+	min := -max - 1
+
+	var positive, negative bool
+
+	switch {
+	case value > max || incr > 0 && value > max-incr:
+		positive = true
+	case value < min || incr < 0 && value < min-incr:
+		negative = true
+	}
+
+	if !positive && !negative {
+		return value + incr, false
+	}
+
+	if mode == BitFieldSat {
+		if positive {
+			return max, true
+		}
+
+		return min, true
+	}
+
+	// the sum is computed modulo 2^64 and then sign extended from width bits
+	sum := uint64(value) + uint64(incr)
+	if width < 64 {
+		if sum>>(width-1)&1 == 1 {
+			sum |= math.MaxUint64 << width
+		} else {
+			sum &^= math.MaxUint64 << width
+		}
+	}
+
+	return int64(sum), true
+}
+
+// unsignedField adds incr to value as an unsigned integer of width bits and
+// reports whether it overflowed, the result follows the overflow mode.
+func unsignedField(value, incr int64, width uint, mode BitFieldOverflow) (int64, bool) {
+	max := uint64(1)<<width - 1
+
+	var positive, negative bool
+
+	switch {
+	case value < 0 && incr == 0:
+		// a negative value given to SET is a huge unsigned one
+		positive = true
+	case uint64(value) > max || incr > 0 && uint64(incr) > max-uint64(value):
+		positive = true
+	case incr < 0 && uint64(-incr) > uint64(value):
+		negative = true
+	}
+
+	if !positive && !negative {
+		return value + incr, false
+	}
+
+	if mode == BitFieldSat {
+		if positive {
+			return int64(max), true
+		}
+
+		return 0, true
+	}
+
+	return int64((uint64(value) + uint64(incr)) & max), true
+}
+
+// SetBit sets or clears the bit at offset of the string stored at key and
+// returns the previous bit. The string grows with zero bytes as needed.
+func (db *DB) SetBit(key string, offset int64, bit int) (int, error) {
+	var prev int
+
+	err := db.write([]string{key}, func(t *txn) error {
+		str, err := t.lookupString(key)
+		if err != nil {
+			return err
+		}
+
+		if str == nil {
+			str = &valueString{}
+		}
+
+		prev = str.setBit(offset, bit)
+		t.storeString(key, str)
+
+		return nil
+	})
+
+	return prev, err
+}
+
+// GetBit returns the bit at offset of the string stored at key, the bits
+// past the end and the ones of a missing key are zero.
+func (db *DB) GetBit(key string, offset int64) (int, error) {
+	var bit int
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		str, err := t.lookupString(key)
+		if err != nil || str == nil {
+			return err
+		}
+
+		bit = str.getBit(offset)
+
+		return nil
+	})
+
+	return bit, err
+}
+
+// BitCount counts the bits set in the range r of the string stored at
+// key, a nil range counts the whole string.
+func (db *DB) BitCount(key string, r *BitRange) (int64, error) {
+	var count int64
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		str, err := t.lookupString(key)
+		if err != nil || str == nil {
+			return err
+		}
+
+		start, end, ok := str.bitRange(r)
+		if ok {
+			count = str.bitCount(start, end)
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// BitPos returns the offset of the first bit equal to bit in the range r
+// of the string stored at key, a nil range searches the whole string. When
+// looking for a clear bit and the range has no end, the bits past the end
+// of the string count as clear. -1 is returned when there is no such bit.
+func (db *DB) BitPos(key string, bit int, r *BitRange, hasEnd bool) (int64, error) {
+	pos := int64(-1)
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		str, err := t.lookupString(key)
+		if err != nil {
+			return err
+		}
+
+		if str == nil {
+			if bit == 0 {
+				pos = 0
+			}
+
+			return nil
+		}
+
+		start, end, ok := str.bitRange(r)
+		if !ok {
+			return nil
+		}
+
+		pos = str.bitPos(bit, start, end)
+		if pos == -1 && bit == 0 && !hasEnd {
+			pos = end + 1
+		}
+
+		return nil
+	})
+
+	return pos, err
+}
+
+// BitOp stores the result of op over the strings stored at keys in dest
+// and returns its length, the missing keys count as strings of zero bytes
+// and the shorter strings are padded with zero bytes. NOT takes a single
+// key. dest is removed when the result is empty.
+func (db *DB) BitOp(op BitOperation, dest string, keys ...string) (int, error) {
+	var length int
+
+	err := db.write(append([]string{dest}, keys...), func(t *txn) error {
+		srcs := make([]string, len(keys))
+
+		for i, key := range keys {
+			str, err := t.lookupString(key)
+			if err != nil {
+				return err
+			}
+
+			if str != nil {
+				srcs[i] = str.val
+			}
+
+			if len(srcs[i]) > length {
+				length = len(srcs[i])
+			}
+		}
+
+		if length == 0 {
+			t.remove(dest)
+			return nil
+		}
+
+		buf := make([]byte, length)
+		copy(buf, srcs[0])
+
+		for i := range buf {
+			if op == BitNot {
+				buf[i] = ^buf[i]
+				continue
+			}
+
+			for _, src := range srcs[1:] {
+				var b byte
+				if i < len(src) {
+					b = src[i]
+				}
+
+				switch op {
+				case BitAnd:
+					buf[i] &= b
+				case BitOr:
+					buf[i] |= b
+				case BitXor:
+					buf[i] ^= b
+				}
+			}
+		}
+
+		entry := t.create(dest)
+		entry.val = valueString{
+			val: string(buf),
+		}
+
+		t.setExpire(dest, entry, 0)
+
+		return nil
+	})
+
+	return length, err
+}
+
+// BitField runs ops on the string stored at key and returns their results,
+// a nil result is an operation that failed with the FAIL overflow. The
+// string grows with zero bytes to hold the fields that are written.
+func (db *DB) BitField(key string, ops []BitFieldOp) ([]*int64, error) {
+	results := make([]*int64, 0, len(ops))
+
+	readonly := true
+	for _, op := range ops {
+		if op.Type != BitFieldGet {
+			readonly = false
+		}
+	}
+
+	run := db.write
+	if readonly {
+		run = db.shard.view
+	}
+
+	err := run([]string{key}, func(t *txn) error {
+		str, err := t.lookupString(key)
+		if err != nil {
+			return err
+		}
+
+		if str == nil {
+			str = &valueString{}
+		}
+
+		for _, op := range ops {
+			results = append(results, str.bitField(op))
+		}
+
+		if !readonly {
+			// the string grows even when every write failed, like in Redis
+			if size := bitFieldSize(ops); size > int64(len(str.val)) {
+				str.setBits(size, func([]byte) {})
+			}
+
+			t.storeString(key, str)
+		}
+
+		return nil
+	})
+
+	return results, err
+}
+
+// bitFieldSize returns the number of bytes needed by the fields written by ops.
+func bitFieldSize(ops []BitFieldOp) int64 {
+	var size int64
+
+	for _, op := range ops {
+		if op.Type == BitFieldGet {
+			continue
+		}
+
+		if end := (op.Offset + int64(op.Width) + 7) >> 3; end > size {
+			size = end
+		}
+	}
+
+	return size
+}
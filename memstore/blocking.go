@@ -0,0 +1,153 @@
+package memstore
+
+import (
+	"sync"
+)
+
+// Waiter is a client blocked until one of its keys receives data.
+type Waiter struct {
+	keys []string
+	// registry is the registry of the database the client is blocked in
+	registry *waitRegistry
+	// ready holds a pending wake up, it's buffered so a notification sent
+	// before the client starts waiting is not lost
+	ready chan struct{}
+}
+
+// waitRegistry keeps the clients blocked on every key in the order they
+// blocked, a key that receives data wakes them up one at a time so they
+// are served fairly.
+type waitRegistry struct {
+	mu      sync.Mutex
+	waiters map[string][]*Waiter
+}
+
+func newWaitRegistry() *waitRegistry {
+	return &waitRegistry{
+		waiters: make(map[string][]*Waiter),
+	}
+}
+
+// Block registers a client waiting for data on keys. The client must check
+// the keys after blocking, then wait on Ready and check again until it gets
+// data, and finally call Unblock.
+func (db *DB) Block(keys []string) *Waiter {
+	w := &Waiter{
+		keys:     keys,
+		registry: db.waiters,
+		ready:    make(chan struct{}, 1),
+	}
+
+	db.waiters.mu.Lock()
+	defer db.waiters.mu.Unlock()
+
+	for _, key := range keys {
+		db.waiters.waiters[key] = append(db.waiters.waiters[key], w)
+	}
+
+	return w
+}
+
+// Ready returns the channel that receives a value when one of the keys
+// may have data.
+func (w *Waiter) Ready() <-chan struct{} {
+	return w.ready
+}
+
+// First reports whether no other client blocked on key before w, a client
+// that isn't first should leave the data to the ones waiting longer.
+func (w *Waiter) First(key string) bool {
+	w.registry.mu.Lock()
+	defer w.registry.mu.Unlock()
+
+	queue := w.registry.waiters[key]
+
+	return len(queue) > 0 && queue[0] == w
+}
+
+// Unblock removes the client from the registry, a wake up it didn't
+// consume is passed to the next client waiting on the same keys.
+func (w *Waiter) Unblock() {
+	w.registry.mu.Lock()
+	defer w.registry.mu.Unlock()
+
+	for _, key := range w.keys {
+		queue := w.registry.waiters[key]
+
+		for i, waiter := range queue {
+			if waiter == w {
+				queue = append(queue[:i:i], queue[i+1:]...)
+				break
+			}
+		}
+
+		if len(queue) == 0 {
+			delete(w.registry.waiters, key)
+		} else {
+			w.registry.waiters[key] = queue
+		}
+	}
+
+	select {
+	case <-w.ready:
+		for _, key := range w.keys {
+			w.registry.signal(key)
+		}
+	default:
+	}
+}
+
+// Notify wakes up the next client blocked on key, it's called when key
+// may have data for it.
+func (db *DB) Notify(key string) {
+	db.waiters.notify(key)
+}
+
+func (r *waitRegistry) notify(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.signal(key)
+}
+
+// broadcast wakes up every client blocked on key, it's used when the data
+// is not consumed by the clients, like the entries of a stream.
+func (r *waitRegistry) broadcast(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, w := range r.waiters[key] {
+		select {
+		case w.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// broadcastAll wakes up every blocked client, it's used when the whole
+// keyspace changes.
+func (r *waitRegistry) broadcastAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, queue := range r.waiters {
+		for _, w := range queue {
+			select {
+			case w.ready <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// signal wakes up the first client of key without a pending wake up,
+// it must be called with mu held.
+func (r *waitRegistry) signal(key string) {
+	for _, w := range r.waiters[key] {
+		select {
+		case w.ready <- struct{}{}:
+			return
+		default:
+		}
+	}
+}
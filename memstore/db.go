@@ -0,0 +1,294 @@
+package memstore
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	DefaultDatabases = 16
+)
+
+// DB is a logical database, an independent keyspace selected by its index.
+// Every database has its own blocks and its own blocked clients.
+type DB struct {
+	index   int
+	shard   *Shard
+	waiters *waitRegistry
+	// record is given the number of keys modified through the handle,
+	// nil for the databases returned by Database
+	record func(changes int64)
+}
+
+// dbSlot creates its database on first use, so the unused databases don't
+// run the sweepers of their blocks.
+type dbSlot struct {
+	once sync.Once
+	db   atomic.Pointer[DB]
+}
+
+var databases = newDBSlots(DefaultDatabases)
+
+func newDBSlots(n int) []*dbSlot {
+	slots := make([]*dbSlot, n)
+	for i := range slots {
+		slots[i] = &dbSlot{}
+	}
+
+	return slots
+}
+
+// SetDatabases sets the number of databases, it must be called before any
+// database is used.
+func SetDatabases(n int) {
+	if n <= 0 {
+		n = DefaultDatabases
+	}
+
+	databases = newDBSlots(n)
+}
+
+// Databases returns the number of databases.
+func Databases() int {
+	return len(databases)
+}
+
+// Database returns the database of index, which must be lower than
+// Databases.
+func Database(index int) *DB {
+	slot := databases[index]
+
+	slot.once.Do(func() {
+		slot.db.Store(&DB{
+			index:   index,
+			shard:   newShard(),
+			waiters: newWaitRegistry(),
+		})
+	})
+
+	return slot.db.Load()
+}
+
+// Index returns the index of the database.
+func (db *DB) Index() int {
+	return db.index
+}
+
+// createdDatabases returns the databases in use, in the order of their index.
+func createdDatabases() []*DB {
+	dbs := make([]*DB, 0, len(databases))
+
+	for _, slot := range databases {
+		if db := slot.db.Load(); db != nil {
+			dbs = append(dbs, db)
+		}
+	}
+
+	return dbs
+}
+
+// storages returns the storage of every block of the database.
+func (db *DB) storages() []*Storage {
+	storages := make([]*Storage, 0, MaxShardBlock)
+
+	for blockNum := uint32(0); blockNum < MaxShardBlock; blockNum++ {
+		if storage := db.shard.Storage(blockNum); storage != nil {
+			storages = append(storages, storage)
+		}
+	}
+
+	return storages
+}
+
+// lockAll locks every block of dbs for writing and returns the function
+// releasing them. Like any operation spanning several databases, dbs must
+// be sorted by index and the blocks of a database are locked in order.
+func lockAll(dbs ...*DB) (unlock func()) {
+	var locked []*Storage
+
+	for _, db := range dbs {
+		for _, storage := range db.storages() {
+			storage.mu.Lock()
+			locked = append(locked, storage)
+		}
+	}
+
+	return func() {
+		for _, storage := range locked {
+			storage.mu.Unlock()
+		}
+	}
+}
+
+// Recorded returns a handle of db that gives record the number of keys
+// modified through it. record is called while the modified keys are still
+// locked, so the calls of the clients modifying the same keys are in the
+// order of the modifications.
+func (db *DB) Recorded(record func(changes int64)) *DB {
+	recorded := *db
+	recorded.record = record
+
+	return &recorded
+}
+
+// updatePair runs fn with the blocks of keys locked in both databases, the
+// database with the lowest index is locked first. The modifications of
+// both are recorded by src.
+func updatePair(src, dst *DB, keys []string, fn func(src, dst *txn) error) error {
+	if src.index > dst.index {
+		return dst.shard.run(keys, false, src.record, func(tdst *txn) error {
+			return src.update(keys, func(tsrc *txn) error {
+				return fn(tsrc, tdst)
+			})
+		})
+	}
+
+	return src.update(keys, func(tsrc *txn) error {
+		return dst.shard.run(keys, false, src.record, func(tdst *txn) error {
+			return fn(tsrc, tdst)
+		})
+	})
+}
+
+// swap exchanges the entries of two blocks, both must be locked. The
+// version of the removals stays with the block, so the keys missing from
+// both databases keep their version. The running snapshots follow the
+// entries they copy.
+func (s *Storage) swap(other *Storage) {
+	s.entries, other.entries = other.entries, s.entries
+	s.volatile, other.volatile = other.volatile, s.volatile
+	s.volatileFields, other.volatileFields = other.volatileFields, s.volatileFields
+	s.used, other.used = other.used, s.used
+	s.keys, other.keys = other.keys, s.keys
+	s.snapshots, other.snapshots = other.snapshots, s.snapshots
+
+	for _, b := range s.snapshots {
+		b.storage.Store(s)
+	}
+
+	for _, b := range other.snapshots {
+		b.storage.Store(other)
+	}
+}
+
+// flush removes every entry of the block, which must be locked. The
+// running snapshots that didn't copy the block keep the removed entries.
+func (s *Storage) flush() {
+	for _, b := range s.snapshots {
+		if b.flushed == nil {
+			b.flushed = s.entries
+		}
+	}
+
+	changes.Add(int64(s.keys))
+	s.account(-s.used)
+
+	s.entries = make(map[uint32]*EntryNode)
+	s.volatile = make(map[string]uint32)
+	s.volatileFields = make(map[string]uint32)
+	s.keys = 0
+	s.removed = nextVersion()
+}
+
+// SwapDB exchanges the keys of two databases, the clients connected to one
+// see the keys of the other right away.
+func SwapDB(i, j int) {
+	Database(i).Swap(Database(j))
+}
+
+// Swap exchanges the keys of db and other.
+func (db *DB) Swap(other *DB) {
+	if db.index == other.index {
+		return
+	}
+
+	a, b := Database(db.index), Database(other.index)
+	if a.index > b.index {
+		a, b = b, a
+	}
+
+	unlock := lockAll(a, b)
+
+	storagesA, storagesB := a.storages(), b.storages()
+	for n := range storagesA {
+		storagesA[n].swap(storagesB[n])
+	}
+
+	changes.Add(1)
+
+	if db.record != nil {
+		db.record(1)
+	}
+
+	unlock()
+
+	// the clients blocked in both databases check their keys again
+	a.waiters.broadcastAll()
+	b.waiters.broadcastAll()
+}
+
+// Flush removes every key of the database. The blocks only drop their
+// entries, so it doesn't depend on the number of keys and the garbage
+// collector reclaims the values concurrently.
+func (db *DB) Flush() {
+	flush(db.record, db)
+}
+
+// FlushAll removes every key of every database at once.
+func FlushAll() {
+	flush(nil, createdDatabases()...)
+}
+
+// FlushAll is like the FlushAll function, the removed keys are recorded
+// by db.
+func (db *DB) FlushAll() {
+	flush(db.record, createdDatabases()...)
+}
+
+func flush(record func(changes int64), dbs ...*DB) {
+	unlock := lockAll(dbs...)
+	defer unlock()
+
+	var removed int64
+
+	for _, db := range dbs {
+		for _, storage := range db.storages() {
+			removed += int64(storage.keys)
+			storage.flush()
+		}
+	}
+
+	if record != nil && removed > 0 {
+		record(removed)
+	}
+}
+
+// Move moves key to dst unless dst already has it, ok reports whether the
+// key was moved.
+func (db *DB) Move(key string, dst *DB) (ok bool, err error) {
+	if db.index == dst.index {
+		return false, nil
+	}
+
+	err = updatePair(db, dst, []string{key}, func(tsrc, tdst *txn) error {
+		entry := tsrc.lookup(key)
+		if entry == nil || tdst.lookup(key) != nil {
+			return nil
+		}
+
+		val, expireAt := entry.val, entry.expireAt
+
+		tsrc.remove(key)
+		tdst.store(key, val, expireAt)
+
+		ok = true
+
+		return nil
+	})
+
+	if ok {
+		dst.waiters.broadcast(key)
+	}
+
+	return ok, err
+}
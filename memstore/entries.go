@@ -2,6 +2,12 @@ package memstore
 
 import (
 	"errors"
+	"sync/atomic"
+)
+
+const (
+	// rough memory used by an entry besides its key and value
+	entryOverhead = 64
 )
 
 type EntryNode struct {
@@ -12,6 +18,17 @@ type EntryNode struct {
 	// it will hold the value with the same hashkey
 	child    *EntryNode
 	assigned bool
+	// expireAt is the unix time in milliseconds when the entry expires,
+	// zero means the entry never expires
+	expireAt int64
+	// access metadata used by the eviction policies, they are updated
+	// atomically since reads only hold the block for reading
+	lastAccess atomic.Int64
+	// lfu packs the last decrement time in minutes (high 16 bits)
+	// with the logarithmic access counter (low 8 bits)
+	lfu atomic.Uint32
+	// version changes whenever the entry is modified, see DB.Version
+	version uint64
 }
 
 func NewEntryNode(key string, val any) EntryNode {
@@ -38,34 +55,76 @@ func (e *EntryNode) Next() (*EntryNode, error) {
 	return e.child, nil
 }
 
-func (e *EntryNode) Delete(key string, parent *EntryNode) (entry *EntryNode) {
-	if e.key == key {
-		e.val = nil
-		e.key = ""
+// find walks the collision chain started by e and returns the node holding key.
+func (e *EntryNode) find(key string) *EntryNode {
+	for node := e; node != nil; node = node.child {
+		if node.assigned && node.key == key {
+			return node
+		}
+	}
+
+	return nil
+}
+
+// Delete removes key from the collision chain started by e, it returns
+// the new head of the chain, which is nil when the chain becomes empty.
+func (e *EntryNode) Delete(key string) (head *EntryNode, deleted bool) {
+	var prev *EntryNode
 
-		child := e.child
+	for node := e; node != nil; node = node.child {
+		if !node.assigned || node.key != key {
+			prev = node
+			continue
+		}
 
-		e.child = nil
+		next := node.child
 
-		if child != nil {
-			if parent != nil {
-				parent.child = child
-				return parent
-			}
+		node.child = nil
+		node.val = nil
+		node.key = ""
+		node.assigned = false
+		node.expireAt = 0
+		node.lastAccess.Store(0)
+		node.lfu.Store(0)
 
-			return child
-		} else {
-			return parent
+		if prev == nil {
+			return next, true
 		}
+
+		prev.child = next
+		return e, true
 	}
 
-	if e.child != nil {
-		child := e.child
-		node := child.Delete(key, e)
-		return node
+	return e, false
+}
+
+// size estimates the memory used by the entry.
+func (e *EntryNode) size() int64 {
+	size := entryOverhead + int64(len(e.key))
+
+	if val, ok := e.val.(sizer); ok {
+		size += val.size()
 	}
 
-	return nil
+	return size
+}
+
+// Expired reports whether the entry has a expiration time that already passed,
+// a hash whose fields all expired is expired too.
+func (e *EntryNode) Expired(now int64) bool {
+	if e.expireAt != 0 && e.expireAt <= now {
+		return true
+	}
+
+	hash, ok := e.val.(*valueMap)
+
+	return ok && hash.expiredAll(now)
+}
+
+// ExpireAt returns the expiration time in unix milliseconds, zero means
+// the entry never expires.
+func (e *EntryNode) ExpireAt() int64 {
+	return e.expireAt
 }
 
 func (e *EntryNode) Set(valueType ValueType, key string, args ...string) (newFieldNum int, err error) {
@@ -78,8 +137,10 @@ func (e *EntryNode) Set(valueType ValueType, key string, args ...string) (newFie
 		switch valueType {
 		case ValueTypeString:
 			err = e.setString(args[0])
-		case ValueTypeMap:
-			newFieldNum, err = e.setMap(args...)
+		case ValueTypeList:
+			newFieldNum, err = e.setList(args...)
+		case ValueTypeSet:
+			newFieldNum, err = e.setSet(args...)
 		}
 
 		return newFieldNum, err
@@ -97,14 +158,17 @@ func (e *EntryNode) Set(valueType ValueType, key string, args ...string) (newFie
 	if node == nil {
 		node = new(EntryNode)
 		node.key = key
+		node.assigned = true
 		e.child = node
 	}
 
 	switch valueType {
 	case ValueTypeString:
 		err = node.setString(args[0])
-	case ValueTypeMap:
-		newFieldNum, err = node.setMap(args...)
+	case ValueTypeList:
+		newFieldNum, err = node.setList(args...)
+	case ValueTypeSet:
+		newFieldNum, err = node.setSet(args...)
 	}
 
 	return newFieldNum, err
@@ -114,7 +178,7 @@ func (e *EntryNode) setString(arg string) error {
 	if e.val != nil {
 		val, ok := e.val.(valueString)
 		if !ok {
-			return errors.New("invalid operations for the value type")
+			return ErrWrongType
 		}
 
 		val.set(arg)
@@ -127,40 +191,55 @@ func (e *EntryNode) setString(arg string) error {
 	return nil
 }
 
-func (e *EntryNode) setMap(args ...string) (int, error) {
-	var (
-		newFieldNum int
-	)
+// setList appends args to the tail of the list and returns the number of
+// elements pushed.
+func (e *EntryNode) setList(args ...string) (int, error) {
+	if e.val == nil {
+		e.val = newValueList()
+	}
 
-	if e.val != nil {
-		val, ok := e.val.(valueMap)
-		if !ok {
-			return newFieldNum, errors.New("invalid operations for the value type")
-		}
+	val, ok := e.val.(*valueList)
+	if !ok {
+		return 0, ErrWrongType
+	}
 
-		newFieldNum = val.set(args...)
+	for _, arg := range args {
+		val.pushBack(arg)
+	}
 
-		e.val = val
+	return len(args), nil
+}
 
-		return newFieldNum, nil
+// setSet adds args to the set and returns the number of new members.
+func (e *EntryNode) setSet(args ...string) (int, error) {
+	if e.val == nil {
+		e.val = newValueSet()
 	}
 
-	val := valueMap{
-		val: make(map[string]string),
+	val, ok := e.val.(*valueSet)
+	if !ok {
+		return 0, ErrWrongType
 	}
 
-	newFieldNum = val.set(args...)
+	var added int
+
+	for _, arg := range args {
+		if val.add(arg) {
+			added++
+		}
+	}
 
-	e.val = val
-	return newFieldNum, nil
+	return added, nil
 }
 
 func (e *EntryNode) Get(valueType ValueType, key string, args ...string) (any, error) {
 	switch valueType {
 	case ValueTypeString:
 		return e.getString()
-	case ValueTypeMap:
-		return e.getMap(args...)
+	case ValueTypeList:
+		return e.getList()
+	case ValueTypeSet:
+		return e.getSet()
 	}
 
 	return nil, nil
@@ -174,24 +253,39 @@ func (e *EntryNode) getString() (string, error) {
 
 	valContainer, ok := container.(valueString)
 	if !ok {
-		return "", errors.New("invalid operations for the value type")
+		return "", ErrWrongType
 	}
 
 	val := valContainer.get()
 	return val, nil
 }
 
-func (e *EntryNode) getMap(args ...string) ([]string, error) {
+// getList returns every element of the list from head to tail.
+func (e *EntryNode) getList() ([]string, error) {
 	container := e.val
 	if container == nil {
 		return nil, ErrNilEntries
 	}
 
-	valContainer, ok := container.(valueMap)
+	valContainer, ok := container.(*valueList)
 	if !ok {
-		return nil, errors.New("invalid operations for the value type")
+		return nil, ErrWrongType
 	}
 
-	val := valContainer.get(args...)
-	return val, nil
+	return valContainer.rangeItems(0, valContainer.length-1), nil
+}
+
+// getSet returns every member of the set.
+func (e *EntryNode) getSet() ([]string, error) {
+	container := e.val
+	if container == nil {
+		return nil, ErrNilEntries
+	}
+
+	valContainer, ok := container.(*valueSet)
+	if !ok {
+		return nil, ErrWrongType
+	}
+
+	return valContainer.items(), nil
 }
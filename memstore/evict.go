@@ -0,0 +1,328 @@
+package memstore
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'")
+)
+
+type EvictionPolicy int32
+
+const (
+	// NoEviction rejects writes once the memory limit is reached
+	NoEviction EvictionPolicy = iota
+	// AllKeysLRU evicts the least recently used keys
+	AllKeysLRU
+	// AllKeysLFU evicts the least frequently used keys
+	AllKeysLFU
+	// AllKeysRandom evicts random keys
+	AllKeysRandom
+	// VolatileLRU evicts the least recently used keys with an expiration
+	VolatileLRU
+	// VolatileLFU evicts the least frequently used keys with an expiration
+	VolatileLFU
+	// VolatileRandom evicts random keys with an expiration
+	VolatileRandom
+	// VolatileTTL evicts the keys with the nearest expiration
+	VolatileTTL
+)
+
+var evictionPolicyNames = map[EvictionPolicy]string{
+	NoEviction:     "noeviction",
+	AllKeysLRU:     "allkeys-lru",
+	AllKeysLFU:     "allkeys-lfu",
+	AllKeysRandom:  "allkeys-random",
+	VolatileLRU:    "volatile-lru",
+	VolatileLFU:    "volatile-lfu",
+	VolatileRandom: "volatile-random",
+	VolatileTTL:    "volatile-ttl",
+}
+
+func (p EvictionPolicy) String() string {
+	return evictionPolicyNames[p]
+}
+
+// volatile reports whether the policy only evicts keys with an expiration.
+func (p EvictionPolicy) volatile() bool {
+	return p == VolatileLRU || p == VolatileLFU || p == VolatileRandom || p == VolatileTTL
+}
+
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	name = strings.ToLower(name)
+
+	for policy, policyName := range evictionPolicyNames {
+		if policyName == name {
+			return policy, nil
+		}
+	}
+
+	return NoEviction, fmt.Errorf("unknown eviction policy %q", name)
+}
+
+const (
+	defaultMaxMemorySamples = 5
+
+	// the LFU counter of new keys, so they aren't evicted right away
+	lfuInitVal = 5
+	// higher factors need more accesses to increment the counter
+	lfuLogFactor = 10
+	// minutes for the counter to be decremented by one
+	lfuDecayTime = 1
+)
+
+var (
+	// usedMemory is the memory held by the entries of every block
+	usedMemory atomic.Int64
+	// maxMemory is the memory limit, zero means no limit
+	maxMemory        atomic.Int64
+	evictionPolicy   atomic.Int32
+	maxMemorySamples atomic.Int32
+	// evicted is called for every evicted key, see OnEvict
+	evicted atomic.Pointer[func(db int, key string)]
+)
+
+func init() {
+	maxMemorySamples.Store(defaultMaxMemorySamples)
+}
+
+// SetMaxMemory sets the memory limit in bytes, zero disables the limit.
+func SetMaxMemory(bytes int64) {
+	maxMemory.Store(bytes)
+}
+
+func MaxMemory() int64 {
+	return maxMemory.Load()
+}
+
+func UsedMemory() int64 {
+	return usedMemory.Load()
+}
+
+// SetEvictionPolicy sets how keys are chosen for eviction once the memory limit is reached.
+func SetEvictionPolicy(policy EvictionPolicy) {
+	evictionPolicy.Store(int32(policy))
+}
+
+func CurrentEvictionPolicy() EvictionPolicy {
+	return EvictionPolicy(evictionPolicy.Load())
+}
+
+// SetMaxMemorySamples sets how many keys are sampled to choose the one to evict,
+// more samples give a better approximation at the cost of CPU.
+func SetMaxMemorySamples(samples int) {
+	if samples <= 0 {
+		samples = defaultMaxMemorySamples
+	}
+
+	maxMemorySamples.Store(int32(samples))
+}
+
+// OnEvict sets fn to be called for every key evicted by the memory limit,
+// with the block of the key still locked.
+func OnEvict(fn func(db int, key string)) {
+	evicted.Store(&fn)
+}
+
+// freeMemory evicts keys of any database until the used memory is under
+// the limit.
+func freeMemory() error {
+	limit := maxMemory.Load()
+	if limit <= 0 || usedMemory.Load() <= limit {
+		return nil
+	}
+
+	policy := CurrentEvictionPolicy()
+	if policy == NoEviction {
+		return ErrOOM
+	}
+
+	for usedMemory.Load() > limit {
+		if !evict(policy) {
+			return ErrOOM
+		}
+	}
+
+	return nil
+}
+
+type evictionCandidate struct {
+	db       int
+	shard    *Shard
+	blockNum uint32
+	hashKey  uint32
+	key      string
+	// keys with a higher score are evicted first
+	score int64
+}
+
+// evict samples keys from random blocks of random databases and evicts the
+// best candidate for the policy, it reports false when there is nothing to
+// evict.
+func evict(policy EvictionPolicy) bool {
+	var best *evictionCandidate
+
+	dbs := createdDatabases()
+	if len(dbs) == 0 {
+		return false
+	}
+
+	samples := int(maxMemorySamples.Load())
+
+	for i := 0; i < samples; i++ {
+		db := dbs[rand.Intn(len(dbs))]
+		blockNum := uint32(rand.Intn(int(MaxShardBlock)))
+
+		candidate := db.shard.sampleBlock(blockNum, policy)
+		if candidate != nil && (best == nil || candidate.score > best.score) {
+			best = candidate
+			best.db = db.index
+		}
+	}
+
+	// the sampled blocks may be empty while others still have keys
+	for _, db := range dbs {
+		for blockNum := uint32(0); blockNum < MaxShardBlock && best == nil; blockNum++ {
+			best = db.shard.sampleBlock(blockNum, policy)
+			if best != nil {
+				best.db = db.index
+			}
+		}
+	}
+
+	if best == nil {
+		return false
+	}
+
+	storage := best.shard.Storage(best.blockNum)
+
+	storage.mu.Lock()
+	if storage.remove(best.hashKey, best.key) {
+		changes.Add(1)
+
+		if fn := evicted.Load(); fn != nil {
+			(*fn)(best.db, best.key)
+		}
+	}
+	storage.mu.Unlock()
+
+	return true
+}
+
+func (s *Shard) sampleBlock(blockNum uint32, policy EvictionPolicy) *evictionCandidate {
+	storage := s.Storage(blockNum)
+	if storage == nil {
+		return nil
+	}
+
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+
+	var (
+		entry   *EntryNode
+		hashKey uint32
+	)
+
+	// map iteration order is random, the first element is the sample
+	if policy.volatile() {
+		for key, h := range storage.volatile {
+			entry, hashKey = storage.peek(h, key), h
+			break
+		}
+	} else {
+		for h, head := range storage.entries {
+			entry, hashKey = head, h
+			break
+		}
+	}
+
+	if entry == nil {
+		return nil
+	}
+
+	candidate := &evictionCandidate{
+		shard:    s,
+		blockNum: blockNum,
+		hashKey:  hashKey,
+		key:      entry.key,
+	}
+
+	switch policy {
+	case AllKeysLRU, VolatileLRU:
+		candidate.score = now() - entry.lastAccess.Load()
+	case AllKeysLFU, VolatileLFU:
+		candidate.score = math.MaxUint8 - int64(entry.lfuCounter())
+	case VolatileTTL:
+		candidate.score = math.MaxInt64 - entry.expireAt
+	}
+
+	return candidate
+}
+
+// touch updates the access metadata of the entry.
+func (e *EntryNode) touch() {
+	e.lastAccess.Store(now())
+
+	counter := lfuIncrement(e.lfuCounter())
+	e.lfu.Store(lfuMinutes()<<8 | counter)
+}
+
+// lfuCounter returns the access counter decremented by the time
+// passed since the last access.
+func (e *EntryNode) lfuCounter() uint32 {
+	lfu := e.lfu.Load()
+	if lfu == 0 {
+		return lfuInitVal
+	}
+
+	lastDecrement := lfu >> 8
+	counter := lfu & math.MaxUint8
+
+	periods := lfuElapsedMinutes(lastDecrement) / lfuDecayTime
+	if periods >= counter {
+		return 0
+	}
+
+	return counter - periods
+}
+
+// lfuIncrement increments the counter logarithmically, the more
+// accesses the counter has the less likely it's incremented.
+func lfuIncrement(counter uint32) uint32 {
+	if counter == math.MaxUint8 {
+		return counter
+	}
+
+	base := float64(counter) - lfuInitVal
+	if base < 0 {
+		base = 0
+	}
+
+	if rand.Float64() < 1.0/(base*lfuLogFactor+1) {
+		counter++
+	}
+
+	return counter
+}
+
+// lfuMinutes returns the current unix time in minutes, reduced to 16 bits.
+func lfuMinutes() uint32 {
+	return uint32(time.Now().Unix()/60) & math.MaxUint16
+}
+
+func lfuElapsedMinutes(since uint32) uint32 {
+	current := lfuMinutes()
+	if current >= since {
+		return current - since
+	}
+
+	// the 16 bits clock wrapped around
+	return math.MaxUint16 - since + current
+}
@@ -0,0 +1,225 @@
+package memstore
+
+import (
+	"time"
+)
+
+const (
+	// how often every block looks for expired keys
+	sweepInterval = 100 * time.Millisecond
+	// number of volatile keys checked on each sweep round
+	sweepSamples = 20
+	// a sweep keeps going while more than a quarter of the samples were
+	// expired, but never longer than this
+	sweepTimeLimit = 25 * time.Millisecond
+)
+
+// ExpireCondition restricts when Expire updates the expiration,
+// conditions can be combined, e.g. ExpireXX | ExpireLT.
+type ExpireCondition int
+
+const (
+	// ExpireNX sets the expiration only when the key has none
+	ExpireNX ExpireCondition = 1 << iota
+	// ExpireXX sets the expiration only when the key already has one
+	ExpireXX
+	// ExpireGT sets the expiration only when it's later than the current one
+	ExpireGT
+	// ExpireLT sets the expiration only when it's earlier than the current one
+	ExpireLT
+)
+
+// now returns the current unix time in milliseconds.
+func now() int64 {
+	return time.Now().UnixMilli()
+}
+
+// Expire sets the expiration time of key in unix milliseconds and reports
+// whether it was updated, a time in the past deletes the key right away.
+func (db *DB) Expire(key string, at int64, cond ExpireCondition) (bool, error) {
+	var updated bool
+
+	err := db.update([]string{key}, func(t *txn) error {
+		entry := t.lookup(key)
+		if entry == nil {
+			return nil
+		}
+
+		// a key without expiration is treated as having an infinite ttl
+		persistent := entry.expireAt == 0
+
+		if cond&ExpireNX != 0 && !persistent {
+			return nil
+		}
+
+		if cond&ExpireXX != 0 && persistent {
+			return nil
+		}
+
+		if cond&ExpireGT != 0 && (persistent || at <= entry.expireAt) {
+			return nil
+		}
+
+		if cond&ExpireLT != 0 && !persistent && at >= entry.expireAt {
+			return nil
+		}
+
+		updated = true
+
+		if at <= now() {
+			t.remove(key)
+			return nil
+		}
+
+		t.setExpire(key, entry, at)
+
+		return nil
+	})
+
+	return updated, err
+}
+
+// Persist removes the expiration of key and reports whether it had one.
+func (db *DB) Persist(key string) (bool, error) {
+	var updated bool
+
+	err := db.update([]string{key}, func(t *txn) error {
+		entry := t.lookup(key)
+		if entry == nil || entry.expireAt == 0 {
+			return nil
+		}
+
+		t.setExpire(key, entry, 0)
+		updated = true
+
+		return nil
+	})
+
+	return updated, err
+}
+
+// ExpireTime returns the expiration time of key in unix milliseconds,
+// zero means the key never expires. ErrNilEntries is returned for a missing key.
+func (db *DB) ExpireTime(key string) (int64, error) {
+	var at int64
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		entry := t.lookup(key)
+		if entry == nil {
+			return ErrNilEntries
+		}
+
+		at = entry.expireAt
+
+		return nil
+	})
+
+	return at, err
+}
+
+func (s *Storage) startSweeper() {
+	go func() {
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			s.sweep()
+		}
+	}()
+}
+
+// sweep actively deletes expired keys, so keys that are never accessed
+// again don't hold memory forever.
+func (s *Storage) sweep() {
+	start := time.Now()
+
+	for {
+		s.mu.Lock()
+		sampled, expired := s.sweepRound()
+		sampledFields, expiredFields := s.sweepFieldsRound()
+		s.mu.Unlock()
+
+		sampled += sampledFields
+		expired += expiredFields
+
+		if sampled == 0 || expired*4 <= sampled || time.Since(start) > sweepTimeLimit {
+			return
+		}
+	}
+}
+
+func (s *Storage) sweepRound() (sampled, expired int) {
+	ts := now()
+
+	// map iteration order is random, which makes it a cheap sampler
+	for key, hashKey := range s.volatile {
+		if sampled >= sweepSamples {
+			break
+		}
+
+		sampled++
+
+		entry := s.entries[hashKey].find(key)
+		if entry == nil {
+			delete(s.volatile, key)
+			continue
+		}
+
+		if entry.Expired(ts) {
+			s.remove(hashKey, key)
+			changes.Add(1)
+			expired++
+		}
+	}
+
+	return sampled, expired
+}
+
+// sweepFieldsRound removes the expired fields of the sampled hashes, a hash
+// counts as expired when some of its fields were removed.
+func (s *Storage) sweepFieldsRound() (sampled, expired int) {
+	ts := now()
+
+	for key, hashKey := range s.volatileFields {
+		if sampled >= sweepSamples {
+			break
+		}
+
+		sampled++
+
+		var hash *valueMap
+
+		entry := s.entries[hashKey].find(key)
+		if entry != nil {
+			hash, _ = entry.val.(*valueMap)
+		}
+
+		if hash == nil {
+			delete(s.volatileFields, key)
+			continue
+		}
+
+		s.preserve(hashKey, key)
+
+		before := entry.size()
+
+		if hash.purge(ts) == 0 {
+			continue
+		}
+
+		s.account(entry.size() - before)
+		changes.Add(1)
+		expired++
+
+		entry.version = nextVersion()
+
+		if hash.len() == 0 {
+			s.remove(hashKey, key)
+			continue
+		}
+
+		s.trackFields(hashKey, key, hash)
+	}
+
+	return sampled, expired
+}
@@ -0,0 +1,589 @@
+package memstore
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// The geo commands store the points in sorted sets, the score of a member is
+// the 52 bits geohash of its position: 26 bits of longitude interleaved with
+// 26 bits of latitude, the longitude taking the odd bits. The latitudes are
+// limited to the ones of the Web Mercator projection, like in Redis, so the
+// scores of both are interchangeable.
+const (
+	GeoLongitudeMin = -180.0
+	GeoLongitudeMax = 180.0
+	GeoLatitudeMin  = -85.05112878
+	GeoLatitudeMax  = 85.05112878
+
+	geoStepMax = 26
+
+	// the earth radius in meters used by the distances of Redis
+	geoEarthRadius = 6372797.560856
+	// the length in meters of the equator in the Mercator projection
+	geoMercatorMax = 20037726.37
+
+	geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+var (
+	ErrGeoMemberNotFound = errors.New("could not decode requested zset member")
+)
+
+// GeoPoint is a position on earth in degrees.
+type GeoPoint struct {
+	Longitude float64
+	Latitude  float64
+}
+
+// GeoMember is a member of a geo index with its position.
+type GeoMember struct {
+	Member string
+	GeoPoint
+}
+
+// GeoSort is the order of the results of GeoSearch.
+type GeoSort int
+
+const (
+	GeoSortNone GeoSort = iota
+	GeoSortAsc
+	GeoSortDesc
+)
+
+// GeoSearchOptions follow the options of the GEOSEARCH command, the
+// distances are in meters.
+type GeoSearchOptions struct {
+	// FromMember takes the center from the position of Member instead of Center
+	FromMember bool
+	Member     string
+	Center     GeoPoint
+	// ByBox searches in a box of Width by Height instead of a circle of Radius
+	ByBox  bool
+	Radius float64
+	Width  float64
+	Height float64
+	Sort   GeoSort
+	// Count limits the number of results when it's positive, with Any the
+	// search stops as soon as Count members are found
+	Count int
+	Any   bool
+}
+
+// GeoResult is a member found by GeoSearch with its distance in meters from
+// the center and its geohash.
+type GeoResult struct {
+	Member string
+	Dist   float64
+	Hash   uint64
+	GeoPoint
+}
+
+// geoHash is a geohash of step bits of longitude and step bits of latitude.
+type geoHash struct {
+	bits uint64
+	step uint
+}
+
+// geoArea is the area of a geohash.
+type geoArea struct {
+	minLongitude, maxLongitude float64
+	minLatitude, maxLatitude   float64
+}
+
+// validGeoPoint reports whether p can be indexed.
+func validGeoPoint(p GeoPoint) error {
+	if p.Longitude < GeoLongitudeMin || p.Longitude > GeoLongitudeMax ||
+		p.Latitude < GeoLatitudeMin || p.Latitude > GeoLatitudeMax {
+		return fmt.Errorf("invalid longitude,latitude pair %f,%f", p.Longitude, p.Latitude)
+	}
+
+	return nil
+}
+
+// interleave spreads the bits of x on the even bits and the ones of y on
+// the odd bits.
+func interleave(x, y uint32) uint64 {
+	spread := func(v uint32) uint64 {
+		b := uint64(v)
+		b = (b | b<<16) & 0x0000ffff0000ffff
+		b = (b | b<<8) & 0x00ff00ff00ff00ff
+		b = (b | b<<4) & 0x0f0f0f0f0f0f0f0f
+		b = (b | b<<2) & 0x3333333333333333
+		b = (b | b<<1) & 0x5555555555555555
+
+		return b
+	}
+
+	return spread(x) | spread(y)<<1
+}
+
+// deinterleave is the reverse of interleave.
+func deinterleave(b uint64) (x, y uint32) {
+	squash := func(b uint64) uint32 {
+		b &= 0x5555555555555555
+		b = (b | b>>1) & 0x3333333333333333
+		b = (b | b>>2) & 0x0f0f0f0f0f0f0f0f
+		b = (b | b>>4) & 0x00ff00ff00ff00ff
+		b = (b | b>>8) & 0x0000ffff0000ffff
+		b = (b | b>>16) & 0x00000000ffffffff
+
+		return uint32(b)
+	}
+
+	return squash(b), squash(b >> 1)
+}
+
+// encodeGeoHash returns the geohash of p with step bits per coordinate in
+// the given latitude limits.
+func encodeGeoHash(p GeoPoint, step uint, minLatitude, maxLatitude float64) geoHash {
+	scale := float64(uint64(1) << step)
+
+	// the maximum of a coordinate belongs to the last cell
+	offset := func(val, min, max float64) uint32 {
+		return uint32(math.Min((val-min)/(max-min)*scale, scale-1))
+	}
+
+	return geoHash{
+		bits: interleave(
+			offset(p.Latitude, minLatitude, maxLatitude),
+			offset(p.Longitude, GeoLongitudeMin, GeoLongitudeMax),
+		),
+		step: step,
+	}
+}
+
+// area returns the area of the geohash.
+func (h geoHash) area() geoArea {
+	lat, lon := deinterleave(h.bits)
+	scale := float64(uint64(1) << h.step)
+
+	latScale := GeoLatitudeMax - GeoLatitudeMin
+	lonScale := GeoLongitudeMax - GeoLongitudeMin
+
+	return geoArea{
+		minLatitude:  GeoLatitudeMin + float64(lat)/scale*latScale,
+		maxLatitude:  GeoLatitudeMin + float64(lat+1)/scale*latScale,
+		minLongitude: GeoLongitudeMin + float64(lon)/scale*lonScale,
+		maxLongitude: GeoLongitudeMin + float64(lon+1)/scale*lonScale,
+	}
+}
+
+// center returns the center of the area of the geohash.
+func (h geoHash) center() GeoPoint {
+	a := h.area()
+
+	p := GeoPoint{
+		Longitude: (a.minLongitude + a.maxLongitude) / 2,
+		Latitude:  (a.minLatitude + a.maxLatitude) / 2,
+	}
+
+	p.Longitude = math.Max(GeoLongitudeMin, math.Min(GeoLongitudeMax, p.Longitude))
+	p.Latitude = math.Max(GeoLatitudeMin, math.Min(GeoLatitudeMax, p.Latitude))
+
+	return p
+}
+
+// move returns the geohash dx steps east and dy steps north, it wraps
+// around at the limits.
+func (h geoHash) move(dx, dy int) geoHash {
+	lon := h.bits & 0xaaaaaaaaaaaaaaaa
+	lat := h.bits & 0x5555555555555555
+
+	width := 64 - h.step*2
+
+	if dx != 0 {
+		zz := uint64(0x5555555555555555) >> width
+		if dx > 0 {
+			lon += zz + 1
+		} else {
+			lon = (lon | zz) - (zz + 1)
+		}
+
+		lon &= 0xaaaaaaaaaaaaaaaa >> width
+	}
+
+	if dy != 0 {
+		zz := uint64(0xaaaaaaaaaaaaaaaa) >> width
+		if dy > 0 {
+			lat += zz + 1
+		} else {
+			lat = (lat | zz) - (zz + 1)
+		}
+
+		lat &= 0x5555555555555555 >> width
+	}
+
+	return geoHash{bits: lon | lat, step: h.step}
+}
+
+// geoPointOf returns the position stored in the score of a member.
+func geoPointOf(score float64) GeoPoint {
+	return geoHash{bits: uint64(score), step: geoStepMax}.center()
+}
+
+// geoDistance returns the distance in meters between a and b with the
+// haversine formula.
+func geoDistance(a, b GeoPoint) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+
+	u := math.Sin((lat2 - lat1) / 2)
+	v := math.Sin((b.Longitude - a.Longitude) * math.Pi / 180 / 2)
+
+	return 2 * geoEarthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1)*math.Cos(lat2)*v*v))
+}
+
+// geoHashString returns the standard 11 characters geohash of p, it uses
+// the full latitude range unlike the scores.
+func geoHashString(p GeoPoint) string {
+	bits := encodeGeoHash(p, geoStepMax, -90, 90).bits
+
+	buf := make([]byte, 11)
+	for i := range buf {
+		// the last character has no bits left, 52 bits make 10.4 characters
+		var idx uint64
+		if i < 10 {
+			idx = bits >> (52 - (i+1)*5) & 0x1f
+		}
+
+		buf[i] = geoAlphabet[idx]
+	}
+
+	return string(buf)
+}
+
+// within reports whether p is in the search shape around center and
+// returns its distance from center.
+func (opts *GeoSearchOptions) within(center, p GeoPoint) (float64, bool) {
+	if !opts.ByBox {
+		dist := geoDistance(center, p)
+		return dist, dist <= opts.Radius
+	}
+
+	// the latitude distance is the same on every meridian
+	if geoDistance(GeoPoint{Latitude: center.Latitude}, GeoPoint{Latitude: p.Latitude}) > opts.Height/2 {
+		return 0, false
+	}
+
+	if geoDistance(GeoPoint{Longitude: center.Longitude, Latitude: p.Latitude}, p) > opts.Width/2 {
+		return 0, false
+	}
+
+	return geoDistance(center, p), true
+}
+
+// areas returns the geohashes that cover the search shape around center:
+// the one of the center and its neighbors, at a step where they're bigger
+// than the shape. The neighbors out of the shape are left out.
+func (opts *GeoSearchOptions) areas(center GeoPoint) []geoHash {
+	halfWidth, halfHeight := opts.Radius, opts.Radius
+	if opts.ByBox {
+		halfWidth, halfHeight = opts.Width/2, opts.Height/2
+	}
+
+	// the bounding box of the shape
+	latDelta := halfHeight / geoEarthRadius * 180 / math.Pi
+	lonDeltaTop := halfWidth / geoEarthRadius / math.Cos((center.Latitude+latDelta)*math.Pi/180) * 180 / math.Pi
+	lonDeltaBottom := halfWidth / geoEarthRadius / math.Cos((center.Latitude-latDelta)*math.Pi/180) * 180 / math.Pi
+
+	// the box is wider on the side closer to the pole
+	lonDelta := lonDeltaTop
+	if center.Latitude < 0 {
+		lonDelta = lonDeltaBottom
+	}
+
+	minLon, maxLon := center.Longitude-lonDelta, center.Longitude+lonDelta
+	minLat, maxLat := center.Latitude-latDelta, center.Latitude+latDelta
+
+	step := geoEstimateStep(math.Sqrt(halfWidth*halfWidth+halfHeight*halfHeight), center.Latitude)
+
+	hash := encodeGeoHash(center, step, GeoLatitudeMin, GeoLatitudeMax)
+
+	// a step lower when the neighbors don't cover the bounding box
+	if step > 1 && (hash.move(0, 1).area().maxLatitude < maxLat ||
+		hash.move(0, -1).area().minLatitude > minLat ||
+		hash.move(1, 0).area().maxLongitude < maxLon ||
+		hash.move(-1, 0).area().minLongitude > minLon) {
+		step--
+		hash = encodeGeoHash(center, step, GeoLatitudeMin, GeoLatitudeMax)
+	}
+
+	area := hash.area()
+
+	hashes := make([]geoHash, 0, 9)
+	seen := make(map[uint64]bool, 9)
+
+	for _, dy := range []int{0, 1, -1} {
+		for _, dx := range []int{0, 1, -1} {
+			if step >= 2 && (dy < 0 && area.minLatitude < minLat ||
+				dy > 0 && area.maxLatitude > maxLat ||
+				dx < 0 && area.minLongitude < minLon ||
+				dx > 0 && area.maxLongitude > maxLon) {
+				continue
+			}
+
+			// the neighbors are the same at the lowest steps
+			neighbor := hash.move(dx, dy)
+			if seen[neighbor.bits] {
+				continue
+			}
+
+			seen[neighbor.bits] = true
+			hashes = append(hashes, neighbor)
+		}
+	}
+
+	return hashes
+}
+
+// geoEstimateStep returns the step of the geohashes that are bigger than the
+// radius in meters at the latitude.
+func geoEstimateStep(radius, latitude float64) uint {
+	if radius == 0 {
+		return geoStepMax
+	}
+
+	step := -1
+	for radius < geoMercatorMax {
+		radius *= 2
+		step++
+	}
+
+	// the areas get narrower close to the poles
+	if latitude > 66 || latitude < -66 {
+		step--
+
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+
+	if step < 1 {
+		step = 1
+	}
+
+	if step > geoStepMax {
+		step = geoStepMax
+	}
+
+	return uint(step)
+}
+
+// geoSearch returns the members of zset in the search shape.
+func (v *valueSortedSet) geoSearch(opts GeoSearchOptions) ([]GeoResult, error) {
+	center := opts.Center
+
+	if !opts.FromMember {
+		if err := validGeoPoint(center); err != nil {
+			return nil, err
+		}
+	} else {
+		score, ok := v.score(opts.Member)
+		if !ok {
+			return nil, ErrGeoMemberNotFound
+		}
+
+		center = geoPointOf(score)
+	}
+
+	results := []GeoResult{}
+
+areas:
+	for _, hash := range opts.areas(center) {
+		shift := 2 * (geoStepMax - hash.step)
+
+		r := ScoreRange{
+			Min:          float64(hash.bits << shift),
+			Max:          float64((hash.bits + 1) << shift),
+			MaxExclusive: true,
+		}
+
+		for x := v.list.firstInRange(r); x != nil && r.lteMax(x); x = x.levels[0].forward {
+			p := geoPointOf(x.score)
+
+			dist, ok := opts.within(center, p)
+			if !ok {
+				continue
+			}
+
+			results = append(results, GeoResult{
+				Member:   x.member,
+				Dist:     dist,
+				Hash:     uint64(x.score),
+				GeoPoint: p,
+			})
+
+			if opts.Any && len(results) == opts.Count {
+				break areas
+			}
+		}
+	}
+
+	// a count without ANY returns the closest members
+	sorting := opts.Sort
+	if sorting == GeoSortNone && opts.Count > 0 && !opts.Any {
+		sorting = GeoSortAsc
+	}
+
+	switch sorting {
+	case GeoSortAsc:
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].Dist < results[j].Dist
+		})
+	case GeoSortDesc:
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].Dist > results[j].Dist
+		})
+	}
+
+	if opts.Count > 0 && len(results) > opts.Count {
+		results = results[:opts.Count]
+	}
+
+	return results, nil
+}
+
+// GeoAdd adds members to the geo index or updates their position, it
+// returns the number of added members, or the changed ones with opts.CH.
+func (db *DB) GeoAdd(key string, opts SortedSetAddOptions, members ...GeoMember) (int, error) {
+	scored := make([]ScoredMember, 0, len(members))
+
+	for _, m := range members {
+		if err := validGeoPoint(m.GeoPoint); err != nil {
+			return 0, err
+		}
+
+		hash := encodeGeoHash(m.GeoPoint, geoStepMax, GeoLatitudeMin, GeoLatitudeMax)
+		scored = append(scored, ScoredMember{Member: m.Member, Score: float64(hash.bits)})
+	}
+
+	added, _, err := db.SortedSetAdd(key, opts, scored...)
+
+	return added, err
+}
+
+// GeoPos returns the position of members, nil for the missing ones.
+func (db *DB) GeoPos(key string, members ...string) ([]*GeoPoint, error) {
+	points := make([]*GeoPoint, len(members))
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		zset, err := t.lookupSortedSet(key)
+		if err != nil || zset == nil {
+			return err
+		}
+
+		for i, member := range members {
+			if score, ok := zset.score(member); ok {
+				p := geoPointOf(score)
+				points[i] = &p
+			}
+		}
+
+		return nil
+	})
+
+	return points, err
+}
+
+// GeoDist returns the distance in meters between two members, nil when one
+// of them is missing.
+func (db *DB) GeoDist(key, member1, member2 string) (*float64, error) {
+	points, err := db.GeoPos(key, member1, member2)
+	if err != nil || points[0] == nil || points[1] == nil {
+		return nil, err
+	}
+
+	dist := geoDistance(*points[0], *points[1])
+
+	return &dist, nil
+}
+
+// GeoHash returns the standard geohash strings of members, nil for the
+// missing ones.
+func (db *DB) GeoHash(key string, members ...string) ([]*string, error) {
+	points, err := db.GeoPos(key, members...)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]*string, len(points))
+
+	for i, p := range points {
+		if p != nil {
+			hash := geoHashString(*p)
+			hashes[i] = &hash
+		}
+	}
+
+	return hashes, nil
+}
+
+// GeoSearch returns the members of the geo index in the shape described by
+// opts.
+func (db *DB) GeoSearch(key string, opts GeoSearchOptions) ([]GeoResult, error) {
+	results := []GeoResult{}
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		zset, err := t.lookupSortedSet(key)
+		if err != nil || zset == nil {
+			return err
+		}
+
+		results, err = zset.geoSearch(opts)
+
+		return err
+	})
+
+	return results, err
+}
+
+// GeoSearchStore stores the members found by GeoSearch at dst, replacing any
+// value, and returns their number. The members keep their geohash as score
+// unless distUnit is set, then the score is their distance in meters divided
+// by distUnit.
+func (db *DB) GeoSearchStore(dst, key string, opts GeoSearchOptions, distUnit float64) (int, error) {
+	var card int
+
+	err := db.write([]string{dst, key}, func(t *txn) error {
+		zset, err := t.lookupSortedSet(key)
+		if err != nil {
+			return err
+		}
+
+		var results []GeoResult
+
+		if zset != nil {
+			results, err = zset.geoSearch(opts)
+			if err != nil {
+				return err
+			}
+		}
+
+		t.remove(dst)
+
+		if len(results) == 0 {
+			return nil
+		}
+
+		stored := newValueSortedSet()
+		for _, result := range results {
+			score := float64(result.Hash)
+			if distUnit != 0 {
+				score = result.Dist / distUnit
+			}
+
+			stored.add(result.Member, score)
+		}
+
+		t.create(dst).val = stored
+		t.modified(dst)
+		card = stored.len()
+
+		return nil
+	})
+
+	return card, err
+}
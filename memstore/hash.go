@@ -0,0 +1,609 @@
+package memstore
+
+import (
+	"errors"
+	"hash/crc32"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrHashNotInteger = errors.New("hash value is not an integer")
+	ErrHashNotFloat   = errors.New("hash value is not a float")
+	ErrIncrOverflow   = errors.New("increment or decrement would overflow")
+	ErrIncrNaN        = errors.New("increment would produce NaN or Infinity")
+)
+
+// HashField is a field of a hash with its value.
+type HashField struct {
+	Field string
+	Value string
+}
+
+// lookupHash returns the hash stored at key, or nil when the key doesn't
+// exist. Writers get the hash without its expired fields, a hash left
+// without fields is removed.
+func (t *txn) lookupHash(key string) (*valueMap, error) {
+	entry := t.lookup(key)
+	if entry == nil {
+		return nil, nil
+	}
+
+	hash, ok := entry.val.(*valueMap)
+	if !ok {
+		return nil, ErrWrongType
+	}
+
+	if !t.readonly && hash.purge(now()) > 0 {
+		t.hashModified(key, hash)
+
+		if hash.len() == 0 {
+			return nil, nil
+		}
+	}
+
+	return hash, nil
+}
+
+// hashModified marks the hash of key as changed, the key is removed once
+// the hash is empty.
+func (t *txn) hashModified(key string, hash *valueMap) {
+	if hash.len() == 0 {
+		t.remove(key)
+		return
+	}
+
+	storage, hashKey := t.storage(key)
+	storage.trackFields(hashKey, key, hash)
+
+	t.modified(key)
+}
+
+// HashSet stores the field value pairs of args and returns the number of
+// new fields, the overwritten fields lose their expiration.
+func (db *DB) HashSet(key string, args ...string) (int, error) {
+	var added int
+
+	err := db.write([]string{key}, func(t *txn) error {
+		hash, err := t.lookupHash(key)
+		if err != nil {
+			return err
+		}
+
+		if hash == nil {
+			hash = newValueMap()
+			t.create(key).val = hash
+		}
+
+		added = hash.set(args...)
+		t.hashModified(key, hash)
+
+		return nil
+	})
+
+	return added, err
+}
+
+// HashGet returns the value of every field, a nil element means the field
+// doesn't exist.
+func (db *DB) HashGet(key string, fields ...string) ([]*string, error) {
+	vals := make([]*string, len(fields))
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		hash, err := t.lookupHash(key)
+		if err != nil || hash == nil {
+			return err
+		}
+
+		ts := now()
+
+		for i, field := range fields {
+			if val, ok := hash.field(field, ts); ok {
+				vals[i] = &val
+			}
+		}
+
+		return nil
+	})
+
+	return vals, err
+}
+
+// HashGetAll returns every field of the hash with its value.
+func (db *DB) HashGetAll(key string) ([]HashField, error) {
+	result := []HashField{}
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		hash, err := t.lookupHash(key)
+		if err != nil || hash == nil {
+			return err
+		}
+
+		for _, field := range hash.fields(now()) {
+			result = append(result, HashField{Field: field, Value: hash.val[field]})
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+// HashDelete removes fields from the hash and returns how many existed.
+func (db *DB) HashDelete(key string, fields ...string) (int, error) {
+	var removed int
+
+	err := db.update([]string{key}, func(t *txn) error {
+		hash, err := t.lookupHash(key)
+		if err != nil || hash == nil {
+			return err
+		}
+
+		for _, field := range fields {
+			if hash.remove(field) {
+				removed++
+			}
+		}
+
+		if removed > 0 {
+			t.hashModified(key, hash)
+		}
+
+		return nil
+	})
+
+	return removed, err
+}
+
+// HashExists reports whether field exists in the hash.
+func (db *DB) HashExists(key, field string) (bool, error) {
+	var exists bool
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		hash, err := t.lookupHash(key)
+		if err != nil || hash == nil {
+			return err
+		}
+
+		_, exists = hash.field(field, now())
+
+		return nil
+	})
+
+	return exists, err
+}
+
+// HashLen returns the number of fields, zero when the key doesn't exist.
+func (db *DB) HashLen(key string) (int, error) {
+	var length int
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		hash, err := t.lookupHash(key)
+		if err != nil || hash == nil {
+			return err
+		}
+
+		length = len(hash.fields(now()))
+
+		return nil
+	})
+
+	return length, err
+}
+
+// HashKeys returns the fields of the hash.
+func (db *DB) HashKeys(key string) ([]string, error) {
+	fields := []string{}
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		hash, err := t.lookupHash(key)
+		if err != nil || hash == nil {
+			return err
+		}
+
+		fields = hash.fields(now())
+
+		return nil
+	})
+
+	return fields, err
+}
+
+// HashValues returns the values of the hash.
+func (db *DB) HashValues(key string) ([]string, error) {
+	values := []string{}
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		hash, err := t.lookupHash(key)
+		if err != nil || hash == nil {
+			return err
+		}
+
+		for _, field := range hash.fields(now()) {
+			values = append(values, hash.val[field])
+		}
+
+		return nil
+	})
+
+	return values, err
+}
+
+// HashStrLen returns the length of the value of field, zero when it
+// doesn't exist.
+func (db *DB) HashStrLen(key, field string) (int, error) {
+	var length int
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		hash, err := t.lookupHash(key)
+		if err != nil || hash == nil {
+			return err
+		}
+
+		val, _ := hash.field(field, now())
+		length = len(val)
+
+		return nil
+	})
+
+	return length, err
+}
+
+// HashSetNX sets field only when it doesn't exist and reports whether it was set.
+func (db *DB) HashSetNX(key, field, value string) (bool, error) {
+	var set bool
+
+	err := db.write([]string{key}, func(t *txn) error {
+		hash, err := t.lookupHash(key)
+		if err != nil {
+			return err
+		}
+
+		if hash == nil {
+			hash = newValueMap()
+			t.create(key).val = hash
+		}
+
+		if _, ok := hash.val[field]; ok {
+			return nil
+		}
+
+		hash.put(field, value)
+		t.hashModified(key, hash)
+		set = true
+
+		return nil
+	})
+
+	return set, err
+}
+
+// HashIncrBy adds delta to the integer stored at field and returns the new
+// value, a missing field counts as zero. The expiration of field is kept.
+func (db *DB) HashIncrBy(key, field string, delta int64) (int64, error) {
+	var result int64
+
+	err := db.write([]string{key}, func(t *txn) error {
+		hash, err := t.lookupHash(key)
+		if err != nil {
+			return err
+		}
+
+		if hash == nil {
+			hash = newValueMap()
+			t.create(key).val = hash
+		}
+
+		var current int64
+
+		if val, ok := hash.val[field]; ok {
+			current, err = strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return ErrHashNotInteger
+			}
+		}
+
+		if delta > 0 && current > math.MaxInt64-delta || delta < 0 && current < math.MinInt64-delta {
+			return ErrIncrOverflow
+		}
+
+		result = current + delta
+
+		hash.put(field, strconv.FormatInt(result, 10))
+		t.hashModified(key, hash)
+
+		return nil
+	})
+
+	return result, err
+}
+
+// HashIncrByFloat adds delta to the number stored at field and returns the
+// new value as it's stored, a missing field counts as zero. The expiration
+// of field is kept.
+func (db *DB) HashIncrByFloat(key, field string, delta float64) (string, error) {
+	var result string
+
+	err := db.write([]string{key}, func(t *txn) error {
+		hash, err := t.lookupHash(key)
+		if err != nil {
+			return err
+		}
+
+		var current float64
+
+		if hash != nil {
+			if val, ok := hash.val[field]; ok {
+				current, err = strconv.ParseFloat(val, 64)
+				if err != nil || math.IsNaN(current) || math.IsInf(current, 0) {
+					return ErrHashNotFloat
+				}
+			}
+		}
+
+		// the hash is only created once the increment is valid
+		sum := current + delta
+		if math.IsNaN(sum) || math.IsInf(sum, 0) {
+			return ErrIncrNaN
+		}
+
+		if hash == nil {
+			hash = newValueMap()
+			t.create(key).val = hash
+		}
+
+		result = strconv.FormatFloat(sum, 'f', -1, 64)
+
+		hash.put(field, result)
+		t.hashModified(key, hash)
+
+		return nil
+	})
+
+	return result, err
+}
+
+// HashRandField returns count fields chosen at random, a negative count may
+// return the same field several times. ErrNilEntries is returned when the
+// key doesn't exist.
+func (db *DB) HashRandField(key string, count int) ([]HashField, error) {
+	var result []HashField
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		hash, err := t.lookupHash(key)
+		if err != nil {
+			return err
+		}
+
+		var fields []string
+		if hash != nil {
+			fields = hash.fields(now())
+		}
+
+		if len(fields) == 0 {
+			return ErrNilEntries
+		}
+
+		repeat := count < 0
+		if repeat {
+			count = -count
+		}
+
+		result = make([]HashField, 0, count)
+
+		if repeat {
+			for i := 0; i < count; i++ {
+				field := fields[rand.Intn(len(fields))]
+				result = append(result, HashField{Field: field, Value: hash.val[field]})
+			}
+
+			return nil
+		}
+
+		if count > len(fields) {
+			count = len(fields)
+		}
+
+		// a partial Fisher-Yates shuffle picks the first count fields
+		for i := 0; i < count; i++ {
+			j := i + rand.Intn(len(fields)-i)
+			fields[i], fields[j] = fields[j], fields[i]
+
+			result = append(result, HashField{Field: fields[i], Value: hash.val[fields[i]]})
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+// HashScan returns about count fields from cursor and the cursor of the
+// next call, which is zero once every field was returned. The fields are
+// visited in the order of their checksum, the cursor is the checksum of
+// the next field plus one, so a field that is in the hash for the whole
+// scan is returned whatever the changes between the calls.
+func (db *DB) HashScan(key string, cursor uint64, count int) (uint64, []HashField, error) {
+	var (
+		next   uint64
+		result = []HashField{}
+	)
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		hash, err := t.lookupHash(key)
+		if err != nil || hash == nil {
+			return err
+		}
+
+		type scanned struct {
+			sum   uint32
+			field string
+		}
+
+		var fields []scanned
+
+		for _, field := range hash.fields(now()) {
+			sum := crc32.Checksum([]byte(field), crcTable)
+			if cursor == 0 || uint64(sum) >= cursor-1 {
+				fields = append(fields, scanned{sum: sum, field: field})
+			}
+		}
+
+		sort.Slice(fields, func(i, j int) bool {
+			if fields[i].sum != fields[j].sum {
+				return fields[i].sum < fields[j].sum
+			}
+
+			return fields[i].field < fields[j].field
+		})
+
+		for i, f := range fields {
+			// fields with the same checksum are never split across calls
+			if i > 0 && len(result) >= count && f.sum != fields[i-1].sum {
+				next = uint64(f.sum) + 1
+				break
+			}
+
+			result = append(result, HashField{Field: f.field, Value: hash.val[f.field]})
+		}
+
+		return nil
+	})
+
+	return next, result, err
+}
+
+// HashExpire sets the expiration time of fields in unix milliseconds, the
+// condition applies to every field like in Expire. The result of every
+// field is -2 when it doesn't exist, 0 when the condition is not met, 1
+// when the expiration was set and 2 when the field was deleted since the
+// time already passed.
+func (db *DB) HashExpire(key string, at int64, cond ExpireCondition, fields ...string) ([]int, error) {
+	results := make([]int, len(fields))
+
+	err := db.update([]string{key}, func(t *txn) error {
+		hash, err := t.lookupHash(key)
+		if err != nil {
+			return err
+		}
+
+		var changed bool
+
+		for i, field := range fields {
+			if hash == nil || !hash.has(field) {
+				results[i] = -2
+				continue
+			}
+
+			current, volatile := hash.expires[field]
+
+			if cond&ExpireNX != 0 && volatile ||
+				cond&ExpireXX != 0 && !volatile ||
+				cond&ExpireGT != 0 && (!volatile || at <= current) ||
+				cond&ExpireLT != 0 && volatile && at >= current {
+				continue
+			}
+
+			changed = true
+
+			if at <= now() {
+				hash.remove(field)
+				results[i] = 2
+
+				continue
+			}
+
+			hash.setExpire(field, at)
+			results[i] = 1
+		}
+
+		if changed {
+			t.hashModified(key, hash)
+		}
+
+		return nil
+	})
+
+	return results, err
+}
+
+// HashExpireTime returns the expiration time of fields in unix milliseconds,
+// -1 for the fields that never expire and -2 for the missing ones.
+func (db *DB) HashExpireTime(key string, fields ...string) ([]int64, error) {
+	results := make([]int64, len(fields))
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		hash, err := t.lookupHash(key)
+		if err != nil {
+			return err
+		}
+
+		ts := now()
+
+		for i, field := range fields {
+			if hash == nil {
+				results[i] = -2
+				continue
+			}
+
+			if _, ok := hash.field(field, ts); !ok {
+				results[i] = -2
+				continue
+			}
+
+			at, ok := hash.expires[field]
+			if !ok {
+				results[i] = -1
+				continue
+			}
+
+			results[i] = at
+		}
+
+		return nil
+	})
+
+	return results, err
+}
+
+// HashPersist removes the expiration of fields. The result of every field
+// is -2 when it doesn't exist, -1 when it has no expiration and 1 when the
+// expiration was removed.
+func (db *DB) HashPersist(key string, fields ...string) ([]int, error) {
+	results := make([]int, len(fields))
+
+	err := db.update([]string{key}, func(t *txn) error {
+		hash, err := t.lookupHash(key)
+		if err != nil {
+			return err
+		}
+
+		var changed bool
+
+		for i, field := range fields {
+			if hash == nil || !hash.has(field) {
+				results[i] = -2
+				continue
+			}
+
+			if _, ok := hash.expires[field]; !ok {
+				results[i] = -1
+				continue
+			}
+
+			hash.setExpire(field, 0)
+			results[i] = 1
+			changed = true
+		}
+
+		if changed {
+			t.hashModified(key, hash)
+		}
+
+		return nil
+	})
+
+	return results, err
+}
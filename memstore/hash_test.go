@@ -0,0 +1,121 @@
+package memstore
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// expireFields makes fields of the hash of key expired without removing
+// them, like when the sweeper didn't reach them yet.
+func expireFields(t *testing.T, db *DB, key string, fields ...string) {
+	t.Helper()
+
+	err := db.shard.update([]string{key}, func(t *txn) error {
+		storage, hashKey := t.storage(key)
+
+		hash := storage.peek(hashKey, key).val.(*valueMap)
+		for _, field := range fields {
+			hash.setExpire(field, now()-1)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHashExpiredFields(t *testing.T) {
+	db := newTestDB()
+
+	if _, err := db.HashSet("hash", "a", "1", "b", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	expireFields(t, db, "hash", "a")
+
+	vals, err := db.HashGet("hash", "a", "b")
+	if err != nil || vals[0] != nil || vals[1] == nil || *vals[1] != "2" {
+		t.Errorf("HashGet(a, b) = %v, %v, want nil and 2", vals, err)
+	}
+
+	if exists, _ := db.HashExists("hash", "a"); exists {
+		t.Error("HashExists(a) = true for an expired field")
+	}
+
+	if all, _ := db.HashGetAll("hash"); len(all) != 1 || all[0].Field != "b" {
+		t.Errorf("HashGetAll() = %v, want b only", all)
+	}
+
+	// an expired field is a new field
+	if added, err := db.HashSet("hash", "a", "3"); err != nil || added != 1 {
+		t.Errorf("HashSet(a) = %d, %v, want 1", added, err)
+	}
+
+	if exp, _ := db.HashExpireTime("hash", "a"); exp[0] != -1 {
+		t.Errorf("the expiration of the new field is %d, want -1", exp[0])
+	}
+
+	if vals, _ := db.HashGet("hash", "a"); vals[0] == nil || *vals[0] != "3" {
+		t.Errorf("HashGet(a) = %v, want 3", vals)
+	}
+}
+
+func TestHashAllFieldsExpired(t *testing.T) {
+	db := newTestDB()
+
+	if _, err := db.HashSet("hash", "a", "1", "b", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	expireFields(t, db, "hash", "a", "b")
+
+	// the key of a hash without fields doesn't exist
+	if n, _ := db.Exists("hash"); n != 0 {
+		t.Errorf("Exists() = %d, want 0", n)
+	}
+
+	if name, _ := db.Type("hash"); name != "none" {
+		t.Errorf("Type() = %s, want none", name)
+	}
+
+	if length, _ := db.HashLen("hash"); length != 0 {
+		t.Errorf("HashLen() = %d, want 0", length)
+	}
+
+	if _, _, err := db.SetString("hash", "str", SetOptions{NX: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if vals, _ := db.GetStrings("hash"); vals[0] == nil || *vals[0] != "str" {
+		t.Errorf("SET NX on the expired hash stored %v, want str", vals)
+	}
+}
+
+func TestHashIncrByFloatInfinity(t *testing.T) {
+	db := newTestDB()
+
+	for _, delta := range []float64{math.Inf(1), math.Inf(-1), math.NaN()} {
+		if _, err := db.HashIncrByFloat("hash", "a", delta); !errors.Is(err, ErrIncrNaN) {
+			t.Errorf("HashIncrByFloat(%v) = %v, want %v", delta, err, ErrIncrNaN)
+		}
+	}
+
+	// the failed increments didn't create the hash
+	if n, _ := db.Exists("hash"); n != 0 {
+		t.Errorf("Exists() = %d, want 0", n)
+	}
+
+	if _, err := db.HashIncrByFloat("hash", "a", 1.5); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.HashIncrByFloat("hash", "a", math.Inf(1)); !errors.Is(err, ErrIncrNaN) {
+		t.Errorf("HashIncrByFloat(+Inf) = %v, want %v", err, ErrIncrNaN)
+	}
+
+	if vals, _ := db.HashGet("hash", "a"); vals[0] == nil || *vals[0] != "1.5" {
+		t.Errorf("HashGet(a) = %v, want 1.5", vals)
+	}
+}
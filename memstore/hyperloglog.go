@@ -0,0 +1,525 @@
+package memstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// The HyperLogLogs are strings in the format of Redis, so they can be read
+// and restored with GET and SET. A 16 bytes header holding the magic HYLL,
+// the encoding and the cached cardinality is followed by the registers in
+// one of two encodings:
+//
+//   - dense, the 16384 registers of 6 bits packed with the least significant
+//     bit first
+//   - sparse, runs of registers where 00xxxxxx is 1 to 64 zero registers,
+//     01xxxxxx yyyyyyyy is 1 to 16384 zero registers and 1vvvvvxx is 1 to 4
+//     registers of the value 1 to 32
+//
+// A HyperLogLog starts sparse and becomes dense once the sparse encoding
+// gets bigger than hllSparseMaxBytes or holds a value it can't represent.
+const (
+	hllP          = 14
+	hllQ          = 64 - hllP
+	hllRegisters  = 1 << hllP
+	hllBits       = 6
+	hllRegMax     = 1<<hllBits - 1
+	hllHeaderSize = 16
+	hllDenseSize  = hllHeaderSize + (hllRegisters*hllBits+7)/8
+
+	hllEncodingDense  = 0
+	hllEncodingSparse = 1
+
+	hllSparseMaxBytes = 3000
+	hllSparseValMax   = 32
+	hllSparseZeroMax  = 64
+	hllSparseXZeroMax = hllRegisters
+	hllSparseValRun   = 4
+
+	hllAlphaInf = 0.721347520444481703680
+	hllSeed     = 0xadc83b19
+
+	hllMagic = "HYLL"
+)
+
+var (
+	ErrNotHyperLogLog       = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	ErrHyperLogLogCorrupted = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+type hyperLogLog struct {
+	registers []uint8
+	sparse    bool
+	// card is the cached cardinality, it's only used when valid is set
+	card  uint64
+	valid bool
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{
+		registers: make([]uint8, hllRegisters),
+		sparse:    true,
+		valid:     true,
+	}
+}
+
+// parseHyperLogLog decodes the HyperLogLog stored in val.
+func parseHyperLogLog(val string) (*hyperLogLog, error) {
+	if len(val) < hllHeaderSize || val[:4] != hllMagic || val[4] > hllEncodingSparse {
+		return nil, ErrNotHyperLogLog
+	}
+
+	h := &hyperLogLog{
+		registers: make([]uint8, hllRegisters),
+		sparse:    val[4] == hllEncodingSparse,
+	}
+
+	card := binary.LittleEndian.Uint64([]byte(val[8:16]))
+	h.valid = card>>63 == 0
+	h.card = card &^ (1 << 63)
+
+	body := val[hllHeaderSize:]
+
+	if !h.sparse {
+		if len(val) != hllDenseSize {
+			return nil, ErrNotHyperLogLog
+		}
+
+		for i := range h.registers {
+			h.registers[i] = denseRegister(body, i)
+		}
+
+		return h, nil
+	}
+
+	var reg int
+
+	for i := 0; i < len(body); i++ {
+		b := body[i]
+
+		switch {
+		case b&0xc0 == 0x00:
+			reg += int(b&0x3f) + 1
+		case b&0xc0 == 0x40:
+			i++
+			if i == len(body) {
+				return nil, ErrHyperLogLogCorrupted
+			}
+
+			reg += (int(b&0x3f)<<8 | int(body[i])) + 1
+		default:
+			run := int(b&0x03) + 1
+			if reg+run > hllRegisters {
+				return nil, ErrHyperLogLogCorrupted
+			}
+
+			for j := 0; j < run; j++ {
+				h.registers[reg+j] = (b>>2)&0x1f + 1
+			}
+
+			reg += run
+		}
+
+		if reg > hllRegisters {
+			return nil, ErrHyperLogLogCorrupted
+		}
+	}
+
+	if reg != hllRegisters {
+		return nil, ErrHyperLogLogCorrupted
+	}
+
+	return h, nil
+}
+
+// denseRegister returns the register i of the dense registers in body.
+func denseRegister(body string, i int) uint8 {
+	bit := i * hllBits
+	b, shift := bit/8, uint(bit%8)
+
+	val := body[b] >> shift
+	if b+1 < len(body) {
+		val |= body[b+1] << (8 - shift)
+	}
+
+	return val & hllRegMax
+}
+
+// encode returns the HyperLogLog as it's stored.
+func (h *hyperLogLog) encode() string {
+	if h.sparse {
+		if sparse, ok := h.encodeSparse(); ok {
+			return sparse
+		}
+
+		h.sparse = false
+	}
+
+	buf := h.header(hllDenseSize, hllEncodingDense)
+
+	for i, val := range h.registers {
+		bit := i * hllBits
+		b, shift := hllHeaderSize+bit/8, uint(bit%8)
+
+		buf[b] |= val << shift
+		if b+1 < len(buf) {
+			buf[b+1] |= val >> (8 - shift)
+		}
+	}
+
+	return string(buf)
+}
+
+// encodeSparse returns the sparse encoding of the HyperLogLog, ok is false
+// when it's too big or a register is over hllSparseValMax.
+func (h *hyperLogLog) encodeSparse() (string, bool) {
+	buf := h.header(hllHeaderSize, hllEncodingSparse)
+
+	for i := 0; i < hllRegisters; {
+		val := h.registers[i]
+
+		j := i + 1
+		for j < hllRegisters && h.registers[j] == val {
+			j++
+		}
+
+		if val > hllSparseValMax {
+			return "", false
+		}
+
+		for run := j - i; run > 0; {
+			switch {
+			case val != 0:
+				n := run
+				if n > hllSparseValRun {
+					n = hllSparseValRun
+				}
+
+				buf = append(buf, 0x80|(val-1)<<2|byte(n-1))
+				run -= n
+			case run > hllSparseZeroMax:
+				n := run
+				if n > hllSparseXZeroMax {
+					n = hllSparseXZeroMax
+				}
+
+				buf = append(buf, 0x40|byte((n-1)>>8), byte(n-1))
+				run -= n
+			default:
+				buf = append(buf, byte(run-1))
+				run = 0
+			}
+		}
+
+		if len(buf) > hllSparseMaxBytes {
+			return "", false
+		}
+
+		i = j
+	}
+
+	return string(buf), true
+}
+
+// header returns a buffer of size bytes starting with the header.
+func (h *hyperLogLog) header(size int, encoding byte) []byte {
+	buf := make([]byte, size)
+	copy(buf, hllMagic)
+	buf[4] = encoding
+
+	card := h.card
+	if !h.valid {
+		card |= 1 << 63
+	}
+
+	binary.LittleEndian.PutUint64(buf[8:16], card)
+
+	return buf
+}
+
+// add adds elem and reports whether a register changed.
+func (h *hyperLogLog) add(elem string) bool {
+	hash := murmurHash64A(elem, hllSeed)
+	reg := hash & (hllRegisters - 1)
+
+	// the position of the first set bit of the remaining bits, the bit
+	// hllQ is set so the count is at most hllQ+1
+	hash >>= hllP
+	hash |= 1 << hllQ
+
+	count := uint8(1)
+	for hash&1 == 0 {
+		count++
+		hash >>= 1
+	}
+
+	if h.registers[reg] >= count {
+		return false
+	}
+
+	h.registers[reg] = count
+	h.valid = false
+
+	return true
+}
+
+// merge sets every register to the maximum of both HyperLogLogs.
+func (h *hyperLogLog) merge(other *hyperLogLog) {
+	for i, val := range other.registers {
+		if val > h.registers[i] {
+			h.registers[i] = val
+			h.valid = false
+		}
+	}
+
+	if !other.sparse {
+		h.sparse = false
+	}
+}
+
+// count returns the estimated cardinality with the estimator of Otmar Ertl
+// used by Redis, the result is cached.
+func (h *hyperLogLog) count() uint64 {
+	if h.valid {
+		return h.card
+	}
+
+	var histogram [hllQ + 2]int
+	for _, val := range h.registers {
+		histogram[val]++
+	}
+
+	m := float64(hllRegisters)
+
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+
+	z += m * hllSigma(float64(histogram[0])/m)
+
+	h.card = uint64(math.Round(hllAlphaInf * m * m / z))
+	h.valid = true
+
+	return h.card
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+
+	y, z := 1.0, x
+
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+
+		if prev == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+
+	y, z := 1.0, 1-x
+
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+
+		if prev == z {
+			return z / 3
+		}
+	}
+}
+
+// murmurHash64A is the 64 bits MurmurHash2 by Austin Appleby, with the
+// little endian reads used by Redis on every platform.
+func murmurHash64A(key string, seed uint64) uint64 {
+	const (
+		m = 0xc6a4a7935bd1e995
+		r = 47
+	)
+
+	h := seed ^ uint64(len(key))*m
+
+	data := []byte(key)
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+
+		h ^= k
+		h *= m
+
+		data = data[8:]
+	}
+
+	if len(data) > 0 {
+		for i := len(data) - 1; i >= 0; i-- {
+			h ^= uint64(data[i]) << (8 * uint(i))
+		}
+
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+
+	return h
+}
+
+// lookupHyperLogLog returns the HyperLogLog stored at key, or nil when the
+// key doesn't exist.
+func (t *txn) lookupHyperLogLog(key string) (*hyperLogLog, error) {
+	str, err := t.lookupString(key)
+	if err != nil {
+		if errors.Is(err, ErrWrongType) {
+			return nil, ErrNotHyperLogLog
+		}
+
+		return nil, err
+	}
+
+	if str == nil {
+		return nil, nil
+	}
+
+	return parseHyperLogLog(str.val)
+}
+
+// storeHyperLogLog stores h at key keeping the expiration of the key.
+func (t *txn) storeHyperLogLog(key string, h *hyperLogLog) {
+	t.storeString(key, &valueString{
+		val: h.encode(),
+	})
+}
+
+// PFAdd adds elems to the HyperLogLog stored at key and reports whether its
+// estimation changed, a missing key is created.
+func (db *DB) PFAdd(key string, elems ...string) (bool, error) {
+	var changed bool
+
+	err := db.write([]string{key}, func(t *txn) error {
+		h, err := t.lookupHyperLogLog(key)
+		if err != nil {
+			return err
+		}
+
+		if h == nil {
+			h = newHyperLogLog()
+			changed = true
+		}
+
+		for _, elem := range elems {
+			if h.add(elem) {
+				changed = true
+			}
+		}
+
+		if changed {
+			t.storeHyperLogLog(key, h)
+		}
+
+		return nil
+	})
+
+	return changed, err
+}
+
+// PFCount returns the estimated cardinality of the union of the
+// HyperLogLogs stored at keys, the missing keys are empty. The cardinality
+// of a single key is cached in the string.
+func (db *DB) PFCount(keys ...string) (int64, error) {
+	var card uint64
+
+	if len(keys) == 1 {
+		key := keys[0]
+
+		err := db.update(keys, func(t *txn) error {
+			h, err := t.lookupHyperLogLog(key)
+			if err != nil || h == nil {
+				return err
+			}
+
+			if h.valid {
+				card = h.card
+				return nil
+			}
+
+			card = h.count()
+			t.storeHyperLogLog(key, h)
+
+			return nil
+		})
+
+		return int64(card), err
+	}
+
+	err := db.shard.view(keys, func(t *txn) error {
+		union := newHyperLogLog()
+
+		for _, key := range keys {
+			h, err := t.lookupHyperLogLog(key)
+			if err != nil {
+				return err
+			}
+
+			if h != nil {
+				union.merge(h)
+			}
+		}
+
+		card = union.count()
+
+		return nil
+	})
+
+	return int64(card), err
+}
+
+// PFMerge stores the union of the HyperLogLogs stored at dest and keys in
+// dest, the missing keys are empty.
+func (db *DB) PFMerge(dest string, keys ...string) error {
+	return db.write(append([]string{dest}, keys...), func(t *txn) error {
+		h, err := t.lookupHyperLogLog(dest)
+		if err != nil {
+			return err
+		}
+
+		if h == nil {
+			h = newHyperLogLog()
+		}
+
+		// the cache of dest is never reused, like in Redis
+		h.valid = false
+
+		for _, key := range keys {
+			src, err := t.lookupHyperLogLog(key)
+			if err != nil {
+				return err
+			}
+
+			if src != nil {
+				h.merge(src)
+			}
+		}
+
+		t.storeHyperLogLog(dest, h)
+
+		return nil
+	})
+}
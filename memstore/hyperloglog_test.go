@@ -0,0 +1,233 @@
+package memstore
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+const (
+	// hllStdError is the standard error of the estimations, 1.04/sqrt(m)
+	hllStdError = 0.0081
+	// hllTolerance is the error allowed for a single estimation, three
+	// standard errors cover 99.7% of them
+	hllTolerance = 3 * hllStdError
+)
+
+// pfAddRange adds the elements elem:from to elem:to-1 to key.
+func pfAddRange(t *testing.T, db *DB, key string, from, to int) {
+	t.Helper()
+
+	const batch = 1000
+
+	elems := make([]string, 0, batch)
+
+	for i := from; i < to; i++ {
+		elems = append(elems, fmt.Sprintf("elem:%d", i))
+
+		if len(elems) == batch || i == to-1 {
+			if _, err := db.PFAdd(key, elems...); err != nil {
+				t.Fatal(err)
+			}
+
+			elems = elems[:0]
+		}
+	}
+}
+
+// hllEncoding returns the encoding of the HyperLogLog stored at key.
+func hllEncoding(t *testing.T, db *DB, key string) byte {
+	t.Helper()
+
+	vals, err := db.GetStrings(key)
+	if err != nil || vals[0] == nil {
+		t.Fatalf("GET %s = %v, %v", key, vals, err)
+	}
+
+	return (*vals[0])[4]
+}
+
+// relativeError returns the error of the estimation of the cardinality of keys.
+func relativeError(t *testing.T, db *DB, want int, keys ...string) float64 {
+	t.Helper()
+
+	card, err := db.PFCount(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return math.Abs(float64(card)-float64(want)) / float64(want)
+}
+
+func TestHyperLogLogSparse(t *testing.T) {
+	db := newTestDB()
+
+	for _, n := range []int{1, 10, 100, 500, 1000} {
+		key := fmt.Sprintf("sparse:%d", n)
+		pfAddRange(t, db, key, 0, n)
+
+		if enc := hllEncoding(t, db, key); enc != hllEncodingSparse {
+			t.Fatalf("%d elements are not sparse, encoding %d", n, enc)
+		}
+
+		// the small cardinalities are almost exact
+		if e := relativeError(t, db, n, key); e > hllTolerance {
+			t.Errorf("the error of %d elements is %.2f%%", n, e*100)
+		}
+	}
+}
+
+func TestHyperLogLogDense(t *testing.T) {
+	db := newTestDB()
+
+	var squares float64
+
+	cards := []int{5000, 10000, 20000, 50000, 100000, 200000}
+
+	for _, n := range cards {
+		key := fmt.Sprintf("dense:%d", n)
+		pfAddRange(t, db, key, 0, n)
+
+		if enc := hllEncoding(t, db, key); enc != hllEncodingDense {
+			t.Fatalf("%d elements are not dense, encoding %d", n, enc)
+		}
+
+		e := relativeError(t, db, n, key)
+		if e > hllTolerance {
+			t.Errorf("the error of %d elements is %.2f%%", n, e*100)
+		}
+
+		squares += e * e
+	}
+
+	// the error over every dataset stays close to the standard error
+	if rms := math.Sqrt(squares / float64(len(cards))); rms > 1.5*hllStdError {
+		t.Errorf("the mean error is %.2f%%, want about %.2f%%", rms*100, hllStdError*100)
+	}
+}
+
+// TestHyperLogLogPromotion adds elements until the sparse encoding is
+// promoted to dense, the estimation must not change with the encoding.
+func TestHyperLogLogPromotion(t *testing.T) {
+	db := newTestDB()
+
+	pfAddRange(t, db, "hll", 0, 1)
+
+	var (
+		n    = 1
+		last int64
+	)
+
+	for hllEncoding(t, db, "hll") != hllEncodingDense {
+		if n > 100000 {
+			t.Fatal("the HyperLogLog is still sparse after 100000 elements")
+		}
+
+		card, err := db.PFCount("hll")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		last = card
+
+		pfAddRange(t, db, "hll", n, n+1)
+		n++
+	}
+
+	// the element promoting it changed at most one register
+	card, err := db.PFCount("hll")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if card < last || card > last+1 {
+		t.Errorf("the estimation went from %d to %d when the encoding was promoted", last, card)
+	}
+
+	if e := relativeError(t, db, n, "hll"); e > hllTolerance {
+		t.Errorf("the error of %d elements after the promotion is %.2f%%", n, e*100)
+	}
+
+	// the registers survive a round trip through the dense encoding
+	err = db.shard.view([]string{"hll"}, func(t *txn) error {
+		h, err := t.lookupHyperLogLog("hll")
+		if err != nil {
+			return err
+		}
+
+		decoded, err := parseHyperLogLog(h.encode())
+		if err != nil {
+			return err
+		}
+
+		for i := range h.registers {
+			if h.registers[i] != decoded.registers[i] {
+				return fmt.Errorf("register %d is %d, want %d", i, decoded.registers[i], h.registers[i])
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	pfAddRange(t, db, "hll", n, 50000)
+
+	if e := relativeError(t, db, 50000, "hll"); e > hllTolerance {
+		t.Errorf("the error of 50000 elements is %.2f%%", e*100)
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	db := newTestDB()
+
+	// a and b overlap on 20000 elements, c is sparse and within a
+	pfAddRange(t, db, "a", 0, 60000)
+	pfAddRange(t, db, "b", 40000, 100000)
+	pfAddRange(t, db, "c", 1000, 1500)
+
+	if e := relativeError(t, db, 100000, "a", "b", "c"); e > hllTolerance {
+		t.Errorf("the error of the union count is %.2f%%", e*100)
+	}
+
+	if err := db.PFMerge("ab", "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := relativeError(t, db, 100000, "ab"); e > hllTolerance {
+		t.Errorf("the error of the merged HyperLogLog is %.2f%%", e*100)
+	}
+
+	// the union and the merge see the same registers
+	union, _ := db.PFCount("a", "b")
+	merged, _ := db.PFCount("ab")
+
+	if union != merged {
+		t.Errorf("the merge counts %d and the union %d", merged, union)
+	}
+
+	// merging a set into itself or a subset into a set changes nothing
+	before, _ := db.PFCount("a")
+
+	if err := db.PFMerge("a", "a", "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	if after, _ := db.PFCount("a"); after != before {
+		t.Errorf("merging a subset changed the count from %d to %d", before, after)
+	}
+
+	// a sparse destination is promoted by the merge
+	if err := db.PFMerge("c", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if enc := hllEncoding(t, db, "c"); enc != hllEncodingDense {
+		t.Errorf("the merged HyperLogLog has the encoding %d, want dense", enc)
+	}
+
+	if e := relativeError(t, db, 60500, "c"); e > hllTolerance {
+		t.Errorf("the error of the sparse and dense merge is %.2f%%", e*100)
+	}
+}
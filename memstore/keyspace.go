@@ -0,0 +1,429 @@
+package memstore
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// typeName returns the name of the type of val like the TYPE command.
+func typeName(val any) string {
+	switch val.(type) {
+	case valueString:
+		return "string"
+	case *valueMap:
+		return "hash"
+	case *valueList:
+		return "list"
+	case *valueSet:
+		return "set"
+	case *valueSortedSet:
+		return "zset"
+	case *valueStream:
+		return "stream"
+	default:
+		return "none"
+	}
+}
+
+// encodingOf returns the internal representation of val like the OBJECT
+// ENCODING command. Strings follow the encodings of Redis, since clients
+// rely on them to tell integers apart.
+func encodingOf(val any) string {
+	switch v := val.(type) {
+	case valueString:
+		if n, err := strconv.ParseInt(v.val, 10, 64); err == nil && strconv.FormatInt(n, 10) == v.val {
+			return "int"
+		}
+
+		if len(v.val) <= 44 {
+			return "embstr"
+		}
+
+		return "raw"
+	case *valueSet:
+		if v.members == nil {
+			return "intset"
+		}
+
+		return "hashtable"
+	case *valueMap:
+		return "hashtable"
+	case *valueList:
+		return "quicklist"
+	case *valueSortedSet:
+		return "skiplist"
+	case *valueStream:
+		return "stream"
+	default:
+		return "unknown"
+	}
+}
+
+// peek returns the entry of key without updating its access metadata, like
+// the commands that only inspect the keys.
+func (t *txn) peek(key string) *EntryNode {
+	storage, hashKey := t.storage(key)
+
+	entry := storage.peek(hashKey, key)
+	if entry == nil || entry.val == nil || entry.Expired(now()) {
+		return nil
+	}
+
+	return entry
+}
+
+// Type returns the name of the type of the value stored at key, "none" when
+// the key doesn't exist.
+func (db *DB) Type(key string) (string, error) {
+	name := "none"
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		if entry := t.peek(key); entry != nil {
+			name = typeName(entry.val)
+		}
+
+		return nil
+	})
+
+	return name, err
+}
+
+// Exists returns how many of keys exist, a key given several times is
+// counted every time.
+func (db *DB) Exists(keys ...string) (int, error) {
+	var count int
+
+	err := db.shard.view(keys, func(t *txn) error {
+		for _, key := range keys {
+			if t.peek(key) != nil {
+				count++
+			}
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// Touch updates the access time of keys and returns how many exist.
+func (db *DB) Touch(keys ...string) (int, error) {
+	var count int
+
+	err := db.shard.view(keys, func(t *txn) error {
+		for _, key := range keys {
+			if t.lookup(key) != nil {
+				count++
+			}
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// Delete removes keys and returns how many existed, keys of any block can
+// be given and they are removed atomically.
+func (db *DB) Delete(keys ...string) (int, error) {
+	var count int
+
+	err := db.update(keys, func(t *txn) error {
+		for _, key := range keys {
+			if t.lookup(key) != nil && t.remove(key) {
+				count++
+			}
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// Rename moves the value and the expiration of src to dst, replacing any
+// value of dst. With nx nothing is done when dst exists, ok reports whether
+// the key was renamed. ErrNoSuchKey is returned when src doesn't exist.
+func (db *DB) Rename(src, dst string, nx bool) (ok bool, err error) {
+	err = db.update([]string{src, dst}, func(t *txn) error {
+		entry := t.lookup(src)
+		if entry == nil {
+			return ErrNoSuchKey
+		}
+
+		if src == dst {
+			ok = !nx
+			return nil
+		}
+
+		if nx && t.lookup(dst) != nil {
+			return nil
+		}
+
+		val, expireAt := entry.val, entry.expireAt
+
+		t.remove(src)
+		t.remove(dst)
+		t.store(dst, val, expireAt)
+
+		ok = true
+
+		return nil
+	})
+
+	// any kind of client blocked on dst may be served by the new value
+	if ok && src != dst {
+		db.waiters.broadcast(dst)
+	}
+
+	return ok, err
+}
+
+// Copy stores a copy of the value and the expiration of src at dst in the
+// database dstDB. With replace any value of dst is replaced, otherwise
+// nothing is done when dst exists. ok reports whether the value was copied.
+func (db *DB) Copy(src string, dstDB *DB, dst string, replace bool) (ok bool, err error) {
+	err = freeMemory()
+	if err != nil {
+		return false, err
+	}
+
+	keys := []string{src, dst}
+
+	copyEntry := func(tsrc, tdst *txn) error {
+		entry := tsrc.lookup(src)
+		if entry == nil || dstDB.index == db.index && src == dst {
+			return nil
+		}
+
+		if tdst.lookup(dst) != nil {
+			if !replace {
+				return nil
+			}
+
+			tdst.remove(dst)
+		}
+
+		val := entry.val
+		if c, ok := val.(cloner); ok {
+			val = c.clone()
+		}
+
+		tdst.store(dst, val, entry.expireAt)
+
+		ok = true
+
+		return nil
+	}
+
+	if dstDB.index == db.index {
+		err = db.update(keys, func(t *txn) error {
+			return copyEntry(t, t)
+		})
+	} else {
+		err = updatePair(db, dstDB, keys, copyEntry)
+	}
+
+	if ok {
+		dstDB.waiters.broadcast(dst)
+	}
+
+	return ok, err
+}
+
+// store creates key with val and its expiration.
+func (t *txn) store(key string, val any, expireAt int64) {
+	entry := t.create(key)
+	entry.val = val
+
+	t.setExpire(key, entry, expireAt)
+
+	// the fields with an expiration must be tracked in the new block
+	if hash, ok := val.(*valueMap); ok {
+		t.hashModified(key, hash)
+	}
+}
+
+// RandomKey returns a key chosen at random, ok is false when there are no
+// keys.
+func (db *DB) RandomKey() (key string, ok bool) {
+	first := rand.Intn(int(MaxShardBlock))
+	ts := now()
+
+	for i := 0; i < int(MaxShardBlock) && !ok; i++ {
+		storage := db.shard.Storage(uint32((first + i) % int(MaxShardBlock)))
+		if storage == nil {
+			continue
+		}
+
+		storage.mu.RLock()
+
+		// map iteration order is random, the first valid key is the sample
+	entries:
+		for _, head := range storage.entries {
+			for entry := head; entry != nil; entry = entry.child {
+				if entry.assigned && entry.val != nil && !entry.Expired(ts) {
+					key, ok = entry.key, true
+					break entries
+				}
+			}
+		}
+
+		storage.mu.RUnlock()
+	}
+
+	return key, ok
+}
+
+// DBSize returns the number of keys, the expired keys that are not removed
+// yet are counted like in Redis.
+func (db *DB) DBSize() int {
+	var size int
+
+	for blockNum := uint32(0); blockNum < MaxShardBlock; blockNum++ {
+		storage := db.shard.Storage(blockNum)
+		if storage == nil {
+			continue
+		}
+
+		storage.mu.RLock()
+		size += storage.keys
+		storage.mu.RUnlock()
+	}
+
+	return size
+}
+
+// ScanOptions filter the keys returned by Scan and Keys.
+type ScanOptions struct {
+	// Count is the number of keys visited by Scan, the filtered keys
+	// included
+	Count int
+	// Match returns the keys it accepts, nil returns every key
+	Match func(key string) bool
+	// Type returns the keys holding this type, like TYPE names it
+	Type string
+}
+
+func (opts *ScanOptions) accept(entry *EntryNode) bool {
+	return (opts.Match == nil || opts.Match(entry.key)) &&
+		(opts.Type == "" || typeName(entry.val) == opts.Type)
+}
+
+// Scan returns the keys from cursor and the cursor of the next call, which
+// is zero once every key was visited. The blocks are visited in order and
+// the keys of a block in the order of their hash, the cursor is the block
+// number in the high 32 bits and the next hash in the low ones. Since the
+// hash of a key never changes, a key that exists for the whole scan is
+// returned whatever the writes between the calls.
+func (db *DB) Scan(cursor uint64, opts ScanOptions) (uint64, []string) {
+	keys := []string{}
+	count := opts.Count
+	ts := now()
+
+	start := uint32(cursor)
+
+	for blockNum := uint32(cursor >> 32); blockNum < MaxShardBlock; blockNum, start = blockNum+1, 0 {
+		storage := db.shard.Storage(blockNum)
+		if storage == nil {
+			continue
+		}
+
+		storage.mu.RLock()
+
+		hashKeys := make([]uint32, 0, len(storage.entries))
+		for hashKey := range storage.entries {
+			if hashKey >= start {
+				hashKeys = append(hashKeys, hashKey)
+			}
+		}
+
+		sort.Slice(hashKeys, func(i, j int) bool {
+			return hashKeys[i] < hashKeys[j]
+		})
+
+		for _, hashKey := range hashKeys {
+			// the keys of a collision chain are never split across calls
+			if count <= 0 {
+				storage.mu.RUnlock()
+				return uint64(blockNum)<<32 | uint64(hashKey), keys
+			}
+
+			for entry := storage.entries[hashKey]; entry != nil; entry = entry.child {
+				if !entry.assigned || entry.val == nil || entry.Expired(ts) {
+					continue
+				}
+
+				count--
+
+				if opts.accept(entry) {
+					keys = append(keys, entry.key)
+				}
+			}
+		}
+
+		storage.mu.RUnlock()
+	}
+
+	return 0, keys
+}
+
+// Keys returns every key accepted by opts, opts.Count is ignored.
+func (db *DB) Keys(opts ScanOptions) []string {
+	keys := []string{}
+	ts := now()
+
+	for blockNum := uint32(0); blockNum < MaxShardBlock; blockNum++ {
+		storage := db.shard.Storage(blockNum)
+		if storage == nil {
+			continue
+		}
+
+		storage.mu.RLock()
+
+		for _, head := range storage.entries {
+			for entry := head; entry != nil; entry = entry.child {
+				if entry.assigned && entry.val != nil && !entry.Expired(ts) && opts.accept(entry) {
+					keys = append(keys, entry.key)
+				}
+			}
+		}
+
+		storage.mu.RUnlock()
+	}
+
+	return keys
+}
+
+// ObjectInfo describes how a key is stored, like the OBJECT command.
+type ObjectInfo struct {
+	Encoding string
+	// IdleTime is the number of seconds since the last access
+	IdleTime int64
+	// Freq is the logarithmic access counter of the LFU policies
+	Freq int
+}
+
+// Object returns how key is stored, nil when the key doesn't exist. The
+// access metadata of the key is left as it is.
+func (db *DB) Object(key string) (*ObjectInfo, error) {
+	var info *ObjectInfo
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		entry := t.peek(key)
+		if entry == nil {
+			return nil
+		}
+
+		info = &ObjectInfo{
+			Encoding: encodingOf(entry.val),
+			IdleTime: (now() - entry.lastAccess.Load()) / 1000,
+			Freq:     int(entry.lfuCounter()),
+		}
+
+		return nil
+	})
+
+	return info, err
+}
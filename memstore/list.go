@@ -0,0 +1,425 @@
+package memstore
+
+import (
+	"errors"
+)
+
+var (
+	ErrNoSuchKey       = errors.New("no such key")
+	ErrIndexOutOfRange = errors.New("index out of range")
+)
+
+// lookupList returns the list stored at key, or nil when the key doesn't exist.
+func (t *txn) lookupList(key string) (*valueList, error) {
+	entry := t.lookup(key)
+	if entry == nil {
+		return nil, nil
+	}
+
+	list, ok := entry.val.(*valueList)
+	if !ok {
+		return nil, ErrWrongType
+	}
+
+	return list, nil
+}
+
+// listModified marks the list of key as changed, the key is removed
+// once the list is empty since Redis never keeps empty lists.
+func (t *txn) listModified(key string, list *valueList) {
+	if list.length == 0 {
+		t.remove(key)
+		return
+	}
+
+	t.modified(key)
+}
+
+// listIndex converts a negative index, counted from the tail, to
+// the index from the head.
+func listIndex(index int64, length int) int64 {
+	if index < 0 {
+		index += int64(length)
+	}
+
+	return index
+}
+
+// listRange clamps start and stop to the list, ok is false when the range is empty.
+func listRange(start, stop int64, length int) (int, int, bool) {
+	start = listIndex(start, length)
+	stop = listIndex(stop, length)
+
+	if start < 0 {
+		start = 0
+	}
+
+	if start > stop || start >= int64(length) {
+		return 0, 0, false
+	}
+
+	if stop >= int64(length) {
+		stop = int64(length) - 1
+	}
+
+	return int(start), int(stop), true
+}
+
+// ListPush adds vals to the head of the list, or to the tail when front
+// is false, and returns the new length. The list is created when it doesn't
+// exist unless existing is set, then zero is returned.
+func (db *DB) ListPush(key string, front, existing bool, vals ...string) (int, error) {
+	var length int
+
+	err := db.write([]string{key}, func(t *txn) error {
+		list, err := t.lookupList(key)
+		if err != nil {
+			return err
+		}
+
+		if list == nil {
+			if existing {
+				return nil
+			}
+
+			list = newValueList()
+			t.create(key).val = list
+		}
+
+		for _, val := range vals {
+			if front {
+				list.pushFront(val)
+			} else {
+				list.pushBack(val)
+			}
+		}
+
+		t.listModified(key, list)
+		length = list.length
+
+		return nil
+	})
+
+	if length > 0 {
+		db.waiters.notify(key)
+	}
+
+	return length, err
+}
+
+// ListMove pops an element from the head of src, or from the tail when
+// srcFront is false, and pushes it to dst. It returns nil when src doesn't exist.
+func (db *DB) ListMove(src, dst string, srcFront, dstFront bool) (*string, error) {
+	var val *string
+
+	err := db.write([]string{src, dst}, func(t *txn) error {
+		srcList, err := t.lookupList(src)
+		if err != nil || srcList == nil {
+			return err
+		}
+
+		dstList, err := t.lookupList(dst)
+		if err != nil {
+			return err
+		}
+
+		item := listPop(srcList, srcFront, 1)[0]
+		val = &item
+
+		// when src and dst are the same list it's rotated, dstList is
+		// srcList then and it still exists even if it had one element
+		if dstList == nil {
+			dstList = newValueList()
+			t.create(dst).val = dstList
+		}
+
+		if dstFront {
+			dstList.pushFront(item)
+		} else {
+			dstList.pushBack(item)
+		}
+
+		t.listModified(src, srcList)
+		t.listModified(dst, dstList)
+
+		return nil
+	})
+
+	if val != nil {
+		db.waiters.notify(dst)
+	}
+
+	return val, err
+}
+
+// ListPop removes and returns up to count elements from the head of the
+// list, or from the tail when front is false. ErrNilEntries is returned
+// when the key doesn't exist.
+func (db *DB) ListPop(key string, front bool, count int) ([]string, error) {
+	var vals []string
+
+	err := db.update([]string{key}, func(t *txn) error {
+		list, err := t.lookupList(key)
+		if err != nil {
+			return err
+		}
+
+		if list == nil {
+			return ErrNilEntries
+		}
+
+		vals = listPop(list, front, count)
+		t.listModified(key, list)
+
+		return nil
+	})
+
+	return vals, err
+}
+
+func listPop(list *valueList, front bool, count int) []string {
+	if count > list.length {
+		count = list.length
+	}
+
+	vals := make([]string, 0, count)
+
+	for i := 0; i < count; i++ {
+		var val string
+
+		if front {
+			val, _ = list.popFront()
+		} else {
+			val, _ = list.popBack()
+		}
+
+		vals = append(vals, val)
+	}
+
+	return vals
+}
+
+// ListRange returns the elements from start to stop, both included,
+// negative indexes are counted from the tail.
+func (db *DB) ListRange(key string, start, stop int64) ([]string, error) {
+	vals := []string{}
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		list, err := t.lookupList(key)
+		if err != nil || list == nil {
+			return err
+		}
+
+		from, to, ok := listRange(start, stop, list.length)
+		if ok {
+			vals = list.rangeItems(from, to)
+		}
+
+		return nil
+	})
+
+	return vals, err
+}
+
+// ListIndex returns the element at index, nil when the key doesn't exist
+// or the index is out of range.
+func (db *DB) ListIndex(key string, index int64) (*string, error) {
+	var val *string
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		list, err := t.lookupList(key)
+		if err != nil || list == nil {
+			return err
+		}
+
+		index = listIndex(index, list.length)
+		if index < 0 || index >= int64(list.length) {
+			return nil
+		}
+
+		item, _ := list.index(int(index))
+		val = &item
+
+		return nil
+	})
+
+	return val, err
+}
+
+// ListSet replaces the element at index.
+func (db *DB) ListSet(key string, index int64, val string) error {
+	return db.write([]string{key}, func(t *txn) error {
+		list, err := t.lookupList(key)
+		if err != nil {
+			return err
+		}
+
+		if list == nil {
+			return ErrNoSuchKey
+		}
+
+		index = listIndex(index, list.length)
+		if index < 0 || index >= int64(list.length) {
+			return ErrIndexOutOfRange
+		}
+
+		list.set(int(index), val)
+		t.modified(key)
+
+		return nil
+	})
+}
+
+// ListRemove removes the first count occurrences of val, the last ones when
+// count is negative or all of them when it's zero, and returns how many were
+// removed.
+func (db *DB) ListRemove(key string, count int64, val string) (int, error) {
+	var removed int
+
+	err := db.update([]string{key}, func(t *txn) error {
+		list, err := t.lookupList(key)
+		if err != nil || list == nil {
+			return err
+		}
+
+		// a count bigger than the list removes every occurrence anyway
+		if count > int64(list.length) || count < -int64(list.length) {
+			count = 0
+		}
+
+		removed = list.remove(int(count), val)
+		if removed > 0 {
+			t.listModified(key, list)
+		}
+
+		return nil
+	})
+
+	return removed, err
+}
+
+// ListTrim keeps the elements from start to stop, both included.
+func (db *DB) ListTrim(key string, start, stop int64) error {
+	return db.update([]string{key}, func(t *txn) error {
+		list, err := t.lookupList(key)
+		if err != nil || list == nil {
+			return err
+		}
+
+		from, to, ok := listRange(start, stop, list.length)
+		if !ok {
+			t.remove(key)
+			return nil
+		}
+
+		if from == 0 && to == list.length-1 {
+			return nil
+		}
+
+		list.trim(from, to)
+		t.listModified(key, list)
+
+		return nil
+	})
+}
+
+// ListLen returns the length of the list, zero when the key doesn't exist.
+func (db *DB) ListLen(key string) (int, error) {
+	var length int
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		list, err := t.lookupList(key)
+		if err != nil || list == nil {
+			return err
+		}
+
+		length = list.length
+
+		return nil
+	})
+
+	return length, err
+}
+
+// ListInsert adds val before or after the first occurrence of pivot and
+// returns the new length, -1 when pivot is not found and zero when the key
+// doesn't exist.
+func (db *DB) ListInsert(key string, before bool, pivot, val string) (int, error) {
+	var length int
+
+	err := db.write([]string{key}, func(t *txn) error {
+		list, err := t.lookupList(key)
+		if err != nil || list == nil {
+			return err
+		}
+
+		if !list.insert(pivot, val, before) {
+			length = -1
+			return nil
+		}
+
+		t.modified(key)
+		length = list.length
+
+		return nil
+	})
+
+	return length, err
+}
+
+// ListPosOptions follow the options of the LPOS command.
+type ListPosOptions struct {
+	// Rank skips the first Rank-1 matches, a negative rank searches
+	// from the tail
+	Rank int64
+	// Count is the number of matches returned, zero returns all of them
+	Count int64
+	// MaxLen limits the number of compared elements, zero means no limit
+	MaxLen int64
+}
+
+// ListPos returns the indexes of the elements equal to val.
+func (db *DB) ListPos(key, val string, opts ListPosOptions) ([]int64, error) {
+	positions := []int64{}
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		list, err := t.lookupList(key)
+		if err != nil || list == nil {
+			return err
+		}
+
+		rank := opts.Rank
+		reverse := rank < 0
+		if reverse {
+			rank = -rank
+		}
+
+		var compared int64
+
+		list.forEach(reverse, func(item string, index int) bool {
+			if opts.MaxLen > 0 && compared >= opts.MaxLen {
+				return false
+			}
+
+			compared++
+
+			if item != val {
+				return true
+			}
+
+			// matches before the rank are skipped
+			if rank > 1 {
+				rank--
+				return true
+			}
+
+			positions = append(positions, int64(index))
+
+			return opts.Count == 0 || int64(len(positions)) < opts.Count
+		})
+
+		return nil
+	})
+
+	return positions, err
+}
@@ -0,0 +1,178 @@
+package memstore
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	// the biggest number of elements added by a single rewritten command,
+	// big values are split so replaying them doesn't need huge commands
+	rewriteItemsPerCommand = 64
+)
+
+// Rewrite calls emit with the commands that rebuild the keyspace of the
+// snapshot, it's used to compact the append only file. Expirations are
+// emitted as absolute PEXPIREAT commands and the database of the entries
+// is selected when it changes.
+func (s *Snapshot) Rewrite(emit func(args ...string) error) error {
+	db := 0
+
+	return s.each(func(entry snapshotEntry) error {
+		if entry.db != db {
+			db = entry.db
+
+			err := emit("SELECT", strconv.Itoa(db))
+			if err != nil {
+				return err
+			}
+		}
+
+		err := rewriteEntry(entry, emit)
+		if err != nil {
+			return err
+		}
+
+		if entry.expireAt == 0 {
+			return nil
+		}
+
+		return emit("PEXPIREAT", entry.key, strconv.FormatInt(entry.expireAt, 10))
+	})
+}
+
+func rewriteEntry(entry snapshotEntry, emit func(args ...string) error) error {
+	switch val := entry.val.(type) {
+	case valueString:
+		return emit("SET", entry.key, val.val)
+	case *valueMap:
+		return rewriteMap(entry.key, val, emit)
+	case *valueList:
+		return rewriteItems("RPUSH", entry.key, val.rangeItems(0, val.length-1), emit)
+	case *valueSet:
+		return rewriteItems("SADD", entry.key, val.items(), emit)
+	case *valueSortedSet:
+		// the score and member pairs are never split since the number
+		// of elements per command is even
+		items := make([]string, 0, val.len()*2)
+
+		for x := val.list.head.levels[0].forward; x != nil; x = x.levels[0].forward {
+			items = append(items, strconv.FormatFloat(x.score, 'g', -1, 64), x.member)
+		}
+
+		return rewriteItems("ZADD", entry.key, items, emit)
+	case *valueStream:
+		return rewriteStream(entry.key, val, emit)
+	}
+
+	return fmt.Errorf("can't rewrite value of type %T", entry.val)
+}
+
+// rewriteItems emits cmd with the elements of a collection, split in
+// several commands for big collections.
+func rewriteItems(cmd, key string, items []string, emit func(args ...string) error) error {
+	for len(items) > 0 {
+		n := len(items)
+		if n > rewriteItemsPerCommand {
+			n = rewriteItemsPerCommand
+		}
+
+		args := make([]string, 0, n+2)
+		args = append(args, cmd, key)
+		args = append(args, items[:n]...)
+
+		err := emit(args...)
+		if err != nil {
+			return err
+		}
+
+		items = items[n:]
+	}
+
+	return nil
+}
+
+// rewriteMap emits the fields of the hash followed by the expiration time
+// of the fields that have one.
+func rewriteMap(key string, hash *valueMap, emit func(args ...string) error) error {
+	// the field and value pairs are never split since the number of
+	// elements per command is even
+	items := make([]string, 0, hash.len()*2)
+
+	for field, val := range hash.val {
+		items = append(items, field, val)
+	}
+
+	err := rewriteItems("HSET", key, items, emit)
+	if err != nil {
+		return err
+	}
+
+	for field, at := range hash.expires {
+		err = emit("HPEXPIREAT", key, strconv.FormatInt(at, 10), "FIELDS", "1", field)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rewriteStream emits the entries of the stream, the id of the last added
+// entry and the consumer groups. The pending entries are claimed back with
+// their delivery time and count.
+func rewriteStream(key string, stream *valueStream, emit func(args ...string) error) error {
+	var err error
+
+	// an empty stream is created by adding an entry and trimming it
+	if stream.length == 0 {
+		err = emit("XADD", key, "MAXLEN", "0", "0-1", "x", "y")
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, node := range stream.nodes {
+		for _, entry := range node.entries {
+			args := make([]string, 0, len(entry.Fields)+3)
+			args = append(args, "XADD", key, entry.ID.String())
+			args = append(args, entry.Fields...)
+
+			err = emit(args...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = emit("XSETID", key, stream.lastID.String())
+	if err != nil {
+		return err
+	}
+
+	for name, group := range stream.groups {
+		err = emit("XGROUP", "CREATE", key, name, group.lastID.String())
+		if err != nil {
+			return err
+		}
+
+		for consumer := range group.consumers {
+			err = emit("XGROUP", "CREATECONSUMER", key, name, consumer)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, p := range group.pending {
+			err = emit("XCLAIM", key, name, p.consumer, "0", p.id.String(),
+				"TIME", strconv.FormatInt(p.deliveryTime, 10),
+				"RETRYCOUNT", strconv.FormatInt(p.deliveryCount, 10),
+				"FORCE", "JUSTID")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,385 @@
+package memstore
+
+// SetOperation is the way SetCombine combines several sets.
+type SetOperation int
+
+const (
+	SetInter SetOperation = iota
+	SetUnion
+	SetDiff
+)
+
+// lookupSet returns the set stored at key, or nil when the key doesn't exist.
+func (t *txn) lookupSet(key string) (*valueSet, error) {
+	entry := t.lookup(key)
+	if entry == nil {
+		return nil, nil
+	}
+
+	set, ok := entry.val.(*valueSet)
+	if !ok {
+		return nil, ErrWrongType
+	}
+
+	return set, nil
+}
+
+// setModified marks the set of key as changed, the key is removed once
+// the set is empty.
+func (t *txn) setModified(key string, set *valueSet) {
+	if set.len() == 0 {
+		t.remove(key)
+		return
+	}
+
+	t.modified(key)
+}
+
+// SetAdd adds members to the set and returns how many were not already in it.
+func (db *DB) SetAdd(key string, members ...string) (int, error) {
+	var added int
+
+	err := db.write([]string{key}, func(t *txn) error {
+		set, err := t.lookupSet(key)
+		if err != nil {
+			return err
+		}
+
+		if set == nil {
+			set = newValueSet()
+			t.create(key).val = set
+		}
+
+		for _, member := range members {
+			if set.add(member) {
+				added++
+			}
+		}
+
+		t.setModified(key, set)
+
+		return nil
+	})
+
+	return added, err
+}
+
+// SetRemove removes members from the set and returns how many were in it.
+func (db *DB) SetRemove(key string, members ...string) (int, error) {
+	var removed int
+
+	err := db.update([]string{key}, func(t *txn) error {
+		set, err := t.lookupSet(key)
+		if err != nil || set == nil {
+			return err
+		}
+
+		for _, member := range members {
+			if set.remove(member) {
+				removed++
+			}
+		}
+
+		if removed > 0 {
+			t.setModified(key, set)
+		}
+
+		return nil
+	})
+
+	return removed, err
+}
+
+// SetMembers returns every member of the set.
+func (db *DB) SetMembers(key string) ([]string, error) {
+	members := []string{}
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		set, err := t.lookupSet(key)
+		if err != nil || set == nil {
+			return err
+		}
+
+		members = set.items()
+
+		return nil
+	})
+
+	return members, err
+}
+
+// SetIsMember reports whether every member is in the set.
+func (db *DB) SetIsMember(key string, members ...string) ([]bool, error) {
+	found := make([]bool, len(members))
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		set, err := t.lookupSet(key)
+		if err != nil || set == nil {
+			return err
+		}
+
+		for i, member := range members {
+			found[i] = set.has(member)
+		}
+
+		return nil
+	})
+
+	return found, err
+}
+
+// SetCard returns the number of members, zero when the key doesn't exist.
+func (db *DB) SetCard(key string) (int, error) {
+	var card int
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		set, err := t.lookupSet(key)
+		if err != nil || set == nil {
+			return err
+		}
+
+		card = set.len()
+
+		return nil
+	})
+
+	return card, err
+}
+
+// combine applies op to the sets of keys, missing keys are empty sets.
+func (t *txn) combine(op SetOperation, keys []string) ([]string, error) {
+	sets := make([]*valueSet, 0, len(keys))
+
+	// every key is type checked even when the result is already known
+	for _, key := range keys {
+		set, err := t.lookupSet(key)
+		if err != nil {
+			return nil, err
+		}
+
+		sets = append(sets, set)
+	}
+
+	result := []string{}
+
+	switch op {
+	case SetInter:
+		for _, set := range sets {
+			if set == nil {
+				return result, nil
+			}
+		}
+
+		// the smallest set has the fewest candidates to check
+		smallest := 0
+		for i, set := range sets {
+			if set.len() < sets[smallest].len() {
+				smallest = i
+			}
+		}
+
+	candidates:
+		for _, member := range sets[smallest].items() {
+			for i, set := range sets {
+				if i != smallest && !set.has(member) {
+					continue candidates
+				}
+			}
+
+			result = append(result, member)
+		}
+	case SetUnion:
+		seen := make(map[string]struct{})
+
+		for _, set := range sets {
+			if set == nil {
+				continue
+			}
+
+			for _, member := range set.items() {
+				if _, ok := seen[member]; ok {
+					continue
+				}
+
+				seen[member] = struct{}{}
+				result = append(result, member)
+			}
+		}
+	case SetDiff:
+		if sets[0] == nil {
+			return result, nil
+		}
+
+	members:
+		for _, member := range sets[0].items() {
+			for _, set := range sets[1:] {
+				if set != nil && set.has(member) {
+					continue members
+				}
+			}
+
+			result = append(result, member)
+		}
+	}
+
+	return result, nil
+}
+
+// SetCombine returns the intersection, the union or the difference of the
+// sets of keys, the difference is between the first set and the others.
+func (db *DB) SetCombine(op SetOperation, keys ...string) ([]string, error) {
+	var members []string
+
+	err := db.shard.view(keys, func(t *txn) error {
+		var err error
+
+		members, err = t.combine(op, keys)
+
+		return err
+	})
+
+	return members, err
+}
+
+// SetCombineStore is like SetCombine but stores the result at dst, replacing
+// any value, and returns its cardinality. An empty result deletes dst.
+func (db *DB) SetCombineStore(op SetOperation, dst string, keys ...string) (int, error) {
+	var card int
+
+	err := db.write(append([]string{dst}, keys...), func(t *txn) error {
+		members, err := t.combine(op, keys)
+		if err != nil {
+			return err
+		}
+
+		t.remove(dst)
+
+		if len(members) == 0 {
+			return nil
+		}
+
+		set := newValueSet()
+		for _, member := range members {
+			set.add(member)
+		}
+
+		t.create(dst).val = set
+		t.modified(dst)
+		card = set.len()
+
+		return nil
+	})
+
+	return card, err
+}
+
+// SetPop removes and returns up to count members chosen at random.
+// ErrNilEntries is returned when the key doesn't exist.
+func (db *DB) SetPop(key string, count int) ([]string, error) {
+	var members []string
+
+	err := db.update([]string{key}, func(t *txn) error {
+		set, err := t.lookupSet(key)
+		if err != nil {
+			return err
+		}
+
+		if set == nil {
+			return ErrNilEntries
+		}
+
+		if count >= set.len() {
+			members = set.items()
+			t.remove(key)
+
+			return nil
+		}
+
+		members = make([]string, 0, count)
+
+		for i := 0; i < count; i++ {
+			member := set.randomMember()
+			set.remove(member)
+			members = append(members, member)
+		}
+
+		if count > 0 {
+			t.setModified(key, set)
+		}
+
+		return nil
+	})
+
+	return members, err
+}
+
+// SetRandMember returns up to count distinct members chosen at random, a
+// negative count returns exactly -count members that may repeat.
+// ErrNilEntries is returned when the key doesn't exist.
+func (db *DB) SetRandMember(key string, count int) ([]string, error) {
+	var members []string
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		set, err := t.lookupSet(key)
+		if err != nil {
+			return err
+		}
+
+		if set == nil {
+			return ErrNilEntries
+		}
+
+		if count < 0 {
+			members = set.random(-count, true)
+		} else {
+			members = set.random(count, false)
+		}
+
+		return nil
+	})
+
+	return members, err
+}
+
+// SetMove moves member from the set of src to the set of dst and reports
+// whether it was in src.
+func (db *DB) SetMove(src, dst, member string) (bool, error) {
+	var moved bool
+
+	err := db.write([]string{src, dst}, func(t *txn) error {
+		srcSet, err := t.lookupSet(src)
+		if err != nil {
+			return err
+		}
+
+		dstSet, err := t.lookupSet(dst)
+		if err != nil {
+			return err
+		}
+
+		if srcSet == nil || !srcSet.has(member) {
+			return nil
+		}
+
+		moved = true
+
+		if src == dst {
+			return nil
+		}
+
+		srcSet.remove(member)
+		t.setModified(src, srcSet)
+
+		if dstSet == nil {
+			dstSet = newValueSet()
+			t.create(dst).val = dstSet
+		}
+
+		dstSet.add(member)
+		t.modified(dst)
+
+		return nil
+	})
+
+	return moved, err
+}
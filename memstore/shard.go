@@ -26,41 +26,38 @@ const (
 	Koopman = 0xeb31d82e
 )
 
-var (
-	shard  *Shard
-	doOnce sync.Once
-)
+func newShard() *Shard {
+	s := new(Shard)
+	s.init()
 
-func init() {
-	doOnce.Do(func() {
-		shard = new(Shard)
-		shard.init()
-	})
+	return s
 }
 
-func Get(valueType ValueType, key string, args ...string) (any, error) {
-	crcTable := crc32.MakeTable(IEEE)
-	hashKey := crc32.Checksum([]byte(key), crcTable)
-	blockNum := hashKey % MaxShardBlock
-
-	return shard.Get(valueType, blockNum, hashKey, key, args...)
+func (db *DB) Get(valueType ValueType, key string, args ...string) (any, error) {
+	blockNum, hashKey := locate(key)
+	return db.shard.Get(valueType, blockNum, hashKey, key, args...)
 }
 
-func Set(valueType ValueType, key string, args ...string) (int, error) {
-	crcTable := crc32.MakeTable(IEEE)
-	hashKey := crc32.Checksum([]byte(key), crcTable)
-	blockNum := hashKey % MaxShardBlock
+func (db *DB) Set(valueType ValueType, key string, args ...string) (int, error) {
+	err := freeMemory()
+	if err != nil {
+		return 0, err
+	}
 
-	return shard.Set(valueType, blockNum, hashKey, key, args...)
+	blockNum, hashKey := locate(key)
+	return db.shard.Set(valueType, blockNum, hashKey, key, args...)
 }
 
-func Delete(key string) string {
-	crcTable := crc32.MakeTable(IEEE)
-	hashKey := crc32.Checksum([]byte(key), crcTable)
-	blockNum := hashKey % MaxShardBlock
+var (
+	crcTable = crc32.MakeTable(IEEE)
+)
+
+// locate returns the block number and the hash of key.
+func locate(key string) (blockNum, hashKey uint32) {
+	hashKey = crc32.Checksum([]byte(key), crcTable)
+	blockNum = hashKey % MaxShardBlock
 
-	shard.Delete(blockNum, hashKey, key)
-	return "1"
+	return blockNum, hashKey
 }
 
 var (
@@ -177,21 +174,32 @@ func (s *Shard) Set(valueType ValueType, blockNum, hashKey uint32, key string, a
 	return n, e
 }
 
-func (s *Shard) Delete(blockNum, hashKey uint32, key string) {
+// Delete removes key and reports whether it existed.
+func (s *Shard) Delete(blockNum, hashKey uint32, key string) bool {
 	node := s.getNode(blockNum)
 	if node == nil {
-		return
+		return false
 	}
 
-	node.Delete(blockNum, hashKey, key)
-	// s.printInfo()
+	return node.Delete(blockNum, hashKey, key)
+}
+
+// Storage returns the storage of a block, or nil when no node owns the block.
+func (s *Shard) Storage(blockNum uint32) *Storage {
+	node := s.getNode(blockNum)
+	if node == nil {
+		return nil
+	}
+
+	return node.Storage(blockNum)
 }
 
 type ShardNode interface {
 	InRange(blockNum uint32) bool
 	Get(valueType ValueType, blockNum, hashKey uint32, key string, args ...string) (any, error)
 	Set(valueType ValueType, blockNum, hashKey uint32, key string, args ...string) (int, error)
-	Delete(blockNum, hashKey uint32, key string)
+	Delete(blockNum, hashKey uint32, key string) bool
+	Storage(blockNum uint32) *Storage
 }
 
 type localShard struct {
@@ -203,10 +211,11 @@ func newLocalShard(blockRange Range) localShard {
 	blocks := make(map[uint32]shardBlock, blockRange.Length())
 
 	for i := uint32(0); i < blockRange.Length(); i++ {
+		storage := newStorage()
+		storage.startSweeper()
+
 		blocks[i] = shardBlock{
-			storage: &Storage{
-				entries: make(map[uint32]EntryNode),
-			},
+			storage: storage,
 		}
 	}
 
@@ -229,8 +238,12 @@ func (l localShard) Set(valueType ValueType, blockNum, hashKey uint32, key strin
 	return n, e
 }
 
-func (l localShard) Delete(blockNum, hashKey uint32, key string) {
-	l.blocks[blockNum-l.blockRange.start].Delete(hashKey, key)
+func (l localShard) Delete(blockNum, hashKey uint32, key string) bool {
+	return l.blocks[blockNum-l.blockRange.start].Delete(hashKey, key)
+}
+
+func (l localShard) Storage(blockNum uint32) *Storage {
+	return l.blocks[blockNum-l.blockRange.start].storage
 }
 
 type RemoteConfig struct{}
@@ -260,13 +273,22 @@ type shardBlock struct {
 }
 
 func (sb shardBlock) Get(valueType ValueType, hashKey uint32, key string, args ...string) (any, error) {
+	sb.storage.mu.RLock()
+	defer sb.storage.mu.RUnlock()
+
 	return sb.storage.Get(valueType, hashKey, key, args...)
 }
 
 func (sb shardBlock) Set(valueType ValueType, hashKey uint32, key string, args ...string) (int, error) {
+	sb.storage.mu.Lock()
+	defer sb.storage.mu.Unlock()
+
 	return sb.storage.Set(valueType, hashKey, key, args...)
 }
 
-func (sb shardBlock) Delete(hashKey uint32, key string) {
-	sb.storage.Delete(hashKey, key)
+func (sb shardBlock) Delete(hashKey uint32, key string) bool {
+	sb.storage.mu.Lock()
+	defer sb.storage.mu.Unlock()
+
+	return sb.storage.Delete(hashKey, key)
 }
@@ -0,0 +1,342 @@
+package memstore
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestDB returns a database that isn't registered in the databases, so
+// the tests don't share their keys.
+func newTestDB() *DB {
+	return &DB{
+		shard:   newShard(),
+		waiters: newWaitRegistry(),
+	}
+}
+
+// spreadKeys returns n keys named after prefix and fails the test unless
+// they live in several blocks.
+func spreadKeys(tb testing.TB, prefix string, n int) []string {
+	tb.Helper()
+
+	keys := make([]string, n)
+	blocks := make(map[uint32]struct{})
+
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%s:%d", prefix, i)
+
+		blockNum, _ := locate(keys[i])
+		blocks[blockNum] = struct{}{}
+	}
+
+	if len(blocks) < 2 {
+		tb.Fatalf("the %d keys of %q live in %d block", n, prefix, len(blocks))
+	}
+
+	return keys
+}
+
+func TestConcurrentSetGetDelete(t *testing.T) {
+	const (
+		workers = 8
+		perKeys = 50
+		rounds  = 20
+	)
+
+	db := newTestDB()
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		keys := spreadKeys(t, fmt.Sprintf("worker%d", w), perKeys)
+
+		wg.Add(1)
+
+		go func(w int, keys []string) {
+			defer wg.Done()
+
+			for round := 0; round < rounds; round++ {
+				for i, key := range keys {
+					val := fmt.Sprintf("%d:%d", round, i)
+
+					_, _, err := db.SetString(key, val, SetOptions{})
+					if err != nil {
+						t.Errorf("set %s: %v", key, err)
+						return
+					}
+
+					got, err := db.GetStrings(key)
+					if err != nil || got[0] == nil || *got[0] != val {
+						t.Errorf("get %s = %v, %v, want %s", key, got, err, val)
+						return
+					}
+				}
+
+				// the odd keys are deleted on every round but the last one
+				if round == rounds-1 {
+					continue
+				}
+
+				for i := 1; i < len(keys); i += 2 {
+					n, err := db.Delete(keys[i])
+					if err != nil || n != 1 {
+						t.Errorf("delete %s = %d, %v, want 1", keys[i], n, err)
+						return
+					}
+				}
+			}
+
+			// every other key of the worker is deleted in a single call
+			var odd []string
+			for i := 1; i < len(keys); i += 2 {
+				odd = append(odd, keys[i])
+			}
+
+			n, err := db.Delete(odd...)
+			if err != nil || n != len(odd) {
+				t.Errorf("worker %d deleted %d keys, %v, want %d", w, n, err, len(odd))
+			}
+		}(w, keys)
+	}
+
+	wg.Wait()
+
+	if t.Failed() {
+		return
+	}
+
+	for w := 0; w < workers; w++ {
+		keys := spreadKeys(t, fmt.Sprintf("worker%d", w), perKeys)
+
+		vals, err := db.GetStrings(keys...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, val := range vals {
+			if i%2 == 1 {
+				if val != nil {
+					t.Errorf("%s = %q, want deleted", keys[i], *val)
+				}
+
+				continue
+			}
+
+			want := fmt.Sprintf("%d:%d", rounds-1, i)
+			if val == nil || *val != want {
+				t.Errorf("%s = %v, want %s", keys[i], val, want)
+			}
+		}
+	}
+
+	if size, want := db.DBSize(), workers*perKeys/2; size != want {
+		t.Errorf("DBSize() = %d, want %d", size, want)
+	}
+}
+
+func TestConcurrentIncrBy(t *testing.T) {
+	const (
+		workers = 16
+		incrs   = 200
+	)
+
+	db := newTestDB()
+	keys := spreadKeys(t, "counter", 10)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < incrs; i++ {
+				_, err := db.IncrBy(keys[i%len(keys)], 1)
+				if err != nil {
+					t.Errorf("incr: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	vals, err := db.GetStrings(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := strconv.Itoa(workers * incrs / len(keys))
+
+	for i, val := range vals {
+		if val == nil || *val != want {
+			t.Errorf("%s = %v, want %s", keys[i], val, want)
+		}
+	}
+}
+
+// TestConcurrentSetStrings checks that the keys written together are
+// never seen half written, even though they live in different blocks.
+func TestConcurrentSetStrings(t *testing.T) {
+	const (
+		writers = 4
+		readers = 4
+		rounds  = 200
+	)
+
+	db := newTestDB()
+	keys := spreadKeys(t, "mset", 8)
+
+	var writes, reads sync.WaitGroup
+
+	for w := 0; w < writers; w++ {
+		writes.Add(1)
+
+		go func(w int) {
+			defer writes.Done()
+
+			for round := 0; round < rounds; round++ {
+				val := fmt.Sprintf("%d:%d", w, round)
+
+				args := make([]string, 0, len(keys)*2)
+				for _, key := range keys {
+					args = append(args, key, val)
+				}
+
+				if _, err := db.SetStrings(false, args...); err != nil {
+					t.Errorf("mset: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	done := make(chan struct{})
+
+	for r := 0; r < readers; r++ {
+		reads.Add(1)
+
+		go func() {
+			defer reads.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				vals, err := db.GetStrings(keys...)
+				if err != nil {
+					t.Errorf("mget: %v", err)
+					return
+				}
+
+				if vals[0] == nil {
+					continue
+				}
+
+				for i, val := range vals {
+					if val == nil || *val != *vals[0] {
+						t.Errorf("%s = %v while %s = %s", keys[i], val, keys[0], *vals[0])
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	writes.Wait()
+	close(done)
+	reads.Wait()
+}
+
+func TestConcurrentExpire(t *testing.T) {
+	const workers = 4
+
+	db := newTestDB()
+	keys := spreadKeys(t, "volatile", 100)
+	persistent := spreadKeys(t, "persistent", 20)
+
+	for _, key := range append(keys, persistent...) {
+		if _, _, err := db.SetString(key, "v", SetOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	at := now() + 500
+
+	var wg sync.WaitGroup
+
+	// the expirations are set while other clients read and write the
+	// same blocks
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func(w int) {
+			defer wg.Done()
+
+			for i := w; i < len(keys); i += workers {
+				if ok, err := db.Expire(keys[i], at, 0); err != nil || !ok {
+					t.Errorf("expire %s = %t, %v", keys[i], ok, err)
+					return
+				}
+			}
+		}(w)
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 100; i++ {
+				if _, err := db.Exists(keys...); err != nil {
+					t.Errorf("exists: %v", err)
+					return
+				}
+
+				if _, err := db.IncrBy("counter", 1); err != nil {
+					t.Errorf("incr: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if n, _ := db.Exists(keys...); n != len(keys) {
+		t.Fatalf("%d keys exist before they expire, want %d", n, len(keys))
+	}
+
+	time.Sleep(time.Until(time.UnixMilli(at)) + 10*time.Millisecond)
+
+	if n, _ := db.Exists(keys...); n != 0 {
+		t.Errorf("%d keys exist after they expired, want 0", n)
+	}
+
+	if n, _ := db.Exists(persistent...); n != len(persistent) {
+		t.Errorf("%d persistent keys exist, want %d", n, len(persistent))
+	}
+
+	// the sweepers remove the expired keys that are never accessed again
+	want := len(persistent) + 1
+
+	deadline := time.Now().Add(5 * time.Second)
+	for db.DBSize() != want && time.Now().Before(deadline) {
+		time.Sleep(sweepInterval)
+	}
+
+	if size := db.DBSize(); size != want {
+		t.Errorf("DBSize() = %d after the sweep, want %d", size, want)
+	}
+
+	if vals, _ := db.GetStrings("counter"); vals[0] == nil || *vals[0] != strconv.Itoa(workers*100) {
+		t.Errorf("counter = %v, want %d", vals[0], workers*100)
+	}
+}
@@ -0,0 +1,279 @@
+package memstore
+
+import (
+	"math/rand"
+)
+
+const (
+	skiplistMaxLevel = 32
+	// the probability of a node to have one more level
+	skiplistP = 0.25
+)
+
+// skiplist keeps the members of a sorted set ordered by score, then by
+// member. Every level stores the span to the next node, so the rank of a
+// member is found while searching it.
+type skiplist struct {
+	head   *skiplistNode
+	tail   *skiplistNode
+	length int
+	level  int
+}
+
+type skiplistNode struct {
+	member   string
+	score    float64
+	backward *skiplistNode
+	levels   []skiplistLevel
+}
+
+type skiplistLevel struct {
+	forward *skiplistNode
+	// span is the number of nodes between this node and forward
+	span int
+}
+
+// skiplistRange is a range of nodes, sorted sets are queried by score
+// or by member.
+type skiplistRange interface {
+	// gteMin reports whether the node is after the start of the range
+	gteMin(node *skiplistNode) bool
+	// lteMax reports whether the node is before the end of the range
+	lteMax(node *skiplistNode) bool
+	// empty reports whether no node can be in the range
+	empty() bool
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head: &skiplistNode{
+			levels: make([]skiplistLevel, skiplistMaxLevel),
+		},
+		level: 1,
+	}
+}
+
+func skiplistRandomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+
+	return level
+}
+
+// before reports whether the node is ordered before score and member.
+func (n *skiplistNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// next returns the following node, or the previous one when reverse is set.
+func (n *skiplistNode) next(reverse bool) *skiplistNode {
+	if reverse {
+		return n.backward
+	}
+
+	return n.levels[0].forward
+}
+
+// insert adds a node, the member must not be in the list yet.
+func (l *skiplist) insert(score float64, member string) *skiplistNode {
+	var (
+		update [skiplistMaxLevel]*skiplistNode
+		rank   [skiplistMaxLevel]int
+	)
+
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		if i < l.level-1 {
+			rank[i] = rank[i+1]
+		}
+
+		for x.levels[i].forward != nil && x.levels[i].forward.before(score, member) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].forward
+		}
+
+		update[i] = x
+	}
+
+	level := skiplistRandomLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			update[i] = l.head
+			update[i].levels[i].span = l.length
+		}
+
+		l.level = level
+	}
+
+	x = &skiplistNode{
+		member: member,
+		score:  score,
+		levels: make([]skiplistLevel, level),
+	}
+
+	for i := 0; i < level; i++ {
+		x.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = x
+
+		x.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+
+	// the levels above the new node now span one more node
+	for i := level; i < l.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	if update[0] != l.head {
+		x.backward = update[0]
+	}
+
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x
+	} else {
+		l.tail = x
+	}
+
+	l.length++
+
+	return x
+}
+
+// delete removes the node of score and member, it reports whether it existed.
+func (l *skiplist) delete(score float64, member string) bool {
+	var update [skiplistMaxLevel]*skiplistNode
+
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && x.levels[i].forward.before(score, member) {
+			x = x.levels[i].forward
+		}
+
+		update[i] = x
+	}
+
+	x = x.levels[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	l.deleteNode(x, update[:])
+
+	return true
+}
+
+func (l *skiplist) deleteNode(x *skiplistNode, update []*skiplistNode) {
+	for i := 0; i < l.level; i++ {
+		if update[i].levels[i].forward == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].forward = x.levels[i].forward
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x.backward
+	} else {
+		l.tail = x.backward
+	}
+
+	for l.level > 1 && l.head.levels[l.level-1].forward == nil {
+		l.level--
+	}
+
+	l.length--
+}
+
+// rank returns the 1-based rank of the node of score and member, zero
+// when it doesn't exist.
+func (l *skiplist) rank(score float64, member string) int {
+	var rank int
+
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil &&
+			(x.levels[i].forward.before(score, member) ||
+				(x.levels[i].forward.score == score && x.levels[i].forward.member == member)) {
+			rank += x.levels[i].span
+			x = x.levels[i].forward
+		}
+
+		if x != l.head && x.member == member {
+			return rank
+		}
+	}
+
+	return 0
+}
+
+// byRank returns the node at the 1-based rank, nil when it's out of range.
+func (l *skiplist) byRank(rank int) *skiplistNode {
+	var traversed int
+
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span <= rank {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+
+		if traversed == rank {
+			return x
+		}
+	}
+
+	return nil
+}
+
+// inRange reports whether some part of the list is in r.
+func (l *skiplist) inRange(r skiplistRange) bool {
+	if r.empty() || l.tail == nil {
+		return false
+	}
+
+	return r.gteMin(l.tail) && r.lteMax(l.head.levels[0].forward)
+}
+
+// firstInRange returns the first node in r, nil when there is none.
+func (l *skiplist) firstInRange(r skiplistRange) *skiplistNode {
+	if !l.inRange(r) {
+		return nil
+	}
+
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && !r.gteMin(x.levels[i].forward) {
+			x = x.levels[i].forward
+		}
+	}
+
+	x = x.levels[0].forward
+	if x == nil || !r.lteMax(x) {
+		return nil
+	}
+
+	return x
+}
+
+// lastInRange returns the last node in r, nil when there is none.
+func (l *skiplist) lastInRange(r skiplistRange) *skiplistNode {
+	if !l.inRange(r) {
+		return nil
+	}
+
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && r.lteMax(x.levels[i].forward) {
+			x = x.levels[i].forward
+		}
+	}
+
+	if x == l.head || !r.gteMin(x) {
+		return nil
+	}
+
+	return x
+}
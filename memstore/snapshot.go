@@ -0,0 +1,1007 @@
+package memstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"math"
+	"sync/atomic"
+)
+
+// The snapshot file starts with the magic string and the format version,
+// followed by one record per entry and the EOF opcode. The entries of a
+// database other than the first one are preceded by the select opcode and
+// the uvarint index of the database. The last 8 bytes
+// are the big endian CRC-64 (ECMA) of everything before them.
+//
+// An entry record is: opcode, value type, expiration in unix milliseconds
+// (uvarint, zero when the key never expires), key and the encoded value.
+// Strings are written as their uvarint length followed by the bytes and
+// scores as big endian IEEE 754 doubles.
+const (
+	snapshotMagic   = "TEMPORAMA"
+	snapshotVersion = 2
+
+	snapshotOpEntry    byte = 0x01
+	snapshotOpSelectDB byte = 0xFE
+	snapshotOpEOF      byte = 0xFF
+
+	// value types as stored in the file, they are part of the format
+	// and must never be renumbered
+	snapshotTypeString    byte = 0
+	snapshotTypeMap       byte = 1
+	snapshotTypeList      byte = 2
+	snapshotTypeSet       byte = 3
+	snapshotTypeSortedSet byte = 4
+	snapshotTypeStream    byte = 5
+	// a map with the expiration time of every field after its value,
+	// zero when the field never expires
+	snapshotTypeMapVolatile byte = 6
+
+	// the biggest string or collection length accepted while decoding
+	snapshotMaxLength = 1 << 32
+)
+
+var (
+	ErrSnapshotCorrupted = errors.New("snapshot is corrupted")
+
+	crc64Table = crc64.MakeTable(crc64.ECMA)
+)
+
+// cloner is implemented by the values that are modified in place,
+// snapshots hold a copy of them.
+type cloner interface {
+	clone() any
+}
+
+func (v *valueMap) clone() any {
+	val := make(map[string]string, len(v.val))
+	for field, fieldVal := range v.val {
+		val[field] = fieldVal
+	}
+
+	var expires map[string]int64
+	if len(v.expires) > 0 {
+		expires = make(map[string]int64, len(v.expires))
+		for field, at := range v.expires {
+			expires[field] = at
+		}
+	}
+
+	return &valueMap{
+		val:     val,
+		expires: expires,
+		bytes:   v.bytes,
+	}
+}
+
+type snapshotEntry struct {
+	// db is the index of the database of the entry
+	db       int
+	key      string
+	val      any
+	expireAt int64
+}
+
+// Snapshot is a point in time copy of the keyspace. Taking it doesn't copy
+// anything, the entries are copied one block at a time while it's written
+// and the writers keep the entries they modify before that as they were,
+// like the copy on write of a forked process.
+type Snapshot struct {
+	// blocks are the blocks that are not copied yet, by database
+	blocks []*blockSnapshot
+	// changes is the value of the change counter when the snapshot was taken
+	changes int64
+	// ts is the time the snapshot was taken, the entries expired at that
+	// time are left out
+	ts   int64
+	keys int
+}
+
+// blockSnapshot is the part of a snapshot held by a block until it's copied.
+type blockSnapshot struct {
+	db int
+	// storage is the block holding the entries, SWAPDB moves them to the
+	// block of the other database
+	storage atomic.Pointer[Storage]
+	// saved holds the entries modified since the snapshot was taken as they
+	// were then, a nil entry is a key that didn't exist
+	saved map[string]*snapshotEntry
+	// flushed holds the entries of the block when it was flushed, the ones
+	// added afterwards are not part of the snapshot
+	flushed map[uint32]*EntryNode
+}
+
+// TakeSnapshot starts a snapshot of every database, it only locks every
+// block for the time needed to mark it. The snapshot must be written with
+// Encode or Rewrite, or released, since the writers keep the modified
+// entries for it until then.
+func TakeSnapshot() *Snapshot {
+	return TakeSnapshotWith(nil)
+}
+
+// TakeSnapshotWith is like TakeSnapshot, marked is called once the blocks
+// are marked and before they are unlocked, so nothing is modified between
+// the snapshot and the call.
+func TakeSnapshotWith(marked func()) *Snapshot {
+	dbs := createdDatabases()
+
+	unlock := lockAll(dbs...)
+	defer unlock()
+
+	snapshot := &Snapshot{
+		changes: changes.Load(),
+		ts:      now(),
+	}
+
+	for _, db := range dbs {
+		for _, storage := range db.storages() {
+			b := &blockSnapshot{
+				db:    db.index,
+				saved: make(map[string]*snapshotEntry),
+			}
+			b.storage.Store(storage)
+
+			storage.snapshots = append(storage.snapshots, b)
+			snapshot.blocks = append(snapshot.blocks, b)
+		}
+	}
+
+	if marked != nil {
+		marked()
+	}
+
+	return snapshot
+}
+
+// preserve keeps the entry of key as it was when the running snapshots
+// were taken, it must be called with the block locked for writing before
+// the entry is modified or removed.
+func (s *Storage) preserve(hashKey uint32, key string) {
+	for _, b := range s.snapshots {
+		if b.flushed != nil {
+			continue
+		}
+
+		if _, ok := b.saved[key]; ok {
+			continue
+		}
+
+		entry := s.peek(hashKey, key)
+		if entry == nil || entry.val == nil {
+			b.saved[key] = nil
+			continue
+		}
+
+		val := entry.val
+		if c, ok := val.(cloner); ok {
+			val = c.clone()
+		}
+
+		b.saved[key] = &snapshotEntry{
+			db:       b.db,
+			key:      key,
+			val:      val,
+			expireAt: entry.expireAt,
+		}
+	}
+}
+
+// detach removes b from the snapshots of its block.
+func (s *Storage) detach(b *blockSnapshot) {
+	for i, snapshot := range s.snapshots {
+		if snapshot == b {
+			s.snapshots = append(s.snapshots[:i], s.snapshots[i+1:]...)
+			return
+		}
+	}
+}
+
+// lock locks the block holding the entries of b, for reading unless write
+// is set.
+func (b *blockSnapshot) lock(write bool) (storage *Storage, unlock func()) {
+	for {
+		storage = b.storage.Load()
+
+		lock, unlock := storage.mu.RLock, storage.mu.RUnlock
+		if write {
+			lock, unlock = storage.mu.Lock, storage.mu.Unlock
+		}
+
+		lock()
+
+		// the entries moved to another block before it was locked
+		if b.storage.Load() == storage {
+			return storage, unlock
+		}
+
+		unlock()
+	}
+}
+
+// copy returns the entries of the block as they were at ts, the values
+// are cloned so they can be written after the block is unlocked.
+func (b *blockSnapshot) copy(ts int64) []snapshotEntry {
+	storage, unlock := b.lock(false)
+	defer unlock()
+
+	entries := b.flushed
+	if entries == nil {
+		entries = storage.entries
+	}
+
+	copied := make([]snapshotEntry, 0, len(entries)+len(b.saved))
+
+	for _, entry := range b.saved {
+		if entry != nil && (entry.expireAt == 0 || entry.expireAt > ts) {
+			copied = append(copied, *entry)
+		}
+	}
+
+	for _, head := range entries {
+		for entry := head; entry != nil; entry = entry.child {
+			if !entry.assigned || entry.val == nil || entry.Expired(ts) {
+				continue
+			}
+
+			if _, ok := b.saved[entry.key]; ok {
+				continue
+			}
+
+			val := entry.val
+			if c, ok := val.(cloner); ok {
+				val = c.clone()
+			}
+
+			copied = append(copied, snapshotEntry{
+				db:       b.db,
+				key:      entry.key,
+				val:      val,
+				expireAt: entry.expireAt,
+			})
+		}
+	}
+
+	return copied
+}
+
+// release stops keeping the modified entries for b.
+func (b *blockSnapshot) release() {
+	storage, unlock := b.lock(true)
+	defer unlock()
+
+	storage.detach(b)
+}
+
+// each calls fn with every entry of the snapshot, by database. The blocks
+// are copied one at a time and released right after, so a snapshot can
+// only be written once.
+func (s *Snapshot) each(fn func(entry snapshotEntry) error) error {
+	defer s.Release()
+
+	for len(s.blocks) > 0 {
+		b := s.blocks[0]
+
+		entries := b.copy(s.ts)
+		b.release()
+
+		s.blocks = s.blocks[1:]
+
+		for _, entry := range entries {
+			err := fn(entry)
+			if err != nil {
+				return err
+			}
+
+			s.keys++
+		}
+	}
+
+	return nil
+}
+
+// Release stops keeping the modified entries for the blocks that are not
+// written yet, it's needed when the snapshot is not written at all.
+func (s *Snapshot) Release() {
+	for _, b := range s.blocks {
+		b.release()
+	}
+
+	s.blocks = nil
+}
+
+// Changes returns the value of the change counter when the snapshot was taken.
+func (s *Snapshot) Changes() int64 {
+	return s.changes
+}
+
+// Len returns the number of keys written from the snapshot.
+func (s *Snapshot) Len() int {
+	return s.keys
+}
+
+// Encode writes the snapshot in the binary snapshot format.
+func (s *Snapshot) Encode(w io.Writer) error {
+	checksum := crc64.New(crc64Table)
+
+	enc := &snapshotEncoder{
+		w: bufio.NewWriter(io.MultiWriter(w, checksum)),
+	}
+
+	enc.writeBytes([]byte(snapshotMagic))
+	enc.writeByte(snapshotVersion)
+
+	db := 0
+
+	err := s.each(func(entry snapshotEntry) error {
+		if entry.db != db {
+			db = entry.db
+
+			enc.writeByte(snapshotOpSelectDB)
+			enc.writeUvarint(uint64(db))
+		}
+
+		enc.writeByte(snapshotOpEntry)
+		enc.writeEntry(entry)
+
+		return enc.err
+	})
+	if err != nil {
+		return err
+	}
+
+	enc.writeByte(snapshotOpEOF)
+
+	if enc.err != nil {
+		return enc.err
+	}
+
+	err = enc.w.Flush()
+	if err != nil {
+		return err
+	}
+
+	sum := make([]byte, 8)
+	binary.BigEndian.PutUint64(sum, checksum.Sum64())
+
+	_, err = w.Write(sum)
+	return err
+}
+
+// Restore decodes a snapshot and adds its entries to their database, the
+// keyspace is left untouched when the snapshot is corrupted.
+func Restore(r io.Reader) error {
+	dec := &snapshotDecoder{
+		r:        bufio.NewReader(r),
+		checksum: crc64.New(crc64Table),
+	}
+
+	entries, err := dec.decode()
+	if err != nil {
+		return err
+	}
+
+	ts := now()
+
+	for _, entry := range entries {
+		// keys that expired while the server was down are not loaded
+		if entry.expireAt != 0 && entry.expireAt <= ts {
+			continue
+		}
+
+		err = Database(entry.db).shard.update([]string{entry.key}, func(t *txn) error {
+			e := t.create(entry.key)
+			e.val = entry.val
+			t.setExpire(entry.key, e, entry.expireAt)
+
+			// the fields of a hash expire while the server is down too
+			if hash, ok := entry.val.(*valueMap); ok {
+				hash.purge(ts)
+				t.hashModified(entry.key, hash)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// snapshotEncoder keeps the first write error, so the encoding code
+// doesn't need to check every write.
+type snapshotEncoder struct {
+	w   *bufio.Writer
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (e *snapshotEncoder) writeBytes(b []byte) {
+	if e.err != nil {
+		return
+	}
+
+	_, e.err = e.w.Write(b)
+}
+
+func (e *snapshotEncoder) writeByte(b byte) {
+	if e.err != nil {
+		return
+	}
+
+	e.err = e.w.WriteByte(b)
+}
+
+func (e *snapshotEncoder) writeUvarint(v uint64) {
+	n := binary.PutUvarint(e.buf[:], v)
+	e.writeBytes(e.buf[:n])
+}
+
+// writeStream writes the entries of the stream with their count, the id of
+// the last added entry and the consumer groups with their pending entries.
+func (e *snapshotEncoder) writeStream(stream *valueStream) {
+	e.writeUvarint(uint64(stream.length))
+
+	for _, node := range stream.nodes {
+		for _, entry := range node.entries {
+			e.writeID(entry.ID)
+			e.writeUvarint(uint64(len(entry.Fields)))
+
+			for _, field := range entry.Fields {
+				e.writeString(field)
+			}
+		}
+	}
+
+	e.writeID(stream.lastID)
+	e.writeUvarint(uint64(len(stream.groups)))
+
+	for name, group := range stream.groups {
+		e.writeString(name)
+		e.writeID(group.lastID)
+		e.writeUvarint(uint64(len(group.consumers)))
+
+		for consumerName, consumer := range group.consumers {
+			e.writeString(consumerName)
+			e.writeUvarint(uint64(consumer.seenTime))
+		}
+
+		e.writeUvarint(uint64(len(group.pending)))
+
+		for _, p := range group.pending {
+			e.writeID(p.id)
+			e.writeString(p.consumer)
+			e.writeUvarint(uint64(p.deliveryTime))
+			e.writeUvarint(uint64(p.deliveryCount))
+		}
+	}
+}
+
+func (e *snapshotEncoder) writeID(id StreamID) {
+	e.writeUvarint(id.Ms)
+	e.writeUvarint(id.Seq)
+}
+
+func (e *snapshotEncoder) writeFloat(v float64) {
+	binary.BigEndian.PutUint64(e.buf[:8], math.Float64bits(v))
+	e.writeBytes(e.buf[:8])
+}
+
+func (e *snapshotEncoder) writeString(s string) {
+	e.writeUvarint(uint64(len(s)))
+
+	if e.err != nil {
+		return
+	}
+
+	_, e.err = e.w.WriteString(s)
+}
+
+func (e *snapshotEncoder) writeEntry(entry snapshotEntry) {
+	switch val := entry.val.(type) {
+	case valueString:
+		e.writeByte(snapshotTypeString)
+		e.writeUvarint(uint64(entry.expireAt))
+		e.writeString(entry.key)
+		e.writeString(val.val)
+	case *valueMap:
+		// the expiration of the fields is only written when there is one
+		volatile := len(val.expires) > 0
+		if volatile {
+			e.writeByte(snapshotTypeMapVolatile)
+		} else {
+			e.writeByte(snapshotTypeMap)
+		}
+
+		e.writeUvarint(uint64(entry.expireAt))
+		e.writeString(entry.key)
+		e.writeUvarint(uint64(len(val.val)))
+
+		for field, fieldVal := range val.val {
+			e.writeString(field)
+			e.writeString(fieldVal)
+
+			if volatile {
+				e.writeUvarint(uint64(val.expires[field]))
+			}
+		}
+	case *valueList:
+		e.writeByte(snapshotTypeList)
+		e.writeUvarint(uint64(entry.expireAt))
+		e.writeString(entry.key)
+		e.writeUvarint(uint64(val.length))
+
+		val.forEach(false, func(item string, _ int) bool {
+			e.writeString(item)
+			return e.err == nil
+		})
+	case *valueSet:
+		e.writeByte(snapshotTypeSet)
+		e.writeUvarint(uint64(entry.expireAt))
+		e.writeString(entry.key)
+		e.writeUvarint(uint64(val.len()))
+
+		for _, member := range val.items() {
+			e.writeString(member)
+		}
+	case *valueSortedSet:
+		e.writeByte(snapshotTypeSortedSet)
+		e.writeUvarint(uint64(entry.expireAt))
+		e.writeString(entry.key)
+		e.writeUvarint(uint64(val.len()))
+
+		for x := val.list.head.levels[0].forward; x != nil; x = x.levels[0].forward {
+			e.writeString(x.member)
+			e.writeFloat(x.score)
+		}
+	case *valueStream:
+		e.writeByte(snapshotTypeStream)
+		e.writeUvarint(uint64(entry.expireAt))
+		e.writeString(entry.key)
+		e.writeStream(val)
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("can't encode value of type %T", entry.val)
+		}
+	}
+}
+
+// snapshotDecoder feeds every consumed byte to the checksum, the bytes
+// buffered ahead by the reader are not part of it until they are consumed.
+type snapshotDecoder struct {
+	r        *bufio.Reader
+	checksum hash.Hash64
+}
+
+func (d *snapshotDecoder) decode() ([]snapshotEntry, error) {
+	magic, err := d.readBytes(len(snapshotMagic))
+	if err != nil || string(magic) != snapshotMagic {
+		return nil, fmt.Errorf("%w: invalid header", ErrSnapshotCorrupted)
+	}
+
+	version, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	if version > snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+
+	var (
+		entries []snapshotEntry
+		db      int
+	)
+
+	for {
+		op, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if op == snapshotOpEOF {
+			break
+		}
+
+		if op == snapshotOpSelectDB {
+			index, err := d.readUvarint()
+			if err != nil {
+				return nil, err
+			}
+
+			if index >= uint64(Databases()) {
+				return nil, fmt.Errorf("snapshot has database %d, only %d databases are configured", index, Databases())
+			}
+
+			db = int(index)
+
+			continue
+		}
+
+		if op != snapshotOpEntry {
+			return nil, fmt.Errorf("%w: unknown opcode %d", ErrSnapshotCorrupted, op)
+		}
+
+		entry, err := d.readEntry()
+		if err != nil {
+			return nil, err
+		}
+
+		entry.db = db
+		entries = append(entries, entry)
+	}
+
+	sum := d.checksum.Sum64()
+
+	expected := make([]byte, 8)
+
+	_, err = io.ReadFull(d.r, expected)
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing checksum", ErrSnapshotCorrupted)
+	}
+
+	if binary.BigEndian.Uint64(expected) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrSnapshotCorrupted)
+	}
+
+	return entries, nil
+}
+
+func (d *snapshotDecoder) readByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, d.unexpected(err)
+	}
+
+	d.checksum.Write([]byte{b})
+
+	return b, nil
+}
+
+func (d *snapshotDecoder) readBytes(n int) ([]byte, error) {
+	buf := make([]byte, n)
+
+	_, err := io.ReadFull(d.r, buf)
+	if err != nil {
+		return nil, d.unexpected(err)
+	}
+
+	d.checksum.Write(buf)
+
+	return buf, nil
+}
+
+func (d *snapshotDecoder) readUvarint() (uint64, error) {
+	return binary.ReadUvarint(byteReaderFunc(d.readByte))
+}
+
+func (d *snapshotDecoder) readStream() (*valueStream, error) {
+	stream := newValueStream()
+
+	n, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < n; i++ {
+		id, err := d.readID()
+		if err != nil {
+			return nil, err
+		}
+
+		fieldNum, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+
+		if fieldNum == 0 || fieldNum%2 != 0 || !stream.lastID.Less(id) {
+			return nil, fmt.Errorf("%w: invalid stream entry", ErrSnapshotCorrupted)
+		}
+
+		fields := make([]string, fieldNum)
+		for j := range fields {
+			fields[j], err = d.readString()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		stream.add(StreamEntry{ID: id, Fields: fields})
+	}
+
+	stream.lastID, err = d.readID()
+	if err != nil {
+		return nil, err
+	}
+
+	groupNum, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < groupNum; i++ {
+		name, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		lastID, err := d.readID()
+		if err != nil {
+			return nil, err
+		}
+
+		group := newStreamGroup(lastID)
+		stream.groups[name] = group
+
+		consumerNum, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+
+		for j := 0; j < consumerNum; j++ {
+			consumerName, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+
+			seenTime, err := d.readUvarint()
+			if err != nil {
+				return nil, err
+			}
+
+			group.consumers[consumerName] = &streamConsumer{
+				seenTime: int64(seenTime),
+			}
+		}
+
+		pendingNum, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+
+		for j := 0; j < pendingNum; j++ {
+			var (
+				p   pendingEntry
+				u64 uint64
+			)
+
+			p.id, err = d.readID()
+			if err != nil {
+				return nil, err
+			}
+
+			p.consumer, err = d.readString()
+			if err != nil {
+				return nil, err
+			}
+
+			u64, err = d.readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			p.deliveryTime = int64(u64)
+
+			u64, err = d.readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			p.deliveryCount = int64(u64)
+
+			group.addPending(&p)
+		}
+	}
+
+	return stream, nil
+}
+
+func (d *snapshotDecoder) readID() (StreamID, error) {
+	ms, err := d.readUvarint()
+	if err != nil {
+		return StreamID{}, err
+	}
+
+	seq, err := d.readUvarint()
+	if err != nil {
+		return StreamID{}, err
+	}
+
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+func (d *snapshotDecoder) readFloat() (float64, error) {
+	b, err := d.readBytes(8)
+	if err != nil {
+		return 0, err
+	}
+
+	return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+}
+
+func (d *snapshotDecoder) readLength() (int, error) {
+	n, err := d.readUvarint()
+	if err != nil {
+		return 0, err
+	}
+
+	if n > snapshotMaxLength {
+		return 0, fmt.Errorf("%w: invalid length %d", ErrSnapshotCorrupted, n)
+	}
+
+	return int(n), nil
+}
+
+func (d *snapshotDecoder) readString() (string, error) {
+	n, err := d.readLength()
+	if err != nil {
+		return "", err
+	}
+
+	buf, err := d.readBytes(n)
+	if err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+func (d *snapshotDecoder) readEntry() (snapshotEntry, error) {
+	var entry snapshotEntry
+
+	valueType, err := d.readByte()
+	if err != nil {
+		return entry, err
+	}
+
+	expireAt, err := d.readUvarint()
+	if err != nil {
+		return entry, err
+	}
+
+	entry.expireAt = int64(expireAt)
+
+	entry.key, err = d.readString()
+	if err != nil {
+		return entry, err
+	}
+
+	switch valueType {
+	case snapshotTypeString:
+		val, err := d.readString()
+		if err != nil {
+			return entry, err
+		}
+
+		entry.val = valueString{
+			val: val,
+		}
+	case snapshotTypeMap, snapshotTypeMapVolatile:
+		n, err := d.readLength()
+		if err != nil {
+			return entry, err
+		}
+
+		val := newValueMap()
+
+		for i := 0; i < n; i++ {
+			field, err := d.readString()
+			if err != nil {
+				return entry, err
+			}
+
+			fieldVal, err := d.readString()
+			if err != nil {
+				return entry, err
+			}
+
+			val.set(field, fieldVal)
+
+			if valueType != snapshotTypeMapVolatile {
+				continue
+			}
+
+			at, err := d.readUvarint()
+			if err != nil {
+				return entry, err
+			}
+
+			val.setExpire(field, int64(at))
+		}
+
+		entry.val = val
+	case snapshotTypeList:
+		n, err := d.readLength()
+		if err != nil {
+			return entry, err
+		}
+
+		val := newValueList()
+
+		for i := 0; i < n; i++ {
+			item, err := d.readString()
+			if err != nil {
+				return entry, err
+			}
+
+			val.pushBack(item)
+		}
+
+		entry.val = val
+	case snapshotTypeSet:
+		n, err := d.readLength()
+		if err != nil {
+			return entry, err
+		}
+
+		val := newValueSet()
+
+		for i := 0; i < n; i++ {
+			member, err := d.readString()
+			if err != nil {
+				return entry, err
+			}
+
+			val.add(member)
+		}
+
+		entry.val = val
+	case snapshotTypeSortedSet:
+		n, err := d.readLength()
+		if err != nil {
+			return entry, err
+		}
+
+		val := newValueSortedSet()
+
+		for i := 0; i < n; i++ {
+			member, err := d.readString()
+			if err != nil {
+				return entry, err
+			}
+
+			score, err := d.readFloat()
+			if err != nil {
+				return entry, err
+			}
+
+			if math.IsNaN(score) {
+				return entry, fmt.Errorf("%w: invalid score", ErrSnapshotCorrupted)
+			}
+
+			val.add(member, score)
+		}
+
+		entry.val = val
+	case snapshotTypeStream:
+		val, err := d.readStream()
+		if err != nil {
+			return entry, err
+		}
+
+		entry.val = val
+	default:
+		return entry, fmt.Errorf("%w: unknown value type %d", ErrSnapshotCorrupted, valueType)
+	}
+
+	return entry, nil
+}
+
+// unexpected reports a truncated snapshot as corrupted.
+func (d *snapshotDecoder) unexpected(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: unexpected end of file", ErrSnapshotCorrupted)
+	}
+
+	return err
+}
+
+type byteReaderFunc func() (byte, error)
+
+func (f byteReaderFunc) ReadByte() (byte, error) {
+	return f()
+}
@@ -0,0 +1,223 @@
+package memstore
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/crc64"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// snapshotStrings writes snapshot and returns the strings it holds by
+// database and key.
+func snapshotStrings(t *testing.T, snapshot *Snapshot) map[int]map[string]string {
+	t.Helper()
+
+	vals := make(map[int]map[string]string)
+
+	err := snapshot.each(func(entry snapshotEntry) error {
+		str, ok := entry.val.(valueString)
+		if !ok {
+			return fmt.Errorf("%s holds a %T", entry.key, entry.val)
+		}
+
+		if vals[entry.db] == nil {
+			vals[entry.db] = make(map[string]string)
+		}
+
+		vals[entry.db][entry.key] = str.val
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return vals
+}
+
+// assertReleased fails the test when a block still keeps entries for a
+// snapshot.
+func assertReleased(t *testing.T) {
+	t.Helper()
+
+	for _, db := range createdDatabases() {
+		for _, storage := range db.storages() {
+			storage.mu.RLock()
+			n := len(storage.snapshots)
+			storage.mu.RUnlock()
+
+			if n != 0 {
+				t.Fatalf("a block of db %d has %d snapshots", db.index, n)
+			}
+		}
+	}
+}
+
+func TestSnapshotPointInTime(t *testing.T) {
+	FlushAll()
+
+	db, other := Database(0), Database(1)
+	keys := spreadKeys(t, "snapshot", 40)
+
+	want := map[int]map[string]string{
+		0: {},
+		1: {"other": "1"},
+	}
+
+	for i, key := range keys {
+		val := strconv.Itoa(i)
+
+		if _, _, err := db.SetString(key, val, SetOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		want[0][key] = val
+	}
+
+	if _, _, err := other.SetString("other", "1", SetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := TakeSnapshot()
+
+	// every kind of modification made after the snapshot was taken
+	for i, key := range keys {
+		var err error
+
+		switch i % 4 {
+		case 0:
+			_, _, err = db.SetString(key, "modified", SetOptions{})
+		case 1:
+			_, err = db.Delete(key)
+		case 2:
+			_, err = db.Expire(key, now()-1, 0)
+		case 3:
+			_, err = db.Append(key, "-appended")
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, err := db.SetString("created", "1", SetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	SwapDB(0, 1)
+	other.Flush()
+
+	got := snapshotStrings(t, snapshot)
+
+	for index, vals := range want {
+		if len(got[index]) != len(vals) {
+			t.Errorf("db %d has %d keys, want %d", index, len(got[index]), len(vals))
+		}
+
+		for key, val := range vals {
+			if got[index][key] != val {
+				t.Errorf("db %d: %s = %q, want %q", index, key, got[index][key], val)
+			}
+		}
+	}
+
+	if snapshot.Len() != len(keys)+1 {
+		t.Errorf("Len() = %d, want %d", snapshot.Len(), len(keys)+1)
+	}
+
+	assertReleased(t)
+}
+
+// TestSnapshotConcurrentWrites writes a snapshot while the keys are being
+// incremented, it must hold the values of when it was taken.
+func TestSnapshotConcurrentWrites(t *testing.T) {
+	const (
+		writers = 8
+		incrs   = 500
+	)
+
+	FlushAll()
+
+	db := Database(0)
+	keys := spreadKeys(t, "counter", 20)
+
+	for _, key := range keys {
+		if _, _, err := db.SetString(key, "0", SetOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshot := TakeSnapshot()
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < incrs; i++ {
+				if _, err := db.IncrBy(keys[(w+i)%len(keys)], 1); err != nil {
+					t.Errorf("incr: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	var buf bytes.Buffer
+
+	err := snapshot.Encode(&buf)
+
+	wg.Wait()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := &snapshotDecoder{
+		r:        bufio.NewReader(&buf),
+		checksum: crc64.New(crc64Table),
+	}
+
+	entries, err := dec.decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != len(keys) {
+		t.Fatalf("the snapshot has %d keys, want %d", len(entries), len(keys))
+	}
+
+	for _, entry := range entries {
+		if str := entry.val.(valueString); str.val != "0" {
+			t.Errorf("%s = %q in the snapshot, want 0", entry.key, str.val)
+		}
+	}
+
+	assertReleased(t)
+}
+
+func TestSnapshotRelease(t *testing.T) {
+	FlushAll()
+
+	db := Database(0)
+
+	snapshot := TakeSnapshot()
+	snapshot.Release()
+
+	assertReleased(t)
+
+	// the writes don't keep anything for a released snapshot
+	if _, _, err := db.SetString("key", "val", SetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := snapshotStrings(t, snapshot); len(got) != 0 {
+		t.Errorf("a released snapshot has %v", got)
+	}
+}
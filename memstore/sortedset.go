@@ -0,0 +1,553 @@
+package memstore
+
+import (
+	"errors"
+	"math"
+)
+
+var (
+	ErrScoreNaN = errors.New("resulting score is not a number (NaN)")
+)
+
+// ScoredMember is a member of a sorted set with its score.
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+// ScoreRange is a range of scores, the bounds are included unless
+// they are marked as exclusive.
+type ScoreRange struct {
+	Min          float64
+	Max          float64
+	MinExclusive bool
+	MaxExclusive bool
+}
+
+func (r ScoreRange) gteMin(node *skiplistNode) bool {
+	if r.MinExclusive {
+		return node.score > r.Min
+	}
+
+	return node.score >= r.Min
+}
+
+func (r ScoreRange) lteMax(node *skiplistNode) bool {
+	if r.MaxExclusive {
+		return node.score < r.Max
+	}
+
+	return node.score <= r.Max
+}
+
+func (r ScoreRange) empty() bool {
+	return r.Min > r.Max || (r.Min == r.Max && (r.MinExclusive || r.MaxExclusive))
+}
+
+// LexBound is a bound of a LexRange, Inf is -1 for the lowest possible
+// member, 1 for the highest one and 0 when Value is the bound.
+type LexBound struct {
+	Value     string
+	Exclusive bool
+	Inf       int
+}
+
+// LexRange is a range of members, it's only meaningful when every member
+// of the sorted set has the same score.
+type LexRange struct {
+	Min LexBound
+	Max LexBound
+}
+
+func (r LexRange) gteMin(node *skiplistNode) bool {
+	switch {
+	case r.Min.Inf < 0:
+		return true
+	case r.Min.Inf > 0:
+		return false
+	case r.Min.Exclusive:
+		return node.member > r.Min.Value
+	}
+
+	return node.member >= r.Min.Value
+}
+
+func (r LexRange) lteMax(node *skiplistNode) bool {
+	switch {
+	case r.Max.Inf > 0:
+		return true
+	case r.Max.Inf < 0:
+		return false
+	case r.Max.Exclusive:
+		return node.member < r.Max.Value
+	}
+
+	return node.member <= r.Max.Value
+}
+
+func (r LexRange) empty() bool {
+	switch {
+	case r.Min.Inf > 0 || r.Max.Inf < 0:
+		return true
+	case r.Min.Inf < 0 || r.Max.Inf > 0:
+		return false
+	}
+
+	return r.Min.Value > r.Max.Value ||
+		(r.Min.Value == r.Max.Value && (r.Min.Exclusive || r.Max.Exclusive))
+}
+
+// lookupSortedSet returns the sorted set stored at key, or nil when the key
+// doesn't exist.
+func (t *txn) lookupSortedSet(key string) (*valueSortedSet, error) {
+	entry := t.lookup(key)
+	if entry == nil {
+		return nil, nil
+	}
+
+	zset, ok := entry.val.(*valueSortedSet)
+	if !ok {
+		return nil, ErrWrongType
+	}
+
+	return zset, nil
+}
+
+// sortedSetModified marks the sorted set of key as changed, the key is
+// removed once the sorted set is empty.
+func (t *txn) sortedSetModified(key string, zset *valueSortedSet) {
+	if zset.len() == 0 {
+		t.remove(key)
+		return
+	}
+
+	t.modified(key)
+}
+
+// SortedSetAddOptions follow the options of the ZADD command.
+type SortedSetAddOptions struct {
+	// NX only adds new members
+	NX bool
+	// XX only updates existing members
+	XX bool
+	// GT only updates a member when the new score is greater
+	GT bool
+	// LT only updates a member when the new score is less
+	LT bool
+	// CH counts the updated members besides the added ones
+	CH bool
+	// Incr increments the score instead of replacing it
+	Incr bool
+}
+
+// SortedSetAdd adds members to the sorted set or updates their score, it
+// returns the number of added members. With Incr the new score of the last
+// member is returned too, nil when the options prevented the update.
+func (db *DB) SortedSetAdd(key string, opts SortedSetAddOptions, members ...ScoredMember) (int, *float64, error) {
+	var (
+		added   int
+		changed int
+		result  *float64
+	)
+
+	err := db.write([]string{key}, func(t *txn) error {
+		zset, err := t.lookupSortedSet(key)
+		if err != nil {
+			return err
+		}
+
+		if zset == nil {
+			if opts.XX {
+				return nil
+			}
+
+			zset = newValueSortedSet()
+			t.create(key).val = zset
+		}
+
+		for _, m := range members {
+			score := m.Score
+			current, exists := zset.score(m.Member)
+
+			if exists && opts.NX || !exists && opts.XX {
+				result = nil
+				continue
+			}
+
+			if opts.Incr && exists {
+				score += current
+				if math.IsNaN(score) {
+					err = ErrScoreNaN
+					break
+				}
+			}
+
+			if exists && (opts.GT && score <= current || opts.LT && score >= current) {
+				result = nil
+				continue
+			}
+
+			if !exists {
+				added++
+			} else if score != current {
+				changed++
+			}
+
+			zset.add(m.Member, score)
+			result = &score
+		}
+
+		if added+changed > 0 || zset.len() == 0 {
+			t.sortedSetModified(key, zset)
+		}
+
+		return err
+	})
+
+	if opts.CH {
+		return added + changed, result, err
+	}
+
+	return added, result, err
+}
+
+// SortedSetRemove removes members from the sorted set and returns how many
+// were in it.
+func (db *DB) SortedSetRemove(key string, members ...string) (int, error) {
+	var removed int
+
+	err := db.update([]string{key}, func(t *txn) error {
+		zset, err := t.lookupSortedSet(key)
+		if err != nil || zset == nil {
+			return err
+		}
+
+		for _, member := range members {
+			if zset.remove(member) {
+				removed++
+			}
+		}
+
+		if removed > 0 {
+			t.sortedSetModified(key, zset)
+		}
+
+		return nil
+	})
+
+	return removed, err
+}
+
+// SortedSetScore returns the score of member, nil when the key or the
+// member doesn't exist.
+func (db *DB) SortedSetScore(key, member string) (*float64, error) {
+	var result *float64
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		zset, err := t.lookupSortedSet(key)
+		if err != nil || zset == nil {
+			return err
+		}
+
+		if score, ok := zset.score(member); ok {
+			result = &score
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+// SortedSetCard returns the number of members, zero when the key doesn't exist.
+func (db *DB) SortedSetCard(key string) (int, error) {
+	var card int
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		zset, err := t.lookupSortedSet(key)
+		if err != nil || zset == nil {
+			return err
+		}
+
+		card = zset.len()
+
+		return nil
+	})
+
+	return card, err
+}
+
+// SortedSetRank returns the 0-based rank of member ordered from the lowest
+// score, or from the highest one when reverse is set. ok is false when the
+// key or the member doesn't exist.
+func (db *DB) SortedSetRank(key, member string, reverse bool) (rank int, ok bool, err error) {
+	err = db.shard.view([]string{key}, func(t *txn) error {
+		zset, err := t.lookupSortedSet(key)
+		if err != nil || zset == nil {
+			return err
+		}
+
+		rank, ok = zset.rank(member, reverse)
+
+		return nil
+	})
+
+	return rank, ok, err
+}
+
+// RangeBy is the kind of range queried by SortedSetRange.
+type RangeBy int
+
+const (
+	RangeByRank RangeBy = iota
+	RangeByScore
+	RangeByLex
+)
+
+// SortedSetRangeOptions follow the options of the ZRANGE command.
+type SortedSetRangeOptions struct {
+	By RangeBy
+	// Start and Stop are the ranks of RangeByRank, negative ranks are
+	// counted from the end
+	Start int64
+	Stop  int64
+	Score ScoreRange
+	Lex   LexRange
+	// Reverse orders the members from the highest score
+	Reverse bool
+	// Offset and Count limit the members of RangeByScore and RangeByLex,
+	// a negative count returns all of them
+	Offset int64
+	Count  int64
+}
+
+// SortedSetRange returns the members in the range described by opts.
+func (db *DB) SortedSetRange(key string, opts SortedSetRangeOptions) ([]ScoredMember, error) {
+	members := []ScoredMember{}
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		zset, err := t.lookupSortedSet(key)
+		if err != nil || zset == nil {
+			return err
+		}
+
+		members = zset.rangeOf(opts)
+
+		return nil
+	})
+
+	return members, err
+}
+
+func (v *valueSortedSet) rangeOf(opts SortedSetRangeOptions) []ScoredMember {
+	offset := int(opts.Offset)
+	if opts.Offset < 0 || opts.Offset > int64(v.len()) {
+		return []ScoredMember{}
+	}
+
+	count := int(opts.Count)
+	if opts.Count > int64(v.len()) {
+		count = -1
+	}
+
+	switch opts.By {
+	case RangeByScore:
+		return v.rangeByNode(opts.Score, opts.Reverse, offset, count)
+	case RangeByLex:
+		return v.rangeByNode(opts.Lex, opts.Reverse, offset, count)
+	}
+
+	start, stop, ok := listRange(opts.Start, opts.Stop, v.len())
+	if !ok {
+		return []ScoredMember{}
+	}
+
+	return v.rangeByRank(start, stop, opts.Reverse)
+}
+
+// SortedSetCount returns the number of members with a score in r.
+func (db *DB) SortedSetCount(key string, r ScoreRange) (int, error) {
+	var count int
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		zset, err := t.lookupSortedSet(key)
+		if err != nil || zset == nil {
+			return err
+		}
+
+		count = zset.count(r)
+
+		return nil
+	})
+
+	return count, err
+}
+
+// SortedSetPop removes and returns up to count members with the lowest
+// scores, or the highest ones when max is set.
+func (db *DB) SortedSetPop(key string, count int, max bool) ([]ScoredMember, error) {
+	members := []ScoredMember{}
+
+	err := db.update([]string{key}, func(t *txn) error {
+		zset, err := t.lookupSortedSet(key)
+		if err != nil || zset == nil {
+			return err
+		}
+
+		members = zset.pop(count, max)
+		if len(members) > 0 {
+			t.sortedSetModified(key, zset)
+		}
+
+		return nil
+	})
+
+	return members, err
+}
+
+// Aggregate is the way SortedSetCombineStore combines the scores of a
+// member found in several sets.
+type Aggregate int
+
+const (
+	AggregateSum Aggregate = iota
+	AggregateMin
+	AggregateMax
+)
+
+func (a Aggregate) apply(x, y float64) float64 {
+	switch a {
+	case AggregateMin:
+		return math.Min(x, y)
+	case AggregateMax:
+		return math.Max(x, y)
+	}
+
+	// the sum of opposite infinities is zero like in Redis
+	sum := x + y
+	if math.IsNaN(sum) {
+		return 0
+	}
+
+	return sum
+}
+
+// lookupScores returns the scores of the sorted set stored at key, the
+// members of a set have a score of 1. It's nil when the key doesn't exist.
+func (t *txn) lookupScores(key string) (map[string]float64, error) {
+	entry := t.lookup(key)
+	if entry == nil {
+		return nil, nil
+	}
+
+	switch val := entry.val.(type) {
+	case *valueSortedSet:
+		return val.scores, nil
+	case *valueSet:
+		scores := make(map[string]float64, val.len())
+		for _, member := range val.items() {
+			scores[member] = 1
+		}
+
+		return scores, nil
+	}
+
+	return nil, ErrWrongType
+}
+
+// SortedSetCombineStore stores at dst the union or the intersection of the
+// sorted sets of keys, replacing any value, and returns its cardinality.
+// The scores of every key are multiplied by its weight, weights may be nil.
+func (db *DB) SortedSetCombineStore(op SetOperation, dst string, keys []string, weights []float64, aggregate Aggregate) (int, error) {
+	var card int
+
+	err := db.write(append([]string{dst}, keys...), func(t *txn) error {
+		inputs := make([]map[string]float64, 0, len(keys))
+
+		for _, key := range keys {
+			scores, err := t.lookupScores(key)
+			if err != nil {
+				return err
+			}
+
+			inputs = append(inputs, scores)
+		}
+
+		weighted := func(i int, score float64) float64 {
+			if weights == nil {
+				return score
+			}
+
+			score *= weights[i]
+			// zero times an infinity is zero like in Redis
+			if math.IsNaN(score) {
+				return 0
+			}
+
+			return score
+		}
+
+		result := make(map[string]float64)
+
+		switch op {
+		case SetUnion:
+			for i, scores := range inputs {
+				for member, score := range scores {
+					score = weighted(i, score)
+
+					if current, ok := result[member]; ok {
+						score = aggregate.apply(current, score)
+					}
+
+					result[member] = score
+				}
+			}
+		case SetInter:
+			for _, scores := range inputs {
+				if len(scores) == 0 {
+					inputs = nil
+					break
+				}
+			}
+
+			if len(inputs) == 0 {
+				break
+			}
+
+		members:
+			for member, score := range inputs[0] {
+				score = weighted(0, score)
+
+				for i, scores := range inputs[1:] {
+					other, ok := scores[member]
+					if !ok {
+						continue members
+					}
+
+					score = aggregate.apply(score, weighted(i+1, other))
+				}
+
+				result[member] = score
+			}
+		}
+
+		t.remove(dst)
+
+		if len(result) == 0 {
+			return nil
+		}
+
+		zset := newValueSortedSet()
+		for member, score := range result {
+			zset.add(member, score)
+		}
+
+		t.create(dst).val = zset
+		t.modified(dst)
+		card = zset.len()
+
+		return nil
+	})
+
+	return card, err
+}
@@ -2,50 +2,252 @@ package memstore
 
 import (
 	"errors"
+	"sync"
+	"sync/atomic"
 )
 
 var (
 	ErrNilEntries = errors.New("nil entries")
+	ErrWrongType  = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
 )
 
-type Storage struct {
-	entries map[uint32]EntryNode
+var (
+	// changes counts the modifications of the keyspace, the persistence
+	// uses it to decide when a new snapshot is needed
+	changes atomic.Int64
+)
+
+// Changes returns the number of modifications made since the start.
+func Changes() int64 {
+	return changes.Load()
 }
 
-func (s Storage) Delete(hashKey uint32, key string) {
-	var entry EntryNode
+type Storage struct {
+	// mu guards the block, reads share it so they scale across cores
+	// while writes are serialized
+	mu      sync.RWMutex
+	entries map[uint32]*EntryNode
+	// volatile holds the keys with an expiration time and their hash key,
+	// the sweeper samples it to find expired entries
+	volatile map[string]uint32
+	// volatileFields holds the hashes with fields that have an expiration
+	// time and their hash key, the sweeper samples it like volatile
+	volatileFields map[string]uint32
+	// used is the estimated memory held by the entries of the block
+	used int64
+	// keys is the number of entries of the block, including the expired
+	// ones that are not removed yet
+	keys int
+	// removed is the version of the last removal, it's the version of
+	// the keys that don't exist
+	removed uint64
+	// snapshots are the running snapshots that didn't copy the block yet
+	snapshots []*blockSnapshot
+}
 
-	entry, ok := s.entries[hashKey]
-	if !ok {
-		return
+func newStorage() *Storage {
+	return &Storage{
+		entries:        make(map[uint32]*EntryNode),
+		volatile:       make(map[string]uint32),
+		volatileFields: make(map[string]uint32),
 	}
+}
 
-	newEntry := entry.Delete(key, nil)
-	if newEntry == nil {
-		delete(s.entries, hashKey)
-	} else {
-		s.entries[hashKey] = *newEntry
+// Delete removes key and reports whether it existed, an expired key is
+// removed but reported as missing.
+func (s *Storage) Delete(hashKey uint32, key string) bool {
+	entry := s.peek(hashKey, key)
+	if entry == nil {
+		return false
 	}
+
+	exists := entry.val != nil && !entry.Expired(now())
+
+	s.remove(hashKey, key)
+	changes.Add(1)
+
+	return exists
 }
 
-func (s Storage) Set(valueType ValueType, hashKey uint32, key string, args ...string) (newFieldNum int, err error) {
-	entry := s.entries[hashKey]
+func (s *Storage) Set(valueType ValueType, hashKey uint32, key string, args ...string) (newFieldNum int, err error) {
+	var before int64
+
+	old := s.lookup(hashKey, key)
+	if old != nil {
+		before = old.size()
+	}
+
+	entry := s.create(hashKey, key)
 
 	newFieldNum, err = entry.Set(valueType, key, args...)
 	if err != nil {
+		if old == nil {
+			s.remove(hashKey, key)
+		}
+
 		return 0, err
 	}
 
-	s.entries[hashKey] = entry
+	entry.version = nextVersion()
+
+	s.account(entry.size() - before)
+	changes.Add(1)
 
 	return
 }
 
-func (s Storage) Get(valueType ValueType, hashKey uint32, key string, args ...string) (any, error) {
-	entry, ok := s.entries[hashKey]
-	if !ok {
+func (s *Storage) Get(valueType ValueType, hashKey uint32, key string, args ...string) (any, error) {
+	entry := s.get(hashKey, key)
+	if entry == nil {
 		return nil, ErrNilEntries
 	}
 
 	return entry.Get(valueType, key, args...)
 }
+
+// lookup returns the entry of key or nil when it doesn't exist,
+// an expired entry is removed and reported as missing.
+func (s *Storage) lookup(hashKey uint32, key string) *EntryNode {
+	// the callers hold the write lock to modify the entry
+	s.preserve(hashKey, key)
+
+	head, ok := s.entries[hashKey]
+	if !ok {
+		return nil
+	}
+
+	entry := head.find(key)
+	if entry == nil {
+		return nil
+	}
+
+	if entry.Expired(now()) {
+		s.remove(hashKey, key)
+		changes.Add(1)
+
+		return nil
+	}
+
+	entry.touch()
+
+	return entry
+}
+
+// get is the read only version of lookup, it can be called while
+// holding the read lock, expired entries are left for the writers
+// and the sweeper to remove.
+func (s *Storage) get(hashKey uint32, key string) *EntryNode {
+	entry := s.peek(hashKey, key)
+	if entry == nil || entry.Expired(now()) {
+		return nil
+	}
+
+	entry.touch()
+
+	return entry
+}
+
+// peek returns the entry of key without checking the expiration
+// or updating the access metadata.
+func (s *Storage) peek(hashKey uint32, key string) *EntryNode {
+	head, ok := s.entries[hashKey]
+	if !ok {
+		return nil
+	}
+
+	return head.find(key)
+}
+
+// create returns the entry of key, a new empty entry is added to the
+// collision chain when the key doesn't exist.
+func (s *Storage) create(hashKey uint32, key string) *EntryNode {
+	entry := s.lookup(hashKey, key)
+	if entry != nil {
+		return entry
+	}
+
+	entry = &EntryNode{
+		key:      key,
+		assigned: true,
+		version:  nextVersion(),
+	}
+	entry.touch()
+
+	s.keys++
+
+	head, ok := s.entries[hashKey]
+	if !ok || !head.assigned {
+		s.entries[hashKey] = entry
+		return entry
+	}
+
+	tail := head
+	for tail.child != nil {
+		tail = tail.child
+	}
+
+	tail.Append(entry)
+
+	return entry
+}
+
+// remove deletes key and reports whether it existed.
+func (s *Storage) remove(hashKey uint32, key string) bool {
+	s.preserve(hashKey, key)
+
+	head, ok := s.entries[hashKey]
+	if !ok {
+		return false
+	}
+
+	if entry := head.find(key); entry != nil {
+		s.account(-entry.size())
+	}
+
+	newHead, deleted := head.Delete(key)
+	if newHead == nil {
+		delete(s.entries, hashKey)
+	} else {
+		s.entries[hashKey] = newHead
+	}
+
+	if deleted {
+		s.keys--
+		s.removed = nextVersion()
+		delete(s.volatile, key)
+		delete(s.volatileFields, key)
+	}
+
+	return deleted
+}
+
+// setExpire updates the expiration time of entry, zero makes it persistent.
+func (s *Storage) setExpire(hashKey uint32, entry *EntryNode, at int64) {
+	s.preserve(hashKey, entry.key)
+
+	entry.expireAt = at
+
+	if at == 0 {
+		delete(s.volatile, entry.key)
+		return
+	}
+
+	s.volatile[entry.key] = hashKey
+}
+
+// trackFields records whether the hash of key has fields with an
+// expiration time, so the sweeper finds them.
+func (s *Storage) trackFields(hashKey uint32, key string, hash *valueMap) {
+	if len(hash.expires) == 0 {
+		delete(s.volatileFields, key)
+		return
+	}
+
+	s.volatileFields[key] = hashKey
+}
+
+// account adds delta bytes to the memory used by the block.
+func (s *Storage) account(delta int64) {
+	s.used += delta
+	usedMemory.Add(delta)
+}
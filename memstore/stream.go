@@ -0,0 +1,1110 @@
+package memstore
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+)
+
+const (
+	streamGroupOverhead    = 64
+	streamPendingOverhead  = 48
+	streamConsumerOverhead = 48
+)
+
+var (
+	ErrStreamIDTooSmall    = errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+	ErrStreamIDZero        = errors.New("The ID specified in XADD must be greater than 0-0")
+	ErrStreamExhausted     = errors.New("The stream has exhausted the last possible ID, unable to add more items")
+	ErrStreamSetIDTooSmall = errors.New("The ID specified in XSETID is smaller than the target stream top item")
+	ErrStreamGroupNoKey    = errors.New("The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	ErrNoGroup             = errors.New("NOGROUP No such key or consumer group")
+	ErrBusyGroup           = errors.New("BUSYGROUP Consumer Group name already exists")
+)
+
+// StreamID identifies an entry of a stream, Ms is the time the entry was
+// added in unix milliseconds and Seq orders the entries of the same
+// millisecond.
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// MaxStreamID is the greatest possible id.
+var MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less reports whether id is ordered before other.
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+// Next returns the id following id, ok is false when id is the greatest one.
+func (id StreamID) Next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{Ms: id.Ms + 1}, true
+	}
+
+	return id, false
+}
+
+// Prev returns the id preceding id, ok is false when id is 0-0.
+func (id StreamID) Prev() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+
+	return id, false
+}
+
+// StreamEntry is an entry of a stream, Fields holds the field value pairs.
+// Fields is nil for a pending entry that was trimmed from the stream.
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string
+}
+
+// streamGroup is a consumer group, it delivers every entry to one of its
+// consumers and keeps it pending until the consumer acknowledges it.
+type streamGroup struct {
+	lastID StreamID
+	// pending is ordered by id
+	pending   []*pendingEntry
+	consumers map[string]*streamConsumer
+}
+
+type pendingEntry struct {
+	id            StreamID
+	consumer      string
+	deliveryTime  int64
+	deliveryCount int64
+}
+
+type streamConsumer struct {
+	seenTime int64
+	pending  int
+}
+
+func newStreamGroup(lastID StreamID) *streamGroup {
+	return &streamGroup{
+		lastID:    lastID,
+		consumers: make(map[string]*streamConsumer),
+	}
+}
+
+func (g *streamGroup) size() int64 {
+	size := streamGroupOverhead + int64(len(g.pending))*streamPendingOverhead
+
+	for name := range g.consumers {
+		size += streamConsumerOverhead + int64(len(name))
+	}
+
+	return size
+}
+
+func (g *streamGroup) clone() *streamGroup {
+	cloned := &streamGroup{
+		lastID:    g.lastID,
+		pending:   make([]*pendingEntry, 0, len(g.pending)),
+		consumers: make(map[string]*streamConsumer, len(g.consumers)),
+	}
+
+	for _, p := range g.pending {
+		copied := *p
+		cloned.pending = append(cloned.pending, &copied)
+	}
+
+	for name, c := range g.consumers {
+		copied := *c
+		cloned.consumers[name] = &copied
+	}
+
+	return cloned
+}
+
+// consumer returns the consumer called name, it's created when it doesn't exist.
+func (g *streamGroup) consumer(name string) *streamConsumer {
+	c, ok := g.consumers[name]
+	if !ok {
+		c = &streamConsumer{
+			seenTime: now(),
+		}
+		g.consumers[name] = c
+	}
+
+	return c
+}
+
+// findPending returns the index of the pending entry with id, or the index
+// where it would be inserted.
+func (g *streamGroup) findPending(id StreamID) (int, bool) {
+	i := sort.Search(len(g.pending), func(i int) bool {
+		return !g.pending[i].id.Less(id)
+	})
+
+	return i, i < len(g.pending) && g.pending[i].id == id
+}
+
+func (g *streamGroup) addPending(p *pendingEntry) {
+	i, _ := g.findPending(p.id)
+
+	g.pending = append(g.pending, nil)
+	copy(g.pending[i+1:], g.pending[i:])
+	g.pending[i] = p
+
+	g.consumer(p.consumer).pending++
+}
+
+func (g *streamGroup) removePending(i int) {
+	if c, ok := g.consumers[g.pending[i].consumer]; ok {
+		c.pending--
+	}
+
+	g.pending = append(g.pending[:i], g.pending[i+1:]...)
+}
+
+// assign gives the pending entry to consumer.
+func (g *streamGroup) assign(p *pendingEntry, consumer string) {
+	if p.consumer == consumer {
+		return
+	}
+
+	if c, ok := g.consumers[p.consumer]; ok {
+		c.pending--
+	}
+
+	g.consumer(consumer).pending++
+	p.consumer = consumer
+}
+
+// lookupStream returns the stream stored at key, or nil when the key doesn't exist.
+func (t *txn) lookupStream(key string) (*valueStream, error) {
+	entry := t.lookup(key)
+	if entry == nil {
+		return nil, nil
+	}
+
+	stream, ok := entry.val.(*valueStream)
+	if !ok {
+		return nil, ErrWrongType
+	}
+
+	return stream, nil
+}
+
+// lookupGroup returns the consumer group of the stream stored at key,
+// ErrNoGroup is returned when the key or the group doesn't exist.
+func (t *txn) lookupGroup(key, group string) (*valueStream, *streamGroup, error) {
+	stream, err := t.lookupStream(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if stream == nil || stream.groups[group] == nil {
+		return nil, nil, ErrNoGroup
+	}
+
+	return stream, stream.groups[group], nil
+}
+
+// StreamTrimStrategy is the way a stream is trimmed.
+type StreamTrimStrategy int
+
+const (
+	// StreamTrimMaxLen keeps the last MaxLen entries
+	StreamTrimMaxLen StreamTrimStrategy = iota
+	// StreamTrimMinID removes the entries with an id less than MinID
+	StreamTrimMinID
+)
+
+// StreamTrimOptions follow the trimming options of the XADD and XTRIM commands.
+type StreamTrimOptions struct {
+	Strategy StreamTrimStrategy
+	MaxLen   int64
+	MinID    StreamID
+	// Approx only removes whole nodes, so the stream may keep a few more
+	// entries than asked
+	Approx bool
+	// Limit is the most entries removed by an approximated trim, zero
+	// means no limit
+	Limit int64
+}
+
+func (v *valueStream) trimWith(opts StreamTrimOptions) int {
+	remove := func(entry StreamEntry, left int) bool {
+		return entry.ID.Less(opts.MinID)
+	}
+
+	if opts.Strategy == StreamTrimMaxLen {
+		remove = func(entry StreamEntry, left int) bool {
+			return int64(left) >= opts.MaxLen
+		}
+	}
+
+	return v.trim(remove, opts.Approx, int(opts.Limit))
+}
+
+// StreamAddOptions follow the options of the XADD command.
+type StreamAddOptions struct {
+	// ID is the id of the new entry unless it's generated
+	ID StreamID
+	// Auto generates the id from the current time
+	Auto bool
+	// AutoSeq only generates the sequence of ID
+	AutoSeq bool
+	// NoMkStream doesn't create the stream when it doesn't exist
+	NoMkStream bool
+	// Trim trims the stream after the entry is added
+	Trim *StreamTrimOptions
+}
+
+// nextStreamID returns the id of an entry added after last.
+func nextStreamID(last StreamID, opts StreamAddOptions) (StreamID, error) {
+	switch {
+	case opts.Auto:
+		if ms := uint64(now()); ms > last.Ms {
+			return StreamID{Ms: ms}, nil
+		}
+
+		id, ok := last.Next()
+		if !ok {
+			return id, ErrStreamExhausted
+		}
+
+		return id, nil
+	case opts.AutoSeq:
+		if opts.ID.Ms < last.Ms || opts.ID.Ms == last.Ms && last.Seq == math.MaxUint64 {
+			return opts.ID, ErrStreamIDTooSmall
+		}
+
+		if opts.ID.Ms == last.Ms {
+			return StreamID{Ms: last.Ms, Seq: last.Seq + 1}, nil
+		}
+
+		return StreamID{Ms: opts.ID.Ms}, nil
+	}
+
+	if opts.ID == (StreamID{}) {
+		return opts.ID, ErrStreamIDZero
+	}
+
+	if !last.Less(opts.ID) {
+		return opts.ID, ErrStreamIDTooSmall
+	}
+
+	return opts.ID, nil
+}
+
+// StreamAdd adds an entry with fields to the stream and returns its id,
+// ok is false when the stream doesn't exist and NoMkStream is set.
+func (db *DB) StreamAdd(key string, fields []string, opts StreamAddOptions) (id StreamID, ok bool, err error) {
+	err = db.write([]string{key}, func(t *txn) error {
+		stream, err := t.lookupStream(key)
+		if err != nil {
+			return err
+		}
+
+		if stream == nil && opts.NoMkStream {
+			return nil
+		}
+
+		var last StreamID
+		if stream != nil {
+			last = stream.lastID
+		}
+
+		id, err = nextStreamID(last, opts)
+		if err != nil {
+			return err
+		}
+
+		if stream == nil {
+			stream = newValueStream()
+			t.create(key).val = stream
+		}
+
+		stream.add(StreamEntry{ID: id, Fields: fields})
+
+		if opts.Trim != nil {
+			stream.trimWith(*opts.Trim)
+		}
+
+		t.modified(key)
+		ok = true
+
+		return nil
+	})
+
+	// every client reading the stream gets the entry
+	if ok {
+		db.waiters.broadcast(key)
+	}
+
+	return id, ok, err
+}
+
+// StreamTrim trims the stream and returns the number of removed entries.
+func (db *DB) StreamTrim(key string, opts StreamTrimOptions) (int, error) {
+	var removed int
+
+	err := db.update([]string{key}, func(t *txn) error {
+		stream, err := t.lookupStream(key)
+		if err != nil || stream == nil {
+			return err
+		}
+
+		removed = stream.trimWith(opts)
+		if removed > 0 {
+			t.modified(key)
+		}
+
+		return nil
+	})
+
+	return removed, err
+}
+
+// StreamLen returns the number of entries, zero when the key doesn't exist.
+func (db *DB) StreamLen(key string) (int, error) {
+	var length int
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		stream, err := t.lookupStream(key)
+		if err != nil || stream == nil {
+			return err
+		}
+
+		length = stream.length
+
+		return nil
+	})
+
+	return length, err
+}
+
+// StreamRange returns the entries from start to end, both included, at
+// most count of them when count is positive. They are returned from end
+// to start when reverse is set.
+func (db *DB) StreamRange(key string, start, end StreamID, count int, reverse bool) ([]StreamEntry, error) {
+	entries := []StreamEntry{}
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		stream, err := t.lookupStream(key)
+		if err != nil || stream == nil {
+			return err
+		}
+
+		entries = stream.rangeEntries(start, end, count, reverse)
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// StreamLastID returns the id of the last entry added to the stream,
+// 0-0 when the key doesn't exist.
+func (db *DB) StreamLastID(key string) (StreamID, error) {
+	var id StreamID
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		stream, err := t.lookupStream(key)
+		if err != nil || stream == nil {
+			return err
+		}
+
+		id = stream.lastID
+
+		return nil
+	})
+
+	return id, err
+}
+
+// StreamSetID sets the id of the last entry added to the stream, it can't
+// be less than the id of the last entry still in the stream.
+func (db *DB) StreamSetID(key string, id StreamID) error {
+	return db.update([]string{key}, func(t *txn) error {
+		stream, err := t.lookupStream(key)
+		if err != nil {
+			return err
+		}
+
+		if stream == nil {
+			return ErrNoSuchKey
+		}
+
+		if stream.length > 0 && id.Less(stream.lastID) {
+			return ErrStreamSetIDTooSmall
+		}
+
+		stream.lastID = id
+		t.modified(key)
+
+		return nil
+	})
+}
+
+// StreamReadResult holds the entries read from a stream.
+type StreamReadResult struct {
+	Key     string
+	Entries []StreamEntry
+	// DeliveryTime is the time the entries were delivered to the
+	// consumer group, in unix milliseconds
+	DeliveryTime int64
+}
+
+// StreamRead returns up to count entries with an id greater than the id
+// given for every key, count is ignored when it's not positive. Only the
+// streams with such entries are part of the result.
+func (db *DB) StreamRead(keys []string, ids []StreamID, count int) ([]StreamReadResult, error) {
+	var results []StreamReadResult
+
+	err := db.shard.view(keys, func(t *txn) error {
+		for i, key := range keys {
+			stream, err := t.lookupStream(key)
+			if err != nil {
+				return err
+			}
+
+			if stream == nil {
+				continue
+			}
+
+			start, ok := ids[i].Next()
+			if !ok {
+				continue
+			}
+
+			entries := stream.rangeEntries(start, MaxStreamID, count, false)
+			if len(entries) > 0 {
+				results = append(results, StreamReadResult{Key: key, Entries: entries})
+			}
+		}
+
+		return nil
+	})
+
+	return results, err
+}
+
+// StreamGroupCreate creates a consumer group that delivers the entries
+// after id, or the entries added from now on when last is set. The stream
+// is created when mkStream is set.
+func (db *DB) StreamGroupCreate(key, group string, id StreamID, last, mkStream bool) error {
+	return db.write([]string{key}, func(t *txn) error {
+		stream, err := t.lookupStream(key)
+		if err != nil {
+			return err
+		}
+
+		if stream == nil {
+			if !mkStream {
+				return ErrStreamGroupNoKey
+			}
+
+			stream = newValueStream()
+			t.create(key).val = stream
+		}
+
+		if _, ok := stream.groups[group]; ok {
+			return ErrBusyGroup
+		}
+
+		if last {
+			id = stream.lastID
+		}
+
+		stream.groups[group] = newStreamGroup(id)
+		t.modified(key)
+
+		return nil
+	})
+}
+
+// StreamGroupSetID sets the id of the last entry delivered to the group.
+func (db *DB) StreamGroupSetID(key, group string, id StreamID, last bool) error {
+	return db.update([]string{key}, func(t *txn) error {
+		stream, err := t.lookupStream(key)
+		if err != nil {
+			return err
+		}
+
+		if stream == nil {
+			return ErrStreamGroupNoKey
+		}
+
+		g, ok := stream.groups[group]
+		if !ok {
+			return ErrNoGroup
+		}
+
+		if last {
+			id = stream.lastID
+		}
+
+		g.lastID = id
+		t.modified(key)
+
+		return nil
+	})
+}
+
+// StreamGroupDestroy removes the consumer group and reports whether it existed.
+func (db *DB) StreamGroupDestroy(key, group string) (bool, error) {
+	var destroyed bool
+
+	err := db.update([]string{key}, func(t *txn) error {
+		stream, err := t.lookupStream(key)
+		if err != nil {
+			return err
+		}
+
+		if stream == nil {
+			return ErrStreamGroupNoKey
+		}
+
+		if _, ok := stream.groups[group]; !ok {
+			return nil
+		}
+
+		delete(stream.groups, group)
+		destroyed = true
+		t.modified(key)
+
+		return nil
+	})
+
+	return destroyed, err
+}
+
+// StreamGroupCreateConsumer adds a consumer to the group and reports
+// whether it's new.
+func (db *DB) StreamGroupCreateConsumer(key, group, consumer string) (bool, error) {
+	var created bool
+
+	err := db.write([]string{key}, func(t *txn) error {
+		stream, err := t.lookupStream(key)
+		if err != nil {
+			return err
+		}
+
+		if stream == nil {
+			return ErrStreamGroupNoKey
+		}
+
+		g, ok := stream.groups[group]
+		if !ok {
+			return ErrNoGroup
+		}
+
+		if _, ok := g.consumers[consumer]; ok {
+			return nil
+		}
+
+		g.consumer(consumer)
+		created = true
+		t.modified(key)
+
+		return nil
+	})
+
+	return created, err
+}
+
+// StreamGroupDeleteConsumer removes a consumer with its pending entries
+// and returns how many entries it had pending.
+func (db *DB) StreamGroupDeleteConsumer(key, group, consumer string) (int, error) {
+	var pending int
+
+	err := db.update([]string{key}, func(t *txn) error {
+		stream, err := t.lookupStream(key)
+		if err != nil {
+			return err
+		}
+
+		if stream == nil {
+			return ErrStreamGroupNoKey
+		}
+
+		g, ok := stream.groups[group]
+		if !ok {
+			return ErrNoGroup
+		}
+
+		c, ok := g.consumers[consumer]
+		if !ok {
+			return nil
+		}
+
+		pending = c.pending
+
+		kept := g.pending[:0]
+		for _, p := range g.pending {
+			if p.consumer != consumer {
+				kept = append(kept, p)
+			}
+		}
+
+		g.pending = kept
+		delete(g.consumers, consumer)
+		t.modified(key)
+
+		return nil
+	})
+
+	return pending, err
+}
+
+// StreamReadGroupOptions follow the options of the XREADGROUP command.
+type StreamReadGroupOptions struct {
+	Group    string
+	Consumer string
+	// Count limits the entries read from every stream when it's positive
+	Count int
+	// NoAck doesn't keep the delivered entries pending
+	NoAck bool
+}
+
+// StreamReadGroup reads the streams of keys as a consumer of a group. A nil
+// id reads the entries never delivered to the group, the other ids read
+// the entries pending for the consumer after them. The streams without
+// new entries are not part of the result.
+func (db *DB) StreamReadGroup(keys []string, ids []*StreamID, opts StreamReadGroupOptions) ([]StreamReadResult, error) {
+	var results []StreamReadResult
+
+	err := db.update(keys, func(t *txn) error {
+		groups := make([]*streamGroup, len(keys))
+		streams := make([]*valueStream, len(keys))
+
+		// nothing is read unless every group exists
+		for i, key := range keys {
+			stream, group, err := t.lookupGroup(key, opts.Group)
+			if err != nil {
+				return err
+			}
+
+			streams[i], groups[i] = stream, group
+		}
+
+		deliveryTime := now()
+
+		for i, key := range keys {
+			stream, group := streams[i], groups[i]
+
+			group.consumer(opts.Consumer).seenTime = deliveryTime
+
+			if ids[i] != nil {
+				results = append(results, StreamReadResult{
+					Key:     key,
+					Entries: group.history(stream, opts.Consumer, *ids[i], opts.Count),
+				})
+
+				continue
+			}
+
+			start, ok := group.lastID.Next()
+			if !ok {
+				continue
+			}
+
+			entries := stream.rangeEntries(start, MaxStreamID, opts.Count, false)
+			if len(entries) == 0 {
+				continue
+			}
+
+			for _, entry := range entries {
+				group.deliver(entry.ID, opts.Consumer, deliveryTime, opts.NoAck)
+			}
+
+			t.modified(key)
+
+			results = append(results, StreamReadResult{
+				Key:          key,
+				Entries:      entries,
+				DeliveryTime: deliveryTime,
+			})
+		}
+
+		return nil
+	})
+
+	return results, err
+}
+
+// deliver records that the entry with id was delivered to consumer.
+func (g *streamGroup) deliver(id StreamID, consumer string, deliveryTime int64, noAck bool) {
+	g.lastID = id
+
+	if noAck {
+		return
+	}
+
+	// the entry may be pending already when the group id was moved back
+	if i, ok := g.findPending(id); ok {
+		p := g.pending[i]
+		g.assign(p, consumer)
+		p.deliveryTime = deliveryTime
+		p.deliveryCount = 1
+
+		return
+	}
+
+	g.addPending(&pendingEntry{
+		id:            id,
+		consumer:      consumer,
+		deliveryTime:  deliveryTime,
+		deliveryCount: 1,
+	})
+}
+
+// history returns up to count entries pending for consumer after id, the
+// entries trimmed from the stream have nil fields.
+func (g *streamGroup) history(stream *valueStream, consumer string, id StreamID, count int) []StreamEntry {
+	entries := []StreamEntry{}
+
+	start, ok := id.Next()
+	if !ok {
+		return entries
+	}
+
+	i, _ := g.findPending(start)
+
+	for ; i < len(g.pending) && (count <= 0 || len(entries) < count); i++ {
+		p := g.pending[i]
+		if p.consumer != consumer {
+			continue
+		}
+
+		entry, ok := stream.lookup(p.id)
+		if !ok {
+			entry = StreamEntry{ID: p.id}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// StreamAck removes the entries with ids from the pending entries of the
+// group and returns how many were pending.
+func (db *DB) StreamAck(key, group string, ids ...StreamID) (int, error) {
+	var acked int
+
+	err := db.update([]string{key}, func(t *txn) error {
+		_, g, err := t.lookupGroup(key, group)
+		if err == ErrNoGroup {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if i, ok := g.findPending(id); ok {
+				g.removePending(i)
+				acked++
+			}
+		}
+
+		if acked > 0 {
+			t.modified(key)
+		}
+
+		return nil
+	})
+
+	return acked, err
+}
+
+// PendingSummary describes the pending entries of a consumer group.
+type PendingSummary struct {
+	Count int
+	// First and Last are the smallest and the greatest pending ids
+	First     StreamID
+	Last      StreamID
+	Consumers []PendingConsumer
+}
+
+// PendingConsumer is the number of entries pending for a consumer.
+type PendingConsumer struct {
+	Name  string
+	Count int
+}
+
+// StreamPendingSummary returns the summary of the pending entries of the group.
+func (db *DB) StreamPendingSummary(key, group string) (PendingSummary, error) {
+	var summary PendingSummary
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		_, g, err := t.lookupGroup(key, group)
+		if err != nil {
+			return err
+		}
+
+		summary.Count = len(g.pending)
+		if summary.Count == 0 {
+			return nil
+		}
+
+		summary.First = g.pending[0].id
+		summary.Last = g.pending[len(g.pending)-1].id
+
+		for name, c := range g.consumers {
+			if c.pending > 0 {
+				summary.Consumers = append(summary.Consumers, PendingConsumer{Name: name, Count: c.pending})
+			}
+		}
+
+		sort.Slice(summary.Consumers, func(i, j int) bool {
+			return summary.Consumers[i].Name < summary.Consumers[j].Name
+		})
+
+		return nil
+	})
+
+	return summary, err
+}
+
+// StreamPendingEntry is an entry delivered to a consumer and not acknowledged yet.
+type StreamPendingEntry struct {
+	ID       StreamID
+	Consumer string
+	// Idle is the time since the last delivery in milliseconds
+	Idle          int64
+	DeliveryCount int64
+}
+
+// StreamPendingOptions follow the options of the extended form of XPENDING.
+type StreamPendingOptions struct {
+	Start StreamID
+	End   StreamID
+	Count int
+	// Consumer only returns the entries of a consumer when it's not empty
+	Consumer string
+	// MinIdle only returns the entries idle for at least MinIdle milliseconds
+	MinIdle int64
+}
+
+// StreamPending returns the pending entries of the group from Start to End.
+func (db *DB) StreamPending(key, group string, opts StreamPendingOptions) ([]StreamPendingEntry, error) {
+	entries := []StreamPendingEntry{}
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		_, g, err := t.lookupGroup(key, group)
+		if err != nil {
+			return err
+		}
+
+		now := now()
+
+		i, _ := g.findPending(opts.Start)
+
+		for ; i < len(g.pending) && len(entries) < opts.Count; i++ {
+			p := g.pending[i]
+			if opts.End.Less(p.id) {
+				break
+			}
+
+			idle := now - p.deliveryTime
+			if opts.Consumer != "" && p.consumer != opts.Consumer || idle < opts.MinIdle {
+				continue
+			}
+
+			entries = append(entries, StreamPendingEntry{
+				ID:            p.id,
+				Consumer:      p.consumer,
+				Idle:          idle,
+				DeliveryCount: p.deliveryCount,
+			})
+		}
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// StreamClaimOptions follow the options of the XCLAIM command.
+type StreamClaimOptions struct {
+	// DeliveryTime is the delivery time set on the claimed entries in unix
+	// milliseconds, zero or a time in the future means now
+	DeliveryTime int64
+	// RetryCount sets the delivery count, otherwise it's incremented
+	RetryCount *int64
+	// Force adds the entries that are not pending yet if they exist
+	Force bool
+	// JustID doesn't increment the delivery count
+	JustID bool
+	// LastID moves forward the id of the last entry delivered to the group
+	LastID *StreamID
+}
+
+// claim gives the pending entry to consumer.
+func (g *streamGroup) claim(p *pendingEntry, consumer string, deliveryTime int64, retryCount *int64, justID bool) {
+	g.assign(p, consumer)
+	p.deliveryTime = deliveryTime
+
+	switch {
+	case retryCount != nil:
+		p.deliveryCount = *retryCount
+	case !justID:
+		p.deliveryCount++
+	}
+}
+
+// StreamClaimResult holds the outcome of StreamClaim and StreamAutoClaim.
+type StreamClaimResult struct {
+	Claimed []StreamEntry
+	// Deleted are the ids of the pending entries removed since they were
+	// trimmed from the stream
+	Deleted []StreamID
+	// Next is the id StreamAutoClaim continues from, 0-0 once every
+	// pending entry was scanned
+	Next StreamID
+	// DeliveryTime is the delivery time set on the claimed entries
+	DeliveryTime int64
+}
+
+// StreamClaim gives to consumer the pending entries with ids idle for at
+// least minIdle milliseconds and returns them. Pending entries trimmed from
+// the stream are removed instead, the fields are nil when JustID is set.
+func (db *DB) StreamClaim(key, group, consumer string, minIdle int64, ids []StreamID, opts StreamClaimOptions) (StreamClaimResult, error) {
+	result := StreamClaimResult{
+		Claimed: []StreamEntry{},
+		Deleted: []StreamID{},
+	}
+
+	err := db.update([]string{key}, func(t *txn) error {
+		stream, g, err := t.lookupGroup(key, group)
+		if err != nil {
+			return err
+		}
+
+		if opts.LastID != nil && g.lastID.Less(*opts.LastID) {
+			g.lastID = *opts.LastID
+			t.modified(key)
+		}
+
+		now := now()
+
+		result.DeliveryTime = opts.DeliveryTime
+		if result.DeliveryTime <= 0 || result.DeliveryTime > now {
+			result.DeliveryTime = now
+		}
+
+		for _, id := range ids {
+			entry, exists := stream.lookup(id)
+
+			i, ok := g.findPending(id)
+			if !ok {
+				if !opts.Force || !exists {
+					continue
+				}
+
+				g.addPending(&pendingEntry{
+					id:            id,
+					consumer:      consumer,
+					deliveryTime:  now,
+					deliveryCount: 1,
+				})
+			}
+
+			p := g.pending[i]
+
+			if minIdle > 0 && now-p.deliveryTime < minIdle {
+				continue
+			}
+
+			if !exists {
+				result.Deleted = append(result.Deleted, id)
+				g.removePending(i)
+				t.modified(key)
+
+				continue
+			}
+
+			g.claim(p, consumer, result.DeliveryTime, opts.RetryCount, opts.JustID)
+			t.modified(key)
+
+			if opts.JustID {
+				entry.Fields = nil
+			}
+
+			result.Claimed = append(result.Claimed, entry)
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+// StreamAutoClaim claims up to count pending entries from start that are
+// idle for at least minIdle milliseconds, like StreamClaim.
+func (db *DB) StreamAutoClaim(key, group, consumer string, minIdle int64, start StreamID, count int, justID bool) (StreamClaimResult, error) {
+	result := StreamClaimResult{
+		Claimed: []StreamEntry{},
+		Deleted: []StreamID{},
+	}
+
+	err := db.update([]string{key}, func(t *txn) error {
+		stream, g, err := t.lookupGroup(key, group)
+		if err != nil {
+			return err
+		}
+
+		result.DeliveryTime = now()
+		// the scan is bounded even when few entries are idle enough
+		attempts := count * 10
+
+		i, _ := g.findPending(start)
+
+		for ; i < len(g.pending) && attempts > 0 && len(result.Claimed) < count; attempts-- {
+			p := g.pending[i]
+
+			if minIdle > 0 && result.DeliveryTime-p.deliveryTime < minIdle {
+				i++
+				continue
+			}
+
+			entry, exists := stream.lookup(p.id)
+			if !exists {
+				result.Deleted = append(result.Deleted, p.id)
+				g.removePending(i)
+				t.modified(key)
+
+				continue
+			}
+
+			g.claim(p, consumer, result.DeliveryTime, nil, justID)
+			t.modified(key)
+
+			if justID {
+				entry.Fields = nil
+			}
+
+			result.Claimed = append(result.Claimed, entry)
+			i++
+		}
+
+		if i < len(g.pending) {
+			result.Next = g.pending[i].id
+		}
+
+		return nil
+	})
+
+	return result, err
+}
@@ -0,0 +1,374 @@
+package memstore
+
+import (
+	"errors"
+)
+
+const (
+	// the biggest string that can be stored, like proto-max-bulk-len in Redis
+	stringMaxSize = 512 << 20
+)
+
+var (
+	ErrNotInteger    = errors.New("value is not an integer or out of range")
+	ErrNotFloat      = errors.New("value is not a valid float")
+	ErrStringTooLong = errors.New("string exceeds maximum allowed size (proto-max-bulk-len)")
+)
+
+// SetOptions are the conditions and the expiration applied by SetString,
+// they follow the options of the SET command.
+type SetOptions struct {
+	// NX only sets the key when it doesn't exist
+	NX bool
+	// XX only sets the key when it already exists
+	XX bool
+	// Get returns the previous string stored at the key
+	Get bool
+	// KeepTTL retains the expiration of the existing key
+	KeepTTL bool
+	// ExpireAt is the expiration in unix milliseconds, zero means no expiration
+	ExpireAt int64
+}
+
+// SetString stores val at key replacing any existing value. prev holds the old
+// string when opts.Get is set and ok reports whether the value was stored.
+func (db *DB) SetString(key, val string, opts SetOptions) (prev *string, ok bool, err error) {
+	err = db.write([]string{key}, func(t *txn) error {
+		entry := t.lookup(key)
+
+		if opts.Get && entry != nil {
+			old, err := entry.getString()
+			if err != nil {
+				return err
+			}
+
+			prev = &old
+		}
+
+		if (opts.NX && entry != nil) || (opts.XX && entry == nil) {
+			return nil
+		}
+
+		expireAt := opts.ExpireAt
+		if opts.KeepTTL && entry != nil {
+			expireAt = entry.expireAt
+		}
+
+		entry = t.create(key)
+		entry.val = valueString{
+			val: val,
+		}
+
+		t.setExpire(key, entry, expireAt)
+		ok = true
+
+		return nil
+	})
+
+	return prev, ok, err
+}
+
+// lookupString returns the string stored at key, or nil when the key
+// doesn't exist. Strings are stored by value, so a modified string must be
+// stored back with storeString.
+func (t *txn) lookupString(key string) (*valueString, error) {
+	entry := t.lookup(key)
+	if entry == nil {
+		return nil, nil
+	}
+
+	str, ok := entry.val.(valueString)
+	if !ok {
+		return nil, ErrWrongType
+	}
+
+	return &str, nil
+}
+
+// storeString stores str at key keeping the expiration of the key.
+func (t *txn) storeString(key string, str *valueString) {
+	t.create(key).val = *str
+	t.modified(key)
+}
+
+// GetStrings returns the string stored at every key, a nil element means
+// the key doesn't exist or doesn't hold a string. The keys are read at
+// the same time even when they live in different blocks.
+func (db *DB) GetStrings(keys ...string) ([]*string, error) {
+	vals := make([]*string, len(keys))
+
+	err := db.shard.view(keys, func(t *txn) error {
+		for i, key := range keys {
+			str, err := t.lookupString(key)
+			if err != nil || str == nil {
+				continue
+			}
+
+			val := str.get()
+			vals[i] = &val
+		}
+
+		return nil
+	})
+
+	return vals, err
+}
+
+// SetStrings stores the key value pairs of args replacing any value and
+// expiration. Every key is set at once, so other clients see all of them
+// or none. With nx nothing is set when any key exists, ok reports whether
+// the keys were set.
+func (db *DB) SetStrings(nx bool, args ...string) (ok bool, err error) {
+	keys := make([]string, 0, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		keys = append(keys, args[i])
+	}
+
+	err = db.write(keys, func(t *txn) error {
+		if nx {
+			for _, key := range keys {
+				if t.lookup(key) != nil {
+					return nil
+				}
+			}
+		}
+
+		for i := 0; i+1 < len(args); i += 2 {
+			entry := t.create(args[i])
+			entry.val = valueString{
+				val: args[i+1],
+			}
+
+			t.setExpire(args[i], entry, 0)
+		}
+
+		ok = true
+
+		return nil
+	})
+
+	return ok, err
+}
+
+// IncrBy adds delta to the integer stored at key and returns the new value,
+// a missing key counts as zero.
+func (db *DB) IncrBy(key string, delta int64) (int64, error) {
+	var result int64
+
+	err := db.write([]string{key}, func(t *txn) error {
+		str, err := t.lookupString(key)
+		if err != nil {
+			return err
+		}
+
+		if str == nil {
+			str = &valueString{}
+		}
+
+		result, err = str.incrBy(delta)
+		if err != nil {
+			return err
+		}
+
+		t.storeString(key, str)
+
+		return nil
+	})
+
+	return result, err
+}
+
+// IncrByFloat adds delta to the number stored at key and returns the new
+// value as it's stored, a missing key counts as zero.
+func (db *DB) IncrByFloat(key string, delta float64) (string, error) {
+	var result string
+
+	err := db.write([]string{key}, func(t *txn) error {
+		str, err := t.lookupString(key)
+		if err != nil {
+			return err
+		}
+
+		if str == nil {
+			str = &valueString{}
+		}
+
+		result, err = str.incrByFloat(delta)
+		if err != nil {
+			return err
+		}
+
+		t.storeString(key, str)
+
+		return nil
+	})
+
+	return result, err
+}
+
+// Append appends val to the string stored at key and returns the new length,
+// the key is created when it doesn't exist.
+func (db *DB) Append(key, val string) (int, error) {
+	var length int
+
+	err := db.write([]string{key}, func(t *txn) error {
+		str, err := t.lookupString(key)
+		if err != nil {
+			return err
+		}
+
+		if str == nil {
+			str = &valueString{}
+		}
+
+		if len(str.val)+len(val) > stringMaxSize {
+			return ErrStringTooLong
+		}
+
+		str.set(str.val + val)
+		t.storeString(key, str)
+		length = len(str.val)
+
+		return nil
+	})
+
+	return length, err
+}
+
+// StrLen returns the length of the string stored at key, zero when the key
+// doesn't exist.
+func (db *DB) StrLen(key string) (int, error) {
+	var length int
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		str, err := t.lookupString(key)
+		if err != nil || str == nil {
+			return err
+		}
+
+		length = len(str.val)
+
+		return nil
+	})
+
+	return length, err
+}
+
+// GetRange returns the substring from start to end, both included, negative
+// offsets are counted from the end of the string.
+func (db *DB) GetRange(key string, start, end int64) (string, error) {
+	var result string
+
+	err := db.shard.view([]string{key}, func(t *txn) error {
+		str, err := t.lookupString(key)
+		if err != nil || str == nil {
+			return err
+		}
+
+		result = str.getRange(start, end)
+
+		return nil
+	})
+
+	return result, err
+}
+
+// SetRange overwrites the string stored at key from offset with val and
+// returns the new length, the string is padded with zero bytes when it's
+// shorter than offset. A missing key is only created when val isn't empty.
+func (db *DB) SetRange(key string, offset int64, val string) (int, error) {
+	var length int
+
+	err := db.write([]string{key}, func(t *txn) error {
+		str, err := t.lookupString(key)
+		if err != nil {
+			return err
+		}
+
+		if str == nil {
+			if val == "" {
+				return nil
+			}
+
+			str = &valueString{}
+		}
+
+		if val == "" {
+			length = len(str.val)
+			return nil
+		}
+
+		if offset+int64(len(val)) > stringMaxSize {
+			return ErrStringTooLong
+		}
+
+		str.setRange(int(offset), val)
+		t.storeString(key, str)
+		length = len(str.val)
+
+		return nil
+	})
+
+	return length, err
+}
+
+// GetDel returns the string stored at key and removes the key, nil when the
+// key doesn't exist.
+func (db *DB) GetDel(key string) (*string, error) {
+	var result *string
+
+	err := db.update([]string{key}, func(t *txn) error {
+		str, err := t.lookupString(key)
+		if err != nil || str == nil {
+			return err
+		}
+
+		val := str.get()
+		result = &val
+		t.remove(key)
+
+		return nil
+	})
+
+	return result, err
+}
+
+// GetExOptions follow the options of the GETEX command.
+type GetExOptions struct {
+	// ExpireAt is the new expiration in unix milliseconds, zero keeps the
+	// current one unless Persist is set
+	ExpireAt int64
+	// Persist removes the expiration
+	Persist bool
+}
+
+// GetEx returns the string stored at key and updates its expiration, nil
+// when the key doesn't exist. An expiration in the past removes the key.
+func (db *DB) GetEx(key string, opts GetExOptions) (*string, error) {
+	var result *string
+
+	err := db.update([]string{key}, func(t *txn) error {
+		str, err := t.lookupString(key)
+		if err != nil || str == nil {
+			return err
+		}
+
+		val := str.get()
+		result = &val
+
+		entry := t.lookup(key)
+
+		switch {
+		case opts.Persist && entry.expireAt != 0:
+			t.setExpire(key, entry, 0)
+		case opts.ExpireAt != 0 && opts.ExpireAt <= now():
+			t.remove(key)
+		case opts.ExpireAt != 0:
+			t.setExpire(key, entry, opts.ExpireAt)
+		}
+
+		return nil
+	})
+
+	return result, err
+}
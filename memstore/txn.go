@@ -0,0 +1,261 @@
+package memstore
+
+import (
+	"fmt"
+	"sort"
+)
+
+// txn gives access to the entries of the blocks locked for an operation,
+// commands that read and write a key, or several keys, atomically are
+// built on top of it.
+type txn struct {
+	shard  *Shard
+	blocks map[uint32]*Storage
+	// touched keeps the keys created during the transaction, the ones that
+	// are left without a value are removed when the transaction ends
+	touched []string
+	// sizes holds the accounted size of every accessed key, the
+	// difference is accounted once the transaction ends
+	sizes map[string]int64
+	// readonly is set when only the read locks are held
+	readonly bool
+	// modifiedKeys holds the keys changed by the transaction
+	modifiedKeys map[string]struct{}
+	// record is given the number of modified keys before the blocks are
+	// unlocked, see DB.Recorded
+	record func(changes int64)
+}
+
+// update locks the blocks owning keys for writing and runs fn,
+// keys of other blocks must not be accessed from fn.
+func (s *Shard) update(keys []string, fn func(t *txn) error) error {
+	return s.run(keys, false, nil, fn)
+}
+
+// view is like update but it only takes the read locks, so several views
+// run concurrently. Entries must not be modified from fn.
+func (s *Shard) view(keys []string, fn func(t *txn) error) error {
+	return s.run(keys, true, nil, fn)
+}
+
+// update is Shard.update reporting the modifications to the recorder of db.
+func (db *DB) update(keys []string, fn func(t *txn) error) error {
+	return db.shard.run(keys, false, db.record, fn)
+}
+
+func (s *Shard) run(keys []string, readonly bool, record func(changes int64), fn func(t *txn) error) error {
+	t := &txn{
+		shard:    s,
+		blocks:   make(map[uint32]*Storage, len(keys)),
+		sizes:    make(map[string]int64, len(keys)),
+		readonly: readonly,
+		record:   record,
+	}
+
+	blockNums := make([]uint32, 0, len(keys))
+
+	for _, key := range keys {
+		blockNum, _ := locate(key)
+		if _, ok := t.blocks[blockNum]; ok {
+			continue
+		}
+
+		storage := s.Storage(blockNum)
+		if storage == nil {
+			return ErrNilEntries
+		}
+
+		t.blocks[blockNum] = storage
+		blockNums = append(blockNums, blockNum)
+	}
+
+	// blocks are always locked in the same order to avoid deadlocks
+	sort.Slice(blockNums, func(i, j int) bool {
+		return blockNums[i] < blockNums[j]
+	})
+
+	for _, blockNum := range blockNums {
+		if readonly {
+			t.blocks[blockNum].mu.RLock()
+		} else {
+			t.blocks[blockNum].mu.Lock()
+		}
+	}
+
+	defer func() {
+		for _, blockNum := range blockNums {
+			if readonly {
+				t.blocks[blockNum].mu.RUnlock()
+			} else {
+				t.blocks[blockNum].mu.Unlock()
+			}
+		}
+	}()
+
+	if !readonly {
+		defer t.cleanup()
+	}
+
+	return fn(t)
+}
+
+// modified marks key as changed, operations that modify a value in place
+// must call it, create, remove and setExpire already do.
+func (t *txn) modified(key string) {
+	if t.modifiedKeys == nil {
+		t.modifiedKeys = make(map[string]struct{})
+	}
+
+	t.modifiedKeys[key] = struct{}{}
+}
+
+// mustWrite panics when a read only transaction tries to modify the entries.
+func (t *txn) mustWrite() {
+	if t.readonly {
+		panic("memstore: write in a read only transaction")
+	}
+}
+
+func (t *txn) storage(key string) (*Storage, uint32) {
+	blockNum, hashKey := locate(key)
+
+	storage, ok := t.blocks[blockNum]
+	if !ok {
+		panic(fmt.Sprintf("memstore: block of key %q is not locked", key))
+	}
+
+	return storage, hashKey
+}
+
+// write runs fn like update, but first makes room for new data when the
+// memory limit is reached. It's used by the operations that can grow the
+// memory usage, ErrOOM is returned when nothing can be evicted.
+func (db *DB) write(keys []string, fn func(t *txn) error) error {
+	err := freeMemory()
+	if err != nil {
+		return err
+	}
+
+	return db.update(keys, fn)
+}
+
+// lookup returns the entry of key or nil when it doesn't exist.
+func (t *txn) lookup(key string) *EntryNode {
+	storage, hashKey := t.storage(key)
+
+	if t.readonly {
+		return storage.get(hashKey, key)
+	}
+
+	entry := storage.lookup(hashKey, key)
+	t.track(key, entry)
+
+	return entry
+}
+
+// create returns the entry of key, a new entry without value is created
+// when the key doesn't exist.
+func (t *txn) create(key string) *EntryNode {
+	t.mustWrite()
+
+	storage, hashKey := t.storage(key)
+
+	entry := storage.lookup(hashKey, key)
+	t.track(key, entry)
+
+	if entry != nil {
+		return entry
+	}
+
+	t.touched = append(t.touched, key)
+
+	return storage.create(hashKey, key)
+}
+
+// remove deletes key and reports whether it existed.
+func (t *txn) remove(key string) bool {
+	t.mustWrite()
+
+	storage, hashKey := t.storage(key)
+
+	// entries created without a value were never visible
+	if entry := storage.peek(hashKey, key); entry != nil && entry.val != nil {
+		t.modified(key)
+	}
+
+	t.settle(key)
+	t.sizes[key] = 0
+
+	return storage.remove(hashKey, key)
+}
+
+// track records the size of the entry of key the first time it's accessed.
+func (t *txn) track(key string, entry *EntryNode) {
+	if _, ok := t.sizes[key]; ok {
+		return
+	}
+
+	var size int64
+	if entry != nil {
+		size = entry.size()
+	}
+
+	t.sizes[key] = size
+}
+
+// settle accounts the size change of key since it was tracked.
+func (t *txn) settle(key string) {
+	before, ok := t.sizes[key]
+	if !ok {
+		return
+	}
+
+	storage, hashKey := t.storage(key)
+
+	var size int64
+	if entry := storage.peek(hashKey, key); entry != nil {
+		size = entry.size()
+	}
+
+	storage.account(size - before)
+	t.sizes[key] = size
+}
+
+// setExpire updates the expiration time of the entry of key, in unix milliseconds.
+func (t *txn) setExpire(key string, entry *EntryNode, at int64) {
+	t.mustWrite()
+
+	storage, hashKey := t.storage(key)
+	storage.setExpire(hashKey, entry, at)
+
+	t.modified(key)
+}
+
+func (t *txn) cleanup() {
+	for _, key := range t.touched {
+		storage, hashKey := t.storage(key)
+
+		entry := storage.peek(hashKey, key)
+		if entry != nil && entry.val == nil {
+			t.remove(key)
+		}
+	}
+
+	for key := range t.sizes {
+		t.settle(key)
+	}
+
+	for key := range t.modifiedKeys {
+		storage, hashKey := t.storage(key)
+
+		if entry := storage.peek(hashKey, key); entry != nil {
+			entry.version = nextVersion()
+		}
+	}
+
+	changes.Add(int64(len(t.modifiedKeys)))
+
+	if t.record != nil && len(t.modifiedKeys) > 0 {
+		t.record(int64(len(t.modifiedKeys)))
+	}
+}
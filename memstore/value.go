@@ -1,12 +1,62 @@
 package memstore
 
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
 type ValueType int
 
 const (
 	ValueTypeString ValueType = iota
 	ValueTypeMap
+	ValueTypeList
+	ValueTypeSet
+	ValueTypeSortedSet
+	ValueTypeStream
+)
+
+const (
+	// rough allocation overheads used by the memory accounting
+	stringOverhead   = 16
+	mapOverhead      = 48
+	mapFieldOverhead = 32
+	// the extra memory of a hash field with an expiration time
+	mapExpireOverhead = 16
+	listOverhead      = 48
+	listNodeOverhead  = 48
+	listItemOverhead  = 16
+
+	// the number of elements held by a list node before a new node is added
+	listNodeSize = 128
+
+	setOverhead       = 48
+	setIntOverhead    = 8
+	setMemberOverhead = 24
+
+	// sets of integers up to this size are kept as a sorted slice
+	setMaxIntsetEntries = 512
+
+	sortedSetOverhead       = 64
+	sortedSetMemberOverhead = 80
+
+	streamOverhead      = 64
+	streamNodeOverhead  = 48
+	streamEntryOverhead = 32
+	streamFieldOverhead = 16
+
+	// the number of entries held by a stream node before a new node is added
+	streamNodeSize = 100
 )
 
+// sizer estimates the memory held by a value in bytes, values keep the
+// estimation up to date on every change so it's cheap to call.
+type sizer interface {
+	size() int64
+}
+
 type valueString struct {
 	val string
 }
@@ -19,57 +69,1258 @@ func (v *valueString) set(arg string) {
 	v.val = arg
 }
 
+func (v valueString) size() int64 {
+	return stringOverhead + int64(len(v.val))
+}
+
+// incrBy adds delta to the integer held by the string and returns the result.
+func (v *valueString) incrBy(delta int64) (int64, error) {
+	var current int64
+
+	if v.val != "" {
+		var err error
+
+		current, err = strconv.ParseInt(v.val, 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+	}
+
+	if delta > 0 && current > math.MaxInt64-delta || delta < 0 && current < math.MinInt64-delta {
+		return 0, ErrIncrOverflow
+	}
+
+	current += delta
+	v.val = strconv.FormatInt(current, 10)
+
+	return current, nil
+}
+
+// incrByFloat adds delta to the number held by the string and returns the
+// result as it's stored.
+func (v *valueString) incrByFloat(delta float64) (string, error) {
+	var current float64
+
+	if v.val != "" {
+		var err error
+
+		current, err = strconv.ParseFloat(v.val, 64)
+		if err != nil || math.IsNaN(current) || math.IsInf(current, 0) {
+			return "", ErrNotFloat
+		}
+	}
+
+	current += delta
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return "", ErrIncrNaN
+	}
+
+	v.val = strconv.FormatFloat(current, 'f', -1, 64)
+
+	return v.val, nil
+}
+
+// getRange returns the bytes from start to end, both included, negative
+// offsets are counted from the end.
+func (v *valueString) getRange(start, end int64) string {
+	n := int64(len(v.val))
+
+	if start < 0 && end < 0 && start > end {
+		return ""
+	}
+
+	if start < 0 {
+		start += n
+	}
+
+	if end < 0 {
+		end += n
+	}
+
+	if start < 0 {
+		start = 0
+	}
+
+	if end < 0 {
+		end = 0
+	}
+
+	if end >= n {
+		end = n - 1
+	}
+
+	if n == 0 || start > end {
+		return ""
+	}
+
+	return v.val[start : end+1]
+}
+
+// setRange overwrites the bytes from offset with val, the string is padded
+// with zero bytes when it's shorter than offset.
+func (v *valueString) setRange(offset int, val string) {
+	buf := []byte(v.val)
+
+	if end := offset + len(val); end > len(buf) {
+		buf = append(buf, make([]byte, end-len(buf))...)
+	}
+
+	copy(buf[offset:], val)
+	v.val = string(buf)
+}
+
 type valueMap struct {
 	val map[string]string
+	// expires holds the expiration time of the fields that have one,
+	// in unix milliseconds
+	expires map[string]int64
+	// bytes is the memory used by the fields and values
+	bytes int64
 }
 
-func (v *valueMap) get(keys ...string) []string {
-	if len(keys) <= 0 {
-		return v.getAll()
+func newValueMap() *valueMap {
+	return &valueMap{
+		val: make(map[string]string),
 	}
+}
 
-	ret := []string{}
+func (v *valueMap) size() int64 {
+	return mapOverhead + v.bytes
+}
 
-	for _, key := range keys {
-		val, ok := v.val[key]
-		if !ok {
-			if key == "" {
-				continue
+// len returns the number of fields, including the expired ones that are
+// not removed yet.
+func (v *valueMap) len() int {
+	return len(v.val)
+}
+
+// expired reports whether field has an expiration time that already passed,
+// expired fields are treated as missing until they are removed.
+func (v *valueMap) expired(field string, now int64) bool {
+	at, ok := v.expires[field]
+	return ok && at <= now
+}
+
+// has reports whether field exists, it must only be called once the
+// expired fields are removed.
+func (v *valueMap) has(field string) bool {
+	_, ok := v.val[field]
+	return ok
+}
+
+// field returns the value of field unless it's missing or expired.
+func (v *valueMap) field(field string, now int64) (string, bool) {
+	val, ok := v.val[field]
+	if !ok || v.expired(field, now) {
+		return "", false
+	}
+
+	return val, true
+}
+
+// expiredAll reports whether the hash has fields and all of them expired,
+// the key of such a hash doesn't exist anymore.
+func (v *valueMap) expiredAll(now int64) bool {
+	if len(v.val) == 0 || len(v.expires) < len(v.val) {
+		return false
+	}
+
+	for _, at := range v.expires {
+		if at > now {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fields returns the fields that are not expired.
+func (v *valueMap) fields(now int64) []string {
+	fields := make([]string, 0, len(v.val))
+
+	for field := range v.val {
+		if !v.expired(field, now) {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
+
+// set stores the field value pairs of args and returns the number of new
+// fields, overwritten fields lose their expiration.
+func (v *valueMap) set(args ...string) int {
+	newFieldNum := 0
+
+	for i := 0; i+1 < len(args); i += 2 {
+		if v.put(args[i], args[i+1]) {
+			newFieldNum++
+		}
+
+		v.setExpire(args[i], 0)
+	}
+
+	return newFieldNum
+}
+
+// put stores the value of field keeping its expiration, it reports
+// whether the field is new.
+func (v *valueMap) put(field, val string) bool {
+	old, ok := v.val[field]
+	if ok {
+		v.bytes -= int64(len(old))
+	} else {
+		v.bytes += mapFieldOverhead + int64(len(field))
+	}
+
+	v.val[field] = val
+	v.bytes += int64(len(val))
+
+	return !ok
+}
+
+// remove deletes field and reports whether it existed.
+func (v *valueMap) remove(field string) bool {
+	val, ok := v.val[field]
+	if !ok {
+		return false
+	}
+
+	v.setExpire(field, 0)
+
+	delete(v.val, field)
+	v.bytes -= mapFieldOverhead + int64(len(field)) + int64(len(val))
+
+	return true
+}
+
+// setExpire sets the expiration time of field, zero makes it persistent.
+func (v *valueMap) setExpire(field string, at int64) {
+	if _, ok := v.expires[field]; ok {
+		if at == 0 {
+			delete(v.expires, field)
+			v.bytes -= mapExpireOverhead
+		} else {
+			v.expires[field] = at
+		}
+
+		return
+	}
+
+	if at == 0 {
+		return
+	}
+
+	if v.expires == nil {
+		v.expires = make(map[string]int64)
+	}
+
+	v.expires[field] = at
+	v.bytes += mapExpireOverhead
+}
+
+// purge removes the expired fields and returns how many were removed.
+func (v *valueMap) purge(now int64) int {
+	var removed int
+
+	for field, at := range v.expires {
+		if at <= now {
+			v.remove(field)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// listNode is a chunk of consecutive list elements, keeping several
+// elements per node saves memory and keeps them close in the cache.
+type listNode struct {
+	items []string
+	prev  *listNode
+	next  *listNode
+}
+
+// valueList is a quicklist: a doubly linked list of nodes holding up to
+// listNodeSize elements each, so pushes and pops on both ends are cheap
+// while big lists don't need a single huge slice.
+type valueList struct {
+	head   *listNode
+	tail   *listNode
+	nodes  int
+	length int
+	// bytes is the memory used by the elements
+	bytes int64
+}
+
+func newValueList() *valueList {
+	return &valueList{}
+}
+
+func (v *valueList) size() int64 {
+	return listOverhead + int64(v.nodes)*listNodeOverhead + v.bytes
+}
+
+func (v *valueList) clone() any {
+	cloned := newValueList()
+
+	for node := v.head; node != nil; node = node.next {
+		for _, item := range node.items {
+			cloned.pushBack(item)
+		}
+	}
+
+	return cloned
+}
+
+func (v *valueList) account(item string, added bool) {
+	size := listItemOverhead + int64(len(item))
+
+	if added {
+		v.length++
+		v.bytes += size
+	} else {
+		v.length--
+		v.bytes -= size
+	}
+}
+
+// insertNode links node after prev, a nil prev makes it the head.
+func (v *valueList) insertNode(prev, node *listNode) {
+	node.prev = prev
+
+	if prev == nil {
+		node.next = v.head
+		v.head = node
+	} else {
+		node.next = prev.next
+		prev.next = node
+	}
+
+	if node.next == nil {
+		v.tail = node
+	} else {
+		node.next.prev = node
+	}
+
+	v.nodes++
+}
+
+func (v *valueList) unlinkNode(node *listNode) {
+	if node.prev == nil {
+		v.head = node.next
+	} else {
+		node.prev.next = node.next
+	}
+
+	if node.next == nil {
+		v.tail = node.prev
+	} else {
+		node.next.prev = node.prev
+	}
+
+	v.nodes--
+}
+
+func (v *valueList) pushFront(item string) {
+	if v.head == nil || len(v.head.items) >= listNodeSize {
+		v.insertNode(nil, &listNode{
+			items: make([]string, 0, 8),
+		})
+	}
+
+	node := v.head
+	node.items = append(node.items, "")
+	copy(node.items[1:], node.items)
+	node.items[0] = item
+
+	v.account(item, true)
+}
+
+func (v *valueList) pushBack(item string) {
+	if v.tail == nil || len(v.tail.items) >= listNodeSize {
+		v.insertNode(v.tail, &listNode{
+			items: make([]string, 0, 8),
+		})
+	}
+
+	v.tail.items = append(v.tail.items, item)
+
+	v.account(item, true)
+}
+
+func (v *valueList) popFront() (string, bool) {
+	if v.head == nil {
+		return "", false
+	}
+
+	return v.removeAt(v.head, 0), true
+}
+
+func (v *valueList) popBack() (string, bool) {
+	if v.tail == nil {
+		return "", false
+	}
+
+	return v.removeAt(v.tail, len(v.tail.items)-1), true
+}
+
+// removeAt removes the element at offset of node, the node is unlinked
+// once it's empty.
+func (v *valueList) removeAt(node *listNode, offset int) string {
+	item := node.items[offset]
+
+	switch offset {
+	case 0:
+		node.items[0] = ""
+		node.items = node.items[1:]
+	case len(node.items) - 1:
+		node.items[offset] = ""
+		node.items = node.items[:offset]
+	default:
+		copy(node.items[offset:], node.items[offset+1:])
+		node.items[len(node.items)-1] = ""
+		node.items = node.items[:len(node.items)-1]
+	}
+
+	if len(node.items) == 0 {
+		v.unlinkNode(node)
+	}
+
+	v.account(item, false)
+
+	return item
+}
+
+// insertAt inserts item before offset of node, full nodes are split in half.
+func (v *valueList) insertAt(node *listNode, offset int, item string) {
+	node.items = append(node.items, "")
+	copy(node.items[offset+1:], node.items[offset:])
+	node.items[offset] = item
+
+	v.account(item, true)
+
+	if len(node.items) <= listNodeSize {
+		return
+	}
+
+	half := len(node.items) / 2
+
+	next := &listNode{
+		items: append(make([]string, 0, len(node.items)-half), node.items[half:]...),
+	}
+
+	node.items = node.items[:half:half]
+	v.insertNode(node, next)
+}
+
+// locate returns the node and the offset of the element at index,
+// the list is walked from the nearest end.
+func (v *valueList) locate(index int) (*listNode, int) {
+	if index < 0 || index >= v.length {
+		return nil, 0
+	}
+
+	if index < v.length/2 {
+		for node := v.head; node != nil; node = node.next {
+			if index < len(node.items) {
+				return node, index
 			}
 
-			val = "-1"
+			index -= len(node.items)
+		}
+
+		return nil, 0
+	}
+
+	index = v.length - 1 - index
+
+	for node := v.tail; node != nil; node = node.prev {
+		if index < len(node.items) {
+			return node, len(node.items) - 1 - index
 		}
-		ret = append(ret, val)
+
+		index -= len(node.items)
+	}
+
+	return nil, 0
+}
+
+func (v *valueList) index(index int) (string, bool) {
+	node, offset := v.locate(index)
+	if node == nil {
+		return "", false
+	}
+
+	return node.items[offset], true
+}
+
+func (v *valueList) set(index int, item string) bool {
+	node, offset := v.locate(index)
+	if node == nil {
+		return false
+	}
+
+	v.bytes += int64(len(item) - len(node.items[offset]))
+	node.items[offset] = item
+
+	return true
+}
+
+// rangeItems returns the elements from start to stop, both included,
+// the indexes must already be within the list.
+func (v *valueList) rangeItems(start, stop int) []string {
+	if start > stop {
+		return []string{}
+	}
+
+	ret := make([]string, 0, stop-start+1)
+
+	node, offset := v.locate(start)
+
+	for ; node != nil && len(ret) < cap(ret); node = node.next {
+		end := offset + cap(ret) - len(ret)
+		if end > len(node.items) {
+			end = len(node.items)
+		}
+
+		ret = append(ret, node.items[offset:end]...)
+		offset = 0
 	}
 
 	return ret
 }
 
-func (v *valueMap) getAll() []string {
-	ret := []string{}
+// forEach calls fn with every element and its index, from the head or
+// from the tail when reverse is set, until fn returns false.
+func (v *valueList) forEach(reverse bool, fn func(item string, index int) bool) {
+	if !reverse {
+		index := 0
+
+		for node := v.head; node != nil; node = node.next {
+			for _, item := range node.items {
+				if !fn(item, index) {
+					return
+				}
+
+				index++
+			}
+		}
+
+		return
+	}
+
+	index := v.length - 1
+
+	for node := v.tail; node != nil; node = node.prev {
+		for offset := len(node.items) - 1; offset >= 0; offset-- {
+			if !fn(node.items[offset], index) {
+				return
+			}
+
+			index--
+		}
+	}
+}
+
+// remove deletes up to count occurrences of item, from the head when count
+// is positive and from the tail when negative, zero removes all of them.
+func (v *valueList) remove(count int, item string) int {
+	removed := 0
+
+	if count >= 0 {
+		for node := v.head; node != nil; {
+			// the node is unlinked once its last element is removed
+			next := node.next
+
+			for offset := 0; offset < len(node.items); {
+				if node.items[offset] != item {
+					offset++
+					continue
+				}
+
+				v.removeAt(node, offset)
+				removed++
+
+				if removed == count {
+					return removed
+				}
+			}
+
+			node = next
+		}
+
+		return removed
+	}
+
+	for node := v.tail; node != nil; {
+		prev := node.prev
+
+		for offset := len(node.items) - 1; offset >= 0; offset-- {
+			if node.items[offset] != item {
+				continue
+			}
+
+			v.removeAt(node, offset)
+			removed++
+
+			if removed == -count {
+				return removed
+			}
+		}
+
+		node = prev
+	}
+
+	return removed
+}
+
+// trim keeps the elements from start to stop, both included.
+func (v *valueList) trim(start, stop int) {
+	for i := 0; i < start; i++ {
+		v.popFront()
+	}
+
+	for v.length > stop-start+1 {
+		v.popBack()
+	}
+}
+
+// insert adds item before or after the first occurrence of pivot,
+// it reports false when pivot is not found.
+func (v *valueList) insert(pivot, item string, before bool) bool {
+	for node := v.head; node != nil; node = node.next {
+		for offset, current := range node.items {
+			if current != pivot {
+				continue
+			}
+
+			if !before {
+				offset++
+			}
+
+			v.insertAt(node, offset, item)
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// valueSet has two encodings: small sets of integers are kept as a sorted
+// slice (intset) which is far more compact, the other sets as a hash table.
+// The intset is converted to a hash table once a member is not an integer
+// or the set grows past setMaxIntsetEntries.
+type valueSet struct {
+	ints []int64
+	// members is nil while the set is an intset
+	members map[string]struct{}
+	// bytes is the memory used by the members of the hash table
+	bytes int64
+}
+
+func newValueSet() *valueSet {
+	return &valueSet{}
+}
+
+// setInteger parses member when it's an integer in its canonical form,
+// so it can be converted back to the same string.
+func setInteger(member string) (int64, bool) {
+	if len(member) == 0 || len(member) > 20 {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != member {
+		return 0, false
+	}
+
+	return n, true
+}
+
+func (v *valueSet) isIntset() bool {
+	return v.members == nil
+}
+
+func (v *valueSet) size() int64 {
+	if v.isIntset() {
+		return setOverhead + int64(len(v.ints))*setIntOverhead
+	}
+
+	return setOverhead + v.bytes
+}
+
+func (v *valueSet) clone() any {
+	cloned := &valueSet{
+		bytes: v.bytes,
+	}
+
+	if v.isIntset() {
+		cloned.ints = append([]int64(nil), v.ints...)
+		return cloned
+	}
+
+	cloned.members = make(map[string]struct{}, len(v.members))
+	for member := range v.members {
+		cloned.members[member] = struct{}{}
+	}
+
+	return cloned
+}
+
+func (v *valueSet) len() int {
+	if v.isIntset() {
+		return len(v.ints)
+	}
+
+	return len(v.members)
+}
+
+// search returns the position of n in the intset and whether it's there.
+func (v *valueSet) search(n int64) (int, bool) {
+	i := sort.Search(len(v.ints), func(i int) bool {
+		return v.ints[i] >= n
+	})
+
+	return i, i < len(v.ints) && v.ints[i] == n
+}
+
+// convert moves the intset members to a hash table.
+func (v *valueSet) convert() {
+	v.members = make(map[string]struct{}, len(v.ints)+1)
+
+	for _, n := range v.ints {
+		v.addMember(strconv.FormatInt(n, 10))
+	}
+
+	v.ints = nil
+}
+
+func (v *valueSet) addMember(member string) bool {
+	if _, ok := v.members[member]; ok {
+		return false
+	}
+
+	v.members[member] = struct{}{}
+	v.bytes += setMemberOverhead + int64(len(member))
+
+	return true
+}
+
+func (v *valueSet) add(member string) bool {
+	if v.isIntset() {
+		n, ok := setInteger(member)
+		if ok {
+			i, found := v.search(n)
+			if found {
+				return false
+			}
+
+			if len(v.ints) < setMaxIntsetEntries {
+				v.ints = append(v.ints, 0)
+				copy(v.ints[i+1:], v.ints[i:])
+				v.ints[i] = n
+
+				return true
+			}
+		}
+
+		v.convert()
+	}
+
+	return v.addMember(member)
+}
+
+func (v *valueSet) remove(member string) bool {
+	if v.isIntset() {
+		n, ok := setInteger(member)
+		if !ok {
+			return false
+		}
+
+		i, found := v.search(n)
+		if !found {
+			return false
+		}
+
+		v.ints = append(v.ints[:i], v.ints[i+1:]...)
+
+		return true
+	}
+
+	if _, ok := v.members[member]; !ok {
+		return false
+	}
+
+	delete(v.members, member)
+	v.bytes -= setMemberOverhead + int64(len(member))
+
+	return true
+}
 
-	for key, val := range v.val {
-		ret = append(ret, key)
-		ret = append(ret, val)
+func (v *valueSet) has(member string) bool {
+	if v.isIntset() {
+		n, ok := setInteger(member)
+		if !ok {
+			return false
+		}
+
+		_, found := v.search(n)
+
+		return found
+	}
+
+	_, ok := v.members[member]
+
+	return ok
+}
+
+// items returns every member, intsets are sorted.
+func (v *valueSet) items() []string {
+	ret := make([]string, 0, v.len())
+
+	if v.isIntset() {
+		for _, n := range v.ints {
+			ret = append(ret, strconv.FormatInt(n, 10))
+		}
+
+		return ret
+	}
+
+	for member := range v.members {
+		ret = append(ret, member)
 	}
 
 	return ret
 }
 
-func (v *valueMap) set(args ...string) int {
-	newFieldNum := 0
+// random returns count members chosen at random, they are distinct unless
+// repeat is set.
+func (v *valueSet) random(count int, repeat bool) []string {
+	items := v.items()
 
-	for i := 0; i < len(args); i += 2 {
-		if args[i] == "" {
+	if repeat {
+		ret := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			ret = append(ret, items[rand.Intn(len(items))])
+		}
+
+		return ret
+	}
+
+	if count >= len(items) {
+		return items
+	}
+
+	// a partial Fisher-Yates shuffle picks the first count members
+	for i := 0; i < count; i++ {
+		j := i + rand.Intn(len(items)-i)
+		items[i], items[j] = items[j], items[i]
+	}
+
+	return items[:count]
+}
+
+// randomMember returns a member chosen at random, the set must not be empty.
+// The hash table relies on the random start of the map iteration, which
+// is cheap but not perfectly uniform.
+func (v *valueSet) randomMember() string {
+	if v.isIntset() {
+		return strconv.FormatInt(v.ints[rand.Intn(len(v.ints))], 10)
+	}
+
+	for member := range v.members {
+		return member
+	}
+
+	return ""
+}
+
+// valueSortedSet keeps the score of every member in a hash table and the
+// members ordered by score in a skiplist, the hash table answers the score
+// lookups while the skiplist answers the rank and range queries.
+type valueSortedSet struct {
+	scores map[string]float64
+	list   *skiplist
+	// bytes is the memory used by the members
+	bytes int64
+}
+
+func newValueSortedSet() *valueSortedSet {
+	return &valueSortedSet{
+		scores: make(map[string]float64),
+		list:   newSkiplist(),
+	}
+}
+
+func (v *valueSortedSet) size() int64 {
+	return sortedSetOverhead + v.bytes
+}
+
+func (v *valueSortedSet) clone() any {
+	cloned := newValueSortedSet()
+
+	for x := v.list.head.levels[0].forward; x != nil; x = x.levels[0].forward {
+		cloned.add(x.member, x.score)
+	}
+
+	return cloned
+}
+
+func (v *valueSortedSet) len() int {
+	return v.list.length
+}
+
+func (v *valueSortedSet) score(member string) (float64, bool) {
+	score, ok := v.scores[member]
+	return score, ok
+}
+
+// add sets the score of member and reports whether the member is new.
+func (v *valueSortedSet) add(member string, score float64) bool {
+	current, ok := v.scores[member]
+	if ok {
+		if current != score {
+			v.list.delete(current, member)
+			v.list.insert(score, member)
+			v.scores[member] = score
+		}
+
+		return false
+	}
+
+	v.list.insert(score, member)
+	v.scores[member] = score
+	v.bytes += int64(len(member)) + sortedSetMemberOverhead
+
+	return true
+}
+
+func (v *valueSortedSet) remove(member string) bool {
+	score, ok := v.scores[member]
+	if !ok {
+		return false
+	}
+
+	v.list.delete(score, member)
+	delete(v.scores, member)
+	v.bytes -= int64(len(member)) + sortedSetMemberOverhead
+
+	return true
+}
+
+// rank returns the 0-based rank of member, counted from the highest score
+// when reverse is set.
+func (v *valueSortedSet) rank(member string, reverse bool) (int, bool) {
+	score, ok := v.scores[member]
+	if !ok {
+		return 0, false
+	}
+
+	rank := v.list.rank(score, member)
+	if reverse {
+		return v.list.length - rank, true
+	}
+
+	return rank - 1, true
+}
+
+// rangeByRank returns the members from the 0-based rank start to stop,
+// both included, ranks are counted from the highest score when reverse is set.
+func (v *valueSortedSet) rangeByRank(start, stop int, reverse bool) []ScoredMember {
+	members := make([]ScoredMember, 0, stop-start+1)
+
+	rank := start + 1
+	if reverse {
+		rank = v.list.length - start
+	}
+
+	x := v.list.byRank(rank)
+	for i := start; i <= stop && x != nil; i++ {
+		members = append(members, ScoredMember{Member: x.member, Score: x.score})
+		x = x.next(reverse)
+	}
+
+	return members
+}
+
+// rangeByNode returns the members in r, the first offset ones are skipped and
+// at most count are returned, a negative count returns all of them.
+func (v *valueSortedSet) rangeByNode(r skiplistRange, reverse bool, offset, count int) []ScoredMember {
+	members := []ScoredMember{}
+
+	var x *skiplistNode
+	if reverse {
+		x = v.list.lastInRange(r)
+	} else {
+		x = v.list.firstInRange(r)
+	}
+
+	for ; x != nil && count != 0; x = x.next(reverse) {
+		if reverse && !r.gteMin(x) || !reverse && !r.lteMax(x) {
+			break
+		}
+
+		if offset > 0 {
+			offset--
 			continue
 		}
 
-		if _, ok := v.val[args[i]]; !ok {
-			newFieldNum++
+		members = append(members, ScoredMember{Member: x.member, Score: x.score})
+		count--
+	}
+
+	return members
+}
+
+// count returns the number of members in r.
+func (v *valueSortedSet) count(r skiplistRange) int {
+	first := v.list.firstInRange(r)
+	if first == nil {
+		return 0
+	}
+
+	last := v.list.lastInRange(r)
+
+	return v.list.rank(last.score, last.member) - v.list.rank(first.score, first.member) + 1
+}
+
+// pop removes and returns up to count members with the lowest scores,
+// or the highest ones when max is set.
+func (v *valueSortedSet) pop(count int, max bool) []ScoredMember {
+	members := []ScoredMember{}
+
+	for ; count > 0 && v.list.length > 0; count-- {
+		x := v.list.head.levels[0].forward
+		if max {
+			x = v.list.tail
 		}
 
-		v.val[args[i]] = args[i+1]
+		members = append(members, ScoredMember{Member: x.member, Score: x.score})
+		v.remove(x.member)
 	}
 
-	return newFieldNum
+	return members
+}
+
+// valueStream keeps the entries in nodes of up to streamNodeSize entries
+// ordered by id, like the listpacks of a Redis stream, so a range is found
+// with a binary search over the nodes and then inside a node. Entries are
+// only added at the tail and trimmed from the head.
+type valueStream struct {
+	nodes  []*streamNode
+	length int
+	// lastID is the id of the last added entry, it stays when the entry
+	// is trimmed so the ids keep growing
+	lastID StreamID
+	groups map[string]*streamGroup
+	// bytes is the memory used by the entries
+	bytes int64
+}
+
+type streamNode struct {
+	entries []StreamEntry
+}
+
+func newValueStream() *valueStream {
+	return &valueStream{
+		groups: make(map[string]*streamGroup),
+	}
+}
+
+func streamEntrySize(entry StreamEntry) int64 {
+	size := int64(streamEntryOverhead)
+	for _, field := range entry.Fields {
+		size += int64(len(field)) + streamFieldOverhead
+	}
+
+	return size
+}
+
+func (v *valueStream) size() int64 {
+	size := streamOverhead + v.bytes + int64(len(v.nodes))*streamNodeOverhead
+
+	for _, group := range v.groups {
+		size += group.size()
+	}
+
+	return size
+}
+
+func (v *valueStream) clone() any {
+	cloned := &valueStream{
+		nodes:  make([]*streamNode, 0, len(v.nodes)),
+		length: v.length,
+		lastID: v.lastID,
+		groups: make(map[string]*streamGroup, len(v.groups)),
+		bytes:  v.bytes,
+	}
+
+	// entries are never modified in place, so they can be shared
+	for _, node := range v.nodes {
+		cloned.nodes = append(cloned.nodes, &streamNode{
+			entries: append([]StreamEntry(nil), node.entries...),
+		})
+	}
+
+	for name, group := range v.groups {
+		cloned.groups[name] = group.clone()
+	}
+
+	return cloned
+}
+
+func (v *valueStream) add(entry StreamEntry) {
+	if len(v.nodes) == 0 || len(v.nodes[len(v.nodes)-1].entries) >= streamNodeSize {
+		v.nodes = append(v.nodes, &streamNode{
+			entries: make([]StreamEntry, 0, streamNodeSize),
+		})
+	}
+
+	tail := v.nodes[len(v.nodes)-1]
+	tail.entries = append(tail.entries, entry)
+
+	v.length++
+	v.lastID = entry.ID
+	v.bytes += streamEntrySize(entry)
+}
+
+// seek returns the position of the first entry with an id greater than or
+// equal to id, the position is past the last entry when there is none.
+func (v *valueStream) seek(id StreamID) (node, index int) {
+	node = sort.Search(len(v.nodes), func(i int) bool {
+		entries := v.nodes[i].entries
+		return !entries[len(entries)-1].ID.Less(id)
+	})
+
+	if node == len(v.nodes) {
+		return node, 0
+	}
+
+	entries := v.nodes[node].entries
+	index = sort.Search(len(entries), func(i int) bool {
+		return !entries[i].ID.Less(id)
+	})
+
+	return node, index
+}
+
+// lookup returns the entry with id.
+func (v *valueStream) lookup(id StreamID) (StreamEntry, bool) {
+	node, index := v.seek(id)
+	if node == len(v.nodes) || v.nodes[node].entries[index].ID != id {
+		return StreamEntry{}, false
+	}
+
+	return v.nodes[node].entries[index], true
+}
+
+// rangeEntries returns the entries from start to end, both included, at most
+// count of them when count is positive. They are returned from end to start
+// when reverse is set.
+func (v *valueStream) rangeEntries(start, end StreamID, count int, reverse bool) []StreamEntry {
+	entries := []StreamEntry{}
+
+	if end.Less(start) {
+		return entries
+	}
+
+	if !reverse {
+		node, index := v.seek(start)
+
+		for ; node < len(v.nodes); node, index = node+1, 0 {
+			for _, entry := range v.nodes[node].entries[index:] {
+				if end.Less(entry.ID) || count > 0 && len(entries) == count {
+					return entries
+				}
+
+				entries = append(entries, entry)
+			}
+		}
+
+		return entries
+	}
+
+	// the walk starts from the last entry before or at end
+	node, index := v.seek(end)
+	if node == len(v.nodes) || v.nodes[node].entries[index].ID != end {
+		node, index = v.before(node, index)
+	}
+
+	for node >= 0 {
+		entry := v.nodes[node].entries[index]
+		if entry.ID.Less(start) || count > 0 && len(entries) == count {
+			break
+		}
+
+		entries = append(entries, entry)
+		node, index = v.before(node, index)
+	}
+
+	return entries
+}
+
+// before returns the position of the entry preceding the one at node and
+// index, node is negative when there is none.
+func (v *valueStream) before(node, index int) (int, int) {
+	if index > 0 {
+		return node, index - 1
+	}
+
+	node--
+	if node < 0 {
+		return node, 0
+	}
+
+	return node, len(v.nodes[node].entries) - 1
+}
+
+// trim removes the entries from the head while remove returns true for
+// them and returns how many were removed. An approximated trim only removes
+// whole nodes, which is far cheaper, and at most limit entries when limit
+// is positive.
+func (v *valueStream) trim(remove func(entry StreamEntry, left int) bool, approx bool, limit int) int {
+	var removed int
+
+	for len(v.nodes) > 0 {
+		node := v.nodes[0]
+		last := node.entries[len(node.entries)-1]
+
+		// the whole node goes when its last entry goes
+		if remove(last, v.length-len(node.entries)) {
+			if approx && limit > 0 && removed+len(node.entries) > limit {
+				break
+			}
+
+			for _, entry := range node.entries {
+				v.bytes -= streamEntrySize(entry)
+			}
+
+			removed += len(node.entries)
+			v.length -= len(node.entries)
+			v.nodes[0] = nil
+			v.nodes = v.nodes[1:]
+
+			continue
+		}
+
+		if approx {
+			break
+		}
+
+		n := 0
+		for n < len(node.entries) && remove(node.entries[n], v.length-n-1) {
+			v.bytes -= streamEntrySize(node.entries[n])
+			n++
+		}
+
+		node.entries = append([]StreamEntry(nil), node.entries[n:]...)
+		removed += n
+		v.length -= n
+
+		break
+	}
+
+	return removed
 }
@@ -0,0 +1,53 @@
+package memstore
+
+import (
+	"sync/atomic"
+)
+
+var (
+	// versions numbers the modifications of the keyspace, every modified
+	// entry and every removal takes the next one
+	versions atomic.Uint64
+)
+
+func nextVersion() uint64 {
+	return versions.Add(1)
+}
+
+// version returns the version of key, the version of the last removal of
+// the block when the key doesn't exist. An expired entry is removed first,
+// the expiration takes a new version even when nobody saw the key expire.
+func (s *Storage) version(hashKey uint32, key string) uint64 {
+	entry := s.peek(hashKey, key)
+	if entry != nil && entry.Expired(now()) {
+		s.remove(hashKey, key)
+		changes.Add(1)
+
+		return s.removed
+	}
+
+	if entry == nil || entry.val == nil {
+		return s.removed
+	}
+
+	return entry.version
+}
+
+// Version returns the version of key, it changes whenever the key is
+// created, modified, removed or expires. WATCH compares it to find the
+// keys modified since they were watched. The version of a missing key
+// also changes when other keys of its block are removed, which only
+// aborts a transaction that could have run.
+func (db *DB) Version(key string) (uint64, error) {
+	var version uint64
+
+	// the expired key is removed, it needs the write lock
+	err := db.update([]string{key}, func(t *txn) error {
+		storage, hashKey := t.storage(key)
+		version = storage.version(hashKey, key)
+
+		return nil
+	})
+
+	return version, err
+}
@@ -0,0 +1,667 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/resp"
+)
+
+const (
+	DefaultAppendFilename = "appendonly.aof"
+)
+
+var (
+	ErrAppendOnlyDisabled  = errors.New("append only file is disabled")
+	ErrRewriteInProgress   = errors.New("background append only file rewriting already in progress")
+	ErrAppendOnlyTruncated = errors.New("append only file is truncated")
+)
+
+type FsyncPolicy int
+
+const (
+	// FsyncEverySec syncs the file once per second, at most one second of
+	// writes is lost on a crash
+	FsyncEverySec FsyncPolicy = iota
+	// FsyncAlways syncs the file after every write command
+	FsyncAlways
+	// FsyncNo leaves the syncing to the operating system
+	FsyncNo
+)
+
+var fsyncPolicyNames = map[FsyncPolicy]string{
+	FsyncEverySec: "everysec",
+	FsyncAlways:   "always",
+	FsyncNo:       "no",
+}
+
+func (p FsyncPolicy) String() string {
+	return fsyncPolicyNames[p]
+}
+
+func ParseFsyncPolicy(name string) (FsyncPolicy, error) {
+	name = strings.ToLower(name)
+
+	for policy, policyName := range fsyncPolicyNames {
+		if policyName == name {
+			return policy, nil
+		}
+	}
+
+	return FsyncEverySec, fmt.Errorf("unknown fsync policy %q", name)
+}
+
+type appendOnly struct {
+	// mu guards the log, it's only held to order and append the commands,
+	// the write commands run concurrently
+	mu sync.Mutex
+	// appended is signaled when commands are appended
+	appended *sync.Cond
+	enabled  bool
+	path     string
+	fsync    FsyncPolicy
+	// loadTruncated allows loading a log with an incomplete last command,
+	// the incomplete command is removed from the file
+	loadTruncated bool
+	file          *os.File
+	// db is the database selected by the commands in the file, -1 when
+	// it's unknown and the next command has to select it
+	db int
+	// dirty is set when the file has writes that are not synced yet
+	dirty bool
+	// next is the position in the log given to the next command modifying
+	// the keyspace, it's taken while the modified keys are locked. The
+	// commands are appended in the order of their positions, so the log
+	// has the modifications of every key in the order they were made.
+	next uint64
+	// written is the position of the next command to append, pending
+	// holds the commands done before the ones preceding them
+	written uint64
+	pending map[uint64]appendEntry
+	// rewriting is set while the log is compacted, the commands from the
+	// position rewriteFrom, which are not part of the snapshot of the
+	// rewrite, are kept in rewriteBuf and added to the new log. rewriteDB
+	// is the database selected by the commands of rewriteBuf.
+	rewriting   bool
+	rewriteFrom uint64
+	rewriteDB   int
+	rewriteBuf  bytes.Buffer
+	stop        chan struct{}
+}
+
+// appendEntry holds the commands appended for a write command.
+type appendEntry struct {
+	db int
+	// buf has the encoded commands, it's empty when the command doesn't
+	// need to be logged
+	buf []byte
+}
+
+var aof = &appendOnly{
+	path: filepath.Join(DefaultDir, DefaultAppendFilename),
+}
+
+// load replays the log through handler, it reports false when
+// there is no log to replay.
+func (a *appendOnly) load(handler resp.CommandHandler) (bool, error) {
+	f, err := os.OpenFile(a.path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	defer f.Close()
+
+	r := &countingReader{
+		r: f,
+	}
+
+	reader := resp.NewReader(r)
+	conn := resp.NewConnection(nil)
+
+	// valid is the offset right after the last complete command
+	var (
+		valid    int64
+		commands int
+	)
+
+	for {
+		valNode, err := reader.ReadValue()
+
+		consumed := r.n - int64(reader.Buffered())
+
+		if err == io.EOF && consumed == valid {
+			break
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if !a.loadTruncated {
+				return true, fmt.Errorf("%w at offset %d", ErrAppendOnlyTruncated, valid)
+			}
+
+			log.Printf("append only file is truncated at offset %d, the incomplete command is removed\n", valid)
+
+			return true, f.Truncate(valid)
+		}
+
+		if err != nil {
+			return true, fmt.Errorf("bad append only file format at offset %d: %w", valid, err)
+		}
+
+		cmd, err := resp.CommandFromValue(conn, valNode)
+		if err != nil {
+			return true, fmt.Errorf("bad append only file format at offset %d: %w", valid, err)
+		}
+
+		reply := handler.Serve(cmd)
+		if reply.IsError() {
+			log.Printf("command %q at offset %d of the append only file failed\n", cmd.Name(), valid)
+		}
+
+		valid = consumed
+		commands++
+	}
+
+	log.Println("append only file loaded, commands: ", commands)
+
+	return true, nil
+}
+
+// open starts appending to the log, the log is created from the current
+// keyspace when it doesn't exist. No command is served yet.
+func (a *appendOnly) open() error {
+	_, err := os.Stat(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		err = a.writeRewrite(memstore.TakeSnapshot())
+	}
+
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.file = f
+	a.db = -1
+	a.appended = sync.NewCond(&a.mu)
+	a.next, a.written = 0, 0
+	a.pending = make(map[uint64]appendEntry)
+	a.stop = make(chan struct{})
+
+	if a.fsync == FsyncEverySec {
+		go a.syncEverySec(a.stop)
+	}
+
+	memstore.OnEvict(a.evicted)
+
+	return nil
+}
+
+// feed runs a write command and appends it to the log. The command takes
+// its position in the log when it first modifies the keyspace, the write
+// commands of the other clients run in the meantime.
+func (a *appendOnly) feed(cmd resp.Command, next resp.CommandHandler) resp.ValueNode {
+	var (
+		pos      uint64
+		reserved bool
+	)
+
+	cmd.OnChange(func() {
+		if !reserved {
+			pos, reserved = a.reserve(), true
+		}
+	})
+
+	// a blocked command doesn't hold back the log while it waits, only the
+	// changes made after it's woken up are appended for it
+	cmd.OnSuspend(func() {
+		if !reserved {
+			return
+		}
+
+		a.mu.Lock()
+		a.append(pos, appendEntry{})
+		a.mu.Unlock()
+
+		reserved = false
+	}, func() {})
+
+	reply := next.Serve(cmd)
+
+	// commands that didn't modify anything, like a pop on an empty list,
+	// are not needed to rebuild the keyspace
+	if !reserved {
+		return reply
+	}
+
+	// a failed command still fills its position, the next commands are
+	// appended after it
+	var buf []byte
+	if !reply.IsError() {
+		for _, args := range propagate(cmd) {
+			buf = append(buf, encodeCommand(args)...)
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.append(pos, appendEntry{
+		db:  cmd.DB(),
+		buf: buf,
+	})
+
+	if a.fsync == FsyncAlways {
+		for a.written <= pos {
+			a.appended.Wait()
+		}
+
+		a.sync()
+	}
+
+	return reply
+}
+
+// reserve returns the position in the log of a command modifying the
+// keyspace, it's called while the modified keys are locked.
+func (a *appendOnly) reserve() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	pos := a.next
+	a.next++
+
+	return pos
+}
+
+// evicted appends a DEL for a key evicted by the memory limit, so the key
+// isn't back after the log is replayed. It's called while the key is
+// locked, like reserve.
+func (a *appendOnly) evicted(db int, key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	pos := a.next
+	a.next++
+
+	a.append(pos, appendEntry{
+		db:  db,
+		buf: encodeCommand([]string{"DEL", key}),
+	})
+}
+
+// append appends the commands of entry once the commands of the previous
+// positions are, it must be called with mu held.
+func (a *appendOnly) append(pos uint64, entry appendEntry) {
+	a.pending[pos] = entry
+
+	for {
+		entry, ok := a.pending[a.written]
+		if !ok {
+			break
+		}
+
+		delete(a.pending, a.written)
+		a.write(a.written, entry)
+		a.written++
+	}
+
+	a.appended.Broadcast()
+}
+
+// write must be called with mu held.
+func (a *appendOnly) write(pos uint64, entry appendEntry) {
+	if len(entry.buf) == 0 || a.file == nil {
+		return
+	}
+
+	buf := entry.buf
+	if entry.db != a.db {
+		buf = append(encodeCommand([]string{"SELECT", strconv.Itoa(entry.db)}), buf...)
+	}
+
+	_, err := a.file.Write(buf)
+	if err != nil {
+		log.Println("failed to write the append only file: ", err)
+		return
+	}
+
+	a.db = entry.db
+	a.dirty = true
+
+	if a.rewriting && pos >= a.rewriteFrom {
+		if entry.db != a.rewriteDB {
+			a.rewriteBuf.Write(encodeCommand([]string{"SELECT", strconv.Itoa(entry.db)}))
+			a.rewriteDB = entry.db
+		}
+
+		a.rewriteBuf.Write(entry.buf)
+	}
+}
+
+// sync must be called with mu held.
+func (a *appendOnly) sync() {
+	if !a.dirty || a.file == nil {
+		return
+	}
+
+	err := a.file.Sync()
+	if err != nil {
+		log.Println("failed to sync the append only file: ", err)
+		return
+	}
+
+	a.dirty = false
+}
+
+func (a *appendOnly) syncEverySec(stop chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			a.mu.Lock()
+			a.sync()
+			a.mu.Unlock()
+		}
+	}
+}
+
+func (a *appendOnly) close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+
+	close(a.stop)
+	a.sync()
+
+	err := a.file.Close()
+	a.file = nil
+
+	return err
+}
+
+// RewriteAppendOnly compacts the log in the background, the new log only
+// has the commands needed to rebuild the current keyspace.
+func RewriteAppendOnly() error {
+	aof.mu.Lock()
+
+	if !aof.enabled {
+		aof.mu.Unlock()
+		return ErrAppendOnlyDisabled
+	}
+
+	if aof.rewriting {
+		aof.mu.Unlock()
+		return ErrRewriteInProgress
+	}
+
+	// nothing is buffered until the snapshot is taken
+	aof.rewriting = true
+	aof.rewriteFrom = math.MaxUint64
+	aof.rewriteBuf.Reset()
+	aof.mu.Unlock()
+
+	// the positions are taken with the modified keys locked, the commands
+	// from the next position on are not part of the snapshot. The
+	// snapshot and the buffered commands together have every write.
+	snapshot := memstore.TakeSnapshotWith(func() {
+		aof.mu.Lock()
+		defer aof.mu.Unlock()
+
+		aof.rewriteFrom = aof.next
+		aof.rewriteDB = -1
+	})
+
+	go func() {
+		err := aof.rewrite(snapshot)
+		if err != nil {
+			log.Println("background append only file rewriting failed: ", err)
+			return
+		}
+
+		log.Println("background append only file rewriting done, keys: ", snapshot.Len())
+	}()
+
+	return nil
+}
+
+func (a *appendOnly) rewrite(snapshot *memstore.Snapshot) error {
+	tmp, err := a.writeTemp(snapshot)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// the commands of the snapshot that are still running are appended to
+	// the current log first
+	for a.written < a.rewriteFrom && a.file != nil {
+		a.appended.Wait()
+	}
+
+	a.rewriting = false
+
+	defer a.rewriteBuf.Reset()
+
+	if err == nil && a.file == nil {
+		err = errors.New("append only file is closed")
+	}
+
+	if err != nil {
+		if tmp != "" {
+			os.Remove(tmp)
+		}
+
+		return err
+	}
+
+	return a.replace(tmp)
+}
+
+// replace adds the commands buffered during the rewrite to the new log
+// and switches to it, it must be called with mu held.
+func (a *appendOnly) replace(tmp string) error {
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	_, err = f.Write(a.rewriteBuf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, a.path)
+	}
+
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+
+		return err
+	}
+
+	a.file.Close()
+	a.file = f
+	a.dirty = false
+
+	// the new log ends with the database of the buffered commands, or the
+	// one of its last key which isn't known
+	a.db = a.rewriteDB
+
+	return nil
+}
+
+// writeRewrite writes the log of snapshot in place of the current log,
+// it must be called with mu held.
+func (a *appendOnly) writeRewrite(snapshot *memstore.Snapshot) error {
+	tmp, err := a.writeTemp(snapshot)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, a.path)
+}
+
+// writeTemp writes the commands rebuilding snapshot to a temporary file
+// and returns its path.
+func (a *appendOnly) writeTemp(snapshot *memstore.Snapshot) (string, error) {
+	tmp := filepath.Join(filepath.Dir(a.path), fmt.Sprintf("temp-rewriteaof-%d.aof", os.Getpid()))
+
+	defer snapshot.Release()
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+
+	w := bufio.NewWriter(f)
+
+	err = snapshot.Rewrite(func(args ...string) error {
+		_, err := w.Write(encodeCommand(args))
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	return tmp, nil
+}
+
+func encodeCommand(args []string) []byte {
+	node := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for _, arg := range args {
+		node.Append(resp.NewValueNode(
+			resp.ValueNodeTypeBulkString,
+			resp.WithValue(arg),
+		))
+	}
+
+	return node.Marshal()
+}
+
+// propagate returns the commands appended to the log for cmd. Relative
+// expirations are stored as absolute times, so replaying the log later
+// doesn't extend them.
+func propagate(cmd resp.Command) [][]string {
+	if cmds, ok := cmd.Propagation(); ok {
+		return cmds
+	}
+
+	switch cmd.Name() {
+	case "expire", "pexpire", "expireat", "pexpireat":
+		return propagateExpire(cmd)
+	case "set":
+		return [][]string{propagateSet(cmd)}
+	}
+
+	return [][]string{cmd.Argv()}
+}
+
+// propagateExpire stores the resulting expiration of the key, a key
+// removed by an expiration in the past is stored as a DEL.
+func propagateExpire(cmd resp.Command) [][]string {
+	key := cmd.Key()
+
+	at, err := memstore.Database(cmd.DB()).ExpireTime(key)
+	if errors.Is(err, memstore.ErrNilEntries) {
+		return [][]string{{"DEL", key}}
+	}
+
+	// the expiration was rejected by the NX, XX, GT or LT condition
+	if err != nil || at == 0 {
+		return nil
+	}
+
+	return [][]string{{"PEXPIREAT", key, strconv.FormatInt(at, 10)}}
+}
+
+// propagateSet replaces the EX, PX and EXAT options of SET with PXAT.
+func propagateSet(cmd resp.Command) []string {
+	argv := cmd.Argv()
+
+	args := make([]string, 0, len(argv))
+	hasExpire := false
+
+	for i := 0; i < len(argv); i++ {
+		switch strings.ToLower(argv[i]) {
+		case "ex", "px", "exat", "pxat":
+			// the first two arguments are the key and the value
+			if i > 2 && i+1 < len(argv) {
+				hasExpire = true
+				i++
+
+				continue
+			}
+		}
+
+		args = append(args, argv[i])
+	}
+
+	if !hasExpire {
+		return argv
+	}
+
+	at, err := memstore.Database(cmd.DB()).ExpireTime(cmd.Key())
+	if err != nil || at == 0 {
+		return args
+	}
+
+	return append(args, "PXAT", strconv.FormatInt(at, 10))
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
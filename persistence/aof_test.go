@@ -0,0 +1,97 @@
+package persistence_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/raspiantoro/temporama/command"
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/persistence"
+	"github.com/raspiantoro/temporama/resp"
+)
+
+func TestAppendOnlyEviction(t *testing.T) {
+	memstore.FlushAll()
+
+	persistence.Configure(persistence.Config{
+		Dir:         t.TempDir(),
+		AppendOnly:  true,
+		AppendFsync: persistence.FsyncAlways,
+	})
+
+	mux := command.Registers()
+
+	err := persistence.Load(mux)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer persistence.Shutdown()
+
+	conn := resp.NewConnection(nil)
+	set := func(key string) {
+		reply := mux.Serve(resp.NewCommand("set", conn, key, strings.Repeat("v", 100)))
+		if reply.IsError() {
+			t.Fatalf("set %s: %s", key, reply.Text())
+		}
+	}
+
+	const keys = 100
+
+	for i := 0; i < keys/2; i++ {
+		set("key:" + strconv.Itoa(i))
+	}
+
+	// every key set from now on evicts another one
+	memstore.SetEvictionPolicy(memstore.AllKeysRandom)
+	memstore.SetMaxMemory(memstore.UsedMemory())
+
+	for i := keys / 2; i < keys; i++ {
+		set("key:" + strconv.Itoa(i))
+	}
+
+	memstore.SetMaxMemory(0)
+	memstore.SetEvictionPolicy(memstore.NoEviction)
+
+	db := memstore.Database(0)
+
+	exists := make(map[string]bool)
+	for i := 0; i < keys; i++ {
+		key := "key:" + strconv.Itoa(i)
+
+		n, err := db.Exists(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exists[key] = n == 1
+	}
+
+	if db.DBSize() == keys {
+		t.Fatal("no key was evicted")
+	}
+
+	err = persistence.Shutdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	memstore.FlushAll()
+
+	err = persistence.Load(command.Registers())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range exists {
+		n, err := db.Exists(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := n == 1; got != want {
+			t.Errorf("%s exists after reloading the append only file: %t, want %t", key, got, want)
+		}
+	}
+}
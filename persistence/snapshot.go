@@ -0,0 +1,422 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raspiantoro/temporama/memstore"
+	"github.com/raspiantoro/temporama/resp"
+)
+
+const (
+	DefaultDir        = "."
+	DefaultDBFilename = "dump.tdb"
+	// DefaultSaveRules saves after an hour when a key changed, after 5
+	// minutes with 100 changes and after a minute with 10000 changes
+	DefaultSaveRules = "3600 1 300 100 60 10000"
+
+	// a failed background save is retried after this delay, even when
+	// the save rules are met
+	retryDelay = 5 * time.Second
+)
+
+var (
+	ErrSaveInProgress = errors.New("background save already in progress")
+)
+
+// SaveRule triggers a background save when at least Changes modifications
+// were made and Seconds passed since the last successful save.
+type SaveRule struct {
+	Seconds int64
+	Changes int64
+}
+
+// ParseSaveRules parses pairs of seconds and changes like "3600 1 300 100",
+// an empty string disables the automatic saves.
+func ParseSaveRules(s string) ([]SaveRule, error) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, errors.New("save rules must be pairs of seconds and changes")
+	}
+
+	rules := make([]SaveRule, 0, len(fields)/2)
+
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid save seconds %q", fields[i])
+		}
+
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes <= 0 {
+			return nil, fmt.Errorf("invalid save changes %q", fields[i+1])
+		}
+
+		rules = append(rules, SaveRule{
+			Seconds: seconds,
+			Changes: changes,
+		})
+	}
+
+	return rules, nil
+}
+
+type Config struct {
+	// Dir is the directory where the snapshot and the append only file are written
+	Dir        string
+	DBFilename string
+	SaveRules  []SaveRule
+	// AppendOnly enables logging every write command to the append only file
+	AppendOnly     bool
+	AppendFilename string
+	AppendFsync    FsyncPolicy
+	// LoadTruncated allows starting with an append only file whose last
+	// command is incomplete, like after a crash in the middle of a write
+	LoadTruncated bool
+}
+
+type snapshotter struct {
+	mu     sync.Mutex
+	path   string
+	rules  []SaveRule
+	saving bool
+	// scheduled is set by BGSAVE SCHEDULE while another save is running
+	scheduled bool
+	done      chan struct{}
+	// lastSave is the time of the last successful save, lastSaveChanges
+	// is the change counter at the moment that snapshot was taken
+	lastSave        time.Time
+	lastSaveChanges int64
+	lastAttempt     time.Time
+	lastErr         error
+	stop            chan struct{}
+}
+
+var snapshots = &snapshotter{
+	path:     filepath.Join(DefaultDir, DefaultDBFilename),
+	lastSave: time.Now(),
+}
+
+// Configure sets where the snapshot and the append only file are stored
+// and when they are written, it must be called before Load and Start.
+func Configure(cfg Config) {
+	dir := cfg.Dir
+	if dir == "" {
+		dir = DefaultDir
+	}
+
+	filename := cfg.DBFilename
+	if filename == "" {
+		filename = DefaultDBFilename
+	}
+
+	appendFilename := cfg.AppendFilename
+	if appendFilename == "" {
+		appendFilename = DefaultAppendFilename
+	}
+
+	snapshots.mu.Lock()
+	snapshots.path = filepath.Join(dir, filename)
+	snapshots.rules = cfg.SaveRules
+	snapshots.mu.Unlock()
+
+	aof.mu.Lock()
+	aof.enabled = cfg.AppendOnly
+	aof.path = filepath.Join(dir, appendFilename)
+	aof.fsync = cfg.AppendFsync
+	aof.loadTruncated = cfg.LoadTruncated
+	aof.mu.Unlock()
+}
+
+// Load restores the keyspace, from the append only file when it's enabled
+// and from the snapshot otherwise. The write commands of mux are appended
+// to the append only file from then on.
+func Load(mux *resp.Mux) error {
+	loaded := false
+
+	if aof.enabled {
+		var err error
+
+		loaded, err = aof.load(mux)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !loaded {
+		err := loadSnapshot()
+		if err != nil {
+			return err
+		}
+	}
+
+	snapshots.mu.Lock()
+	snapshots.lastSaveChanges = memstore.Changes()
+	snapshots.mu.Unlock()
+
+	if !aof.enabled {
+		return nil
+	}
+
+	err := aof.open()
+	if err != nil {
+		return err
+	}
+
+	mux.Use(resp.FlagWrite, aof.feed)
+
+	return nil
+}
+
+// loadSnapshot restores the snapshot file, a missing file is not an error
+// since there is nothing to restore on the first start.
+func loadSnapshot() error {
+	f, err := os.Open(snapshots.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	err = memstore.Restore(f)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", snapshots.path, err)
+	}
+
+	return nil
+}
+
+// Save writes the snapshot in the foreground, every block is only locked
+// while it's copied.
+func Save() error {
+	snapshots.mu.Lock()
+	if snapshots.saving {
+		snapshots.mu.Unlock()
+		return ErrSaveInProgress
+	}
+
+	snapshots.saving = true
+	snapshots.done = make(chan struct{})
+	snapshots.mu.Unlock()
+
+	return snapshots.save(memstore.TakeSnapshot())
+}
+
+// BackgroundSave takes the snapshot and writes it from another goroutine.
+// With schedule set, a save requested while another one is running is
+// started once it completes instead of failing with ErrSaveInProgress.
+func BackgroundSave(schedule bool) (scheduled bool, err error) {
+	snapshots.mu.Lock()
+	defer snapshots.mu.Unlock()
+
+	if snapshots.saving {
+		if !schedule {
+			return false, ErrSaveInProgress
+		}
+
+		snapshots.scheduled = true
+		return true, nil
+	}
+
+	snapshots.startBackgroundSave()
+
+	return false, nil
+}
+
+// LastSave returns the time of the last successful save, or the start time
+// when nothing was saved yet.
+func LastSave() time.Time {
+	snapshots.mu.Lock()
+	defer snapshots.mu.Unlock()
+
+	return snapshots.lastSave
+}
+
+// Start runs the scheduler that saves the snapshot when a save rule is met.
+func Start() {
+	snapshots.mu.Lock()
+	defer snapshots.mu.Unlock()
+
+	if snapshots.stop != nil {
+		return
+	}
+
+	snapshots.stop = make(chan struct{})
+
+	go snapshots.cron(snapshots.stop)
+}
+
+// Shutdown stops the scheduler, waits for a running save and writes a last
+// snapshot when save rules are configured. The append only file is synced
+// and closed.
+func Shutdown() error {
+	err := aof.close()
+	if err != nil {
+		log.Println("failed to close the append only file: ", err)
+	}
+
+	snapshots.mu.Lock()
+
+	if snapshots.stop != nil {
+		close(snapshots.stop)
+		snapshots.stop = nil
+	}
+
+	rules := len(snapshots.rules)
+	snapshots.mu.Unlock()
+
+	snapshots.wait()
+
+	if rules == 0 {
+		return nil
+	}
+
+	return Save()
+}
+
+// startBackgroundSave must be called with mu held.
+func (s *snapshotter) startBackgroundSave() {
+	s.saving = true
+	s.scheduled = false
+	s.done = make(chan struct{})
+
+	// the snapshot is taken right away, so it holds the keyspace as it
+	// was when the save was requested, the entries are only copied by the
+	// goroutine writing it
+	snapshot := memstore.TakeSnapshot()
+
+	go func() {
+		err := s.save(snapshot)
+		if err != nil {
+			log.Println("background save failed: ", err)
+			return
+		}
+
+		log.Println("background save done, keys: ", snapshot.Len())
+	}()
+}
+
+func (s *snapshotter) save(snapshot *memstore.Snapshot) error {
+	err := s.writeFile(snapshot)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.saving = false
+	s.lastAttempt = time.Now()
+	s.lastErr = err
+	close(s.done)
+
+	if err == nil {
+		s.lastSave = s.lastAttempt
+		s.lastSaveChanges = snapshot.Changes()
+	}
+
+	if s.scheduled {
+		s.startBackgroundSave()
+	}
+
+	return err
+}
+
+// writeFile writes the snapshot to a temporary file that replaces the
+// previous snapshot once it's synced, so a crash never leaves a partial file.
+func (s *snapshotter) writeFile(snapshot *memstore.Snapshot) error {
+	s.mu.Lock()
+	path := s.path
+	s.mu.Unlock()
+
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf("temp-%d.tdb", os.Getpid()))
+
+	defer snapshot.Release()
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = snapshot.Encode(f)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// wait blocks until the running save, if any, completes.
+func (s *snapshotter) wait() {
+	for {
+		s.mu.Lock()
+		saving, done := s.saving, s.done
+		s.mu.Unlock()
+
+		if !saving {
+			return
+		}
+
+		<-done
+	}
+}
+
+func (s *snapshotter) cron(stop chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.checkRules()
+		}
+	}
+}
+
+func (s *snapshotter) checkRules() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.saving {
+		return
+	}
+
+	ts := time.Now()
+
+	// don't hammer the disk when the last save failed
+	if s.lastErr != nil && ts.Sub(s.lastAttempt) < retryDelay {
+		return
+	}
+
+	changes := memstore.Changes() - s.lastSaveChanges
+	elapsed := int64(ts.Sub(s.lastSave) / time.Second)
+
+	for _, rule := range s.rules {
+		if changes >= rule.Changes && elapsed >= rule.Seconds {
+			log.Printf("%d changes in %d seconds, saving", rule.Changes, rule.Seconds)
+			s.startBackgroundSave()
+
+			return
+		}
+	}
+}
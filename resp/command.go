@@ -1,6 +1,12 @@
 package resp
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
 
 type CommandHandler interface {
 	Serve(cmd Command) ValueNode
@@ -12,26 +18,119 @@ func (h HandlerFunc) Serve(cmd Command) ValueNode {
 	return h(cmd)
 }
 
+// CommandFlag describes how a command behaves, middlewares use it to
+// choose the commands they wrap.
+type CommandFlag uint32
+
+const (
+	// FlagWrite marks the commands that may modify the keyspace
+	FlagWrite CommandFlag = 1 << iota
+	// FlagTransaction marks the commands controlling a transaction, like
+	// EXEC, they run right away instead of being queued after MULTI
+	FlagTransaction
+	// FlagExclusive marks the commands running others with no command of
+	// the other clients in between, like EXEC, they take the exclusive
+	// lock themselves
+	FlagExclusive
+	// FlagNoScript marks the commands that scripts can't call
+	FlagNoScript
+	// FlagAllowBusy marks the commands that still run while the server
+	// is busy, like SCRIPT KILL, they don't take the exclusive lock
+	FlagAllowBusy
+)
+
+// Middleware wraps the execution of a command, next runs the command itself.
+type Middleware func(cmd Command, next CommandHandler) ValueNode
+
+type route struct {
+	handler CommandHandler
+	// arity is the number of arguments of the command counting its name,
+	// a negative arity is the minimum number of arguments
+	arity int
+	flags CommandFlag
+}
+
+// checkArity reports whether the command has the number of arguments of
+// the route.
+func (r route) checkArity(cmd Command) bool {
+	argc := len(cmd.args) + 1
+
+	if r.arity < 0 {
+		return argc >= -r.arity
+	}
+
+	return argc == r.arity
+}
+
+type middleware struct {
+	flags CommandFlag
+	fn    Middleware
+}
+
 type Mux struct {
-	handlers map[string]CommandHandler
+	handlers    map[string]route
+	middlewares []middleware
+	// exclusive is held for reading while a command runs, transactions
+	// hold it for writing so no other command runs in between theirs
+	exclusive sync.RWMutex
+	// busy is set while an exclusive command runs for too long, the
+	// other clients get ErrBusy instead of waiting for it
+	busy atomic.Bool
 }
 
 func NewCommandMux() *Mux {
 	return &Mux{
-		handlers: make(map[string]CommandHandler),
+		handlers: make(map[string]route),
 	}
 }
 
-func (m *Mux) Handle(name string, handler CommandHandler) {
-	m.handlers[name] = handler
+// Handle registers handler for the command name. arity is the number of
+// arguments counting the name, or minus the minimum number of them when
+// the command takes a variable number of arguments.
+func (m *Mux) Handle(name string, handler CommandHandler, arity int, flags ...CommandFlag) {
+	r := route{
+		handler: handler,
+		arity:   arity,
+	}
+
+	for _, flag := range flags {
+		r.flags |= flag
+	}
+
+	m.handlers[name] = r
 }
 
-func (m *Mux) HandleFunc(name string, handler func(cmd Command) ValueNode) {
-	m.handlers[name] = HandlerFunc(handler)
+func (m *Mux) HandleFunc(name string, handler func(cmd Command) ValueNode, arity int, flags ...CommandFlag) {
+	m.Handle(name, HandlerFunc(handler), arity, flags...)
+}
+
+// Use wraps the commands having any of flags with fn, middlewares added
+// first run first. It must be called before the mux serves commands.
+func (m *Mux) Use(flags CommandFlag, fn Middleware) {
+	m.middlewares = append(m.middlewares, middleware{
+		flags: flags,
+		fn:    fn,
+	})
 }
 
 func (m *Mux) Serve(cmd Command) ValueNode {
-	handler, ok := m.handlers[cmd.name]
+	r, ok := m.handlers[cmd.name]
+
+	// after MULTI the commands are queued until EXEC, an unknown command
+	// or a wrong number of arguments makes EXEC discard the transaction
+	if cmd.queueable() && r.flags&FlagTransaction == 0 {
+		if !ok || !r.checkArity(cmd) {
+			cmd.conn.tx.aborted = true
+		} else {
+			cmd.conn.tx.queued = append(cmd.conn.tx.queued, cmd)
+
+			return ValueNode{
+				types: ValueNodeTypeSimpleString,
+				val:   "QUEUED",
+			}
+		}
+	}
+
 	if !ok {
 		return ValueNode{
 			types: ValueNodeTypeSimpleError,
@@ -39,6 +138,46 @@ func (m *Mux) Serve(cmd Command) ValueNode {
 		}
 	}
 
+	if !r.checkArity(cmd) {
+		return ValueNode{
+			types: ValueNodeTypeSimpleError,
+			val:   fmt.Sprintf("ERROR: wrong arguments number for '%s' command", cmd.name),
+		}
+	}
+
+	if m.busy.Load() && r.flags&FlagAllowBusy == 0 && !cmd.nested() && !cmd.exclusive() {
+		return ValueNode{
+			types: ValueNodeTypeSimpleError,
+			val:   ErrBusy.Error(),
+		}
+	}
+
+	handler := r.handler
+
+	for i := len(m.middlewares) - 1; i >= 0; i-- {
+		mw := m.middlewares[i]
+		if r.flags&mw.flags == 0 {
+			continue
+		}
+
+		next := handler
+		handler = HandlerFunc(func(cmd Command) ValueNode {
+			return mw.fn(cmd, next)
+		})
+	}
+
+	// commands run by the handler go through the same mux
+	cmd.mux = m
+
+	// the commands run by another one or by a transaction are covered by
+	// their lock
+	if !cmd.nested() && !cmd.exclusive() && r.flags&(FlagExclusive|FlagAllowBusy) == 0 {
+		m.exclusive.RLock()
+		defer m.exclusive.RUnlock()
+
+		cmd.OnSuspend(m.exclusive.RUnlock, m.exclusive.RLock)
+	}
+
 	return handler.Serve(cmd)
 }
 
@@ -46,20 +185,82 @@ type Command struct {
 	conn *Connection
 	name string
 	args []string
+	// mux is the mux serving the command
+	mux *Mux
+	// state is shared by the copies of the command, so the handler and
+	// the middlewares can talk to each other
+	state *commandState
+}
+
+type commandState struct {
+	propagated  bool
+	propagation [][]string
+	// hooks release and take back what the middlewares hold while the
+	// command is suspended
+	hooks []suspendHook
+	// nested is set for the commands run by another command, like the
+	// queued commands run by EXEC
+	nested bool
+	// changes counts the keys modified by the command, changeHooks run on
+	// every modification
+	changes     int64
+	changeHooks []func()
+}
+
+type suspendHook struct {
+	release func()
+	acquire func()
 }
 
 func NewCommand(name string, conn *Connection, args ...string) Command {
 	return Command{
-		name: name,
-		args: args,
-		conn: conn,
+		name:  name,
+		args:  args,
+		conn:  conn,
+		state: &commandState{},
 	}
 }
 
+// CommandFromValue builds the command sent as valNode, which must be an
+// array of bulk strings starting with the command name.
+func CommandFromValue(conn *Connection, valNode ValueNode) (Command, error) {
+	if valNode.types != ValueNodeTypeArray {
+		return Command{}, fmt.Errorf("expected * at the begining, got %s", valNode.types)
+	}
+
+	if len(valNode.nodes) == 0 {
+		return Command{}, errors.New("empty command")
+	}
+
+	cmdStrs := make([]string, 0, len(valNode.nodes))
+
+	for _, node := range valNode.nodes {
+		if node.types != ValueNodeTypeBulkString {
+			return Command{}, fmt.Errorf("expected $ at the begining, got %s", node.types)
+		}
+
+		if node.null {
+			return Command{}, errors.New("null bulk string is not a valid command argument")
+		}
+
+		cmdStrs = append(cmdStrs, node.val)
+	}
+
+	return NewCommand(strings.ToLower(cmdStrs[0]), conn, cmdStrs[1:]...), nil
+}
+
 func (c *Command) Name() string {
 	return c.name
 }
 
+// Argv returns the command name followed by all the arguments.
+func (c *Command) Argv() []string {
+	argv := make([]string, 0, len(c.args)+1)
+	argv = append(argv, c.name)
+
+	return append(argv, c.args...)
+}
+
 func (c *Command) Key() string {
 	var val string
 
@@ -84,6 +285,148 @@ func (c *Command) Args() []string {
 	return val
 }
 
+// Call runs another command for the same client through the mux serving c,
+// so the middlewares of that command run as well.
+func (c *Command) Call(name string, args ...string) ValueNode {
+	if c.mux == nil {
+		return ValueNode{
+			types: ValueNodeTypeSimpleError,
+			val:   fmt.Sprintf("ERROR: \"%s\" can't be called outside of a command", name),
+		}
+	}
+
+	cmd := NewCommand(strings.ToLower(name), c.conn, args...)
+	cmd.state.nested = true
+
+	return c.mux.Serve(cmd)
+}
+
+func (c *Command) nested() bool {
+	return c.state != nil && c.state.nested
+}
+
+// Flags returns the flags of the command name, ok is false when the mux
+// serving c doesn't have it.
+func (c *Command) Flags(name string) (flags CommandFlag, ok bool) {
+	if c.mux == nil {
+		return 0, false
+	}
+
+	r, ok := c.mux.handlers[strings.ToLower(name)]
+
+	return r.flags, ok
+}
+
+// Propagate replaces the command written to the append only file with cmds.
+// Commands with a random effect use it, so replaying the file gives the
+// same result, no command is written when cmds is empty.
+func (c *Command) Propagate(cmds ...[]string) {
+	if c.state == nil {
+		return
+	}
+
+	c.state.propagated = true
+	c.state.propagation = cmds
+}
+
+// Propagation returns the commands set by Propagate, ok is false when the
+// command is written as it is.
+func (c *Command) Propagation() (cmds [][]string, ok bool) {
+	if c.state == nil {
+		return nil, false
+	}
+
+	return c.state.propagation, c.state.propagated
+}
+
+// Changed adds n to the keys modified by the command and runs the functions
+// given to OnChange. The storage calls it while the modified keys are still
+// locked.
+func (c *Command) Changed(n int64) {
+	if c.state == nil || n <= 0 {
+		return
+	}
+
+	c.state.changes += n
+
+	for _, fn := range c.state.changeHooks {
+		fn()
+	}
+}
+
+// Changes returns the number of keys modified by the command.
+func (c *Command) Changes() int64 {
+	if c.state == nil {
+		return 0
+	}
+
+	return c.state.changes
+}
+
+// OnChange registers fn to run whenever the command modifies the keyspace.
+// It runs with the modified keys locked, so the functions of the commands
+// modifying the same keys run in the order of the modifications.
+func (c *Command) OnChange(fn func()) {
+	if c.state == nil {
+		return
+	}
+
+	c.state.changeHooks = append(c.state.changeHooks, fn)
+}
+
+// OnSuspend registers the functions called when the command is suspended,
+// a middleware holding a resource while the command runs releases it in
+// release and takes it back in acquire.
+func (c *Command) OnSuspend(release, acquire func()) {
+	if c.state == nil {
+		return
+	}
+
+	c.state.hooks = append(c.state.hooks, suspendHook{
+		release: release,
+		acquire: acquire,
+	})
+}
+
+// CanBlock reports whether the command may wait, the commands run by a
+// transaction can't since the other clients are stalled until it ends.
+// Blocking commands return right away as if they timed out instead.
+func (c *Command) CanBlock() bool {
+	return !c.exclusive()
+}
+
+// Suspend runs wait with the resources of the middlewares released, the
+// blocking commands wait through it so they don't stall the other clients.
+func (c *Command) Suspend(wait func()) {
+	if c.state == nil {
+		wait()
+		return
+	}
+
+	hooks := c.state.hooks
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i].release()
+	}
+
+	defer func() {
+		for _, hook := range hooks {
+			hook.acquire()
+		}
+	}()
+
+	wait()
+}
+
+// Closed returns a channel that is closed when the client disconnects.
+func (c *Command) Closed() <-chan struct{} {
+	if c.conn == nil {
+		return nil
+	}
+
+	return c.conn.Closed()
+}
+
 func (c *Command) Proto() int {
 	return c.conn.proto
 }
@@ -91,3 +434,13 @@ func (c *Command) Proto() int {
 func (c *Command) SetProto(proto int) {
 	c.conn.proto = proto
 }
+
+// DB returns the index of the database selected by the client.
+func (c *Command) DB() int {
+	return c.conn.db
+}
+
+// SetDB selects the database of index for the next commands of the client.
+func (c *Command) SetDB(index int) {
+	c.conn.db = index
+}
@@ -1,15 +1,44 @@
 package resp
 
-import "net"
+import (
+	"net"
+	"sync"
+)
 
 type Connection struct {
 	net.Conn
 	proto int
+	// db is the index of the database selected by the client
+	db int
+	// tx holds the commands queued since MULTI, nil outside of MULTI
+	tx *transaction
+	// watches are the conditions set by WATCH, EXEC fails when one of
+	// them reports a modification
+	watches []func() (modified bool)
+	// exclusive is set while the client runs commands with the other
+	// clients stalled
+	exclusive bool
+	// closed is closed once the client can't send more requests
+	closed    chan struct{}
+	closeOnce sync.Once
 }
 
 func NewConnection(conn net.Conn) *Connection {
 	return &Connection{
-		Conn:  conn,
-		proto: 2, // default protocol
+		Conn:   conn,
+		proto:  2, // default protocol
+		closed: make(chan struct{}),
 	}
 }
+
+// Closed returns a channel that is closed when the client disconnects,
+// commands that wait use it to stop waiting for a gone client.
+func (c *Connection) Closed() <-chan struct{} {
+	return c.closed
+}
+
+func (c *Connection) markClosed() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+}
@@ -0,0 +1,187 @@
+package resp
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	errUnbalancedQuotes = errors.New("unbalanced quotes")
+)
+
+// ReadCommand decodes the next client request. Besides RESP arrays it also
+// accepts inline commands, a line of space separated arguments as typed in a
+// telnet or netcat session, e.g. "SET foo bar\r\n".
+func (r *Reader) ReadCommand() (ValueNode, error) {
+	for {
+		prefix, err := r.rd.Peek(1)
+		if err != nil {
+			return ValueNode{}, err
+		}
+
+		if ValueNodeType(prefix[0]) == ValueNodeTypeArray {
+			return r.ReadValue()
+		}
+
+		line, err := r.readLine()
+		if err != nil {
+			return ValueNode{}, err
+		}
+
+		args, err := splitArgs(string(line))
+		if err != nil {
+			return ValueNode{}, newProtocolError("unbalanced quotes in request")
+		}
+
+		// blank lines are ignored, clients send them as keep alive
+		if len(args) == 0 {
+			continue
+		}
+
+		node := ValueNode{
+			types: ValueNodeTypeArray,
+			nodes: make([]ValueNode, 0, len(args)),
+		}
+
+		for _, arg := range args {
+			node.nodes = append(node.nodes, ValueNode{
+				types: ValueNodeTypeBulkString,
+				val:   arg,
+			})
+		}
+
+		return node, nil
+	}
+}
+
+// splitArgs splits an inline command into arguments the same way redis does.
+// Arguments can be wrapped in double quotes, which support the \n \r \t \b \a
+// \\ \" and \xHH escapes, or in single quotes, which only support \'.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+
+		if i >= len(line) {
+			return args, nil
+		}
+
+		var (
+			current  strings.Builder
+			inDouble bool
+			inSingle bool
+			done     bool
+		)
+
+		for !done {
+			switch {
+			case inDouble:
+				if i >= len(line) {
+					return nil, errUnbalancedQuotes
+				}
+
+				c := line[i]
+
+				switch {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]):
+					current.WriteByte(hexValue(line[i+2])<<4 | hexValue(line[i+3]))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					current.WriteByte(unescape(line[i]))
+				case c == '"':
+					// the closing quote must be followed by a space or nothing
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				default:
+					current.WriteByte(c)
+				}
+			case inSingle:
+				if i >= len(line) {
+					return nil, errUnbalancedQuotes
+				}
+
+				c := line[i]
+
+				switch {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					current.WriteByte('\'')
+				case c == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				default:
+					current.WriteByte(c)
+				}
+			default:
+				if i >= len(line) {
+					done = true
+					break
+				}
+
+				c := line[i]
+
+				switch {
+				case isSpace(c):
+					done = true
+				case c == '"':
+					inDouble = true
+				case c == '\'':
+					inSingle = true
+				default:
+					current.WriteByte(c)
+				}
+			}
+
+			if i < len(line) {
+				i++
+			}
+		}
+
+		args = append(args, current.String())
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == 0
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	}
+
+	return c
+}
@@ -2,6 +2,7 @@ package resp
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 )
 
@@ -14,12 +15,26 @@ const (
 	ValueNodeTypeSimpleError  ValueNodeType = "-"
 	ValueNodeTypeIntegers     ValueNodeType = ":"
 	ValueNodeTypeMaps         ValueNodeType = "%"
+
+	// RESP3 only types
+	ValueNodeTypeNull           ValueNodeType = "_"
+	ValueNodeTypeBoolean        ValueNodeType = "#"
+	ValueNodeTypeDouble         ValueNodeType = ","
+	ValueNodeTypeBigNumber      ValueNodeType = "("
+	ValueNodeTypeVerbatimString ValueNodeType = "="
+	ValueNodeTypeSet            ValueNodeType = "~"
+	ValueNodeTypeAttribute      ValueNodeType = "|"
+	ValueNodeTypePush           ValueNodeType = ">"
+	ValueNodeTypeBulkError      ValueNodeType = "!"
 )
 
 type ValueNode struct {
 	types ValueNodeType
 	val   string
 	nodes []ValueNode
+	// null marks a nil bulk string or a nil array, they are written as
+	// $-1 and *-1 since RESP2 has no dedicated null type
+	null bool
 }
 
 func WithValue(val string) func(node *ValueNode) {
@@ -28,6 +43,13 @@ func WithValue(val string) func(node *ValueNode) {
 	}
 }
 
+// WithNull marks a bulk string or an array as nil.
+func WithNull() func(node *ValueNode) {
+	return func(node *ValueNode) {
+		node.null = true
+	}
+}
+
 func NewValueNode(types ValueNodeType, opts ...func(node *ValueNode)) ValueNode {
 	node := ValueNode{types: types}
 
@@ -38,10 +60,105 @@ func NewValueNode(types ValueNodeType, opts ...func(node *ValueNode)) ValueNode
 	return node
 }
 
+// NewNullValueNode creates a RESP3 null.
+func NewNullValueNode() ValueNode {
+	return ValueNode{types: ValueNodeTypeNull}
+}
+
+// NewBooleanValueNode creates a RESP3 boolean.
+func NewBooleanValueNode(val bool) ValueNode {
+	node := ValueNode{
+		types: ValueNodeTypeBoolean,
+		val:   "f",
+	}
+
+	if val {
+		node.val = "t"
+	}
+
+	return node
+}
+
+// NewDoubleValueNode creates a RESP3 double, infinities are sent as inf and -inf.
+func NewDoubleValueNode(val float64) ValueNode {
+	return ValueNode{
+		types: ValueNodeTypeDouble,
+		val:   FormatDouble(val),
+	}
+}
+
+// NewBigNumberValueNode creates a RESP3 big number, val must be a decimal
+// integer with an optional minus sign.
+func NewBigNumberValueNode(val string) ValueNode {
+	return ValueNode{
+		types: ValueNodeTypeBigNumber,
+		val:   val,
+	}
+}
+
+// NewVerbatimValueNode creates a RESP3 verbatim string, format is a three
+// bytes hint for the client, like "txt" or "mkd".
+func NewVerbatimValueNode(format, val string) ValueNode {
+	return ValueNode{
+		types: ValueNodeTypeVerbatimString,
+		val:   fmt.Sprintf("%.3s:%s", format, val),
+	}
+}
+
+// NewBulkErrorValueNode creates a RESP3 bulk error, it can carry binary
+// data and line breaks unlike the simple error.
+func NewBulkErrorValueNode(val string) ValueNode {
+	return ValueNode{
+		types: ValueNodeTypeBulkError,
+		val:   val,
+	}
+}
+
+// FormatDouble formats a float the way RESP3 doubles are written.
+func FormatDouble(val float64) string {
+	switch {
+	case math.IsInf(val, 1):
+		return "inf"
+	case math.IsInf(val, -1):
+		return "-inf"
+	case math.IsNaN(val):
+		return "nan"
+	}
+
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
+
 func (v *ValueNode) Value(val string) {
 	v.val = val
 }
 
+// Type returns the RESP type of the node.
+func (v *ValueNode) Type() ValueNodeType {
+	return v.types
+}
+
+// Text returns the value of a node that isn't an aggregate, like the
+// content of a bulk string or the digits of an integer.
+func (v *ValueNode) Text() string {
+	return v.val
+}
+
+// Nodes returns the elements of an aggregate node, the keys and the values
+// of a map are interleaved.
+func (v *ValueNode) Nodes() []ValueNode {
+	return v.nodes
+}
+
+// IsNull reports whether the node is a nil bulk string, a nil array or a RESP3 null.
+func (v *ValueNode) IsNull() bool {
+	return v.null || v.types == ValueNodeTypeNull
+}
+
+// IsError reports whether the value is a simple or a bulk error.
+func (v *ValueNode) IsError() bool {
+	return v.types == ValueNodeTypeSimpleError || v.types == ValueNodeTypeBulkError
+}
+
 func (v *ValueNode) Append(node ValueNode) {
 	v.nodes = append(v.nodes, node)
 }
@@ -52,7 +169,7 @@ func (v *ValueNode) Marshal() []byte {
 	switch v.types {
 	case ValueNodeTypeBulkString:
 		result = v.marshalBulkString()
-	case ValueNodeTypeArray:
+	case ValueNodeTypeArray, ValueNodeTypeSet, ValueNodeTypePush:
 		result = v.marshalArray()
 	case ValueNodeTypeSimpleString:
 		result = v.marshalSimpleString()
@@ -60,8 +177,14 @@ func (v *ValueNode) Marshal() []byte {
 		result = v.marshalIntegers()
 	case ValueNodeTypeSimpleError:
 		result = v.marshalSimpleError()
-	case ValueNodeTypeMaps:
+	case ValueNodeTypeMaps, ValueNodeTypeAttribute:
 		result = v.marshalMaps()
+	case ValueNodeTypeNull:
+		result = v.marshalNull()
+	case ValueNodeTypeBoolean, ValueNodeTypeDouble, ValueNodeTypeBigNumber:
+		result = v.marshalSimpleString()
+	case ValueNodeTypeVerbatimString, ValueNodeTypeBulkError:
+		result = v.marshalBlob()
 	}
 
 	return result
@@ -97,9 +220,8 @@ func (v *ValueNode) marshalBulkString() []byte {
 
 	result = append(result, []byte(v.types)...)
 
-	// -1 indicates the value is nil
-	if v.val == "-1" {
-		result = append(result, []byte(v.val)...)
+	if v.null {
+		result = append(result, []byte("-1")...)
 	} else {
 		result = append(result, []byte(fmt.Sprintf("%d\r\n%s", len(v.val), v.val))...)
 	}
@@ -112,6 +234,11 @@ func (v *ValueNode) marshalBulkString() []byte {
 func (v *ValueNode) marshalArray() []byte {
 	result := []byte{}
 
+	if v.null {
+		result = append(result, []byte(fmt.Sprintf("%s-1\r\n", v.types))...)
+		return result
+	}
+
 	result = append(result, []byte(fmt.Sprintf("%s%d\r\n", v.types, len(v.nodes)))...)
 
 	for _, node := range v.nodes {
@@ -132,3 +259,13 @@ func (v *ValueNode) marshalMaps() []byte {
 
 	return result
 }
+
+func (v *ValueNode) marshalNull() []byte {
+	return []byte(fmt.Sprintf("%s\r\n", v.types))
+}
+
+// marshalBlob writes length prefixed types which has no nil value,
+// like the verbatim string and bulk error.
+func (v *ValueNode) marshalBlob() []byte {
+	return []byte(fmt.Sprintf("%s%d\r\n%s\r\n", v.types, len(v.val), v.val))
+}
@@ -1,98 +1,263 @@
 package resp
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
 	"io"
-	"log"
-	"strconv"
-	"strings"
+	"math"
 )
 
-func parse(sr io.Reader) ValueNode {
-	prefix := make([]byte, 1)
-	_, err := sr.Read(prefix)
+const (
+	readerBufferSize = 64 * 1024
+
+	// MaxBulkLength is the biggest bulk string accepted from a client (512MB).
+	MaxBulkLength int64 = 512 * 1024 * 1024
+
+	// MaxArrayLength is the biggest number of elements accepted in an array.
+	MaxArrayLength int64 = math.MaxInt32
+
+	// the number of array elements allocated up front, the slice grows
+	// from there so a bogus header can't allocate a huge array
+	arrayPreallocLimit int64 = 1024
+)
+
+// ProtocolError reports input that doesn't follow the RESP specification.
+// The stream can't be resynchronized after it, so the connection should be closed.
+type ProtocolError struct {
+	msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("protocol error: %s", e.msg)
+}
+
+func newProtocolError(format string, args ...any) *ProtocolError {
+	return &ProtocolError{
+		msg: fmt.Sprintf(format, args...),
+	}
+}
+
+var (
+	errInvalidLength = errors.New("invalid length")
+)
+
+// Reader decodes RESP values from a stream. Frames that are split across
+// several network reads are kept in the buffer until they are complete,
+// so every call returns exactly one value.
+type Reader struct {
+	rd *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		rd: bufio.NewReaderSize(r, readerBufferSize),
+	}
+}
+
+// Buffered returns the number of bytes already received but not decoded yet.
+func (r *Reader) Buffered() int {
+	return r.rd.Buffered()
+}
+
+func (r *Reader) ReadValue() (ValueNode, error) {
+	prefix, err := r.rd.ReadByte()
 	if err != nil {
-		return ValueNode{}
+		return ValueNode{}, err
 	}
 
 	switch ValueNodeType(prefix) {
-	case ValueNodeTypeBulkString:
-		return parseBulkString(sr)
 	case ValueNodeTypeArray:
-		return parseArray(sr)
-	case "\r\n":
-		return parse(sr)
-	case "\r":
-		return parse(sr)
-	case "\n":
-		return parse(sr)
-	default:
-		log.Print("unknown command prefix: ", string(prefix))
-	}
-
-	return ValueNode{}
+		return r.readArray()
+	case ValueNodeTypeBulkString:
+		return r.readBulkString()
+	case ValueNodeTypeSimpleString, ValueNodeTypeSimpleError:
+		return r.readSimple(ValueNodeType(prefix))
+	case ValueNodeTypeIntegers:
+		return r.readInteger()
+	case "\r", "\n":
+		return r.ReadValue()
+	}
+
+	return ValueNode{}, newProtocolError("unexpected type prefix %q", prefix)
 }
 
-func parseArray(sr io.Reader) ValueNode {
-	length := parseInteger(sr)
+// readLine reads until the next line feed and strips the line terminator.
+// The returned slice is only valid until the next read.
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.rd.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, newProtocolError("line is longer than %d bytes", readerBufferSize)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	node := ValueNode{
-		types: ValueNodeTypeArray,
-		nodes: make([]ValueNode, length),
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
 	}
 
-	for i := int64(0); i < length; i++ {
-		node.nodes[i] = parse(sr)
+	return line, nil
+}
+
+// parseLength decodes the length of an array or bulk string header,
+// it only accepts an optional minus sign followed by decimal digits.
+func parseLength(line []byte) (int64, error) {
+	digits := line
+	negative := false
+
+	if len(digits) > 0 && digits[0] == '-' {
+		negative = true
+		digits = digits[1:]
+	}
+
+	if len(digits) == 0 || len(digits) > 19 {
+		return 0, errInvalidLength
+	}
+
+	// leading zeros are not a valid length, except zero itself
+	if digits[0] == '0' && (len(digits) > 1 || negative) {
+		return 0, errInvalidLength
 	}
 
-	return node
+	var length int64
+
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, errInvalidLength
+		}
+
+		digit := int64(c - '0')
+		if length > (math.MaxInt64-digit)/10 {
+			return 0, errInvalidLength
+		}
+
+		length = length*10 + digit
+	}
+
+	if negative {
+		length = -length
+	}
+
+	return length, nil
 }
 
-func parseInteger(sr io.Reader) int64 {
-	// read 3 byte, include \r\n
-	length := make([]byte, 3)
-	_, err := sr.Read(length)
+func (r *Reader) readLength(limit int64) (length int64, null bool, err error) {
+	line, err := r.readLine()
+	if err != nil {
+		return 0, false, err
+	}
+
+	length, err = parseLength(line)
 	if err != nil {
-		log.Println(err)
-		return 0
+		return 0, false, err
 	}
 
-	trimLength := strings.Trim(string(length), "\r\n")
-	trimLength = strings.Trim(trimLength, "\r")
-	trimLength = strings.Trim(trimLength, "\n")
+	// -1 indicates the value is nil, any other negative length is invalid
+	if length == -1 {
+		return 0, true, nil
+	}
+
+	if length < 0 || length > limit {
+		return 0, false, errInvalidLength
+	}
+
+	return length, false, nil
+}
 
-	ln, err := strconv.ParseInt(trimLength, 10, 64)
+func (r *Reader) readInteger() (ValueNode, error) {
+	line, err := r.readLine()
 	if err != nil {
-		log.Println("err while parse int: ", err)
-		return 0
+		return ValueNode{}, err
 	}
 
-	return ln
+	_, err = parseLength(line)
+	if err != nil {
+		return ValueNode{}, newProtocolError("invalid integer %q", line)
+	}
+
+	return ValueNode{
+		types: ValueNodeTypeIntegers,
+		val:   string(line),
+	}, nil
 }
 
-func parseBulkString(sr io.Reader) ValueNode {
-	length := parseInteger(sr)
-	buf := bufCreate(length)
+func (r *Reader) readSimple(types ValueNodeType) (ValueNode, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return ValueNode{}, err
+	}
+
+	return ValueNode{
+		types: types,
+		val:   string(line),
+	}, nil
+}
+
+func (r *Reader) readArray() (ValueNode, error) {
+	length, null, err := r.readLength(MaxArrayLength)
+	if err == errInvalidLength {
+		return ValueNode{}, newProtocolError("invalid multibulk length")
+	}
+	if err != nil {
+		return ValueNode{}, err
+	}
 
 	node := ValueNode{
-		types: ValueNodeTypeBulkString,
+		types: ValueNodeTypeArray,
+		null:  null,
 	}
 
-	_, err := sr.Read(buf)
-	if err != nil {
-		log.Println(err)
-		return ValueNode{}
+	prealloc := length
+	if prealloc > arrayPreallocLimit {
+		prealloc = arrayPreallocLimit
 	}
 
-	str := strings.Trim(string(buf), "\r\n")
-	str = strings.Trim(str, "\r")
-	str = strings.Trim(str, "\n")
+	node.nodes = make([]ValueNode, 0, prealloc)
+
+	for i := int64(0); i < length; i++ {
+		child, err := r.ReadValue()
+		if err != nil {
+			return ValueNode{}, err
+		}
 
-	node.val = str
+		node.nodes = append(node.nodes, child)
+	}
 
-	return node
+	return node, nil
 }
 
-func bufCreate(length int64) []byte {
+func (r *Reader) readBulkString() (ValueNode, error) {
+	length, null, err := r.readLength(MaxBulkLength)
+	if err == errInvalidLength {
+		return ValueNode{}, newProtocolError("invalid bulk length")
+	}
+	if err != nil {
+		return ValueNode{}, err
+	}
+
+	if null {
+		return ValueNode{
+			types: ValueNodeTypeBulkString,
+			null:  true,
+		}, nil
+	}
+
 	// +2 include \r\n
-	return make([]byte, length+2)
+	buf := make([]byte, length+2)
+
+	_, err = io.ReadFull(r.rd, buf)
+	if err != nil {
+		return ValueNode{}, err
+	}
+
+	if buf[length] != '\r' || buf[length+1] != '\n' {
+		return ValueNode{}, newProtocolError("bulk string is not terminated by CRLF")
+	}
+
+	return ValueNode{
+		types: ValueNodeTypeBulkString,
+		val:   string(buf[:length]),
+	}, nil
 }
@@ -0,0 +1,379 @@
+package resp
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func bulk(val string) ValueNode {
+	return ValueNode{
+		types: ValueNodeTypeBulkString,
+		val:   val,
+	}
+}
+
+func array(nodes ...ValueNode) ValueNode {
+	return ValueNode{
+		types: ValueNodeTypeArray,
+		nodes: nodes,
+	}
+}
+
+func equalNodes(a, b ValueNode) bool {
+	if a.types != b.types || a.val != b.val || a.null != b.null || len(a.nodes) != len(b.nodes) {
+		return false
+	}
+
+	for i := range a.nodes {
+		if !equalNodes(a.nodes[i], b.nodes[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// readers returns the ways input is read, at once or one byte at a time
+// like a frame split across many network reads.
+func readers(input string) map[string]*Reader {
+	return map[string]*Reader{
+		"whole": NewReader(strings.NewReader(input)),
+		"split": NewReader(iotest.OneByteReader(strings.NewReader(input))),
+	}
+}
+
+func TestReadValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  ValueNode
+		// err is the error expected from the read, protocol errors are
+		// matched by type
+		err      error
+		protocol bool
+	}{
+		{
+			name:  "bulk string",
+			input: "$3\r\nfoo\r\n",
+			want:  bulk("foo"),
+		},
+		{
+			name:  "multi digit length",
+			input: "$12\r\nhello world!\r\n",
+			want:  bulk("hello world!"),
+		},
+		{
+			name:  "empty bulk string",
+			input: "$0\r\n\r\n",
+			want:  bulk(""),
+		},
+		{
+			name:  "binary bulk string",
+			input: "$4\r\na\r\nb\r\n",
+			want:  bulk("a\r\nb"),
+		},
+		{
+			name:  "null bulk string",
+			input: "$-1\r\n",
+			want:  ValueNode{types: ValueNodeTypeBulkString, null: true},
+		},
+		{
+			name:  "array",
+			input: "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n",
+			want:  array(bulk("GET"), bulk("foo")),
+		},
+		{
+			name:  "nested array",
+			input: "*2\r\n*1\r\n:1\r\n+OK\r\n",
+			want: array(
+				array(ValueNode{types: ValueNodeTypeIntegers, val: "1"}),
+				ValueNode{types: ValueNodeTypeSimpleString, val: "OK"},
+			),
+		},
+		{
+			name:  "empty array",
+			input: "*0\r\n",
+			want:  array(),
+		},
+		{
+			name:  "null array",
+			input: "*-1\r\n",
+			want:  ValueNode{types: ValueNodeTypeArray, null: true},
+		},
+		{
+			name:  "integer",
+			input: ":-42\r\n",
+			want:  ValueNode{types: ValueNodeTypeIntegers, val: "-42"},
+		},
+		{
+			name:  "simple error",
+			input: "-ERR bad\r\n",
+			want:  ValueNode{types: ValueNodeTypeSimpleError, val: "ERR bad"},
+		},
+		{
+			name:     "leading zero length",
+			input:    "$03\r\nfoo\r\n",
+			protocol: true,
+		},
+		{
+			name:     "negative zero length",
+			input:    "*-0\r\n",
+			protocol: true,
+		},
+		{
+			name:     "negative length",
+			input:    "$-2\r\n",
+			protocol: true,
+		},
+		{
+			name:     "not a number length",
+			input:    "*1a\r\n",
+			protocol: true,
+		},
+		{
+			name:     "empty length",
+			input:    "$\r\n",
+			protocol: true,
+		},
+		{
+			// doesn't fit in an int64
+			name:     "20 digit length",
+			input:    "$12345678901234567890\r\n",
+			protocol: true,
+		},
+		{
+			name:     "bulk length over the limit",
+			input:    "$9223372036854775807\r\n",
+			protocol: true,
+		},
+		{
+			name:     "array length over the limit",
+			input:    "*9223372036854775807\r\n",
+			protocol: true,
+		},
+		{
+			name:  "unterminated bulk string",
+			input: "$5\r\nfoo",
+			err:   io.ErrUnexpectedEOF,
+		},
+		{
+			name:     "bulk string without CRLF",
+			input:    "$3\r\nfooXY",
+			protocol: true,
+		},
+		{
+			name:     "unknown type",
+			input:    "?3\r\n",
+			protocol: true,
+		},
+		{
+			name:     "invalid integer",
+			input:    ":4x\r\n",
+			protocol: true,
+		},
+	}
+
+	for _, tt := range tests {
+		for mode, reader := range readers(tt.input) {
+			t.Run(tt.name+"/"+mode, func(t *testing.T) {
+				got, err := reader.ReadValue()
+
+				var protoErr *ProtocolError
+				switch {
+				case tt.protocol:
+					if !errors.As(err, &protoErr) {
+						t.Fatalf("ReadValue() error = %v, want a protocol error", err)
+					}
+				case tt.err != nil:
+					if !errors.Is(err, tt.err) {
+						t.Fatalf("ReadValue() error = %v, want %v", err, tt.err)
+					}
+				case err != nil:
+					t.Fatalf("ReadValue() error = %v", err)
+				case !equalNodes(got, tt.want):
+					t.Errorf("ReadValue() = %+v, want %+v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestReadCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []ValueNode
+	}{
+		{
+			name:  "pipelined arrays",
+			input: "*1\r\n$4\r\nPING\r\n*2\r\n$4\r\nECHO\r\n$2\r\nhi\r\n",
+			want: []ValueNode{
+				array(bulk("PING")),
+				array(bulk("ECHO"), bulk("hi")),
+			},
+		},
+		{
+			name:  "inline",
+			input: "SET foo \"bar baz\"\r\n",
+			want: []ValueNode{
+				array(bulk("SET"), bulk("foo"), bulk("bar baz")),
+			},
+		},
+		{
+			name:  "inline without CR",
+			input: "PING\n",
+			want: []ValueNode{
+				array(bulk("PING")),
+			},
+		},
+		{
+			name:  "blank lines",
+			input: "\r\n   \r\nPING\r\n",
+			want: []ValueNode{
+				array(bulk("PING")),
+			},
+		},
+		{
+			name:  "inline and arrays",
+			input: "PING\r\n*1\r\n$4\r\nPING\r\nECHO a\r\n",
+			want: []ValueNode{
+				array(bulk("PING")),
+				array(bulk("PING")),
+				array(bulk("ECHO"), bulk("a")),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for mode, reader := range readers(tt.input) {
+			t.Run(tt.name+"/"+mode, func(t *testing.T) {
+				for _, want := range tt.want {
+					got, err := reader.ReadCommand()
+					if err != nil {
+						t.Fatalf("ReadCommand() error = %v", err)
+					}
+
+					if !equalNodes(got, want) {
+						t.Errorf("ReadCommand() = %+v, want %+v", got, want)
+					}
+				}
+
+				if _, err := reader.ReadCommand(); err != io.EOF {
+					t.Errorf("ReadCommand() error = %v after the last command, want EOF", err)
+				}
+			})
+		}
+	}
+}
+
+func TestReadCommandUnbalancedQuotes(t *testing.T) {
+	reader := NewReader(strings.NewReader("SET foo \"bar\r\n"))
+
+	var protoErr *ProtocolError
+
+	_, err := reader.ReadCommand()
+	if !errors.As(err, &protoErr) {
+		t.Errorf("ReadCommand() error = %v, want a protocol error", err)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want []string
+		err  error
+	}{
+		{
+			name: "empty",
+			line: "",
+		},
+		{
+			name: "spaces only",
+			line: " \t ",
+		},
+		{
+			name: "plain",
+			line: "SET foo bar",
+			want: []string{"SET", "foo", "bar"},
+		},
+		{
+			name: "extra spaces",
+			line: "  SET \t foo   bar  ",
+			want: []string{"SET", "foo", "bar"},
+		},
+		{
+			name: "double quotes",
+			line: `SET "foo bar" ""`,
+			want: []string{"SET", "foo bar", ""},
+		},
+		{
+			name: "double quote escapes",
+			line: `"a\nb\r\t\b\a\\\"c"`,
+			want: []string{"a\nb\r\t\b\a\\\"c"},
+		},
+		{
+			name: "hex escapes",
+			line: `"\x41\x7a\x00"`,
+			want: []string{"Az\x00"},
+		},
+		{
+			name: "incomplete hex escape",
+			line: `"\x4"`,
+			want: []string{"x4"},
+		},
+		{
+			name: "single quotes",
+			line: `'it\'s' 'a\nb'`,
+			want: []string{"it's", `a\nb`},
+		},
+		{
+			name: "quote inside an argument",
+			line: `foo"bar baz"`,
+			want: []string{"foobar baz"},
+		},
+		{
+			name: "unterminated double quotes",
+			line: `SET "foo`,
+			err:  errUnbalancedQuotes,
+		},
+		{
+			name: "unterminated single quotes",
+			line: `SET 'foo`,
+			err:  errUnbalancedQuotes,
+		},
+		{
+			name: "text after the closing double quote",
+			line: `"foo"bar`,
+			err:  errUnbalancedQuotes,
+		},
+		{
+			name: "text after the closing single quote",
+			line: `'foo'bar`,
+			err:  errUnbalancedQuotes,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitArgs(tt.line)
+			if err != tt.err {
+				t.Fatalf("splitArgs(%q) error = %v, want %v", tt.line, err, tt.err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("splitArgs(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("splitArgs(%q) = %q, want %q", tt.line, got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
@@ -1,13 +1,14 @@
 package resp
 
 import (
-	"bytes"
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
 )
 
@@ -68,7 +69,8 @@ loop:
 			case <-s.quit:
 				break loop
 			default:
-				log.Printf("[server] failed to accept connection from %s: %s\n", conn.RemoteAddr(), err)
+				log.Printf("[server] failed to accept connection: %s\n", err)
+				continue
 			}
 		}
 
@@ -80,66 +82,108 @@ loop:
 	return nil
 }
 
-func (s *Server) handle(conn *Connection) {
-	buf := make([]byte, 4096)
-	for {
-		n, err := conn.Read(buf)
-		if err != nil {
-			continue
-		}
+const (
+	// the number of parsed requests queued while a command runs
+	pipelineSize = 64
+)
 
-		readBuf := buf[:n]
-		splitString := strings.Split(string(readBuf), "*")
+type request struct {
+	val ValueNode
+	err error
+}
 
-		var valNodes []ValueNode
+func (s *Server) handle(conn *Connection) {
+	defer conn.Close()
 
-		for i := 1; i < len(splitString); i++ {
-			s := fmt.Sprintf("*%s", splitString[i])
-			sr := strings.NewReader(s)
-			valNode := parse(sr)
-			valNodes = append(valNodes, valNode)
-		}
+	requests := make(chan request, pipelineSize)
+	done := make(chan struct{})
+	defer close(done)
 
-		var responses []ValueNode
+	// requests are read by another goroutine so a client that goes away is
+	// noticed even while one of its commands is blocked
+	go s.read(conn, requests, done)
 
-	outer:
-		for _, valNode := range valNodes {
-			if valNode.types != ValueNodeTypeArray {
-				responses = append(responses, ValueNode{
+	writer := bufio.NewWriter(conn)
+
+	for req := range requests {
+		if req.err != nil {
+			var protoErr *ProtocolError
+			if errors.As(req.err, &protoErr) {
+				// the rest of the stream can't be trusted, reply and close the connection
+				reply := ValueNode{
 					types: ValueNodeTypeSimpleError,
-					val:   fmt.Sprintf("ERROR: expected * at the begining, got %s", valNode.types),
-				})
-				break
+					val:   fmt.Sprintf("ERROR: %s", protoErr.Error()),
+				}
+				writer.Write(reply.Marshal())
+			} else if req.err != io.EOF {
+				log.Printf("[server] failed to read request from %s: %s\n", conn.RemoteAddr(), req.err)
 			}
 
-			cmdStrs := []string{}
+			writer.Flush()
+			return
+		}
 
-			for _, node := range valNode.nodes {
-				if node.types != ValueNodeTypeBulkString {
-					responses = append(responses, ValueNode{
-						types: ValueNodeTypeSimpleError,
-						val:   fmt.Sprintf("ERROR: expected $ at the begining, got %s\r\n", node.types),
-					})
-					break outer
-				}
+		response := s.serve(conn, req.val)
 
-				cmdStrs = append(cmdStrs, node.val)
-			}
+		_, err := writer.Write(response.Marshal())
+		if err != nil {
+			return
+		}
+
+		// pipelined commands that were already read are answered in one
+		// write, the replies are flushed once no request is waiting
+		if len(requests) > 0 {
+			continue
+		}
 
-			cmd := NewCommand(strings.ToLower(cmdStrs[0]), conn, cmdStrs[1:]...)
+		err = writer.Flush()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// read parses the requests of conn until it fails, the error is sent as the
+// last request and conn is marked as closed.
+func (s *Server) read(conn *Connection, requests chan<- request, done <-chan struct{}) {
+	defer close(requests)
+
+	reader := NewReader(conn)
+
+	for {
+		valNode, err := reader.ReadCommand()
+		if err != nil {
+			conn.markClosed()
 
-			response := s.handler.Serve(cmd)
+			select {
+			case requests <- request{err: err}:
+			case <-done:
+			}
 
-			responses = append(responses, response)
+			return
 		}
 
-		response := bytes.Buffer{}
-		for _, r := range responses {
-			response.Write(r.Marshal())
+		// an empty or null array is not a command, skip it without replying
+		if valNode.types == ValueNodeTypeArray && len(valNode.nodes) == 0 {
+			continue
 		}
 
-		conn.Write(response.Bytes())
+		select {
+		case requests <- request{val: valNode}:
+		case <-done:
+			return
+		}
+	}
+}
 
+func (s *Server) serve(conn *Connection, valNode ValueNode) ValueNode {
+	cmd, err := CommandFromValue(conn, valNode)
+	if err != nil {
+		return ValueNode{
+			types: ValueNodeTypeSimpleError,
+			val:   fmt.Sprintf("ERROR: %s", err),
+		}
 	}
 
+	return s.handler.Serve(cmd)
 }
@@ -0,0 +1,132 @@
+package resp
+
+import (
+	"errors"
+)
+
+var (
+	ErrNestedMulti      = errors.New("MULTI calls can not be nested")
+	ErrExecWithoutMulti = errors.New("EXEC without MULTI")
+	ErrExecAbort        = errors.New("EXECABORT Transaction discarded because of previous errors.")
+	ErrBusy             = errors.New("BUSY the server is busy running a script. You can only call SCRIPT KILL.")
+)
+
+// transaction is started by MULTI, the commands sent afterwards are
+// queued and run at once by EXEC.
+type transaction struct {
+	queued []Command
+	// aborted is set when a command couldn't be queued, EXEC discards
+	// the transaction then
+	aborted bool
+}
+
+// queueable reports whether the command is queued instead of running.
+func (c *Command) queueable() bool {
+	return c.conn != nil && c.conn.tx != nil && !c.nested() && !c.exclusive()
+}
+
+func (c *Command) exclusive() bool {
+	return c.conn != nil && c.conn.exclusive
+}
+
+// Multi starts a transaction, the next commands of the client are queued
+// until Exec or Discard.
+func (c *Command) Multi() error {
+	if c.conn.tx != nil {
+		return ErrNestedMulti
+	}
+
+	c.conn.tx = &transaction{}
+
+	return nil
+}
+
+// InMulti reports whether the client started a transaction.
+func (c *Command) InMulti() bool {
+	return c.conn.tx != nil
+}
+
+// Discard drops the queued commands and the watches of the client, it
+// reports whether a transaction was started.
+func (c *Command) Discard() bool {
+	started := c.conn.tx != nil
+
+	c.conn.tx = nil
+	c.Unwatch()
+
+	return started
+}
+
+// Watch adds a condition to the next transaction of the client, modified
+// is called by Exec and the transaction fails when it returns true.
+func (c *Command) Watch(modified func() bool) {
+	c.conn.watches = append(c.conn.watches, modified)
+}
+
+// Unwatch removes the conditions set by Watch.
+func (c *Command) Unwatch() {
+	c.conn.watches = nil
+}
+
+// Exec runs the queued commands one after the other, no command of the
+// other clients runs in between. The replies are nil when a watch
+// reported a modification, the commands don't run then.
+func (c *Command) Exec() ([]ValueNode, error) {
+	tx := c.conn.tx
+	if tx == nil {
+		return nil, ErrExecWithoutMulti
+	}
+
+	watches := c.conn.watches
+	c.Discard()
+
+	if tx.aborted {
+		return nil, ErrExecAbort
+	}
+
+	var replies []ValueNode
+
+	c.Exclusive(func() {
+		for _, modified := range watches {
+			if modified() {
+				return
+			}
+		}
+
+		replies = make([]ValueNode, 0, len(tx.queued))
+
+		for _, cmd := range tx.queued {
+			replies = append(replies, c.mux.Serve(cmd))
+		}
+	})
+
+	return replies, nil
+}
+
+// Exclusive runs fn while no command of the other clients runs, the
+// commands served for the client meanwhile can't block. A suspended
+// command of another client resumes once fn returns. It can be nested,
+// like for a script run by EXEC.
+func (c *Command) Exclusive(fn func()) {
+	if c.exclusive() {
+		fn()
+		return
+	}
+
+	c.mux.exclusive.Lock()
+	defer c.mux.exclusive.Unlock()
+
+	c.conn.exclusive = true
+	defer func() {
+		c.conn.exclusive = false
+	}()
+
+	fn()
+}
+
+// SetBusy reports that the exclusive command of the client runs for too
+// long, the other clients get ErrBusy until it's reset. Only the commands
+// with FlagAllowBusy run meanwhile.
+func (c *Command) SetBusy(busy bool) {
+	c.mux.busy.Store(busy)
+}
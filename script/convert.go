@@ -0,0 +1,243 @@
+package script
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/raspiantoro/temporama/resp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	// maxReplyDepth stops the conversion of tables referencing themselves
+	maxReplyDepth = 1000
+)
+
+func stringsTable(L *lua.LState, vals []string) *lua.LTable {
+	tbl := L.CreateTable(len(vals), 0)
+	for _, val := range vals {
+		tbl.Append(lua.LString(val))
+	}
+
+	return tbl
+}
+
+// singleField returns a table with name as its only field, it's how the
+// replies without a Lua type are represented, like {ok = "OK"}.
+func singleField(L *lua.LState, name string, val lua.LValue) *lua.LTable {
+	tbl := L.CreateTable(0, 1)
+	tbl.RawSetString(name, val)
+
+	return tbl
+}
+
+// toLua converts the reply of a command called by a script to a Lua value
+// following the conversion rules of Redis: integers become numbers, bulk
+// strings become strings, arrays become tables, status and error replies
+// become tables with an ok or an err field and the RESP2 nulls become
+// false. The RESP3 types are only received after redis.setresp(3).
+func toLua(L *lua.LState, node resp.ValueNode) lua.LValue {
+	switch node.Type() {
+	case resp.ValueNodeTypeSimpleString:
+		return singleField(L, "ok", lua.LString(node.Text()))
+	case resp.ValueNodeTypeSimpleError, resp.ValueNodeTypeBulkError:
+		return singleField(L, "err", lua.LString(node.Text()))
+	case resp.ValueNodeTypeIntegers:
+		n, _ := strconv.ParseInt(node.Text(), 10, 64)
+		return lua.LNumber(n)
+	case resp.ValueNodeTypeBulkString:
+		if node.IsNull() {
+			return lua.LFalse
+		}
+
+		return lua.LString(node.Text())
+	case resp.ValueNodeTypeArray, resp.ValueNodeTypePush:
+		if node.IsNull() {
+			return lua.LFalse
+		}
+
+		nodes := node.Nodes()
+
+		tbl := L.CreateTable(len(nodes), 0)
+		for _, n := range nodes {
+			tbl.Append(toLua(L, n))
+		}
+
+		return tbl
+	case resp.ValueNodeTypeSet:
+		nodes := node.Nodes()
+
+		set := L.CreateTable(0, len(nodes))
+		for _, n := range nodes {
+			set.RawSet(toLua(L, n), lua.LTrue)
+		}
+
+		return singleField(L, "set", set)
+	case resp.ValueNodeTypeMaps:
+		nodes := node.Nodes()
+
+		m := L.CreateTable(0, len(nodes)/2)
+		for i := 0; i+1 < len(nodes); i += 2 {
+			m.RawSet(toLua(L, nodes[i]), toLua(L, nodes[i+1]))
+		}
+
+		return singleField(L, "map", m)
+	case resp.ValueNodeTypeBoolean:
+		return lua.LBool(node.Text() == "t")
+	case resp.ValueNodeTypeDouble:
+		f, _ := strconv.ParseFloat(node.Text(), 64)
+		return singleField(L, "double", lua.LNumber(f))
+	case resp.ValueNodeTypeBigNumber:
+		return singleField(L, "big_number", lua.LString(node.Text()))
+	case resp.ValueNodeTypeVerbatimString:
+		format, text, _ := strings.Cut(node.Text(), ":")
+
+		verbatim := L.CreateTable(0, 2)
+		verbatim.RawSetString("format", lua.LString(format))
+		verbatim.RawSetString("string", lua.LString(text))
+
+		return singleField(L, "verbatim_string", verbatim)
+	}
+
+	return lua.LNil
+}
+
+// toReply converts the value returned by a script to a reply for a client
+// using proto. Numbers are truncated to integers, tables are arrays ending
+// at their first nil unless they have one of the fields set by toLua.
+func toReply(val lua.LValue, proto int, depth int) resp.ValueNode {
+	if depth > maxReplyDepth {
+		return resp.NewValueNode(
+			resp.ValueNodeTypeSimpleError,
+			resp.WithValue("ERROR: reached lua stack limit"),
+		)
+	}
+
+	switch v := val.(type) {
+	case lua.LString:
+		return resp.NewValueNode(resp.ValueNodeTypeBulkString, resp.WithValue(string(v)))
+	case lua.LNumber:
+		return integer(int64(v))
+	case lua.LBool:
+		if proto == 3 {
+			return resp.NewBooleanValueNode(bool(v))
+		}
+
+		if v {
+			return integer(1)
+		}
+
+		return null(proto)
+	case *lua.LTable:
+		return tableReply(v, proto, depth)
+	}
+
+	return null(proto)
+}
+
+func tableReply(tbl *lua.LTable, proto int, depth int) resp.ValueNode {
+	if msg, ok := errorField(tbl); ok {
+		return resp.NewValueNode(
+			resp.ValueNodeTypeSimpleError,
+			resp.WithValue(oneLine(msg)),
+		)
+	}
+
+	if ok, isString := tbl.RawGetString("ok").(lua.LString); isString {
+		return resp.NewValueNode(resp.ValueNodeTypeSimpleString, resp.WithValue(string(ok)))
+	}
+
+	if f, isNumber := tbl.RawGetString("double").(lua.LNumber); isNumber {
+		if proto == 3 {
+			return resp.NewDoubleValueNode(float64(f))
+		}
+
+		return resp.NewValueNode(resp.ValueNodeTypeBulkString, resp.WithValue(resp.FormatDouble(float64(f))))
+	}
+
+	if n, isString := tbl.RawGetString("big_number").(lua.LString); isString {
+		if proto == 3 {
+			return resp.NewBigNumberValueNode(string(n))
+		}
+
+		return resp.NewValueNode(resp.ValueNodeTypeBulkString, resp.WithValue(string(n)))
+	}
+
+	if verbatim, isTable := tbl.RawGetString("verbatim_string").(*lua.LTable); isTable {
+		format := lua.LVAsString(verbatim.RawGetString("format"))
+		text := lua.LVAsString(verbatim.RawGetString("string"))
+
+		if proto == 3 {
+			return resp.NewVerbatimValueNode(format, text)
+		}
+
+		return resp.NewValueNode(resp.ValueNodeTypeBulkString, resp.WithValue(text))
+	}
+
+	// RESP2 clients get the maps flattened and the sets as arrays
+	if m, isTable := tbl.RawGetString("map").(*lua.LTable); isTable {
+		response := resp.NewValueNode(resp.ValueNodeTypeArray)
+		if proto == 3 {
+			response = resp.NewValueNode(resp.ValueNodeTypeMaps)
+		}
+
+		m.ForEach(func(key, val lua.LValue) {
+			response.Append(toReply(key, proto, depth+1))
+			response.Append(toReply(val, proto, depth+1))
+		})
+
+		return response
+	}
+
+	if set, isTable := tbl.RawGetString("set").(*lua.LTable); isTable {
+		response := resp.NewValueNode(resp.ValueNodeTypeArray)
+		if proto == 3 {
+			response = resp.NewValueNode(resp.ValueNodeTypeSet)
+		}
+
+		set.ForEach(func(member, _ lua.LValue) {
+			response.Append(toReply(member, proto, depth+1))
+		})
+
+		return response
+	}
+
+	response := resp.NewValueNode(resp.ValueNodeTypeArray)
+
+	for i := 1; i < math.MaxInt32; i++ {
+		elem := tbl.RawGetInt(i)
+		if elem == lua.LNil {
+			break
+		}
+
+		response.Append(toReply(elem, proto, depth+1))
+	}
+
+	return response
+}
+
+// errorField returns the message of an error reply, a table with an err
+// field holding a string.
+func errorField(val lua.LValue) (string, bool) {
+	tbl, ok := val.(*lua.LTable)
+	if !ok {
+		return "", false
+	}
+
+	msg, ok := tbl.RawGetString("err").(lua.LString)
+
+	return string(msg), ok
+}
+
+func integer(n int64) resp.ValueNode {
+	return resp.NewValueNode(resp.ValueNodeTypeIntegers, resp.WithValue(strconv.FormatInt(n, 10)))
+}
+
+func null(proto int) resp.ValueNode {
+	if proto == 3 {
+		return resp.NewNullValueNode()
+	}
+
+	return resp.NewValueNode(resp.ValueNodeTypeBulkString, resp.WithNull())
+}
@@ -0,0 +1,265 @@
+package script
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/raspiantoro/temporama/resp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	logDebug = iota
+	logVerbose
+	logNotice
+	logWarning
+)
+
+// newState creates the Lua state running the scripts, with the libraries
+// of the Redis scripting environment and the globals protected.
+func newState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	globals := L.Get(lua.GlobalsIndex).(*lua.LTable)
+
+	// scripts can't reach the file system
+	for _, name := range []string{"dofile", "loadfile", "module", "require"} {
+		globals.RawSetString(name, lua.LNil)
+	}
+
+	redis := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"call":         redisCall,
+		"pcall":        redisPCall,
+		"error_reply":  redisErrorReply,
+		"status_reply": redisStatusReply,
+		"sha1hex":      redisSHA1Hex,
+		"log":          redisLog,
+		"setresp":      redisSetResp,
+		// the effects of the scripts are always replicated
+		"replicate_commands": func(L *lua.LState) int {
+			L.Push(lua.LTrue)
+			return 1
+		},
+	})
+	redis.RawSetString("LOG_DEBUG", lua.LNumber(logDebug))
+	redis.RawSetString("LOG_VERBOSE", lua.LNumber(logVerbose))
+	redis.RawSetString("LOG_NOTICE", lua.LNumber(logNotice))
+	redis.RawSetString("LOG_WARNING", lua.LNumber(logWarning))
+	globals.RawSetString("redis", redis)
+
+	// the state is shared by every script, they can read the libraries
+	// and the globals but not change them for the next ones
+	readOnly := map[*lua.LTable]bool{globals: true}
+
+	for _, name := range []string{lua.TabLibName, lua.StringLibName, lua.MathLibName, "redis"} {
+		proxy := readOnlyTable(L, globals.RawGetString(name).(*lua.LTable))
+		globals.RawSetString(name, proxy)
+		readOnly[proxy] = true
+	}
+
+	// the methods of the strings are looked up in the string library
+	if mt, ok := L.GetMetatable(lua.LString("")).(*lua.LTable); ok {
+		mt.RawSetString("__index", globals.RawGetString(lua.StringLibName))
+		mt.RawSetString("__metatable", lua.LFalse)
+	}
+
+	// rawset bypasses __newindex, it can't write the read only tables
+	rawset := globals.RawGetString("rawset").(*lua.LFunction)
+	globals.RawSetString("rawset", L.NewFunction(func(L *lua.LState) int {
+		if readOnly[L.CheckTable(1)] {
+			L.RaiseError("Attempt to modify a readonly table")
+		}
+
+		return rawset.GFunction(L)
+	}))
+
+	// the globals are moved to base so every assignment to a global goes
+	// through __newindex, a script can't leave globals behind for the
+	// next ones, nor use an undefined one by mistake
+	base := L.NewTable()
+	globals.ForEach(func(key, val lua.LValue) {
+		base.RawSet(key, val)
+	})
+
+	base.ForEach(func(key, _ lua.LValue) {
+		globals.RawSet(key, lua.LNil)
+	})
+
+	undefined := L.NewTable()
+	undefined.RawSetString("__index", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Script attempted to access nonexistent global variable '%s'", L.CheckAny(2).String())
+		return 0
+	}))
+	L.SetMetatable(base, undefined)
+
+	protect := L.NewTable()
+	protect.RawSetString("__index", base)
+	protect.RawSetString("__newindex", L.NewFunction(func(L *lua.LState) int {
+		if base.RawGet(L.CheckAny(2)) != lua.LNil {
+			L.RaiseError("Attempt to modify a readonly table")
+		}
+
+		L.RaiseError("Script attempted to create global variable '%s'", L.CheckAny(2).String())
+		return 0
+	}))
+	protect.RawSetString("__metatable", lua.LFalse)
+	L.SetMetatable(globals, protect)
+
+	return L
+}
+
+// readOnlyTable returns a table reading the fields of tbl that raises an
+// error when a field is set.
+func readOnlyTable(L *lua.LState, tbl *lua.LTable) *lua.LTable {
+	mt := L.NewTable()
+	mt.RawSetString("__index", tbl)
+	mt.RawSetString("__newindex", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Attempt to modify a readonly table")
+		return 0
+	}))
+	// getmetatable can't reach tbl through the proxy
+	mt.RawSetString("__metatable", lua.LFalse)
+
+	proxy := L.NewTable()
+	L.SetMetatable(proxy, mt)
+
+	return proxy
+}
+
+func redisCall(L *lua.LState) int {
+	reply, ok := call(L)
+	if !ok || reply.IsError() {
+		// the error reply is raised as is, so it reaches the client when
+		// the script doesn't catch it
+		L.Error(toLua(L, reply), 1)
+		return 0
+	}
+
+	L.Push(toLua(L, reply))
+
+	return 1
+}
+
+func redisPCall(L *lua.LState) int {
+	reply, _ := call(L)
+	L.Push(toLua(L, reply))
+
+	return 1
+}
+
+// call runs the command given as the arguments of redis.call for the
+// client of the running script, ok is false when it couldn't be called.
+func call(L *lua.LState) (reply resp.ValueNode, ok bool) {
+	vm.mu.Lock()
+	e := vm.running
+	vm.mu.Unlock()
+
+	argc := L.GetTop()
+	if argc == 0 {
+		return resp.NewValueNode(
+			resp.ValueNodeTypeSimpleError,
+			resp.WithValue("ERROR: Please specify at least one argument for this redis lib call"),
+		), false
+	}
+
+	argv := make([]string, 0, argc)
+
+	for i := 1; i <= argc; i++ {
+		switch arg := L.Get(i).(type) {
+		case lua.LString:
+			argv = append(argv, string(arg))
+		case lua.LNumber:
+			// the precision of the numbers is kept, like in Redis
+			argv = append(argv, strconv.FormatFloat(float64(arg), 'g', 17, 64))
+		default:
+			return resp.NewValueNode(
+				resp.ValueNodeTypeSimpleError,
+				resp.WithValue("ERROR: Lua redis lib command arguments must be strings or integers"),
+			), false
+		}
+	}
+
+	flags, found := e.cmd.Flags(argv[0])
+	if !found {
+		return resp.NewValueNode(
+			resp.ValueNodeTypeSimpleError,
+			resp.WithValue("ERROR: Unknown Redis command called from script"),
+		), false
+	}
+
+	if flags&resp.FlagNoScript != 0 {
+		return resp.NewValueNode(
+			resp.ValueNodeTypeSimpleError,
+			resp.WithValue("ERROR: This Redis command is not allowed from script"),
+		), false
+	}
+
+	if flags&resp.FlagWrite != 0 {
+		e.wrote.Store(true)
+	}
+
+	return e.cmd.Call(argv[0], argv[1:]...), true
+}
+
+func redisErrorReply(L *lua.LState) int {
+	L.Push(singleField(L, "err", lua.LString(L.CheckString(1))))
+	return 1
+}
+
+func redisStatusReply(L *lua.LState) int {
+	L.Push(singleField(L, "ok", lua.LString(L.CheckString(1))))
+	return 1
+}
+
+func redisSHA1Hex(L *lua.LState) int {
+	L.Push(lua.LString(SHA1(L.CheckString(1))))
+	return 1
+}
+
+func redisLog(L *lua.LState) int {
+	level := L.CheckInt(1)
+	if level < logDebug || level > logWarning {
+		L.ArgError(1, "Invalid debug level.")
+	}
+
+	msg := make([]string, 0, L.GetTop()-1)
+	for i := 2; i <= L.GetTop(); i++ {
+		msg = append(msg, L.ToStringMeta(L.Get(i)).String())
+	}
+
+	log.Println("[script]", strings.Join(msg, " "))
+
+	return 0
+}
+
+// redisSetResp sets the protocol of the replies returned by redis.call.
+func redisSetResp(L *lua.LState) int {
+	proto := L.CheckInt(1)
+	if proto != 2 && proto != 3 {
+		L.RaiseError("RESP version must be 2 or 3.")
+	}
+
+	vm.mu.Lock()
+	e := vm.running
+	vm.mu.Unlock()
+
+	e.proto = proto
+	e.cmd.SetProto(proto)
+
+	return 0
+}
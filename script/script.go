@@ -0,0 +1,323 @@
+package script
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/raspiantoro/temporama/resp"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+const (
+	// DefaultTimeLimit is the time after which a running script makes the
+	// server reply BUSY to the other clients, like lua-time-limit in Redis
+	DefaultTimeLimit = 5 * time.Second
+)
+
+var (
+	ErrNoScript   = errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	ErrNotBusy    = errors.New("NOTBUSY No scripts in execution right now.")
+	ErrUnkillable = errors.New("UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way.")
+)
+
+// scripts is the cache of the compiled scripts by their SHA1 digest, it's
+// filled by EVAL and SCRIPT LOAD.
+var scripts = struct {
+	mu    sync.RWMutex
+	cache map[string]*lua.FunctionProto
+}{
+	cache: make(map[string]*lua.FunctionProto),
+}
+
+// vm runs the scripts one at a time in the same Lua state.
+var vm struct {
+	// run is held while a script runs
+	run   sync.Mutex
+	state *lua.LState
+	// mu guards running and timeLimit, SCRIPT KILL reads them while a
+	// script runs
+	mu        sync.Mutex
+	running   *execution
+	timeLimit time.Duration
+}
+
+// execution is a running script.
+type execution struct {
+	cmd resp.Command
+	// proto is the protocol of the replies returned by redis.call,
+	// scripts get RESP2 unless they call redis.setresp
+	proto int
+	// wrote is set once the script called a write command, it can't be
+	// killed anymore then
+	wrote  atomic.Bool
+	killed atomic.Bool
+	cancel context.CancelFunc
+}
+
+// SetTimeLimit sets the time after which a running script makes the
+// server busy, zero restores DefaultTimeLimit.
+func SetTimeLimit(limit time.Duration) {
+	if limit <= 0 {
+		limit = DefaultTimeLimit
+	}
+
+	vm.mu.Lock()
+	vm.timeLimit = limit
+	vm.mu.Unlock()
+}
+
+// SHA1 returns the digest identifying body in the cache.
+func SHA1(body string) string {
+	sum := sha1.Sum([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// Load compiles body and adds it to the cache, it returns its digest.
+func Load(body string) (string, error) {
+	sha := SHA1(body)
+
+	_, err := load(sha, body)
+	if err != nil {
+		return "", err
+	}
+
+	return sha, nil
+}
+
+func load(sha, body string) (*lua.FunctionProto, error) {
+	scripts.mu.RLock()
+	proto, ok := scripts.cache[sha]
+	scripts.mu.RUnlock()
+
+	if ok {
+		return proto, nil
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(body), "user_script")
+	if err == nil {
+		proto, err = lua.Compile(chunk, "user_script")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Error compiling script (new function): %s", oneLine(err.Error()))
+	}
+
+	scripts.mu.Lock()
+	scripts.cache[sha] = proto
+	scripts.mu.Unlock()
+
+	return proto, nil
+}
+
+// Exists reports whether the script of sha is in the cache.
+func Exists(sha string) bool {
+	scripts.mu.RLock()
+	defer scripts.mu.RUnlock()
+
+	_, ok := scripts.cache[strings.ToLower(sha)]
+
+	return ok
+}
+
+// Flush empties the cache.
+func Flush() {
+	scripts.mu.Lock()
+	scripts.cache = make(map[string]*lua.FunctionProto)
+	scripts.mu.Unlock()
+}
+
+// Kill stops the running script, unless it already modified the keyspace
+// since the modifications couldn't be rolled back.
+func Kill() error {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	e := vm.running
+	if e == nil {
+		return ErrNotBusy
+	}
+
+	if e.wrote.Load() {
+		return ErrUnkillable
+	}
+
+	e.killed.Store(true)
+	e.cancel()
+
+	return nil
+}
+
+// Eval runs body with keys and args as the KEYS and ARGV tables, the
+// script is added to the cache.
+func Eval(cmd resp.Command, body string, keys, args []string) (resp.ValueNode, error) {
+	sha := SHA1(body)
+
+	proto, err := load(sha, body)
+	if err != nil {
+		return resp.ValueNode{}, err
+	}
+
+	return run(cmd, proto, keys, args), nil
+}
+
+// EvalSHA runs the cached script of sha like Eval.
+func EvalSHA(cmd resp.Command, sha string, keys, args []string) (resp.ValueNode, error) {
+	scripts.mu.RLock()
+	proto, ok := scripts.cache[strings.ToLower(sha)]
+	scripts.mu.RUnlock()
+
+	if !ok {
+		return resp.ValueNode{}, ErrNoScript
+	}
+
+	return run(cmd, proto, keys, args), nil
+}
+
+// run runs the script with no command of the other clients in between.
+// The commands called by the script reply with RESP2 by default and use
+// the database selected by the client, the script can select another
+// one without changing the database of the client.
+func run(cmd resp.Command, proto *lua.FunctionProto, keys, args []string) resp.ValueNode {
+	var reply resp.ValueNode
+
+	cmd.Exclusive(func() {
+		vm.run.Lock()
+		defer vm.run.Unlock()
+
+		clientProto, db := cmd.Proto(), cmd.DB()
+		defer func() {
+			cmd.SetProto(clientProto)
+			cmd.SetDB(db)
+		}()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		e := &execution{
+			cmd:    cmd,
+			proto:  2,
+			cancel: cancel,
+		}
+
+		stop := start(e)
+		defer stop()
+
+		cmd.SetProto(e.proto)
+
+		if vm.state == nil {
+			vm.state = newState()
+		}
+
+		L := vm.state
+		L.SetContext(ctx)
+		defer L.RemoveContext()
+
+		globals := L.Get(lua.GlobalsIndex).(*lua.LTable)
+		globals.RawSetString("KEYS", stringsTable(L, keys))
+		globals.RawSetString("ARGV", stringsTable(L, args))
+
+		L.Push(L.NewFunctionFromProto(proto))
+
+		err := L.PCall(0, 1, nil)
+		if err != nil {
+			// the state may be left in the middle of anything, the next
+			// script gets a new one
+			L.Close()
+			vm.state = nil
+
+			reply = errorReply(e, err)
+			return
+		}
+
+		ret := L.Get(-1)
+		L.Pop(1)
+
+		reply = toReply(ret, clientProto, 0)
+	})
+
+	return reply
+}
+
+// start marks e as the running script, the server turns busy when it runs
+// for longer than the time limit. The returned function ends it.
+func start(e *execution) (stop func()) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.running = e
+
+	limit := vm.timeLimit
+	if limit <= 0 {
+		limit = DefaultTimeLimit
+	}
+
+	timer := time.AfterFunc(limit, func() {
+		vm.mu.Lock()
+		defer vm.mu.Unlock()
+
+		if vm.running != e {
+			return
+		}
+
+		log.Printf("slow script detected: still in execution after %d milliseconds, it can be stopped with SCRIPT KILL\n", limit.Milliseconds())
+		e.cmd.SetBusy(true)
+	})
+
+	return func() {
+		timer.Stop()
+
+		vm.mu.Lock()
+		defer vm.mu.Unlock()
+
+		vm.running = nil
+		e.cmd.SetBusy(false)
+	}
+}
+
+// errorReply converts the error stopping a script to a reply.
+func errorReply(e *execution, err error) resp.ValueNode {
+	if e.killed.Load() {
+		return resp.NewValueNode(
+			resp.ValueNodeTypeSimpleError,
+			resp.WithValue("ERROR: Script killed by user with SCRIPT KILL..."),
+		)
+	}
+
+	var apiErr *lua.ApiError
+	if errors.As(err, &apiErr) {
+		// an error reply raised as is, like the failure of redis.call
+		if msg, ok := errorField(apiErr.Object); ok {
+			return resp.NewValueNode(
+				resp.ValueNodeTypeSimpleError,
+				resp.WithValue(oneLine(msg)),
+			)
+		}
+
+		if apiErr.Object != nil {
+			return resp.NewValueNode(
+				resp.ValueNodeTypeSimpleError,
+				resp.WithValue(oneLine("ERROR: "+apiErr.Object.String())),
+			)
+		}
+	}
+
+	return resp.NewValueNode(
+		resp.ValueNodeTypeSimpleError,
+		resp.WithValue(oneLine("ERROR: "+err.Error())),
+	)
+}
+
+// oneLine replaces the line breaks of the Lua errors, they can't be sent
+// in a simple error.
+func oneLine(msg string) string {
+	return strings.TrimSpace(strings.NewReplacer("\r", " ", "\n", " ").Replace(msg))
+}
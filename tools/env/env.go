@@ -2,8 +2,10 @@ package env
 
 import (
 	"errors"
+	"math"
 	"os"
 	"strconv"
+	"strings"
 )
 
 func GetUint32(name string) (uint32, error) {
@@ -19,3 +21,45 @@ func GetUint32(name string) (uint32, error) {
 
 	return uint32(v), nil
 }
+
+// GetBytes parses a memory size like 1024, 100kb, 64mb or 2gb into bytes.
+func GetBytes(name string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(os.Getenv(name)))
+	if s == "" {
+		return 0, errors.New("empty value")
+	}
+
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"gb", 1024 * 1024 * 1024},
+		{"mb", 1024 * 1024},
+		{"kb", 1024},
+		{"g", 1000 * 1000 * 1000},
+		{"m", 1000 * 1000},
+		{"k", 1000},
+		{"b", 1},
+	}
+
+	multiplier := int64(1)
+
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSuffix(s, unit.suffix)
+			multiplier = unit.size
+			break
+		}
+	}
+
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if v < 0 || v > math.MaxInt64/multiplier {
+		return 0, errors.New("out of range value")
+	}
+
+	return v * multiplier, nil
+}